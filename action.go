@@ -7,9 +7,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/xerrors"
 
@@ -44,18 +46,61 @@ type CmdAction interface {
 	resp.Unmarshaler
 }
 
+// BlockingAction is implemented by Actions which may block on the server for a
+// known amount of time before redis replies, e.g. BLPOP. Conns created by Dial
+// extend their read timeout (see DialReadTimeout) by the block duration while
+// performing such an Action, so that the read doesn't time out while redis is
+// still blocking. Deadlines set using WithDeadline or WithTimeout still apply.
+//
+// Actions created using Cmd or CmdBytes implement BlockingAction for BLPOP,
+// BRPOP, BRPOPLPUSH, BLMOVE, BLMPOP, BZPOPMIN, BZPOPMAX, BZMPOP and WAIT, as
+// well as for XREAD and XREADGROUP with the BLOCK option.
+type BlockingAction interface {
+	Action
+
+	// BlockDuration returns for how long redis may block before replying. ok
+	// is false if the Action doesn't block. A duration of zero means that redis
+	// may block indefinitely, in which case no read timeout is applied.
+	BlockDuration() (d time.Duration, ok bool)
+}
+
+// blockDuration returns the block duration of the given Action, looking through
+// the wrappers created by WithCommandName, WithDeadline, WithTimeout and
+// WithWireTrace.
+func blockDuration(a Action) (time.Duration, bool) {
+	for {
+		switch aa := a.(type) {
+		case BlockingAction:
+			return aa.BlockDuration()
+		case *namedAction:
+			a = aa.Action
+		case *deadlineAction:
+			a = aa.Action
+		case *wireTraceAction:
+			a = aa.Action
+		case *meteredAction:
+			a = aa.Action
+		default:
+			return 0, false
+		}
+	}
+}
+
 var noKeyCmds = map[string]bool{
 	"SENTINEL": true,
 
 	"CLUSTER":   true,
+	"FAILOVER":  true,
 	"READONLY":  true,
 	"READWRITE": true,
 	"ASKING":    true,
 
 	"AUTH":   true,
 	"ECHO":   true,
+	"HELLO":  true,
 	"PING":   true,
 	"QUIT":   true,
+	"RESET":  true,
 	"SELECT": true,
 	"SWAPDB": true,
 
@@ -66,10 +111,12 @@ var noKeyCmds = map[string]bool{
 	"WAIT":      true,
 	"SCAN":      true,
 
-	"EVAL":    true,
-	"EVALSHA": true,
-	"SCRIPT":  true,
+	"EVAL":     true,
+	"EVALSHA":  true,
+	"FUNCTION": true,
+	"SCRIPT":   true,
 
+	"ACL":          true,
 	"BGREWRITEAOF": true,
 	"BGSAVE":       true,
 	"CLIENT":       true,
@@ -81,9 +128,12 @@ var noKeyCmds = map[string]bool{
 	"FLUSHDB":      true,
 	"INFO":         true,
 	"LASTSAVE":     true,
+	"LATENCY":      true,
+	"MODULE":       true,
 	"MONITOR":      true,
 	"ROLE":         true,
 	"SAVE":         true,
+	"REPLICAOF":    true,
 	"SHUTDOWN":     true,
 	"SLAVEOF":      true,
 	"SLOWLOG":      true,
@@ -132,13 +182,20 @@ func marshalBulkStringBytes(prevErr error, w io.Writer, b []byte) error {
 ////////////////////////////////////////////////////////////////////////////////
 
 type cmdAction struct {
-	rcv  interface{}
-	cmd  string
-	args []string
+	rcv       interface{}
+	cmd       string
+	args      []string
+	bytesArgs [][]byte
 
 	flat     bool
 	flatKey  [1]string // use array to avoid allocation in Keys
 	flatArgs []interface{}
+
+	// bytesKeys caches the result of Keys for CmdBytes
+	bytesKeys    []string
+	bytesKeysSet bool
+
+	strict bool
 }
 
 // BREAM: Benchmarks Rule Everything Around Me
@@ -168,6 +225,22 @@ func Cmd(rcv interface{}, cmd string, args ...string) CmdAction {
 	return c
 }
 
+// CmdBytes is like Cmd, but the arguments are given as byte slices, which are
+// written to the connection as-is. This allows for passing binary keys and
+// values without first converting them to strings. The byte slices must not be
+// modified until the CmdBytes has been performed.
+//
+// Like Cmd, a CmdBytes should not be passed into Do more than once.
+func CmdBytes(rcv interface{}, cmd string, args ...[]byte) CmdAction {
+	c := getCmdAction()
+	*c = cmdAction{
+		rcv:       rcv,
+		cmd:       cmd,
+		bytesArgs: args,
+	}
+	return c
+}
+
 // FlatCmd is like Cmd, but the arguments can be of almost any type, and FlatCmd
 // will automatically flatten them into a single array of strings. Like Cmd, a
 // FlatCmd should not be passed into Do more than once.
@@ -195,6 +268,22 @@ func FlatCmd(rcv interface{}, cmd, key string, args ...interface{}) CmdAction {
 	return c
 }
 
+// WithStrictReply causes the reply to the given CmdAction, which must have been
+// created using Cmd, FlatCmd or CmdBytes, to be unmarshaled into its receiver
+// as if using resp2.Any with Strict set. An error is then returned if the reply
+// doesn't match the receiver, e.g. because it contains a field the receiving
+// struct doesn't have, rather than the mismatch being silently ignored. Other
+// CmdActions are returned as-is.
+//
+// The given CmdAction is modified and returned, so that it can still be used
+// as a CmdAction, e.g. within a Pipeline.
+func WithStrictReply(cmd CmdAction) CmdAction {
+	if c, ok := cmd.(*cmdAction); ok {
+		c.strict = true
+	}
+	return cmd
+}
+
 func findStreamsKeys(args []string) []string {
 	for i, arg := range args {
 		if strings.ToUpper(arg) != "STREAMS" {
@@ -214,25 +303,48 @@ func findStreamsKeys(args []string) []string {
 
 func (c *cmdAction) Keys() []string {
 	if c.flat {
+		if noKeyCmds[strings.ToUpper(c.cmd)] {
+			return nil
+		}
 		return c.flatKey[:]
 	}
 
+	if c.bytesArgs == nil {
+		return c.keys(c.args)
+	}
+
+	// the keys of a CmdBytes are only converted once, since Keys is called
+	// multiple times e.g. when performed through a Cluster
+	if !c.bytesKeysSet {
+		args := make([]string, len(c.bytesArgs))
+		for i := range c.bytesArgs {
+			args[i] = string(c.bytesArgs[i])
+		}
+		c.bytesKeys, c.bytesKeysSet = c.keys(args), true
+	}
+	return c.bytesKeys
+}
+
+// keys returns the keys of the command given its arguments as strings.
+func (c *cmdAction) keys(args []string) []string {
 	cmd := strings.ToUpper(c.cmd)
-	if cmd == "BITOP" && len(c.args) > 1 { // antirez why you do this
-		return c.args[1:]
+	if cmd == "BITOP" && len(args) > 1 { // antirez why you do this
+		return args[1:]
 	} else if cmd == "XINFO" {
-		if len(c.args) < 2 {
+		if len(args) < 2 {
 			return nil
 		}
-		return c.args[1:2]
-	} else if cmd == "XGROUP" && len(c.args) > 1 {
-		return c.args[1:2]
+		return args[1:2]
+	} else if (cmd == "LMOVE" || cmd == "BLMOVE") && len(args) > 1 {
+		return args[:2]
+	} else if cmd == "XGROUP" && len(args) > 1 {
+		return args[1:2]
 	} else if cmd == "XREAD" || cmd == "XREADGROUP" { // antirez why you still do this
-		return findStreamsKeys(c.args)
-	} else if noKeyCmds[cmd] || len(c.args) == 0 {
+		return findStreamsKeys(args)
+	} else if noKeyCmds[cmd] || len(args) == 0 {
 		return nil
 	}
-	return c.args[:1]
+	return args[:1]
 }
 
 func (c *cmdAction) flatMarshalRESP(w io.Writer) error {
@@ -257,16 +369,25 @@ func (c *cmdAction) MarshalRESP(w io.Writer) error {
 		return c.flatMarshalRESP(w)
 	}
 
-	err := resp2.ArrayHeader{N: len(c.args) + 1}.MarshalRESP(w)
+	err := resp2.ArrayHeader{N: len(c.args) + len(c.bytesArgs) + 1}.MarshalRESP(w)
 	err = marshalBulkString(err, w, c.cmd)
 	for i := range c.args {
 		err = marshalBulkString(err, w, c.args[i])
 	}
+	for i := range c.bytesArgs {
+		err = marshalBulkStringBytes(err, w, c.bytesArgs[i])
+	}
 	return err
 }
 
 func (c *cmdAction) UnmarshalRESP(br *bufio.Reader) error {
-	if err := (resp2.Any{I: c.rcv}).UnmarshalRESP(br); err != nil {
+	return c.unmarshalRESPWith(br, resp2.Any{})
+}
+
+func (c *cmdAction) unmarshalRESPWith(br *bufio.Reader, opts resp2.Any) error {
+	opts.I = c.rcv
+	opts.Strict = c.strict
+	if err := opts.UnmarshalRESP(br); err != nil {
 		return err
 	}
 	cmdActionPool.Put(c)
@@ -280,6 +401,66 @@ func (c *cmdAction) Run(conn Conn) error {
 	return conn.Decode(c)
 }
 
+func (c *cmdAction) arg(i int) string {
+	if c.bytesArgs != nil {
+		return string(c.bytesArgs[i])
+	}
+	return c.args[i]
+}
+
+func parseBlockSeconds(s string) (time.Duration, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, false
+	}
+	return time.Duration(f * float64(time.Second)), true
+}
+
+func parseBlockMillis(s string) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+func (c *cmdAction) BlockDuration() (time.Duration, bool) {
+	if c.flat {
+		return 0, false
+	}
+
+	n := len(c.args) + len(c.bytesArgs)
+	switch strings.ToUpper(c.cmd) {
+	case "BLPOP", "BRPOP", "BRPOPLPUSH", "BLMOVE", "BZPOPMIN", "BZPOPMAX":
+		if n > 1 {
+			return parseBlockSeconds(c.arg(n - 1))
+		}
+	case "BLMPOP", "BZMPOP":
+		if n > 0 {
+			return parseBlockSeconds(c.arg(0))
+		}
+	case "WAIT":
+		if n == 2 {
+			return parseBlockMillis(c.arg(1))
+		}
+	case "XREAD", "XREADGROUP":
+		i := 0
+		if n >= 3 && strings.ToUpper(c.arg(0)) == "GROUP" {
+			// skip the group and consumer names, which might be "BLOCK"
+			i = 3
+		}
+		for ; i+1 < n; i++ {
+			switch strings.ToUpper(c.arg(i)) {
+			case "STREAMS":
+				return 0, false
+			case "BLOCK":
+				return parseBlockMillis(c.arg(i + 1))
+			}
+		}
+	}
+	return 0, false
+}
+
 func (c *cmdAction) String() string {
 	return cmdString(c)
 }
@@ -290,6 +471,261 @@ func (c *cmdAction) ClusterCanRetry() bool {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+type namedAction struct {
+	Action
+	name string
+}
+
+// WithCommandName wraps the given Action such that the given name is reported
+// as its CommandName in traces (see trace.PoolDoCompleted), in place of the
+// name of the command being performed. This is useful for tying traces to a
+// logical operation, e.g. a Pipeline or WithConn performing multiple commands.
+func WithCommandName(a Action, name string) Action {
+	return &namedAction{Action: a, name: name}
+}
+
+func (na *namedAction) ClusterCanRetry() bool {
+	ccra, ok := na.Action.(ClusterCanRetryAction)
+	return ok && ccra.ClusterCanRetry()
+}
+
+type deadlineAction struct {
+	Action
+	deadline time.Time
+	timeout  time.Duration
+}
+
+// WithDeadline wraps the given Action such that all reads and writes it
+// performs on a Conn fail once the given deadline has passed. This applies in
+// addition to any timeouts of the Conn itself (e.g. DialReadTimeout), with
+// whichever expires first taking effect.
+//
+// If the deadline is hit the Conn will most likely be left in an unusable
+// state, in which case Pool will discard it rather than reusing it. Conns
+// created by Dial or NewConn mark themselves as broken in that case, so that
+// all further commands fail immediately rather than reading a stale reply.
+// Blocking commands, e.g. BLPOP, are an exception to this for Conns created by
+// Dial, see DialUnblockOnTimeout.
+func WithDeadline(a Action, deadline time.Time) Action {
+	return &deadlineAction{Action: a, deadline: deadline}
+}
+
+// WithTimeout is like WithDeadline, but the deadline is set to the given
+// duration after the Action starts running on a Conn.
+func WithTimeout(a Action, timeout time.Duration) Action {
+	return &deadlineAction{Action: a, timeout: timeout}
+}
+
+func (da *deadlineAction) Run(conn Conn) error {
+	_, inner := commandName(da.Action)
+	defer armUnblock(conn, inner)()
+
+	deadline := da.deadline
+	if da.timeout > 0 {
+		deadline = time.Now().Add(da.timeout)
+	}
+
+	// Conns created by Dial apply their own timeouts on every read and write,
+	// so the deadline has to be applied there as well.
+	if tc, ok := conn.NetConn().(*timeoutConn); ok {
+		prev := tc.deadline
+		if prev.IsZero() || deadline.Before(prev) {
+			tc.deadline = deadline
+		}
+		defer func() {
+			tc.deadline = prev
+			if prev.IsZero() {
+				tc.Conn.SetDeadline(time.Time{})
+			}
+		}()
+		err := da.Action.Run(conn)
+		markBrokenOnTimeout(conn, err)
+		return err
+	}
+
+	netConn := conn.NetConn()
+	if err := netConn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	defer netConn.SetDeadline(time.Time{})
+	err := da.Action.Run(conn)
+	markBrokenOnTimeout(conn, err)
+	return err
+}
+
+func (da *deadlineAction) ClusterCanRetry() bool {
+	ccra, ok := da.Action.(ClusterCanRetryAction)
+	return ok && ccra.ClusterCanRetry()
+}
+
+// actionDeadline returns the deadline of the given Action, if it was created
+// using WithDeadline or WithTimeout. For the latter the deadline is relative to
+// the current time.
+func actionDeadline(a Action) (time.Time, bool) {
+	for {
+		switch aa := a.(type) {
+		case *deadlineAction:
+			if aa.timeout > 0 {
+				return time.Now().Add(aa.timeout), true
+			}
+			return aa.deadline, true
+		case *namedAction:
+			a = aa.Action
+		case *wireTraceAction:
+			a = aa.Action
+		case *meteredAction:
+			a = aa.Action
+		default:
+			return time.Time{}, false
+		}
+	}
+}
+
+type wireTraceAction struct {
+	Action
+}
+
+// WithWireTrace wraps the given Action such that all data it writes to and
+// reads from a Conn is logged by the Conn's wire logger, if the Conn was
+// created using DialWireLoggerSampled. This allows for tracing a single
+// problematic Action on the wire, without logging the data of all other
+// Actions.
+//
+// Data which was read ahead into the Conn's buffer before the Action started
+// isn't logged again. For all other Conns the Action is performed as if it
+// wasn't wrapped.
+func WithWireTrace(a Action) Action {
+	return &wireTraceAction{Action: a}
+}
+
+func (wa *wireTraceAction) Run(conn Conn) error {
+	tc, _ := conn.NetConn().(*timeoutConn)
+	if tc == nil {
+		return wa.Action.Run(conn)
+	}
+	wc, _ := tc.Conn.(*wireLogConn)
+	if wc == nil || !wc.sampled || wc.traced {
+		return wa.Action.Run(conn)
+	}
+	wc.traced = true
+	defer func() { wc.traced = false }()
+	return wa.Action.Run(conn)
+}
+
+func (wa *wireTraceAction) ClusterCanRetry() bool {
+	ccra, ok := wa.Action.(ClusterCanRetryAction)
+	return ok && ccra.ClusterCanRetry()
+}
+
+type primaryAction struct {
+	Action
+}
+
+// Primary wraps the given Action such that the DoSecondary methods of Cluster
+// and Sentinel, as well as their Do methods when using a ReadPolicy, will
+// perform it on the primary instance, rather than on a secondary. This allows
+// for using a single client for both reads which may be served by a secondary
+// and reads which must see all previously completed writes.
+//
+// For all other methods the Action is performed as if it wasn't wrapped.
+func Primary(a Action) Action {
+	return &primaryAction{Action: a}
+}
+
+func (pa *primaryAction) ClusterCanRetry() bool {
+	ccra, ok := pa.Action.(ClusterCanRetryAction)
+	return ok && ccra.ClusterCanRetry()
+}
+
+// isPrimary returns true if the given Action was wrapped using Primary, looking
+// through any other wrappers around it.
+func isPrimary(a Action) bool {
+	for {
+		switch aa := a.(type) {
+		case *primaryAction:
+			return true
+		case *namedAction:
+			a = aa.Action
+		case *deadlineAction:
+			a = aa.Action
+		case *wireTraceAction:
+			a = aa.Action
+		case *meteredAction:
+			a = aa.Action
+		case *cachingAction:
+			a = aa.Action
+		default:
+			return false
+		}
+	}
+}
+
+type cachingAction struct {
+	Action
+	caching string
+}
+
+// WithClientCaching wraps the given Action such that it is immediately preceded
+// by a CLIENT CACHING command on the same Conn. If yes is true CLIENT CACHING
+// YES is sent, causing the keys read by the Action to be tracked when client
+// tracking is enabled with the OptIn option, otherwise CLIENT CACHING NO is
+// sent, causing the keys not to be tracked when using the OptOut option. See
+// DialClientTracking.
+//
+// CLIENT CACHING only applies to the command immediately following it, so a
+// wrapped Pipeline or WithConn only affects its first command. If the Action
+// is a CmdAction, CLIENT CACHING is pipelined with it.
+func WithClientCaching(a Action, yes bool) Action {
+	caching := "NO"
+	if yes {
+		caching = "YES"
+	}
+	return &cachingAction{Action: a, caching: caching}
+}
+
+func (ca *cachingAction) Run(conn Conn) error {
+	cachingCmd := Cmd(nil, "CLIENT", "CACHING", ca.caching)
+	if cmd, ok := ca.Action.(CmdAction); ok {
+		return Pipeline(cachingCmd, cmd).Run(conn)
+	} else if err := conn.Do(cachingCmd); err != nil {
+		return err
+	}
+	return ca.Action.Run(conn)
+}
+
+func (ca *cachingAction) ClusterCanRetry() bool {
+	ccra, ok := ca.Action.(ClusterCanRetryAction)
+	return ok && ccra.ClusterCanRetry()
+}
+
+// commandName returns the name which should be used for the given Action in
+// traces, as well as the Action with any WithCommandName wrapping removed.
+//
+// This must be called before the Action is performed, as a cmdAction may be
+// reused once it has completed.
+func commandName(a Action) (string, Action) {
+	switch a := a.(type) {
+	case *namedAction:
+		_, inner := commandName(a.Action)
+		return a.name, inner
+	case *deadlineAction:
+		name, _ := commandName(a.Action)
+		return name, a
+	case *wireTraceAction:
+		name, _ := commandName(a.Action)
+		return name, a
+	case *meteredAction:
+		name, _ := commandName(a.Action)
+		return name, a
+	case *cmdAction:
+		return a.cmd, a
+	default:
+		return "", a
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 // MaybeNil is a type which wraps a receiver. It will first detect if what's
 // being received is a nil RESP type (either bulk string or array), and if so
 // set Nil to true. If not the return value will be unmarshalled into Rcv
@@ -364,6 +800,298 @@ func (t Tuple) UnmarshalRESP(br *bufio.Reader) error {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// Enum is a helper type which can be used when unmarshaling a simple or bulk
+// string reply which is one of a known set of values, such as the reply from
+// TYPE or OBJECT ENCODING. The reply is looked up in Values and the matching
+// value is set into Rcv.
+//
+// Values must be a map whose keys are strings (or a string based type), and Rcv
+// must be a pointer to a value of the map's element type.
+//
+// If the reply is not found in Values then Default will be set into Rcv, or
+// Rcv will be set to its zero value if Default is nil. If ErrOnUnknown is true
+// an error is returned instead and Rcv is left untouched.
+type Enum struct {
+	Rcv          interface{}
+	Values       interface{}
+	Default      interface{}
+	ErrOnUnknown bool
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (e *Enum) UnmarshalRESP(br *bufio.Reader) error {
+	var s string
+	if err := (resp2.Any{I: &s}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	valuesV := reflect.ValueOf(e.Values)
+	if valuesV.Kind() != reflect.Map || valuesV.Type().Key().Kind() != reflect.String {
+		return resp.ErrDiscarded{Err: fmt.Errorf("can't use %T as Enum values", e.Values)}
+	}
+
+	rcvV := reflect.ValueOf(e.Rcv)
+	if rcvV.Kind() != reflect.Ptr || rcvV.IsNil() {
+		return resp.ErrDiscarded{Err: fmt.Errorf("can't unmarshal Enum into %T", e.Rcv)}
+	}
+	rcvV = rcvV.Elem()
+
+	elemT := valuesV.Type().Elem()
+	if !elemT.AssignableTo(rcvV.Type()) {
+		return resp.ErrDiscarded{Err: fmt.Errorf("can't unmarshal Enum of %v into %T", elemT, e.Rcv)}
+	}
+
+	v := valuesV.MapIndex(reflect.ValueOf(s).Convert(valuesV.Type().Key()))
+	switch {
+	case v.IsValid():
+	case e.ErrOnUnknown:
+		return resp.ErrDiscarded{Err: fmt.Errorf("unknown Enum value %q", s)}
+	case e.Default != nil:
+		v = reflect.ValueOf(e.Default)
+		if !v.Type().AssignableTo(rcvV.Type()) {
+			return resp.ErrDiscarded{Err: fmt.Errorf("can't unmarshal Enum default %T into %T", e.Default, e.Rcv)}
+		}
+	default:
+		v = reflect.Zero(rcvV.Type())
+	}
+
+	rcvV.Set(v)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// intResultSentinels describes the sentinel values of an integer reply.
+type intResultSentinels struct {
+	notFound []int64
+	noExpiry []int64
+}
+
+// intResultCmds contains the commands whose integer replies have sentinel
+// values which are interpreted by IntResult.
+var intResultCmds = map[string]intResultSentinels{
+	"TTL":         {notFound: []int64{-2}, noExpiry: []int64{-1}},
+	"PTTL":        {notFound: []int64{-2}, noExpiry: []int64{-1}},
+	"EXPIRETIME":  {notFound: []int64{-2}, noExpiry: []int64{-1}},
+	"PEXPIRETIME": {notFound: []int64{-2}, noExpiry: []int64{-1}},
+
+	// -1 if the pivot wasn't found, 0 if the list doesn't exist
+	"LINSERT": {notFound: []int64{-1, 0}},
+}
+
+// IntResult is a helper type which can be used when unmarshaling an integer
+// reply in which certain values have special meanings, such as the replies to
+// TTL or LINSERT, so that the sentinel values don't have to be checked by the
+// caller. Cmd is the name of the command the reply belongs to, and determines
+// which sentinel values apply:
+//
+//	TTL, PTTL, EXPIRETIME, PEXPIRETIME: -2 sets NotFound, -1 sets NoExpiry
+//	LINSERT: -1 (pivot not found) and 0 (key not found) set NotFound
+//
+// For all commands a nil reply, e.g. the reply to ZRANK for a member which
+// doesn't exist, sets NotFound. Value is only set if neither NotFound nor
+// NoExpiry were set, e.g.
+//
+//	res := radix.IntResult{Cmd: "PTTL"}
+//	err := client.Do(radix.Cmd(&res, "PTTL", key))
+//
+type IntResult struct {
+	Cmd string
+
+	Value    int64
+	NotFound bool
+	NoExpiry bool
+}
+
+func containsInt64(ii []int64, i int64) bool {
+	for _, j := range ii {
+		if i == j {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ir *IntResult) UnmarshalRESP(br *bufio.Reader) error {
+	var n int64
+	mn := MaybeNil{Rcv: &n}
+	if err := mn.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	*ir = IntResult{Cmd: ir.Cmd}
+	sentinels := intResultCmds[strings.ToUpper(ir.Cmd)]
+	switch {
+	case mn.Nil, containsInt64(sentinels.notFound, n):
+		ir.NotFound = true
+	case containsInt64(sentinels.noExpiry, n):
+		ir.NoExpiry = true
+	default:
+		ir.Value = n
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type unixTime struct {
+	t    *time.Time
+	unit time.Duration
+}
+
+// UnixSeconds returns a resp.Unmarshaler which unmarshals an integer reply
+// containing a Unix timestamp in seconds, such as the reply to EXPIRETIME or
+// LASTSAVE, into t.
+//
+// Negative replies, which commands like EXPIRETIME use to indicate that the key
+// doesn't exist or has no expiry, as well as nil replies set t to the zero
+// time.Time, so that t.IsZero() can be used to check for them.
+func UnixSeconds(t *time.Time) resp.Unmarshaler {
+	return unixTime{t: t, unit: time.Second}
+}
+
+// UnixMillis is like UnixSeconds, but for replies containing a Unix timestamp
+// in milliseconds, such as the reply to PEXPIRETIME.
+func UnixMillis(t *time.Time) resp.Unmarshaler {
+	return unixTime{t: t, unit: time.Millisecond}
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ut unixTime) UnmarshalRESP(br *bufio.Reader) error {
+	var n int64
+	mn := MaybeNil{Rcv: &n}
+	if err := mn.UnmarshalRESP(br); err != nil {
+		return err
+	} else if mn.Nil || n < 0 {
+		*ut.t = time.Time{}
+		return nil
+	}
+
+	perSec := int64(time.Second / ut.unit)
+	*ut.t = time.Unix(n/perSec, (n%perSec)*int64(ut.unit))
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Path is a helper type which can be used to unmarshal a single value nested
+// within a larger reply, such as the reply from XINFO STREAM FULL or CLUSTER
+// SHARDS, into Rcv. All other parts of the reply are discarded without being
+// unmarshaled.
+//
+// Each element of Elems selects the next value to descend into, and must be
+// either a string or an int. A string selects the value following the first
+// element equal to the string in an array of key/value pairs. An int selects
+// the element at that index of an array. For example the following unmarshals
+// the pending entries of the first consumer group of a stream:
+//
+//	p := radix.Path{
+//		Elems: []interface{}{"groups", 0, "pending"},
+//		Rcv:   &pending,
+//	}
+//	err := client.Do(radix.Cmd(&p, "XINFO", "STREAM", "mystream", "FULL"))
+//
+// If the selected value doesn't exist, e.g. because an array is too short, a
+// key is missing or a value is not an array, then Missing will be set to true
+// and Rcv will be left untouched. No error is returned in that case.
+type Path struct {
+	Elems   []interface{}
+	Rcv     interface{}
+	Missing bool
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (p *Path) UnmarshalRESP(br *bufio.Reader) error {
+	for _, elem := range p.Elems {
+		switch elem.(type) {
+		case string, int:
+		default:
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+			return resp.ErrDiscarded{Err: fmt.Errorf("invalid Path element %#v", elem)}
+		}
+	}
+
+	found, err := p.unmarshal(br, p.Elems)
+	p.Missing = err == nil && !found
+	return err
+}
+
+func (p *Path) unmarshal(br *bufio.Reader, elems []interface{}) (bool, error) {
+	if len(elems) == 0 {
+		return true, (resp2.Any{I: p.Rcv}).UnmarshalRESP(br)
+	}
+
+	if b, err := br.Peek(1); err != nil {
+		return false, err
+	} else if !bytes.Equal(b, resp2.ArrayPrefix) && !bytes.Equal(b, resp2.MapPrefix) {
+		// RESP3 maps are handled like arrays of key/value pairs
+		return false, (resp2.Any{}).UnmarshalRESP(br)
+	}
+
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return false, err
+	}
+
+	// all other elements of the array must always be discarded, even if
+	// unmarshaling the selected value failed, in which case its error is
+	// returned once the whole array was read.
+	var found bool
+	var retErr error
+	handle := func(selected bool) error {
+		var err error
+		if selected {
+			found, err = p.unmarshal(br, elems[1:])
+		} else {
+			err = (resp2.Any{}).UnmarshalRESP(br)
+		}
+		if err != nil && !xerrors.As(err, new(resp.ErrDiscarded)) {
+			return err
+		} else if err != nil && selected && retErr == nil {
+			retErr = err
+		}
+		return nil
+	}
+
+	switch elem := elems[0].(type) {
+	case int:
+		for i := 0; i < ah.N; i++ {
+			if err := handle(i == elem); err != nil {
+				return false, err
+			}
+		}
+	case string:
+		if ah.N%2 != 0 {
+			for i := 0; i < ah.N; i++ {
+				if err := handle(false); err != nil {
+					return false, err
+				}
+			}
+			break
+		}
+
+		var matched bool
+		for i := 0; i < ah.N; i += 2 {
+			var key string
+			if err := (resp2.Any{I: &key}).UnmarshalRESP(br); err != nil && !xerrors.As(err, new(resp.ErrDiscarded)) {
+				return false, err
+			}
+			selected := !matched && key == elem
+			matched = matched || selected
+			if err := handle(selected); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return found, retErr
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 // EvalScript contains the body of a script to be used with redis' EVAL
 // functionality. Call Cmd on a EvalScript to actually create an Action which
 // can be run.
@@ -432,6 +1160,85 @@ func (es EvalScript) FlatCmd(rcv interface{}, keys []string, args ...interface{}
 	}
 }
 
+// CmdStruct is like FlatCmd, except that the keys and arguments are taken from
+// the fields of the given structs. The values of the exported fields of keys
+// become the KEYS of the script and the values of the exported fields of args
+// its ARGV, both in the order the fields were declared in. Fields tagged with
+// `redis:"-"` are skipped and embedded structs are descended into.
+//
+// Field values are marshaled the same way FlatCmd marshals its arguments, but
+// each field of keys must marshal into exactly one key. The number of keys must
+// match the numKeys argument of NewEvalScript. Either struct may be nil.
+func (es EvalScript) CmdStruct(rcv interface{}, keys, args interface{}) Action {
+	keyVals := structFieldValues(keys)
+	if len(keyVals) != es.numKeys {
+		panic("incorrect number of keys passed into EvalScript.CmdStruct")
+	}
+
+	keyStrs := make([]string, len(keyVals))
+	for i, kv := range keyVals {
+		if n := (resp2.Any{I: kv}).NumElems(); n != 1 {
+			panic(fmt.Sprintf("key field %d of EvalScript.CmdStruct marshals into %d elements", i, n))
+		}
+
+		buf := new(bytes.Buffer)
+		err := (resp2.Any{
+			I:                     kv,
+			MarshalBulkString:     true,
+			MarshalNoArrayHeaders: true,
+		}).MarshalRESP(buf)
+		if err == nil {
+			err = resp2.RawMessage(buf.Bytes()).UnmarshalInto(resp2.Any{I: &keyStrs[i]})
+		}
+		if err != nil {
+			panic(fmt.Sprintf("could not marshal key field %d of EvalScript.CmdStruct: %s", i, err))
+		}
+	}
+
+	return &evalAction{
+		EvalScript: es,
+		keys:       keyStrs,
+		flatArgs:   structFieldValues(args),
+		flat:       true,
+		rcv:        rcv,
+	}
+}
+
+// structFieldValues returns the values of all exported fields of the given
+// struct, or pointer to a struct, in the order they were declared in.
+func structFieldValues(s interface{}) []interface{} {
+	if s == nil {
+		return nil
+	}
+
+	var values []interface{}
+	var walk func(vv reflect.Value)
+	walk = func(vv reflect.Value) {
+		tt := vv.Type()
+		for i := 0; i < vv.NumField(); i++ {
+			ft, fv := tt.Field(i), vv.Field(i)
+			if ft.Anonymous {
+				if fv = reflect.Indirect(fv); fv.IsValid() && fv.Kind() == reflect.Struct {
+					walk(fv)
+				}
+				continue
+			} else if ft.PkgPath != "" || ft.Tag.Get("redis") == "-" {
+				continue
+			}
+			values = append(values, fv.Interface())
+		}
+	}
+
+	vv := reflect.Indirect(reflect.ValueOf(s))
+	if !vv.IsValid() {
+		return nil // nil pointer
+	} else if vv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("expected struct or pointer to struct, got %T", s))
+	}
+	walk(vv)
+	return values
+}
+
 func (ec *evalAction) Keys() []string {
 	return ec.keys
 }
@@ -510,6 +1317,10 @@ type pipeline []CmdAction
 //
 // Run will not be called on any of the passed in CmdActions.
 //
+// If a CmdAction's response is an error returned by redis, or is otherwise
+// discarded (see resp.ErrDiscarded), the responses of the remaining CmdActions
+// are still read into their receivers, and the first such error is returned.
+//
 // NOTE that, while a Pipeline performs all commands on a single Conn, it
 // shouldn't be used by itself for MULTI/EXEC transactions, because if there's
 // an error it won't discard the incomplete transaction. Use WithConn or
@@ -536,14 +1347,37 @@ func (p pipeline) Run(c Conn) error {
 	if err := c.Encode(p); err != nil {
 		return err
 	}
+	return p.decode(c, nil)
+}
 
+// decode reads the responses of all CmdActions of the pipeline, after they
+// were written to c. If errs is not nil the error of each CmdAction is stored
+// in it at the CmdAction's index, with CmdActions whose responses couldn't be
+// read at all getting the error which prevented it.
+func (p pipeline) decode(c Conn, errs []error) error {
+	var firstErr error
 	for i, cmd := range p {
-		if err := c.Decode(cmd); err != nil {
+		err := c.Decode(cmd)
+		if err == nil {
+			continue
+		} else if !xerrors.As(err, new(resp.ErrDiscarded)) {
 			p.drain(c, len(p)-i-1)
-			return decodeErr(cmd, err)
+			err = decodeErr(cmd, err)
+			for j := i; j < len(errs); j++ {
+				errs[j] = err
+			}
+			return err
+		}
+
+		err = decodeErr(cmd, err)
+		if errs != nil {
+			errs[i] = err
+		}
+		if firstErr == nil {
+			firstErr = err
 		}
 	}
-	return nil
+	return firstErr
 }
 
 func (p pipeline) drain(c Conn, n int) {
@@ -592,6 +1426,356 @@ func (p pipeline) MarshalRESP(w io.Writer) error {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// ErrPipelineTooLarge is returned by PipelineBuilder.Append if appending the
+// CmdAction would make the PipelineBuilder exceed its MaxBufferedBytes and it
+// can't be flushed automatically.
+var ErrPipelineTooLarge = xerrors.New("pipeline exceeds the maximum number of buffered bytes")
+
+// PipelineBuilder is used to build a pipeline from many CmdActions appended
+// over time, while limiting the amount of memory used for buffering them. Each
+// appended CmdAction is marshaled immediately, so that the size of the
+// pipeline is always known.
+//
+// A PipelineBuilder is an Action which behaves like one created using
+// Pipeline with all appended CmdActions. Once performed the PipelineBuilder is
+// not reset automatically, see Reset. The error of each CmdAction can be
+// inspected using Errors until then:
+//
+//	var pb radix.PipelineBuilder
+//	for _, key := range keys {
+//		pb.Append(radix.Cmd(nil, "INCR", key))
+//	}
+//	err := client.Do(&pb)
+//	for i, cmdErr := range pb.Errors() {
+//		if cmdErr != nil {
+//			log.Printf("incrementing %q: %v", keys[i], cmdErr)
+//		}
+//	}
+//	pb.Reset()
+//
+//
+// A PipelineBuilder must not be used concurrently, the zero value is ready to
+// be used.
+type PipelineBuilder struct {
+	// MaxBufferedBytes, if greater than zero, is the maximum number of bytes
+	// the marshaled CmdActions may use. If appending a CmdAction would exceed
+	// the limit the current pipeline is performed using AutoFlush first, or if
+	// AutoFlush isn't set ErrPipelineTooLarge is returned.
+	//
+	// A single CmdAction exceeding the limit by itself is always rejected.
+	MaxBufferedBytes int
+
+	// AutoFlush, if set, is the Client the pipeline is performed on once
+	// MaxBufferedBytes would be exceeded.
+	AutoFlush Client
+
+	cmds pipeline
+	buf  bytes.Buffer
+	errs []error
+}
+
+// Append marshals the given CmdAction and appends it to the pipeline. If the
+// CmdAction can't be appended due to MaxBufferedBytes, or if the automatic
+// flush failed, an error is returned and the CmdAction isn't appended.
+func (pb *PipelineBuilder) Append(cmd CmdAction) error {
+	n := pb.buf.Len()
+	if err := cmd.MarshalRESP(&pb.buf); err != nil {
+		pb.buf.Truncate(n)
+		return err
+	} else if pb.MaxBufferedBytes <= 0 || pb.buf.Len() <= pb.MaxBufferedBytes {
+		pb.cmds = append(pb.cmds, cmd)
+		return nil
+	}
+
+	size := pb.buf.Len() - n
+	pb.buf.Truncate(n)
+	if size > pb.MaxBufferedBytes || pb.AutoFlush == nil {
+		return xerrors.Errorf("appending %d bytes to pipeline of %d bytes: %w", size, n, ErrPipelineTooLarge)
+	}
+	if err := pb.Flush(pb.AutoFlush); err != nil {
+		return err
+	}
+	return pb.Append(cmd)
+}
+
+// BufferedBytes returns the number of bytes used by the marshaled CmdActions
+// of the pipeline.
+func (pb *PipelineBuilder) BufferedBytes() int {
+	return pb.buf.Len()
+}
+
+// Len returns the number of CmdActions in the pipeline.
+func (pb *PipelineBuilder) Len() int {
+	return len(pb.cmds)
+}
+
+// Flush performs the pipeline on the given Client, if it's not empty, and
+// resets it afterwards, even if an error was returned.
+func (pb *PipelineBuilder) Flush(c Client) error {
+	if len(pb.cmds) == 0 {
+		return nil
+	}
+	defer pb.Reset()
+	return c.Do(pb)
+}
+
+// Errors returns the error of each CmdAction of the pipeline, in the order
+// they were appended, after the PipelineBuilder was performed. The error of a
+// CmdAction is nil if its response was read successfully. If the responses
+// couldn't be read at all, e.g. due to a network error, the affected
+// CmdActions have that error.
+//
+// Errors returns nil if the PipelineBuilder wasn't performed since it was last
+// reset. Note that Flush resets the PipelineBuilder, so Do must be used
+// instead of Flush to inspect the errors.
+func (pb *PipelineBuilder) Errors() []error {
+	if len(pb.errs) == 0 {
+		return nil
+	}
+	return pb.errs
+}
+
+// Reset removes all CmdActions from the pipeline, retaining the allocated
+// memory.
+func (pb *PipelineBuilder) Reset() {
+	for i := range pb.cmds {
+		pb.cmds[i] = nil
+	}
+	pb.cmds = pb.cmds[:0]
+	pb.buf.Reset()
+	for i := range pb.errs {
+		pb.errs[i] = nil
+	}
+	pb.errs = pb.errs[:0]
+}
+
+// Keys implements the method for the Action interface.
+func (pb *PipelineBuilder) Keys() []string {
+	return pb.cmds.Keys()
+}
+
+// Run implements the method for the Action interface.
+func (pb *PipelineBuilder) Run(c Conn) error {
+	if cap(pb.errs) < len(pb.cmds) {
+		pb.errs = make([]error, len(pb.cmds))
+	} else {
+		pb.errs = pb.errs[:len(pb.cmds)]
+		for i := range pb.errs {
+			pb.errs[i] = nil
+		}
+	}
+
+	if err := c.Encode(pb); err != nil {
+		for i := range pb.errs {
+			pb.errs[i] = err
+		}
+		return err
+	}
+	return pb.cmds.decode(c, pb.errs)
+}
+
+// MarshalRESP implements the method for the resp.Marshaler interface. The
+// already marshaled CmdActions are written as-is.
+func (pb *PipelineBuilder) MarshalRESP(w io.Writer) error {
+	_, err := w.Write(pb.buf.Bytes())
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ErrTxnAborted is returned by an Action created using Txn if EXEC returned a
+// null reply, i.e. if the transaction was aborted because a WATCHed key was
+// modified. Use errors.Is to check for it.
+var ErrTxnAborted = xerrors.New("transaction aborted")
+
+type txn []CmdAction
+
+// Txn returns an Action which performs the given commands in a MULTI/EXEC
+// transaction. MULTI, the commands and EXEC are written in a single write, and
+// their responses are read in a single read, just like with Pipeline.
+//
+// Run will not be called on any of the passed in CmdActions. Instead each
+// element of the EXEC reply is unmarshaled into the receiver of the
+// corresponding CmdAction.
+//
+// If a command is rejected by redis while being queued, e.g. due to a wrong
+// number of arguments, redis aborts the whole transaction and the error for the
+// first rejected command is returned. If a command fails during EXEC the
+// remaining replies are still unmarshaled and the first such error is
+// returned, the same as with Pipeline.
+//
+// If the transaction was aborted because a WATCHed key was modified, an error
+// wrapping ErrTxnAborted is returned and none of the receivers are touched. As
+// WATCH must be called on the same Conn before the transaction, Txn is usually
+// used within WithConn in that case:
+//
+//	err := client.Do(radix.WithConn("foo", func(conn radix.Conn) error {
+//		if err := conn.Do(radix.Cmd(nil, "WATCH", "foo")); err != nil {
+//			return err
+//		}
+//		var curr int
+//		if err := conn.Do(radix.Cmd(&curr, "GET", "foo")); err != nil {
+//			return err
+//		}
+//		return conn.Do(radix.Txn(
+//			radix.FlatCmd(nil, "SET", "foo", curr*2),
+//		))
+//	}))
+//	if errors.Is(err, radix.ErrTxnAborted) {
+//		// retry
+//	}
+//
+func Txn(cmds ...CmdAction) Action {
+	return txn(cmds)
+}
+
+func (t txn) Keys() []string {
+	return pipeline(t).Keys()
+}
+
+func (t txn) Run(c Conn) error {
+	if err := c.Encode(t); err != nil {
+		return err
+	}
+
+	if err := c.Decode(&resp2.Any{}); err != nil {
+		if xerrors.As(err, new(resp.ErrDiscarded)) {
+			pipeline(t).drain(c, len(t)+1)
+		}
+		return xerrors.Errorf("failed to start transaction: %w", err)
+	}
+
+	var queueErr error
+	for _, cmd := range t {
+		err := c.Decode(&resp2.Any{})
+		if err == nil {
+			continue
+		} else if !xerrors.As(err, new(resp.ErrDiscarded)) {
+			return txnErr(cmd, err)
+		} else if queueErr == nil {
+			queueErr = txnErr(cmd, err)
+		}
+	}
+
+	// if a command was rejected EXEC returns an EXECABORT error, in which case
+	// the error of the rejected command is more useful
+	err := c.Decode(txnExec(t))
+	if err != nil && (queueErr == nil || !xerrors.As(err, new(resp.ErrDiscarded))) {
+		return err
+	}
+	return queueErr
+}
+
+// MarshalRESP implements the resp.Marshaler interface, writing the commands
+// wrapped in MULTI and EXEC. See pipeline.MarshalRESP for why this is done in a
+// single call to Conn.Encode.
+func (t txn) MarshalRESP(w io.Writer) error {
+	if err := Cmd(nil, "MULTI").MarshalRESP(w); err != nil {
+		return err
+	} else if err := pipeline(t).MarshalRESP(w); err != nil {
+		return err
+	}
+	return Cmd(nil, "EXEC").MarshalRESP(w)
+}
+
+func txnErr(cmd CmdAction, err error) error {
+	return xerrors.Errorf("transaction CmdAction '%v' failed: %w", cmd, err)
+}
+
+// txnExec unmarshals the reply to EXEC into the receivers of the CmdActions of
+// a txn.
+type txnExec []CmdAction
+
+func (te txnExec) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 0 {
+		return resp.ErrDiscarded{Err: ErrTxnAborted}
+	} else if ah.N != len(te) {
+		for i := 0; i < ah.N; i++ {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+		}
+		return resp.ErrDiscarded{
+			Err: fmt.Errorf("expected EXEC reply of size %d but got one of size %d", len(te), ah.N),
+		}
+	}
+
+	var firstErr error
+	for _, cmd := range te {
+		err := cmd.UnmarshalRESP(br)
+		if err == nil {
+			continue
+		} else if !xerrors.As(err, new(resp.ErrDiscarded)) {
+			return txnErr(cmd, err)
+		} else if firstErr == nil {
+			firstErr = txnErr(cmd, err)
+		}
+	}
+	return firstErr
+}
+
+type watchTxn struct {
+	keys    []string
+	retries int
+	fn      func(Conn) ([]CmdAction, error)
+}
+
+// WatchTxn returns an Action which performs an optimistic transaction on the
+// given keys. It calls WATCH for the keys and then fn, which can read the
+// current values using the given Conn and returns the CmdActions to perform in
+// a transaction using Txn.
+//
+// If the transaction is aborted because one of the keys was modified, WATCH and
+// fn are called again, up to retries times. Once there are no retries left the
+// error wrapping ErrTxnAborted is returned. If fn returns an error or no
+// CmdActions UNWATCH is called and fn's error returned, without performing a
+// transaction.
+//
+// When using a Cluster all keys must belong to the same slot.
+//
+//	err := client.Do(radix.WatchTxn([]string{"foo"}, 3, func(conn radix.Conn) ([]radix.CmdAction, error) {
+//		var curr int
+//		if err := conn.Do(radix.Cmd(&curr, "GET", "foo")); err != nil {
+//			return nil, err
+//		}
+//		return []radix.CmdAction{radix.FlatCmd(nil, "SET", "foo", curr*2)}, nil
+//	}))
+//
+func WatchTxn(keys []string, retries int, fn func(conn Conn) ([]CmdAction, error)) Action {
+	return &watchTxn{keys: keys, retries: retries, fn: fn}
+}
+
+func (wt *watchTxn) Keys() []string {
+	return wt.keys
+}
+
+func (wt *watchTxn) Run(c Conn) error {
+	var err error
+	for i := 0; i == 0 || i <= wt.retries; i++ {
+		if err = c.Do(Cmd(nil, "WATCH", wt.keys...)); err != nil {
+			return err
+		}
+
+		cmds, fnErr := wt.fn(c)
+		if fnErr != nil || len(cmds) == 0 {
+			if err = c.Do(Cmd(nil, "UNWATCH")); fnErr != nil {
+				return fnErr
+			}
+			return err
+		}
+
+		if err = c.Do(Txn(cmds...)); !xerrors.Is(err, ErrTxnAborted) {
+			return err
+		}
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 type withConn struct {
 	key [1]string // use array to avoid allocation in Keys
 	fn  func(Conn) error
@@ -620,3 +1804,51 @@ func (wc *withConn) Keys() []string {
 func (wc *withConn) Run(c Conn) error {
 	return wc.fn(c)
 }
+
+////////////////////////////////////////////////////////////////////////////////
+
+type noReplyAction struct {
+	cmds []CmdAction
+}
+
+// NoReply returns an Action which performs the given CmdActions without redis
+// sending any replies for them, using CLIENT REPLY. This saves redis from
+// sending, and the Conn from reading, the replies of commands whose result
+// isn't needed, e.g. for high-throughput writes of metrics.
+//
+// A single CmdAction is preceded by CLIENT REPLY SKIP, and the Action returns
+// as soon as it was written, without waiting for redis at all. Multiple
+// CmdActions are wrapped in CLIENT REPLY OFF and CLIENT REPLY ON, which are
+// written together with the CmdActions and only the reply to CLIENT REPLY ON is
+// read, so that a single round-trip is needed and it's known that redis
+// processed all commands once the Action returned.
+//
+// The receivers of the CmdActions are left untouched, and any errors returned
+// by their commands, including MOVED and ASK errors, are lost. NoReply must not
+// be used with instances which don't support CLIENT REPLY, e.g. redis versions
+// before 3.2 and some proxies, as the Conn would be left with unread replies.
+func NoReply(cmds ...CmdAction) Action {
+	return &noReplyAction{cmds: cmds}
+}
+
+func (nr *noReplyAction) Keys() []string {
+	return pipeline(nr.cmds).Keys()
+}
+
+func (nr *noReplyAction) Run(c Conn) error {
+	if len(nr.cmds) == 0 {
+		return nil
+	} else if len(nr.cmds) == 1 {
+		return c.Encode(pipeline{Cmd(nil, "CLIENT", "REPLY", "SKIP"), nr.cmds[0]})
+	}
+
+	replyOn := Cmd(nil, "CLIENT", "REPLY", "ON")
+	p := make(pipeline, 0, len(nr.cmds)+2)
+	p = append(p, Cmd(nil, "CLIENT", "REPLY", "OFF"))
+	p = append(p, nr.cmds...)
+	p = append(p, replyOn)
+	if err := c.Encode(p); err != nil {
+		return err
+	}
+	return c.Decode(replyOn)
+}
@@ -3,8 +3,12 @@ package radix
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha1"
 	"fmt"
+	"net"
+	"strings"
 	. "testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -31,6 +35,47 @@ func TestCmdAction(t *T) {
 	var dstval string
 	require.Nil(t, c.Do(Cmd(&dstval, "GET", key+key)))
 	assert.Equal(t, val, dstval)
+
+	// binary arguments containing NUL bytes and CRLF sequences
+	bkey, bval := []byte(key+"\x00\r\n"), []byte("\r\n\x00"+val)
+	require.Nil(t, c.Do(CmdBytes(nil, "SET", bkey, bval)))
+	var bgot []byte
+	require.Nil(t, c.Do(CmdBytes(&bgot, "GET", bkey)))
+	assert.Equal(t, bval, bgot)
+}
+
+func TestCmdBytes(t *T) {
+	key := []byte("foo\x00bar\r\nbaz")
+	val := []byte("\r\n\x00\xff\r\n")
+
+	var got [][]string
+	m := map[string]string{}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = append(got, args)
+		switch args[0] {
+		case "SET":
+			m[args[1]] = args[2]
+			return "OK"
+		case "GET":
+			return m[args[1]]
+		default:
+			return xerrors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	setCmd := CmdBytes(nil, "SET", key, val)
+	assert.Equal(t, []string{string(key)}, setCmd.Keys())
+	// the keys are only converted once
+	assert.Zero(t, AllocsPerRun(10, func() { setCmd.Keys() }))
+	require.NoError(t, stub.Do(setCmd))
+
+	var res []byte
+	require.NoError(t, stub.Do(CmdBytes(&res, "GET", key)))
+	assert.Equal(t, val, res)
+	assert.Equal(t, [][]string{
+		{"SET", string(key), string(val)},
+		{"GET", string(key)},
+	}, got)
 }
 
 func TestCmdActionStreams(t *T) {
@@ -103,6 +148,37 @@ func ExampleCmd() {
 	// Output: bar
 }
 
+func TestCmdActionBlockDuration(t *T) {
+	type blockTest struct {
+		a     Action
+		expD  time.Duration
+		expOK bool
+	}
+	tests := []blockTest{
+		{Cmd(nil, "GET", "foo"), 0, false},
+		{Cmd(nil, "BLPOP", "foo", "bar", "1.5"), 1500 * time.Millisecond, true},
+		{Cmd(nil, "brpop", "foo", "0"), 0, true},
+		{CmdBytes(nil, "BZPOPMIN", []byte("foo"), []byte("2")), 2 * time.Second, true},
+		{Cmd(nil, "BLMOVE", "foo", "bar", "LEFT", "RIGHT", "3"), 3 * time.Second, true},
+		{Cmd(nil, "BLMPOP", "0.5", "1", "foo", "LEFT"), 500 * time.Millisecond, true},
+		{Cmd(nil, "WAIT", "1", "100"), 100 * time.Millisecond, true},
+		{Cmd(nil, "XREAD", "COUNT", "1", "BLOCK", "250", "STREAMS", "foo", "$"), 250 * time.Millisecond, true},
+		{Cmd(nil, "XREAD", "STREAMS", "BLOCK", "0"), 0, false},
+		{Cmd(nil, "XREADGROUP", "GROUP", "BLOCK", "BLOCK", "STREAMS", "foo", ">"), 0, false},
+		{Cmd(nil, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "foo", ">"), 0, true},
+		{Cmd(nil, "BLPOP", "foo", "forever"), 0, false},
+		{FlatCmd(nil, "BLPOP", "foo", 1), 0, false},
+		{WithTimeout(WithCommandName(Cmd(nil, "BLPOP", "foo", "1"), "pop"), time.Second), time.Second, true},
+		{Pipeline(Cmd(nil, "BLPOP", "foo", "1")), 0, false},
+	}
+
+	for i, test := range tests {
+		d, ok := blockDuration(test.a)
+		assert.Equal(t, test.expD, d, "test %d", i)
+		assert.Equal(t, test.expOK, ok, "test %d", i)
+	}
+}
+
 func TestFlatCmdAction(t *T) {
 	c := dial()
 	key := randStr()
@@ -145,6 +221,54 @@ func TestFlatCmdActionEmpty(t *T) {
 	require.True(t, nilVal.EmptyArray)
 }
 
+func TestFlatCmdActionSlices(t *T) {
+	var got []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = args
+		return len(args) - 1
+	})
+
+	tests := []struct {
+		cmd  CmdAction
+		exp  []string
+		keys []string
+	}{
+		{
+			cmd:  FlatCmd(nil, "DEL", "a", []string{"b", "c"}),
+			exp:  []string{"DEL", "a", "b", "c"},
+			keys: []string{"a"},
+		},
+		{
+			cmd:  FlatCmd(nil, "DEL", "a", []string{}),
+			exp:  []string{"DEL", "a"},
+			keys: []string{"a"},
+		},
+		{
+			cmd:  FlatCmd(nil, "SADD", "set", []int{1, 2, 3}),
+			exp:  []string{"SADD", "set", "1", "2", "3"},
+			keys: []string{"set"},
+		},
+		{
+			cmd:  FlatCmd(nil, "SADD", "set", []string{"a"}, []int{}, []string{"b", "c"}),
+			exp:  []string{"SADD", "set", "a", "b", "c"},
+			keys: []string{"set"},
+		},
+		{
+			cmd:  FlatCmd(nil, "SADD", "set", [][]string{{"a", "b"}, {}, {"c"}}),
+			exp:  []string{"SADD", "set", "a", "b", "c"},
+			keys: []string{"set"},
+		},
+	}
+
+	for _, test := range tests {
+		// Keys must be checked before the action is performed, as it's reused
+		// afterwards
+		assert.Equal(t, test.keys, test.cmd.Keys())
+		require.NoError(t, stub.Do(test.cmd))
+		assert.Equal(t, test.exp, got)
+	}
+}
+
 func ExampleFlatCmd() {
 	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
 	if err != nil {
@@ -205,6 +329,101 @@ func TestEvalAction(t *T) {
 	}
 }
 
+func TestEvalActionNoScriptFallback(t *T) {
+	const script = "return ARGV[1]"
+	sum := fmt.Sprintf("%x", sha1.Sum([]byte(script)))
+
+	scripts := map[string]bool{}
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch args[0] {
+		case "SCRIPT":
+			scripts = map[string]bool{}
+			return "OK"
+		case "EVALSHA":
+			if !scripts[args[1]] {
+				return resp2.Error{E: xerrors.New("NOSCRIPT No matching script. Please use EVAL.")}
+			}
+		case "EVAL":
+			scripts[fmt.Sprintf("%x", sha1.Sum([]byte(args[1])))] = true
+		}
+		return args[len(args)-1]
+	})
+
+	es := NewEvalScript(2, script)
+	do := func() {
+		var res string
+		require.NoError(t, stub.Do(es.Cmd(&res, "k1", "k2", "a1", "a2")))
+		assert.Equal(t, "a2", res)
+	}
+
+	do()
+	do()
+	require.NoError(t, stub.Do(Cmd(nil, "SCRIPT", "FLUSH")))
+	do()
+
+	assert.Equal(t, [][]string{
+		{"EVALSHA", sum, "2", "k1", "k2", "a1", "a2"},
+		{"EVAL", script, "2", "k1", "k2", "a1", "a2"},
+		{"EVALSHA", sum, "2", "k1", "k2", "a1", "a2"},
+		{"SCRIPT", "FLUSH"},
+		{"EVALSHA", sum, "2", "k1", "k2", "a1", "a2"},
+		{"EVAL", script, "2", "k1", "k2", "a1", "a2"},
+	}, cmds)
+}
+
+func TestEvalActionCmdStruct(t *T) {
+	script := NewEvalScript(2, `return redis.call("SET", KEYS[1], ARGV[1])`)
+
+	type base struct {
+		TTL int
+	}
+	type keys struct {
+		Src    string
+		Dst    []byte
+		ignore string
+	}
+	type args struct {
+		Value   string
+		Skipped string `redis:"-"`
+		base
+		Tags    []string
+		Version float64
+	}
+
+	var got []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = args
+		return "OK"
+	})
+
+	cmd := script.CmdStruct(nil, &keys{Src: "a", Dst: []byte("b"), ignore: "c"}, args{
+		Value:   "v",
+		Skipped: "s",
+		base:    base{TTL: 10},
+		Tags:    []string{"x", "y"},
+		Version: 1.5,
+	})
+	assert.Equal(t, []string{"a", "b"}, cmd.Keys())
+	require.NoError(t, stub.Do(cmd))
+	assert.Equal(t, []string{"2", "a", "b", "v", "10", "x", "y", "1.5"}, got[2:])
+
+	// no args
+	require.NoError(t, stub.Do(script.CmdStruct(nil, keys{Src: "a", Dst: []byte("b")}, nil)))
+	assert.Equal(t, []string{"2", "a", "b"}, got[2:])
+
+	assert.Panics(t, func() {
+		script.CmdStruct(nil, struct{ A string }{"a"}, nil)
+	})
+	assert.Panics(t, func() {
+		script.CmdStruct(nil, struct{ A, B []string }{[]string{"a", "b"}, nil}, nil)
+	})
+	assert.Panics(t, func() {
+		script.CmdStruct(nil, []string{"a", "b"}, nil)
+	})
+}
+
 func TestEvalActionWithInterfaceRcv(t *T) {
 	simpleScript := NewEvalScript(0, `
 		return 123
@@ -295,6 +514,281 @@ func TestPipelineAction(t *T) {
 	})
 }
 
+func TestPipelineActionErrors(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "ERR" {
+			return resp2.Error{E: xerrors.New(args[1])}
+		}
+		return args[1]
+	})
+
+	var a, b, c string
+	var d int
+	err := stub.Do(Pipeline(
+		Cmd(&a, "ECHO", "a"),
+		Cmd(nil, "ERR", "first"),
+		Cmd(&b, "ECHO", "b"),
+		Cmd(nil, "ERR", "second"),
+		Cmd(&d, "ECHO", "d"),
+		Cmd(&c, "ECHO", "c"),
+	))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "first")
+	assert.True(t, xerrors.As(err, new(resp2.Error)))
+	assert.Equal(t, "a", a)
+	assert.Equal(t, "b", b)
+	assert.Equal(t, "c", c)
+
+	// the Conn must still be usable afterwards
+	require.NoError(t, stub.Do(Cmd(&a, "ECHO", "foo")))
+	assert.Equal(t, "foo", a)
+}
+
+func TestPipelineBuilder(t *T) {
+	var received []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		received = append(received, args[1])
+		return args[1]
+	})
+
+	// each ECHO with a single character argument is 21 bytes
+	rcvs := make([]string, 5)
+	echo := func(i int) CmdAction {
+		return Cmd(&rcvs[i], "ECHO", string(rune('a'+i)))
+	}
+
+	var pb PipelineBuilder
+	pb.MaxBufferedBytes = 50
+	require.NoError(t, pb.Append(echo(0)))
+	require.NoError(t, pb.Append(echo(1)))
+	assert.Equal(t, 2, pb.Len())
+	assert.Equal(t, 42, pb.BufferedBytes())
+
+	err := pb.Append(echo(2))
+	assert.True(t, xerrors.Is(err, ErrPipelineTooLarge))
+	assert.Equal(t, 2, pb.Len())
+	assert.Equal(t, 42, pb.BufferedBytes())
+
+	require.NoError(t, stub.Do(&pb))
+	assert.Equal(t, []string{"a", "b", "", "", ""}, rcvs)
+
+	pb.Reset()
+	assert.Zero(t, pb.Len())
+	assert.Zero(t, pb.BufferedBytes())
+
+	// with AutoFlush set the pipeline is flushed once it's full
+	received = nil
+	pb.AutoFlush = stub
+	for i := range rcvs {
+		require.NoError(t, pb.Append(echo(i)))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, received)
+	assert.Equal(t, 1, pb.Len())
+	require.NoError(t, pb.Flush(stub))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, received)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, rcvs)
+	assert.Zero(t, pb.Len())
+
+	// a single CmdAction exceeding the limit is always rejected
+	err = pb.Append(Cmd(nil, "ECHO", string(make([]byte, 50))))
+	assert.True(t, xerrors.Is(err, ErrPipelineTooLarge))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, received)
+}
+
+func TestPipelineBuilderErrors(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "ERR" {
+			return resp2.Error{E: xerrors.New(args[1])}
+		}
+		return args[1]
+	})
+
+	var pb PipelineBuilder
+	assert.Nil(t, pb.Errors())
+
+	var a, c string
+	require.NoError(t, pb.Append(Cmd(&a, "ECHO", "a")))
+	require.NoError(t, pb.Append(Cmd(nil, "ERR", "b")))
+	require.NoError(t, pb.Append(Cmd(&c, "ECHO", "c")))
+	require.NoError(t, pb.Append(Cmd(nil, "ERR", "d")))
+
+	err := stub.Do(&pb)
+	require.Error(t, err)
+	assert.Equal(t, "a", a)
+	assert.Equal(t, "c", c)
+
+	errs := pb.Errors()
+	require.Len(t, errs, 4)
+	assert.NoError(t, errs[0])
+	assert.Equal(t, err, errs[1])
+	assert.Contains(t, errs[1].Error(), "b")
+	assert.NoError(t, errs[2])
+	assert.Contains(t, errs[3].Error(), "d")
+
+	// the PipelineBuilder can be reused after a Reset
+	pb.Reset()
+	assert.Nil(t, pb.Errors())
+	require.NoError(t, pb.Append(Cmd(&a, "ECHO", "e")))
+	require.NoError(t, stub.Do(&pb))
+	assert.Equal(t, "e", a)
+	assert.Equal(t, []error{nil}, pb.Errors())
+}
+
+func TestTxnAction(t *T) {
+	// newStub returns a Stub which implements a small subset of MULTI/EXEC. If
+	// abort is true then EXEC behaves as if a WATCHed key was modified.
+	newStub := func(abort bool) Conn {
+		var queued []interface{}
+		var inMulti, dirty bool
+		return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+			switch {
+			case args[0] == "MULTI":
+				inMulti, dirty, queued = true, false, nil
+				return "OK"
+			case args[0] == "EXEC":
+				inMulti = false
+				if dirty {
+					return resp2.Error{E: xerrors.New("EXECABORT Transaction discarded")}
+				} else if abort {
+					return resp2.Array{}
+				}
+				return queued
+			case args[0] != "ECHO" && args[0] != "ERR":
+				if inMulti {
+					dirty = true
+				}
+				return resp2.Error{E: xerrors.Errorf("ERR unknown command %q", args[0])}
+			case !inMulti && args[0] == "ECHO":
+				return args[1]
+			case args[0] == "ECHO":
+				queued = append(queued, args[1])
+			default:
+				queued = append(queued, resp2.Error{E: xerrors.New(args[1])})
+			}
+			return resp2.SimpleString{S: "QUEUED"}
+		})
+	}
+
+	t.Run("success", func(t *T) {
+		stub := newStub(false)
+		var a, b string
+		require.NoError(t, stub.Do(Txn(
+			Cmd(&a, "ECHO", "a"),
+			Cmd(&b, "ECHO", "b"),
+		)))
+		assert.Equal(t, "a", a)
+		assert.Equal(t, "b", b)
+	})
+
+	t.Run("exec error", func(t *T) {
+		stub := newStub(false)
+		var a, b string
+		err := stub.Do(Txn(
+			Cmd(&a, "ECHO", "a"),
+			Cmd(nil, "ERR", "failed"),
+			Cmd(&b, "ECHO", "b"),
+		))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed")
+		assert.True(t, xerrors.As(err, new(resp2.Error)))
+		assert.Equal(t, "a", a)
+		assert.Equal(t, "b", b)
+	})
+
+	t.Run("queue error", func(t *T) {
+		stub := newStub(false)
+		var a string
+		err := stub.Do(Txn(
+			Cmd(&a, "ECHO", "a"),
+			Cmd(nil, "NOPE"),
+		))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown command")
+		assert.True(t, xerrors.As(err, new(resp2.Error)))
+		assert.Empty(t, a)
+	})
+
+	t.Run("aborted", func(t *T) {
+		stub := newStub(true)
+		a := "unchanged"
+		err := stub.Do(Txn(Cmd(&a, "ECHO", "a")))
+		assert.True(t, xerrors.Is(err, ErrTxnAborted), "err: %v", err)
+		assert.True(t, xerrors.As(err, new(resp.ErrDiscarded)))
+		assert.Equal(t, "unchanged", a)
+
+		// the Conn must still be usable afterwards
+		require.NoError(t, stub.Do(Cmd(&a, "ECHO", "foo")))
+		assert.Equal(t, "foo", a)
+	})
+}
+
+func TestWatchTxn(t *T) {
+	// newStub returns a Stub whose EXEC replies are aborted the first aborts
+	// times. Only SET can be queued.
+	newStub := func(aborts int) (Conn, *[]string) {
+		var cmds []string
+		var queued []interface{}
+		return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+			cmds = append(cmds, strings.Join(args, " "))
+			switch args[0] {
+			case "WATCH", "UNWATCH", "MULTI":
+				queued = nil
+				return "OK"
+			case "GET":
+				return "1"
+			case "SET":
+				queued = append(queued, "OK")
+				return resp2.SimpleString{S: "QUEUED"}
+			case "EXEC":
+				if aborts > 0 {
+					aborts--
+					return resp2.Array{}
+				}
+				return queued
+			}
+			return resp2.Error{E: xerrors.Errorf("ERR unknown command %q", args[0])}
+		}), &cmds
+	}
+
+	var calls int
+	fn := func(conn Conn) ([]CmdAction, error) {
+		calls++
+		var curr int
+		if err := conn.Do(Cmd(&curr, "GET", "foo")); err != nil {
+			return nil, err
+		}
+		return []CmdAction{FlatCmd(nil, "SET", "foo", curr*2)}, nil
+	}
+
+	t.Run("retried", func(t *T) {
+		calls = 0
+		stub, cmds := newStub(2)
+		a := WatchTxn([]string{"foo"}, 2, fn)
+		assert.Equal(t, []string{"foo"}, a.Keys())
+		require.NoError(t, stub.Do(a))
+		assert.Equal(t, 3, calls)
+		assert.Equal(t, []string{"WATCH foo", "GET foo", "MULTI", "SET foo 2", "EXEC"}, (*cmds)[10:])
+	})
+
+	t.Run("no retries left", func(t *T) {
+		calls = 0
+		stub, _ := newStub(2)
+		err := stub.Do(WatchTxn([]string{"foo"}, 1, fn))
+		assert.True(t, xerrors.Is(err, ErrTxnAborted), "err: %v", err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("fn error", func(t *T) {
+		stub, cmds := newStub(0)
+		fnErr := xerrors.New("fn failed")
+		err := stub.Do(WatchTxn([]string{"foo", "bar"}, 1, func(Conn) ([]CmdAction, error) {
+			return nil, fnErr
+		}))
+		assert.Equal(t, fnErr, err)
+		assert.Equal(t, []string{"WATCH foo bar", "UNWATCH"}, *cmds)
+	})
+}
+
 func ExamplePipeline() {
 	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
 	if err != nil {
@@ -412,6 +906,7 @@ func TestMaybeNil(t *T) {
 	}{
 		{b: "$-1\r\n", isNil: true},
 		{b: "*-1\r\n", isNil: true},
+		{b: "_\r\n", isNil: true},
 		{b: "+foo\r\n"},
 		{b: "-\r\n"},
 		{b: "-foo\r\n"},
@@ -444,6 +939,35 @@ func TestMaybeNil(t *T) {
 	}
 }
 
+func TestMaybeNilResp3(t *T) {
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		switch args[0] {
+		case "HELLO":
+			return resp2.RawMessage("%1\r\n$5\r\nproto\r\n:3\r\n")
+		case "GET":
+			// RESP3 replies with a null instead of a nil bulk string
+			return resp2.RawMessage("_\r\n")
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", addr, DialProtocol(3))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"HELLO", "3"}, <-cmdCh)
+
+	var val string
+	mn := MaybeNil{Rcv: &val}
+	require.NoError(t, c.Do(Cmd(&mn, "GET", "foo")))
+	assert.True(t, mn.Nil)
+	assert.Empty(t, val)
+
+	// the connection must still be usable afterwards
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, []string{"GET", "foo"}, <-cmdCh)
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+}
+
 func ExampleMaybeNil() {
 	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
 	if err != nil {
@@ -524,6 +1048,195 @@ func TestTuple(t *T) {
 	}
 }
 
+func TestEnum(t *T) {
+	type keyType int
+	const (
+		keyTypeUnknown keyType = iota
+		keyTypeString
+		keyTypeHash
+		keyTypeOther
+	)
+	values := map[string]keyType{
+		"string": keyTypeString,
+		"hash":   keyTypeHash,
+	}
+
+	tests := []struct {
+		in           string
+		def          interface{}
+		errOnUnknown bool
+		exp          keyType
+		expErr       bool
+	}{
+		{in: "+string\r\n", exp: keyTypeString},
+		{in: "$4\r\nhash\r\n", exp: keyTypeHash},
+		{in: "+none\r\n", exp: keyTypeUnknown},
+		{in: "+none\r\n", def: keyTypeOther, exp: keyTypeOther},
+		{in: "+none\r\n", errOnUnknown: true, expErr: true},
+		{in: "+hash\r\n", errOnUnknown: true, exp: keyTypeHash},
+		{in: "-ERR foo\r\n", expErr: true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprint(i), func(t *T) {
+			buf := bytes.NewBufferString(test.in)
+			br := bufio.NewReader(buf)
+
+			var got keyType
+			e := Enum{Rcv: &got, Values: values, Default: test.def, ErrOnUnknown: test.errOnUnknown}
+			err := e.UnmarshalRESP(br)
+			assert.Empty(t, buf.Bytes())
+			assert.Zero(t, br.Buffered())
+			if test.expErr {
+				assert.Error(t, err)
+				assert.True(t, xerrors.As(err, new(resp.ErrDiscarded)))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got)
+		})
+	}
+
+	t.Run("mismatched types", func(t *T) {
+		var got string
+		e := Enum{Rcv: &got, Values: values}
+		err := e.UnmarshalRESP(bufio.NewReader(bytes.NewBufferString("+hash\r\n")))
+		assert.Error(t, err)
+	})
+}
+
+func TestIntResult(t *T) {
+	tests := []struct {
+		cmd, in string
+		exp     IntResult
+	}{
+		{cmd: "TTL", in: ":10\r\n", exp: IntResult{Value: 10}},
+		{cmd: "TTL", in: ":-1\r\n", exp: IntResult{NoExpiry: true}},
+		{cmd: "pttl", in: ":-2\r\n", exp: IntResult{NotFound: true}},
+		{cmd: "LINSERT", in: ":3\r\n", exp: IntResult{Value: 3}},
+		{cmd: "LINSERT", in: ":-1\r\n", exp: IntResult{NotFound: true}},
+		{cmd: "LINSERT", in: ":0\r\n", exp: IntResult{NotFound: true}},
+		{cmd: "ZRANK", in: "$-1\r\n", exp: IntResult{NotFound: true}},
+		{cmd: "ZRANK", in: ":0\r\n", exp: IntResult{Value: 0}},
+		{cmd: "INCRBY", in: ":-2\r\n", exp: IntResult{Value: -2}},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprint(i), func(t *T) {
+			// fields from a previous use are reset
+			res := IntResult{Cmd: test.cmd, Value: 5, NoExpiry: true}
+			require.NoError(t, res.UnmarshalRESP(bufio.NewReader(bytes.NewBufferString(test.in))))
+			test.exp.Cmd = test.cmd
+			assert.Equal(t, test.exp, res)
+		})
+	}
+
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return -1
+	})
+	res := IntResult{Cmd: "TTL"}
+	require.NoError(t, stub.Do(Cmd(&res, "TTL", "foo")))
+	assert.True(t, res.NoExpiry)
+}
+
+func TestUnixTime(t *T) {
+	tests := []struct {
+		in   string
+		unit func(*time.Time) resp.Unmarshaler
+		exp  time.Time
+	}{
+		{in: ":1700000000\r\n", unit: UnixSeconds, exp: time.Unix(1700000000, 0)},
+		{in: ":1700000000123\r\n", unit: UnixMillis, exp: time.Unix(1700000000, 123e6)},
+		{in: "$10\r\n1700000000\r\n", unit: UnixSeconds, exp: time.Unix(1700000000, 0)},
+		{in: ":-1\r\n", unit: UnixSeconds},
+		{in: ":-2\r\n", unit: UnixMillis},
+		{in: "$-1\r\n", unit: UnixMillis},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprint(i), func(t *T) {
+			ts := time.Now()
+			require.NoError(t, test.unit(&ts).UnmarshalRESP(bufio.NewReader(bytes.NewBufferString(test.in))))
+			assert.True(t, test.exp.Equal(ts), "expected %v, got %v", test.exp, ts)
+			assert.Equal(t, test.exp.IsZero(), ts.IsZero())
+		})
+	}
+
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return 1700000000500
+	})
+	var ts time.Time
+	require.NoError(t, stub.Do(Cmd(UnixMillis(&ts), "PEXPIRETIME", "foo")))
+	assert.Equal(t, int64(1700000000500), ts.UnixNano()/1e6)
+}
+
+func TestPath(t *T) {
+	reply := []interface{}{
+		"length", 2,
+		"groups", []interface{}{
+			[]interface{}{
+				"name", "g1",
+				"pending", []interface{}{
+					[]interface{}{"1-0", "alice"},
+					[]interface{}{"2-0", "bob"},
+				},
+			},
+			[]interface{}{"name", "g2", "pending", []interface{}{}},
+		},
+		"odd", []interface{}{"a", "b", "c"},
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, resp2.Any{I: reply}.MarshalRESP(buf))
+	in := buf.String()
+
+	tests := []struct {
+		elems   []interface{}
+		rcv     interface{}
+		exp     interface{}
+		missing bool
+		expErr  bool
+	}{
+		{elems: []interface{}{"odd"}, rcv: new([]string), exp: &[]string{"a", "b", "c"}},
+		{elems: []interface{}{"length"}, rcv: new(int), exp: func() *int { i := 2; return &i }()},
+		{elems: []interface{}{"groups", 1, "name"}, rcv: new(string), exp: func() *string { s := "g2"; return &s }()},
+		{elems: []interface{}{"groups", 0, "pending", 1}, rcv: new([]string), exp: &[]string{"2-0", "bob"}},
+		{elems: []interface{}{"groups", 0, "pending", 1, 0}, rcv: new(StreamEntryID), exp: &StreamEntryID{Time: 2}},
+		{elems: []interface{}{"groups", 2, "name"}, rcv: new(string), exp: new(string), missing: true},
+		{elems: []interface{}{"groups", 0, "foo"}, rcv: new(string), exp: new(string), missing: true},
+		{elems: []interface{}{"length", 0}, rcv: new(string), exp: new(string), missing: true},
+		{elems: []interface{}{"odd", "a"}, rcv: new(string), exp: new(string), missing: true},
+		{elems: []interface{}{"groups", 0, "name"}, rcv: new(int), expErr: true},
+		{elems: []interface{}{"groups", 1.5}, rcv: new(string), expErr: true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprint(i), func(t *T) {
+			buf := bytes.NewBufferString(in)
+			br := bufio.NewReader(buf)
+
+			p := Path{Elems: test.elems, Rcv: test.rcv}
+			err := p.UnmarshalRESP(br)
+			assert.Empty(t, buf.Bytes())
+			assert.Zero(t, br.Buffered())
+			if test.expErr {
+				assert.Error(t, err)
+				assert.True(t, xerrors.As(err, new(resp.ErrDiscarded)))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.missing, p.Missing)
+			assert.Equal(t, test.exp, test.rcv)
+		})
+	}
+
+	t.Run("error reply", func(t *T) {
+		p := Path{Elems: []interface{}{"foo"}, Rcv: new(string)}
+		err := p.UnmarshalRESP(bufio.NewReader(bytes.NewBufferString("-ERR foo\r\n")))
+		assert.True(t, xerrors.As(err, new(resp2.Error)))
+		assert.False(t, p.Missing)
+	})
+}
+
 var benchCmdActionKeys []string // global variable used to store the action keys in benchmarks
 
 func BenchmarkCmdActionKeys(b *B) {
@@ -543,3 +1256,136 @@ func BenchmarkWithConnKeys(b *B) {
 		benchCmdActionKeys = WithConn("a", func(Conn) error { return nil }).Keys()
 	}
 }
+
+func TestWithDeadline(t *T) {
+	addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+		if args[0] == "SLOW" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", addr, DialReadTimeout(0), DialWriteTimeout(0))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Do(WithTimeout(Cmd(nil, "FAST"), 50*time.Millisecond)))
+	require.NoError(t, c.Do(WithDeadline(Cmd(nil, "FAST"), time.Now().Add(50*time.Millisecond))))
+
+	// the deadline must not stick around after the Action completed
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, c.Do(Cmd(nil, "FAST")))
+
+	err = c.Do(WithTimeout(Cmd(nil, "SLOW"), 10*time.Millisecond))
+	var netErr net.Error
+	require.True(t, xerrors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+}
+
+func TestWithClientCaching(t *T) {
+	var got [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = append(got, args)
+		if args[0] == "CLIENT" {
+			return resp2.SimpleString{S: "OK"}
+		}
+		return "bar"
+	})
+
+	var res string
+	a := WithClientCaching(Cmd(&res, "GET", "foo"), true)
+	assert.Equal(t, []string{"foo"}, a.Keys())
+	require.NoError(t, stub.Do(a))
+	assert.Equal(t, "bar", res)
+
+	require.NoError(t, stub.Do(WithClientCaching(WithConn("foo", func(conn Conn) error {
+		return conn.Do(Cmd(nil, "GET", "foo"))
+	}), false)))
+
+	assert.Equal(t, [][]string{
+		{"CLIENT", "CACHING", "YES"},
+		{"GET", "foo"},
+		{"CLIENT", "CACHING", "NO"},
+		{"GET", "foo"},
+	}, got)
+}
+
+func TestWithStrictReply(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "HGETALL":
+			return []string{"name", "foo", "age", "5"}
+		case "TTL":
+			return int64(-1)
+		}
+		return nil
+	})
+
+	type user struct {
+		Name string `redis:"name"`
+	}
+
+	var u user
+	require.NoError(t, stub.Do(Cmd(&u, "HGETALL", "user")))
+	assert.Equal(t, "foo", u.Name)
+	err := stub.Do(WithStrictReply(Cmd(&u, "HGETALL", "user")))
+	assert.True(t, xerrors.As(err, new(resp.ErrDiscarded)), "err: %v", err)
+
+	var s string
+	require.NoError(t, stub.Do(FlatCmd(&s, "TTL", "user")))
+	assert.Equal(t, "-1", s)
+	err = stub.Do(WithStrictReply(FlatCmd(&s, "TTL", "user")))
+	assert.True(t, xerrors.As(err, new(resp.ErrDiscarded)), "err: %v", err)
+
+	// within a Pipeline only the wrapped command is strict
+	var n int
+	err = stub.Do(Pipeline(
+		Cmd(&n, "GET", "foo"),
+		WithStrictReply(CmdBytes(&n, "GET", []byte("foo"))),
+	))
+	assert.True(t, xerrors.As(err, new(resp.ErrDiscarded)), "err: %v", err)
+	assert.Contains(t, err.Error(), "cannot unmarshal nil into int")
+
+	// the connection is still usable
+	require.NoError(t, stub.Do(Cmd(&u, "HGETALL", "user")))
+}
+
+func TestNoReply(t *T) {
+	var mode string
+	var cmds [][]string
+	conn := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		if args[0] == "CLIENT" && args[1] == "REPLY" {
+			mode = args[2]
+			if mode != "ON" {
+				return multiMarshal(nil)
+			}
+		} else if mode == "SKIP" {
+			mode = ""
+			return multiMarshal(nil)
+		} else if mode == "OFF" {
+			return multiMarshal(nil)
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	var rcv string
+	require.NoError(t, conn.Do(NoReply(Cmd(&rcv, "INCR", "foo"))))
+	assert.Equal(t, [][]string{{"CLIENT", "REPLY", "SKIP"}, {"INCR", "foo"}}, cmds)
+
+	cmds = nil
+	require.NoError(t, conn.Do(NoReply(Cmd(&rcv, "INCR", "foo"), Cmd(&rcv, "SET", "bar", "1"))))
+	assert.Equal(t, [][]string{
+		{"CLIENT", "REPLY", "OFF"},
+		{"INCR", "foo"},
+		{"SET", "bar", "1"},
+		{"CLIENT", "REPLY", "ON"},
+	}, cmds)
+	assert.Empty(t, rcv)
+
+	// the Conn is still in sync
+	require.NoError(t, conn.Do(Cmd(&rcv, "PING")))
+	assert.Equal(t, "OK", rcv)
+
+	assert.ElementsMatch(t, []string{"foo", "bar"}, NoReply(Cmd(nil, "INCR", "foo"), Cmd(nil, "SET", "bar", "1")).Keys())
+}
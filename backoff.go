@@ -0,0 +1,105 @@
+package radix
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff determines how long to wait before retrying an operation which
+// failed. It is accepted by all options which configure how something is
+// retried, e.g. ReconnectBackoff, ClusterRedirectBackoff, MutexRetryBackoff
+// and PersistentPubSubBackoff.
+//
+// Implementations must be safe for concurrent use.
+type Backoff interface {
+	// Next returns the duration to wait before the given attempt, where
+	// attempt is 1 for the first retry, 2 for the second and so on.
+	Next(attempt int) time.Duration
+}
+
+// BackoffFunc is an adapter which allows using an ordinary function as a
+// Backoff.
+type BackoffFunc func(attempt int) time.Duration
+
+// Next implements the method for the Backoff interface.
+func (fn BackoffFunc) Next(attempt int) time.Duration {
+	return fn(attempt)
+}
+
+// ConstantBackoff returns a Backoff which always waits for the given duration.
+func ConstantBackoff(d time.Duration) Backoff {
+	return BackoffFunc(func(int) time.Duration { return d })
+}
+
+type exponentialBackoff struct {
+	base, max time.Duration
+	jitter    bool
+}
+
+func (eb exponentialBackoff) Next(attempt int) time.Duration {
+	d := eb.base
+	for i := 1; i < attempt && (eb.max <= 0 || d < eb.max) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if eb.max > 0 && d > eb.max {
+		d = eb.max
+	}
+	if eb.jitter && d > 1 {
+		// "equal jitter", i.e. a random duration in [d/2, d)
+		d = d/2 + time.Duration(rand.Int63n(int64(d-d/2)))
+	}
+	return d
+}
+
+// ExponentialBackoff returns a Backoff which waits for base before the first
+// retry, and doubles the duration for each further retry, up to max. If max is
+// zero the duration is not capped.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return exponentialBackoff{base: base, max: max}
+}
+
+// ExponentialJitterBackoff is like ExponentialBackoff, but the returned
+// durations are randomized to be between half of and the full duration
+// ExponentialBackoff would return. This prevents many clients, which all
+// started retrying at the same time, from retrying in lockstep.
+func ExponentialJitterBackoff(base, max time.Duration) Backoff {
+	return exponentialBackoff{base: base, max: max, jitter: true}
+}
+
+type decorrelatedJitterBackoff struct {
+	base, max time.Duration
+
+	l    sync.Mutex
+	prev time.Duration
+}
+
+func (db *decorrelatedJitterBackoff) Next(attempt int) time.Duration {
+	db.l.Lock()
+	defer db.l.Unlock()
+	if attempt <= 1 || db.prev < db.base {
+		db.prev = db.base
+	}
+	d := db.base
+	if upper := db.prev * 3; upper > db.base {
+		d += time.Duration(rand.Int63n(int64(upper - db.base)))
+	}
+	if db.max > 0 && d > db.max {
+		d = db.max
+	}
+	db.prev = d
+	return d
+}
+
+// DecorrelatedJitterBackoff returns a Backoff which waits for a random
+// duration between base and three times the previously returned duration,
+// capped at max, as described in the "Exponential Backoff And Jitter" article
+// of the AWS Architecture Blog. If max is zero the duration is not capped.
+//
+// As each duration depends on the previous one the returned Backoff is best
+// used for a single sequence of retries at a time, it starts over whenever Next
+// is called with an attempt of 1.
+func DecorrelatedJitterBackoff(base, max time.Duration) Backoff {
+	return &decorrelatedJitterBackoff{base: base, max: max}
+}
@@ -0,0 +1,61 @@
+package radix
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *T) {
+	t.Run("constant", func(t *T) {
+		b := ConstantBackoff(time.Second)
+		for attempt := 1; attempt <= 3; attempt++ {
+			assert.Equal(t, time.Second, b.Next(attempt))
+		}
+	})
+
+	t.Run("exponential", func(t *T) {
+		b := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+		var got []time.Duration
+		for attempt := 1; attempt <= 5; attempt++ {
+			got = append(got, b.Next(attempt))
+		}
+		assert.Equal(t, []time.Duration{
+			10 * time.Millisecond,
+			20 * time.Millisecond,
+			40 * time.Millisecond,
+			50 * time.Millisecond,
+			50 * time.Millisecond,
+		}, got)
+
+		// without a maximum the duration keeps doubling, but never overflows
+		b = ExponentialBackoff(10*time.Millisecond, 0)
+		assert.Equal(t, 80*time.Millisecond, b.Next(4))
+		assert.True(t, b.Next(1000) > 0)
+	})
+
+	t.Run("exponential jitter", func(t *T) {
+		b := ExponentialJitterBackoff(10*time.Millisecond, 50*time.Millisecond)
+		for i := 0; i < 100; i++ {
+			d := b.Next(2)
+			assert.True(t, d >= 10*time.Millisecond && d < 20*time.Millisecond, "got %v", d)
+			d = b.Next(10)
+			assert.True(t, d >= 25*time.Millisecond && d < 50*time.Millisecond, "got %v", d)
+		}
+	})
+
+	t.Run("decorrelated jitter", func(t *T) {
+		b := DecorrelatedJitterBackoff(10*time.Millisecond, 100*time.Millisecond)
+		for i := 0; i < 100; i++ {
+			prev := b.Next(1)
+			assert.True(t, prev >= 10*time.Millisecond && prev < 30*time.Millisecond, "got %v", prev)
+			for attempt := 2; attempt <= 5; attempt++ {
+				d := b.Next(attempt)
+				assert.True(t, d >= 10*time.Millisecond && d <= 100*time.Millisecond, "got %v", d)
+				assert.True(t, d < 3*prev || d == 100*time.Millisecond, "got %v after %v", d, prev)
+				prev = d
+			}
+		}
+	})
+}
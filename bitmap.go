@@ -0,0 +1,121 @@
+package radix
+
+import (
+	"strconv"
+)
+
+// SetBit sets the bit at the given offset of the string stored at key to
+// value, which must be 0 or 1, and returns the previous value of the bit, using
+// SETBIT.
+func SetBit(c Client, key string, offset int64, value int) (int, error) {
+	var prev int
+	err := c.Do(Cmd(&prev, "SETBIT", key, strconv.FormatInt(offset, 10), strconv.Itoa(value)))
+	return prev, err
+}
+
+// GetBit returns the value of the bit at the given offset of the string stored
+// at key, using GETBIT.
+func GetBit(c Client, key string, offset int64) (int, error) {
+	var bit int
+	err := c.Do(Cmd(&bit, "GETBIT", key, strconv.FormatInt(offset, 10)))
+	return bit, err
+}
+
+// BitScanner is used to iterate through the offsets of all set bits of a
+// bitmap.
+//
+// Once created, repeatedly call Next() on it to fill the passed in int64
+// pointer with the offset of the next set bit, in ascending order. Next will
+// return false if there's no more set bits or if an error occurred, at which
+// point Close should be called to retrieve any error.
+type BitScanner interface {
+	Next(*int64) bool
+	Close() error
+}
+
+type bitScanner struct {
+	c          Client
+	key        string
+	start, end int64
+
+	nextByte int64
+	offsets  []int64
+	done     bool
+	err      error
+}
+
+// NewBitScanner creates a new BitScanner which iterates over the offsets of
+// all set bits of the bitmap stored at key, between the bit offsets start and
+// end (inclusive). If end is negative all bits from start up to the end of the
+// bitmap are scanned.
+//
+// BITPOS is used to skip over ranges of unset bits on the server, so only the
+// bytes containing set bits are ever read. This makes iterating over sparse
+// bitmaps cheap, while for dense bitmaps it's more efficient to read the whole
+// bitmap using GET.
+func NewBitScanner(c Client, key string, start, end int64) BitScanner {
+	return &bitScanner{
+		c:        c,
+		key:      key,
+		start:    start,
+		end:      end,
+		nextByte: start / 8,
+	}
+}
+
+func (bs *bitScanner) Next(offset *int64) bool {
+	for len(bs.offsets) == 0 {
+		if bs.done || bs.err != nil {
+			return false
+		}
+		bs.err = bs.fill()
+	}
+	*offset, bs.offsets = bs.offsets[0], bs.offsets[1:]
+	return true
+}
+
+// fill looks for the next byte containing a set bit and fills offsets with the
+// set bits of that byte which are within the scanned range.
+func (bs *bitScanner) fill() error {
+	endByte := int64(-1)
+	if bs.end >= 0 {
+		endByte = bs.end / 8
+		if bs.nextByte > endByte {
+			bs.done = true
+			return nil
+		}
+	}
+
+	var pos int64
+	err := bs.c.Do(Cmd(&pos, "BITPOS", bs.key, "1",
+		strconv.FormatInt(bs.nextByte, 10), strconv.FormatInt(endByte, 10)))
+	if err != nil {
+		return err
+	} else if pos < 0 || (bs.end >= 0 && pos > bs.end) {
+		bs.done = true
+		return nil
+	}
+
+	byteIdx := pos / 8
+	byteIdxStr := strconv.FormatInt(byteIdx, 10)
+	var b []byte
+	if err := bs.c.Do(Cmd(&b, "GETRANGE", bs.key, byteIdxStr, byteIdxStr)); err != nil {
+		return err
+	}
+	bs.nextByte = byteIdx + 1
+
+	for i := int64(0); len(b) > 0 && i < 8; i++ {
+		offset := byteIdx*8 + i
+		if b[0]&(0x80>>uint(i)) == 0 || offset < bs.start {
+			continue
+		} else if bs.end >= 0 && offset > bs.end {
+			break
+		}
+		bs.offsets = append(bs.offsets, offset)
+	}
+	return nil
+}
+
+func (bs *bitScanner) Close() error {
+	return bs.err
+}
@@ -0,0 +1,113 @@
+package radix
+
+import (
+	"strconv"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+)
+
+// testBitmapStub returns a Conn which emulates the bitmap commands used by
+// SetBit, GetBit and BitScanner on a single bitmap. The number of received
+// commands is counted in numCmds.
+func testBitmapStub(numCmds *int) Conn {
+	var bitmap []byte
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		*numCmds++
+		atoi := func(i int) int {
+			n, _ := strconv.Atoi(args[i])
+			return n
+		}
+		getBit := func(offset int) int {
+			if offset/8 >= len(bitmap) {
+				return 0
+			}
+			return int(bitmap[offset/8]>>uint(7-offset%8)) & 1
+		}
+
+		switch args[0] {
+		case "SETBIT":
+			offset := atoi(2)
+			for offset/8 >= len(bitmap) {
+				bitmap = append(bitmap, 0)
+			}
+			prev := getBit(offset)
+			mask := byte(0x80 >> uint(offset%8))
+			if atoi(3) == 1 {
+				bitmap[offset/8] |= mask
+			} else {
+				bitmap[offset/8] &^= mask
+			}
+			return prev
+		case "GETBIT":
+			return getBit(atoi(2))
+		case "BITPOS":
+			start, end := atoi(3), atoi(4)
+			if end < 0 || end >= len(bitmap) {
+				end = len(bitmap) - 1
+			}
+			for offset := start * 8; offset < (end+1)*8; offset++ {
+				if getBit(offset) == 1 {
+					return offset
+				}
+			}
+			return -1
+		case "GETRANGE":
+			i := atoi(2)
+			if i >= len(bitmap) {
+				return ""
+			}
+			return string(bitmap[i : i+1])
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+}
+
+func TestBitmap(t *T) {
+	var numCmds int
+	stub := testBitmapStub(&numCmds)
+
+	bits := []int64{1, 3, 7, 8, 100, 10000, 10001, 10007}
+	for _, offset := range bits {
+		prev, err := SetBit(stub, "bitmap", offset, 1)
+		require.NoError(t, err)
+		assert.Equal(t, 0, prev)
+	}
+
+	prev, err := SetBit(stub, "bitmap", 3, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, prev)
+
+	bit, err := GetBit(stub, "bitmap", 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bit)
+	bit, err = GetBit(stub, "bitmap", 101)
+	require.NoError(t, err)
+	assert.Equal(t, 0, bit)
+
+	scan := func(start, end int64) []int64 {
+		var offsets []int64
+		bs := NewBitScanner(stub, "bitmap", start, end)
+		var offset int64
+		for bs.Next(&offset) {
+			offsets = append(offsets, offset)
+		}
+		require.NoError(t, bs.Close())
+		return offsets
+	}
+
+	numCmds = 0
+	assert.Equal(t, bits, scan(0, -1))
+	// one BITPOS and GETRANGE for each of the 4 bytes with set bits, plus a
+	// final BITPOS
+	assert.Equal(t, 9, numCmds)
+
+	assert.Equal(t, []int64{3, 7, 8, 100}, scan(2, 100))
+	assert.Equal(t, []int64{10001}, scan(10001, 10006))
+	assert.Equal(t, []int64{10001, 10007}, scan(10001, -1))
+	assert.Empty(t, scan(101, 9999))
+	assert.Empty(t, scan(20000, -1))
+}
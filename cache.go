@@ -0,0 +1,222 @@
+package radix
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a read-through cache built on top of a Client. Values are stored in
+// redis itself, and on a miss a user-provided loader is used to produce the
+// value which is then SET with a TTL.
+//
+// Concurrent misses for the same key are deduplicated, so that only a single
+// loader call is made and all callers receive its result.
+//
+// If a PubSubConn is given to NewCache then the Cache will subscribe to
+// keyspace notifications for a key for the duration of its load. If the key is
+// modified by someone else while the loader is running the loaded value is
+// considered stale; it will still be returned to the callers, but it will not
+// be SET. This requires the redis instance to have keyspace notifications
+// enabled (e.g. `notify-keyspace-events K$g`). Detection is best-effort, as
+// notifications are delivered asynchronously.
+type Cache struct {
+	c       Client
+	ps      PubSubConn
+	msgCh   chan PubSubMessage
+	closeCh chan struct{}
+	close   sync.Once
+
+	l     sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// cacheArmType is the Type of the PubSubMessages which the Cache sends to its
+// own msgCh once the subscription of a load is in place. Notifications
+// received before it are for modifications which happened prior to the load,
+// e.g. by the SET of a previous load of the same key, and are ignored.
+const cacheArmType = "radix-cache-arm"
+
+type cacheCall struct {
+	done chan struct{}
+
+	val string
+	ok  bool
+	err error
+
+	// armedCh is closed once notifications for the key are considered
+	// invalidations of this call, see cacheArmType.
+	armedCh chan struct{}
+
+	// protected by Cache.l
+	armed, invalidated bool
+}
+
+// NewCache initializes and returns a Cache which will use the given Client for
+// all commands. ps may be nil, in which case invalidations during loads will
+// not be detected. Close should be called once the Cache isn't used anymore.
+func NewCache(c Client, ps PubSubConn) *Cache {
+	cache := &Cache{
+		c:       c,
+		ps:      ps,
+		closeCh: make(chan struct{}),
+		calls:   map[string]*cacheCall{},
+	}
+	if ps != nil {
+		cache.msgCh = make(chan PubSubMessage, 16)
+		go cache.spin()
+	}
+	return cache
+}
+
+// Close stops the go-routine used for handling keyspace notifications, if a
+// PubSubConn was given to NewCache. Neither the Client nor the PubSubConn are
+// closed. Close must not be called while GetOrLoad calls are in progress, and
+// the Cache must not be used afterwards.
+func (c *Cache) Close() error {
+	c.close.Do(func() { close(c.closeCh) })
+	return nil
+}
+
+func (c *Cache) spin() {
+	for {
+		var m PubSubMessage
+		select {
+		case m = <-c.msgCh:
+		case <-c.closeCh:
+			return
+		}
+
+		if m.Type == cacheArmType {
+			c.l.Lock()
+			if call, ok := c.calls[m.Channel]; ok && !call.armed {
+				call.armed = true
+				close(call.armedCh)
+			}
+			c.l.Unlock()
+			continue
+		}
+
+		i := strings.Index(m.Channel, "__:")
+		if i < 0 {
+			continue
+		}
+		key := m.Channel[i+3:]
+		c.l.Lock()
+		if call, ok := c.calls[key]; ok && call.armed {
+			call.invalidated = true
+		}
+		c.l.Unlock()
+	}
+}
+
+// arm waits until all notifications which were received before the
+// subscription of the given call was in place have been handled, as the
+// PubSubConn publishes messages in the order redis sent them.
+func (c *Cache) arm(call *cacheCall, key string) error {
+	select {
+	case c.msgCh <- PubSubMessage{Type: cacheArmType, Channel: key}:
+	case <-c.closeCh:
+		return errClientClosed
+	}
+	select {
+	case <-call.armedCh:
+		return nil
+	case <-c.closeCh:
+		return errClientClosed
+	}
+}
+
+// keyspacePattern returns a PSUBSCRIBE pattern matching the keyspace
+// notification channel of the given key on any database.
+func keyspacePattern(key string) string {
+	var sb strings.Builder
+	sb.WriteString("__keyspace@*__:")
+	for _, r := range key {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// GetOrLoad returns the value of the given key. If the key isn't set and loader
+// is not nil then loader is called, and the value it returns is SET on the key
+// with the given TTL (or with no TTL if ttl is zero) and returned.
+//
+// The returned bool will be false if the key wasn't set and loader is nil.
+// Errors returned from loader are returned as-is, and nothing is SET.
+func (c *Cache) GetOrLoad(key string, loader func(key string) (string, error), ttl time.Duration) (string, bool, error) {
+	c.l.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.l.Unlock()
+		<-call.done
+		return call.val, call.ok, call.err
+	}
+	call := &cacheCall{done: make(chan struct{}), armedCh: make(chan struct{})}
+	c.calls[key] = call
+	c.l.Unlock()
+
+	call.val, call.ok, call.err = c.load(call, key, loader, ttl)
+
+	c.l.Lock()
+	delete(c.calls, key)
+	c.l.Unlock()
+	close(call.done)
+	return call.val, call.ok, call.err
+}
+
+func (c *Cache) load(call *cacheCall, key string, loader func(string) (string, error), ttl time.Duration) (string, bool, error) {
+	// the subscription is only removed once the load is completely done, but
+	// before the call is removed from calls, so that a subsequent call for the
+	// same key can't have its subscription removed by this one.
+	if c.ps != nil && loader != nil {
+		pattern := keyspacePattern(key)
+		if err := c.ps.PSubscribe(c.msgCh, pattern); err != nil {
+			return "", false, err
+		}
+		defer func() { _ = c.ps.PUnsubscribe(c.msgCh, pattern) }()
+		if err := c.arm(call, key); err != nil {
+			return "", false, err
+		}
+	}
+
+	var val string
+	mn := MaybeNil{Rcv: &val}
+	if err := c.c.Do(Cmd(&mn, "GET", key)); err != nil {
+		return "", false, err
+	} else if !mn.Nil {
+		return val, true, nil
+	} else if loader == nil {
+		return "", false, nil
+	}
+
+	val, err := loader(key)
+	if err != nil {
+		return "", false, err
+	}
+
+	c.l.Lock()
+	invalidated := call.invalidated
+	c.l.Unlock()
+	if invalidated {
+		return val, true, nil
+	}
+
+	args := []string{key, val}
+	if ttl > 0 {
+		// PX 0 is rejected by redis, so TTLs below a millisecond are rounded up
+		ms := int64(ttl / time.Millisecond)
+		if ms == 0 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	if err := c.c.Do(Cmd(nil, "SET", args...)); err != nil {
+		return "", false, err
+	}
+	return val, true, nil
+}
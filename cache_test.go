@@ -0,0 +1,169 @@
+package radix
+
+import (
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cacheStub struct {
+	l    sync.Mutex
+	m    map[string]string
+	cmds [][]string
+}
+
+func (s *cacheStub) fn(args []string) interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.cmds = append(s.cmds, args)
+	switch args[0] {
+	case "GET":
+		if v, ok := s.m[args[1]]; ok {
+			return v
+		}
+		return nil
+	case "SET":
+		s.m[args[1]] = args[2]
+		return "OK"
+	}
+	return nil
+}
+
+func TestCacheGetOrLoad(t *T) {
+	s := &cacheStub{m: map[string]string{"foo": "bar"}}
+	cache := NewCache(Stub("tcp", "127.0.0.1:6379", s.fn), nil)
+
+	val, ok, err := cache.GetOrLoad("foo", nil, 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", val)
+
+	val, ok, err = cache.GetOrLoad("missing", nil, 0)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, val)
+
+	enteredCh, releaseCh := make(chan struct{}), make(chan struct{})
+	var loads int
+	loader := func(key string) (string, error) {
+		loads++
+		close(enteredCh)
+		<-releaseCh
+		return key + "-loaded", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	vals := make([]string, n)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		vals[0], _, _ = cache.GetOrLoad("baz", loader, time.Second)
+	}()
+	<-enteredCh
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			vals[i], _, _ = cache.GetOrLoad("baz", loader, time.Second)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(releaseCh)
+	wg.Wait()
+
+	assert.Equal(t, 1, loads)
+	for _, val := range vals {
+		assert.Equal(t, "baz-loaded", val)
+	}
+	assert.Equal(t, "baz-loaded", s.m["baz"])
+	assert.Equal(t, []string{"SET", "baz", "baz-loaded", "PX", "1000"}, s.cmds[len(s.cmds)-1])
+
+	// TTLs below a millisecond are rounded up, as PX 0 is invalid
+	_, _, err = cache.GetOrLoad("qux", func(key string) (string, error) {
+		return "qux-loaded", nil
+	}, time.Microsecond)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SET", "qux", "qux-loaded", "PX", "1"}, s.cmds[len(s.cmds)-1])
+}
+
+func TestCacheGetOrLoadInvalidated(t *T) {
+	s := &cacheStub{m: map[string]string{}}
+	psStub, stubCh := PubSubStub("tcp", "127.0.0.1:6379", func([]string) interface{} {
+		return nil
+	})
+	ps := PubSub(psStub)
+	defer ps.Close()
+	cache := NewCache(Stub("tcp", "127.0.0.1:6379", s.fn), ps)
+	defer cache.Close()
+
+	assert.Equal(t, `__keyspace@*__:a\*b`, keyspacePattern("a*b"))
+
+	loader := func(key string) (string, error) {
+		stubCh <- PubSubMessage{
+			Pattern: keyspacePattern(key),
+			Channel: "__keyspace@0__:" + key,
+			Message: []byte("set"),
+		}
+		for {
+			cache.l.Lock()
+			invalidated := cache.calls[key].invalidated
+			cache.l.Unlock()
+			if invalidated {
+				return "stale", nil
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	val, ok, err := cache.GetOrLoad("foo", loader, 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "stale", val)
+	_, set := s.m["foo"]
+	assert.False(t, set)
+}
+
+// staleNotifyPubSub delivers a notification for each subscribed pattern right
+// after subscribing, as if the key was modified just before the subscription
+// was in place, e.g. by the SET of a previous load.
+type staleNotifyPubSub struct {
+	PubSubConn
+}
+
+func (ps staleNotifyPubSub) PSubscribe(msgCh chan<- PubSubMessage, patterns ...string) error {
+	if err := ps.PubSubConn.PSubscribe(msgCh, patterns...); err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		msgCh <- PubSubMessage{
+			Type:    "pmessage",
+			Pattern: pattern,
+			Channel: "__keyspace@0__:foo",
+			Message: []byte("set"),
+		}
+	}
+	return nil
+}
+
+func TestCacheGetOrLoadStaleNotification(t *T) {
+	s := &cacheStub{m: map[string]string{}}
+	psStub, _ := PubSubStub("tcp", "127.0.0.1:6379", func([]string) interface{} {
+		return nil
+	})
+	ps := PubSub(psStub)
+	defer ps.Close()
+	cache := NewCache(Stub("tcp", "127.0.0.1:6379", s.fn), staleNotifyPubSub{ps})
+	defer cache.Close()
+
+	val, ok, err := cache.GetOrLoad("foo", func(key string) (string, error) {
+		return "bar", nil
+	}, 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", val)
+	assert.Equal(t, "bar", s.m["foo"])
+}
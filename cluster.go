@@ -62,6 +62,11 @@ type clusterOpts struct {
 	syncEvery            time.Duration
 	ct                   trace.ClusterTrace
 	initAllowUnavailable bool
+	maxRedirects         int
+	redirectBackoff      Backoff
+	noKeyAddr            string
+	readRouter           readRouter
+	retryPolicy          *RetryPolicy
 }
 
 // ClusterOpt is an optional behavior which can be applied to the NewCluster
@@ -121,6 +126,73 @@ func ClusterOnInitAllowUnavailable(initAllowUnavailable bool) ClusterOpt {
 	}
 }
 
+// ClusterMaxRedirects tells the Cluster how many MOVED and ASK redirects it may
+// follow for a single Action before giving up. Once the limit is exceeded an
+// error containing the addresses the Action was redirected through is
+// returned.
+//
+// If the given number is 0 then Cluster will not follow any redirects and
+// return the MOVED or ASK error as-is.
+func ClusterMaxRedirects(n int) ClusterOpt {
+	return func(co *clusterOpts) {
+		co.maxRedirects = n
+	}
+}
+
+// ClusterRedirectBackoff tells the Cluster to wait before following a MOVED or
+// ASK redirect, using the given Backoff with the number of redirects followed
+// so far for the Action. By default redirects are followed immediately, as the
+// node an Action is redirected to is expected to be able to handle it.
+func ClusterRedirectBackoff(b Backoff) ClusterOpt {
+	return func(co *clusterOpts) {
+		co.redirectBackoff = b
+	}
+}
+
+// ClusterNoKeyAddr tells the Cluster to perform Actions which don't have any
+// keys, e.g. CONFIG GET or PING, on the node with the given address. By default
+// they are performed on a random node of the cluster, which is fine for most
+// commands, but e.g. administrative tooling may want to always use the same
+// node.
+func ClusterNoKeyAddr(addr string) ClusterOpt {
+	return func(co *clusterOpts) {
+		co.noKeyAddr = addr
+	}
+}
+
+// ClusterReadPolicy tells the Cluster to perform read-only commands passed to
+// Do according to the given ReadPolicy, e.g. on a replica of the primary
+// serving the command's key. isRead is used to determine whether a command is
+// read-only. If nil IsIdempotentCommand is used. Actions other than those
+// created using Cmd or FlatCmd, and Actions wrapped using Primary, are always
+// performed on the primary.
+//
+// This has the same requirements as DoSecondary, i.e. connections to replicas
+// must have READONLY mode enabled (see DefaultClusterConnFunc). Otherwise
+// replicas redirect the commands to their primary.
+func ClusterReadPolicy(policy ReadPolicy, isRead func(cmd string) bool) ClusterOpt {
+	return func(co *clusterOpts) {
+		co.readRouter = newReadRouter(policy, isRead)
+	}
+}
+
+// ClusterRetryPolicy tells the Cluster to retry Actions passed to Do or
+// DoSecondary which failed due to a transient error, e.g. a TRYAGAIN error
+// during a resharding or a CLUSTERDOWN error during a failover, as described by
+// the given RetryPolicy. Before retrying an Action which failed due to a
+// connection error the Cluster syncs its topology, so that the retry is
+// performed on the new primary if the node failed over.
+//
+// MOVED and ASK redirects are followed independently of this option, see
+// ClusterMaxRedirects. The Pools created by the Cluster should not have a
+// RetryPolicy of their own, as otherwise Actions are retried by both.
+func ClusterRetryPolicy(rp RetryPolicy) ClusterOpt {
+	return func(co *clusterOpts) {
+		rp = rp.withDefaults()
+		co.retryPolicy = &rp
+	}
+}
+
 // Cluster contains all information about a redis cluster needed to interact
 // with it, including a set of pools to each of its instances. All methods on
 // Cluster are thread-safe
@@ -140,6 +212,10 @@ type Cluster struct {
 	primTopo, topo ClusterTopo
 	secondaries    map[string]map[string]ClusterNode
 
+	// slotAddrs maps each slot to the address of the primary serving it, or ""
+	// if no primary is serving it.
+	slotAddrs []string
+
 	closeCh   chan struct{}
 	closeWG   sync.WaitGroup
 	closeOnce sync.Once
@@ -176,6 +252,7 @@ var DefaultClusterConnFunc = func(network, addr string) (Conn, error) {
 //     ClusterPoolFunc(DefaultClientFunc)
 //     ClusterSyncEvery(5 * time.Second)
 //     ClusterOnDownDelayActionsBy(100 * time.Millisecond)
+//     ClusterMaxRedirects(5)
 //
 func NewCluster(clusterAddrs []string, opts ...ClusterOpt) (*Cluster, error) {
 	c := &Cluster{
@@ -189,6 +266,7 @@ func NewCluster(clusterAddrs []string, opts ...ClusterOpt) (*Cluster, error) {
 		ClusterPoolFunc(DefaultClientFunc),
 		ClusterSyncEvery(5 * time.Second),
 		ClusterOnDownDelayActionsBy(100 * time.Millisecond),
+		ClusterMaxRedirects(5),
 	}
 
 	for _, opt := range append(defaultClusterOpts, opts...) {
@@ -294,6 +372,27 @@ func (c *Cluster) Client(addr string) (Client, error) {
 	return cl, nil
 }
 
+// Latencies returns the latency of each node in the cluster, keyed by the
+// node's address, as tracked by the Client used for the node. Only Clients
+// which implement a Latency method, like *Pool does, and which have observed a
+// latency are included. This can be used to prefer nodes with lower latency,
+// e.g. when choosing between secondaries.
+func (c *Cluster) Latencies() map[string]time.Duration {
+	c.l.RLock()
+	defer c.l.RUnlock()
+
+	m := make(map[string]time.Duration, len(c.pools))
+	for addr, p := range c.pools {
+		lp, ok := p.(interface{ Latency() time.Duration })
+		if !ok {
+			continue
+		} else if l := lp.Latency(); l > 0 {
+			m[addr] = l
+		}
+	}
+	return m
+}
+
 // if addr is "" returns a random pool. If addr is given but there's no pool for
 // it one will be created on-the-fly
 func (c *Cluster) pool(addr string) (Client, error) {
@@ -437,6 +536,15 @@ func (c *Cluster) sync(p Client, silenceFlag bool) error {
 		c.topo = tt
 		c.primTopo = tt.Primaries()
 
+		c.slotAddrs = make([]string, numSlots)
+		for _, node := range c.primTopo {
+			for _, slot := range node.Slots {
+				for i := slot[0]; i < slot[1] && i < numSlots; i++ {
+					c.slotAddrs[i] = node.Addr
+				}
+			}
+		}
+
 		c.secondaries = make(map[string]map[string]ClusterNode, len(c.primTopo))
 		for _, node := range c.topo {
 			if node.SecondaryOfAddr != "" {
@@ -486,17 +594,55 @@ func (c *Cluster) syncEvery(d time.Duration) {
 }
 
 func (c *Cluster) addrForKey(key string) string {
-	s := ClusterSlot([]byte(key))
+	return c.addrForSlot(ClusterSlot([]byte(key)))
+}
+
+func (c *Cluster) addrForSlot(slot uint16) string {
 	c.l.RLock()
 	defer c.l.RUnlock()
-	for _, t := range c.primTopo {
-		for _, slot := range t.Slots {
-			if s >= slot[0] && s < slot[1] {
-				return t.Addr
-			}
-		}
+	if int(slot) >= len(c.slotAddrs) {
+		return ""
 	}
-	return ""
+	return c.slotAddrs[slot]
+}
+
+// NodeForKey returns the address of the primary node which is serving the
+// given key, according to the Cluster's current topology. See NodeForSlot.
+func (c *Cluster) NodeForKey(key string) (string, error) {
+	return c.NodeForSlot(ClusterSlot([]byte(key)))
+}
+
+// NodeForSlot returns the address of the primary node which is serving the
+// given slot, according to the Cluster's current topology. An error is
+// returned if the slot is invalid or not served by any node.
+//
+// The topology may change at any time, e.g. due to resharding, in which case
+// the returned address is only updated once the Cluster has synced.
+func (c *Cluster) NodeForSlot(slot uint16) (string, error) {
+	if slot >= numSlots {
+		return "", errors.Errorf("invalid slot %d", slot)
+	} else if addr := c.addrForSlot(slot); addr != "" {
+		return addr, nil
+	}
+	return "", errors.Errorf("no node is serving slot %d", slot)
+}
+
+// readAddrForKey returns the address of the instance a read-only command for
+// the given key is performed on, according to the ReadPolicy.
+func (c *Cluster) readAddrForKey(key string) (string, error) {
+	primAddr := c.addrForKey(key)
+	c.l.RLock()
+	secAddrs := make([]string, 0, len(c.secondaries[primAddr]))
+	for addr := range c.secondaries[primAddr] {
+		secAddrs = append(secAddrs, addr)
+	}
+	c.l.RUnlock()
+
+	return c.co.readRouter.pick(primAddr, secAddrs, func(addr string) time.Duration {
+		c.l.RLock()
+		defer c.l.RUnlock()
+		return clientLatency(c.pools[addr])
+	})
 }
 
 func (c *Cluster) secondaryAddrForKey(key string) string {
@@ -531,26 +677,50 @@ func (ac askConn) Do(a Action) error {
 	return a.Run(ac)
 }
 
-const doAttempts = 5
-
 // Do performs an Action on a redis instance in the cluster, with the instance
-// being determeined by the key returned from the Action's Key() method.
+// being determeined by the key returned from the Action's Key() method. Actions
+// without keys are performed on a random instance, see ClusterNoKeyAddr.
+// Read-only commands may be performed on a replica, see ClusterReadPolicy.
 //
 // This method handles MOVED and ASK errors automatically in most cases, see
 // ClusterCanRetryAction's docs for more.
+//
+// MGET, MSET, DEL, UNLINK, EXISTS and TOUCH commands created using Cmd or
+// CmdBytes whose keys belong to different slots are split into one command per
+// slot, also if they're wrapped using e.g. WithDeadline or WithCommandName, in
+// which case each split command is wrapped the same way. These are performed
+// concurrently, and their replies are merged into the receiver as if redis had
+// processed a single command, with the values of MGET being in the order of the
+// original keys. Unlike the single command the split commands are not atomic,
+// and if an error is returned only some of them may have been processed.
 func (c *Cluster) Do(a Action) error {
-	var addr, key string
+	if c.co.retryPolicy != nil {
+		return c.co.retryPolicy.do(a, c.retrySync(c.do))
+	}
+	return c.do(a)
+}
+
+func (c *Cluster) do(a Action) error {
+	if sc, ok := newClusterSplitCmd(a); ok {
+		return c.doSplit(sc)
+	}
+
+	addr, key := c.co.noKeyAddr, ""
 	keys := a.Keys()
 	if len(keys) == 0 {
 		// that's ok, key will then just be ""
 	} else if err := assertKeysSlot(keys); err != nil {
 		return err
+	} else if key = keys[0]; c.co.readRouter.routed(a) {
+		var err error
+		if addr, err = c.readAddrForKey(key); err != nil {
+			return err
+		}
 	} else {
-		key = keys[0]
 		addr = c.addrForKey(key)
 	}
 
-	return c.doInner(a, addr, key, false, doAttempts)
+	return c.doInner(a, addr, key, false, nil)
 }
 
 // DoSecondary is like Do but executes the Action on a random secondary for the affected keys.
@@ -561,8 +731,45 @@ func (c *Cluster) Do(a Action) error {
 // See ClusterPoolFunc for an example using the global DefaultClusterConnFunc.
 //
 // If the Action can not be handled by a secondary the Action will be send to the primary instead.
+// Actions wrapped using Primary are always sent to the primary. Actions of a
+// read-only Function are sent to the primary if the function is missing on the
+// secondary, see Function.ReadOnly.
 func (c *Cluster) DoSecondary(a Action) error {
-	var addr, key string
+	if isPrimary(a) {
+		return c.Do(a)
+	}
+
+	var err error
+	if c.co.retryPolicy != nil {
+		err = c.co.retryPolicy.do(a, c.retrySync(c.doSecondary))
+	} else {
+		err = c.doSecondary(a)
+	}
+	if isFunctionNotFound(err) {
+		// the library of a Function can only be loaded on the primary
+		return c.Do(a)
+	}
+	return err
+}
+
+// retrySync wraps fn so that the topology of the cluster is synced before each
+// retry which follows a connection error, since those are usually caused by a
+// node failing over.
+func (c *Cluster) retrySync(fn func(Action) error) func(Action) error {
+	var prevErr error
+	return func(a Action) error {
+		if prevErr != nil && isConnErr(prevErr) {
+			// if the sync fails the retry will most likely fail too, in which
+			// case the error of the Action is more useful
+			_ = c.Sync()
+		}
+		prevErr = fn(a)
+		return prevErr
+	}
+}
+
+func (c *Cluster) doSecondary(a Action) error {
+	addr, key := c.co.noKeyAddr, ""
 	keys := a.Keys()
 	if len(keys) == 0 {
 		// that's ok, key will then just be ""
@@ -573,13 +780,264 @@ func (c *Cluster) DoSecondary(a Action) error {
 		addr = c.secondaryAddrForKey(key)
 	}
 
-	return c.doInner(a, addr, key, false, doAttempts)
+	return c.doInner(a, addr, key, false, nil)
+}
+
+// SecondaryResult holds the result of a command performed on a single
+// secondary using DoOnAllSecondaries.
+type SecondaryResult struct {
+	// Reply contains the raw reply to the command, which can be unmarshaled
+	// using its UnmarshalInto method. It is only set if Err is nil.
+	Reply resp2.RawMessage
+
+	// Err is set if the command couldn't be performed on the secondary, e.g.
+	// because it is down, or if redis returned an error.
+	Err error
+}
+
+// DoOnAllSecondaries performs the given read-only command on all secondaries
+// of the cluster concurrently and returns the result for each, keyed by the
+// address of the secondary. This can be used to check the secondaries for
+// divergence, e.g. a key having a different value on one secondary due to a
+// replication issue.
+//
+// READONLY is sent prior to the command, so that keys can be read from a
+// secondary even if its connections weren't created in read-only mode. If the
+// Client of a secondary is a *Pool this is done on a separate connection,
+// created using the Pool's ConnFunc, so that the connections of the Pool
+// aren't affected. For all other Clients READWRITE is sent after the command,
+// resetting the connection to the default read-write mode. Note that a key can
+// only be read on the secondaries of the primary serving it, all other
+// secondaries return a MOVED error.
+//
+// Failures of individual secondaries are reported using their
+// SecondaryResult, rather than failing the whole call. An error is only
+// returned if the command isn't known to be read-only and free of side effects
+// (see IsIdempotentCommand), in which case it is never sent.
+func (c *Cluster) DoOnAllSecondaries(cmd string, args ...string) (map[string]SecondaryResult, error) {
+	if !IsIdempotentCommand(cmd) {
+		return nil, errors.Errorf("refusing to send %s to secondaries: %w", strings.ToUpper(cmd), ErrReadOnly)
+	}
+
+	c.l.RLock()
+	addrs := make([]string, 0, len(c.topo)-len(c.primTopo))
+	for _, secondaries := range c.secondaries {
+		for addr := range secondaries {
+			addrs = append(addrs, addr)
+		}
+	}
+	c.l.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		l       sync.Mutex
+		results = make(map[string]SecondaryResult, len(addrs))
+	)
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			var res SecondaryResult
+			if err := c.doOnSecondary(addr, Cmd(&res.Reply, cmd, args...)); err != nil {
+				res = SecondaryResult{Err: err}
+			} else if len(res.Reply) > 0 && res.Reply[0] == resp2.ErrorPrefix[0] {
+				// RawMessage also captures error replies as-is
+				res = SecondaryResult{Err: res.Reply.UnmarshalInto(resp2.Any{})}
+			}
+			l.Lock()
+			results[addr] = res
+			l.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// doOnSecondary performs the CmdAction for DoOnAllSecondaries on the secondary
+// with the given address, without leaving the READONLY mode enabled on
+// connections shared with other callers.
+func (c *Cluster) doOnSecondary(addr string, a CmdAction) error {
+	client, err := c.Client(addr)
+	if err != nil {
+		return err
+	}
+	p, ok := client.(*Pool)
+	if !ok {
+		return client.Do(Pipeline(Cmd(nil, "READONLY"), a, Cmd(nil, "READWRITE")))
+	}
+
+	conn, err := p.opts.cf(p.network, p.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Do(Pipeline(Cmd(nil, "READONLY"), a))
 }
 
 func (c *Cluster) getClusterDownSince() int64 {
 	return atomic.LoadInt64(&c.lastClusterdown)
 }
 
+// DoPipeline performs the given CmdActions, grouping them by the node serving
+// their keys and performing a separate Pipeline for each node, with all
+// Pipelines being performed concurrently. Unlike passing a Pipeline to Do the
+// CmdActions don't need to belong to the same slot, only the keys of each
+// individual CmdAction do.
+//
+// An error returned for one CmdAction doesn't affect any of the other
+// CmdActions. MOVED and ASK errors are handled for each CmdAction
+// individually, the same way Do handles them.
+//
+// If all CmdActions succeeded nil is returned, otherwise the returned slice
+// contains the error of each CmdAction (or nil), in the same order as the
+// given CmdActions.
+func (c *Cluster) DoPipeline(cmds ...CmdAction) []error {
+	errs := make([]error, len(cmds))
+	keys := make([]string, len(cmds))
+
+	byAddr := map[string]*clusterPipeline{}
+	var addrs []string
+	for i, cmd := range cmds {
+		addr := c.co.noKeyAddr
+		if cmdKeys := cmd.Keys(); len(cmdKeys) > 0 {
+			if err := assertKeysSlot(cmdKeys); err != nil {
+				errs[i] = err
+				continue
+			}
+			keys[i] = cmdKeys[0]
+			addr = c.addrForKey(keys[i])
+		}
+
+		cp, ok := byAddr[addr]
+		if !ok {
+			cp = new(clusterPipeline)
+			byAddr[addr] = cp
+			addrs = append(addrs, addr)
+		}
+		cp.idxs = append(cp.idxs, i)
+		cp.cmds = append(cp.cmds, cmd)
+	}
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string, cp *clusterPipeline) {
+			defer wg.Done()
+			cp.errs = make([]error, len(cp.cmds))
+			p, err := c.pool(addr)
+			if err == nil {
+				err = p.Do(cp)
+			}
+			for i, idx := range cp.idxs {
+				if cp.errs[i] == nil {
+					cp.errs[i] = err
+				}
+				errs[idx] = cp.errs[i]
+			}
+		}(addr, byAddr[addr])
+	}
+	wg.Wait()
+
+	// handle any redirects individually. If any of the redirects was a MOVED a
+	// Sync is done once up front, rather than once per CmdAction.
+	type redirect struct {
+		idx        int
+		from, addr string
+		ask        bool
+	}
+	var redirects []redirect
+	var synced bool
+	for i, err := range errs {
+		var respErr resp2.Error
+		if err == nil || !errors.As(err, &respErr) {
+			continue
+		}
+
+		msg := respErr.Error()
+		moved := strings.HasPrefix(msg, "MOVED ")
+		ask := strings.HasPrefix(msg, "ASK ")
+		if !moved && !ask {
+			continue
+		}
+
+		if moved && !synced {
+			if serr := c.Sync(); serr != nil {
+				errs[i] = serr
+				continue
+			}
+			synced = true
+		}
+
+		if ccra, ok := cmds[i].(ClusterCanRetryAction); !ok || !ccra.ClusterCanRetry() {
+			continue
+		}
+
+		msgParts := strings.Split(msg, " ")
+		if len(msgParts) < 3 {
+			errs[i] = errors.Errorf("malformed MOVED/ASK error %q", msg)
+			continue
+		}
+
+		ogAddr := c.addrForKey(keys[i])
+		final := c.co.maxRedirects <= 0
+		c.traceRedirected(ogAddr, keys[i], moved, ask, 1, final)
+		if final {
+			continue
+		}
+		redirects = append(redirects, redirect{idx: i, from: ogAddr, addr: msgParts[2], ask: ask})
+	}
+
+	for _, r := range redirects {
+		wg.Add(1)
+		go func(r redirect) {
+			defer wg.Done()
+			errs[r.idx] = c.doInner(cmds[r.idx], r.addr, keys[r.idx], r.ask, []string{r.from})
+		}(r)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return errs
+		}
+	}
+	return nil
+}
+
+// clusterPipeline is like pipeline, but continues decoding the results of all
+// CmdActions if one of them returns an error, recording the error for each
+// CmdAction individually.
+type clusterPipeline struct {
+	idxs []int // indexes of the CmdActions in the DoPipeline call
+	cmds []CmdAction
+	errs []error
+}
+
+func (cp *clusterPipeline) Keys() []string {
+	return pipeline(cp.cmds).Keys()
+}
+
+func (cp *clusterPipeline) Run(conn Conn) error {
+	if err := conn.Encode(pipeline(cp.cmds)); err != nil {
+		return err
+	}
+
+	for i, cmd := range cp.cmds {
+		err := conn.Decode(cmd)
+		if err == nil {
+			continue
+		}
+		cp.errs[i] = decodeErr(cmd, err)
+
+		// if the error wasn't discarded the connection is in an unknown state
+		// and the remaining results can't be read.
+		if !errors.As(err, new(resp.ErrDiscarded)) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *Cluster) setClusterDown(down bool) (changed bool) {
 	// There is a race when calling this method concurrently when the cluster
 	// healed after being down.
@@ -655,7 +1113,10 @@ func (c *Cluster) traceRedirected(addr, key string, moved, ask bool, count int,
 	}
 }
 
-func (c *Cluster) doInner(a Action, addr, key string, ask bool, attempts int) error {
+// doInner performs the Action on the node with the given address, following
+// MOVED and ASK redirects. redirects contains the addresses the Action was
+// already redirected from.
+func (c *Cluster) doInner(a Action, addr, key string, ask bool, redirects []string) error {
 	if downSince := c.getClusterDownSince(); downSince > 0 && c.co.clusterDownWait > 0 {
 		// only wait when the last command was not too long, because
 		// otherwise the chance it high that the cluster already healed
@@ -698,7 +1159,7 @@ func (c *Cluster) doInner(a Action, addr, key string, ask bool, attempts int) er
 	clusterDown := strings.HasPrefix(msg, "CLUSTERDOWN ")
 	clusterDownChanged := c.setClusterDown(clusterDown)
 	if clusterDown && c.co.clusterDownWait > 0 && clusterDownChanged {
-		return c.doInner(a, addr, key, ask, 1)
+		return c.doInner(a, addr, key, ask, redirects)
 	}
 
 	// if the error was a MOVED or ASK we can potentially retry
@@ -731,12 +1192,23 @@ func (c *Cluster) doInner(a Action, addr, key string, ask bool, attempts int) er
 	}
 	ogAddr, addr := addr, msgParts[2]
 
-	c.traceRedirected(ogAddr, key, moved, ask, doAttempts-attempts+1, attempts <= 1)
-	if attempts--; attempts <= 0 {
-		return errors.New("cluster action redirected too many times")
+	final := len(redirects) >= c.co.maxRedirects
+	c.traceRedirected(ogAddr, key, moved, ask, len(redirects)+1, final)
+	if c.co.maxRedirects <= 0 {
+		return err
+	}
+
+	redirects = append(redirects, ogAddr)
+	if final {
+		return errors.Errorf("cluster action redirected too many times (%s): %w",
+			strings.Join(append(redirects, addr), " -> "), err)
+	}
+
+	if c.co.redirectBackoff != nil {
+		time.Sleep(c.co.redirectBackoff.Next(len(redirects)))
 	}
 
-	return c.doInner(a, addr, key, ask, attempts)
+	return c.doInner(a, addr, key, ask, redirects)
 }
 
 // Close cleans up all goroutines spawned by Cluster and closes all of its
@@ -11,6 +11,19 @@ func TestClusterSlot(t *T) {
 	// basic test
 	assert.Equal(t, uint16(0x31c3), ClusterSlot([]byte("123456789")))
 
+	// known slots, as returned by CLUSTER KEYSLOT
+	for key, slot := range map[string]uint16{
+		"foo":                  12182,
+		"bar":                  5061,
+		"hello":                866,
+		"somekey":              11058,
+		"{user1000}.following": 3443,
+		"{user1000}.followers": 3443,
+		"":                     0,
+	} {
+		assert.Equal(t, slot, ClusterSlot([]byte(key)), "key: %q", key)
+	}
+
 	// this is more to test that the hash tag checking works than anything
 	k := []byte(randStr())
 	crcSlot := ClusterSlot(k)
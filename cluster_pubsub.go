@@ -0,0 +1,523 @@
+package radix
+
+import (
+	"bufio"
+	"strings"
+	"sync"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+type shardedPubSubOpts struct {
+	connFn  ConnFunc
+	errCh   chan<- error
+	backoff Backoff
+}
+
+// ShardedPubSubOpt is an optional parameter which can be passed into
+// NewShardedPubSub in order to affect its behavior.
+type ShardedPubSubOpt func(*shardedPubSubOpts)
+
+// ShardedPubSubConnFunc causes ShardedPubSub to use the given ConnFunc when
+// connecting to the nodes of the Cluster.
+func ShardedPubSubConnFunc(connFn ConnFunc) ShardedPubSubOpt {
+	return func(opts *shardedPubSubOpts) {
+		opts.connFn = connFn
+	}
+}
+
+// ShardedPubSubErrCh takes a channel which asynchronous errors encountered by
+// the ShardedPubSub can be read off of, e.g. errors during resubscribing. If
+// the channel blocks the error will be dropped. The channel will be closed when
+// the ShardedPubSub is closed.
+func ShardedPubSubErrCh(errCh chan<- error) ShardedPubSubOpt {
+	return func(opts *shardedPubSubOpts) {
+		opts.errCh = errCh
+	}
+}
+
+// ShardedPubSubBackoff sets the Backoff used to determine how long to wait
+// between attempts to resubscribe to a channel.
+func ShardedPubSubBackoff(b Backoff) ShardedPubSubOpt {
+	return func(opts *shardedPubSubOpts) {
+		opts.backoff = b
+	}
+}
+
+// shardedReply is a single message received on a connection in sharded pubsub
+// mode.
+type shardedReply struct {
+	typ     string // smessage, ssubscribe, sunsubscribe or pong
+	channel string
+	message []byte
+	err     error // set if redis returned an error
+}
+
+// in RESP3 all messages are sent as push frames
+func (r *shardedReply) unmarshalsPush() {}
+
+func (r *shardedReply) UnmarshalRESP(br *bufio.Reader) error {
+	if _, err := peekMessage(br); err != nil {
+		return err
+	}
+	var rm resp2.RawMessage
+	if err := rm.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	switch rm[0] {
+	case resp2.SimpleStringPrefix[0]:
+		// PING returns a simple string if no channel is subscribed to
+		r.typ = "pong"
+		return nil
+	case resp2.ErrorPrefix[0]:
+		err := rm.UnmarshalInto(resp2.Any{})
+		if !errors.As(err, new(resp2.Error)) {
+			return err
+		}
+		r.err = err
+		return nil
+	}
+
+	var ss []string
+	if err := rm.UnmarshalInto(resp2.Any{I: &ss}); err != nil {
+		return err
+	} else if len(ss) < 2 {
+		return errors.New("message has too few elements")
+	}
+	r.typ, r.channel = ss[0], ss[1]
+	if r.typ == "smessage" {
+		if len(ss) != 3 {
+			return errors.New("message has wrong number of elements")
+		}
+		r.message = []byte(ss[2])
+	}
+	return nil
+}
+
+// shardedNode is a connection to a single node of the Cluster, on which all
+// channels whose slots are served by that node are subscribed.
+type shardedNode struct {
+	sp   *ShardedPubSub
+	addr string
+	conn Conn
+
+	// cmdL is held while performing a command, as the replies to commands are
+	// read in the order the commands were sent.
+	cmdL   sync.Mutex
+	resCh  chan shardedReply
+	doneCh chan struct{}
+
+	l            sync.Mutex
+	pendingUnsub map[string]bool
+}
+
+func (n *shardedNode) do(cmd string, args ...string) error {
+	n.cmdL.Lock()
+	defer n.cmdL.Unlock()
+
+	if cmd == "SUNSUBSCRIBE" {
+		n.l.Lock()
+		n.pendingUnsub[args[0]] = true
+		n.l.Unlock()
+		defer func() {
+			n.l.Lock()
+			delete(n.pendingUnsub, args[0])
+			n.l.Unlock()
+		}()
+	}
+
+	if err := n.conn.Encode(Cmd(nil, cmd, args...)); err != nil {
+		return err
+	}
+	select {
+	case r := <-n.resCh:
+		return r.err
+	case <-n.doneCh:
+		return errors.New("connection closed")
+	}
+}
+
+func (n *shardedNode) spin() {
+	defer n.sp.wg.Done()
+	defer close(n.doneCh)
+	for {
+		var r shardedReply
+		err := n.conn.Decode(&r)
+		if isTimeout(err) {
+			continue
+		} else if err != nil {
+			n.sp.nodeFailed(n, err)
+			return
+		}
+
+		switch r.typ {
+		case "smessage":
+			n.sp.publish(r.channel, r.message)
+		case "sunsubscribe":
+			n.l.Lock()
+			pending := n.pendingUnsub[r.channel]
+			n.l.Unlock()
+			if pending {
+				n.resCh <- r
+				continue
+			}
+			// redis unsubscribes all channels of a slot by itself once the
+			// slot was moved to another node
+			n.sp.wg.Add(1)
+			go n.sp.migrate(n, r.channel)
+		default:
+			n.resCh <- r
+		}
+	}
+}
+
+// ShardedPubSub subscribes to channels using redis' sharded pubsub (SSUBSCRIBE,
+// available since Redis 7), where messages are only published on the node
+// serving the slot of the channel. Each channel is subscribed to on the
+// primary node serving its slot, using a separate connection for each node.
+//
+// When a slot is moved to another node redis unsubscribes the channels of that
+// slot, in which case the ShardedPubSub syncs the Cluster's topology and
+// resubscribes the channels on the new node. Channels are resubscribed in the
+// same way if the connection to a node fails.
+//
+// Messages are published using SPUBLISH, which can be done using the Cluster
+// as it's routed to the node serving the slot of the channel:
+//
+//	err := cluster.Do(radix.Cmd(nil, "SPUBLISH", "channel", "message"))
+//
+// Messages are delivered as PubSubMessages with the Type "smessage". The same
+// notes about blocking msgChs apply as for PubSubConn. All methods are
+// threadsafe.
+type ShardedPubSub struct {
+	c    *Cluster
+	opts shardedPubSubOpts
+
+	// subL is held while changing subscriptions, so that changes for the same
+	// channel don't race each other.
+	subL sync.Mutex
+
+	l        sync.RWMutex
+	closed   bool
+	subs     chanSet
+	channels map[string]*shardedNode // channel -> node it's subscribed on
+	nodes    map[string]*shardedNode // addr -> node
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewShardedPubSub returns a ShardedPubSub which uses the given Cluster to
+// determine which node a channel must be subscribed on. The Cluster isn't
+// closed when the ShardedPubSub is closed.
+//
+// The default options NewShardedPubSub uses are:
+//
+//	ShardedPubSubConnFunc(DefaultConnFunc)
+//	ShardedPubSubBackoff(ConstantBackoff(200 * time.Millisecond))
+//
+func NewShardedPubSub(c *Cluster, opts ...ShardedPubSubOpt) *ShardedPubSub {
+	sp := &ShardedPubSub{
+		c:        c,
+		subs:     chanSet{},
+		channels: map[string]*shardedNode{},
+		nodes:    map[string]*shardedNode{},
+		closeCh:  make(chan struct{}),
+	}
+
+	defaultShardedPubSubOpts := []ShardedPubSubOpt{
+		ShardedPubSubConnFunc(DefaultConnFunc),
+		ShardedPubSubBackoff(ConstantBackoff(200 * time.Millisecond)),
+	}
+	for _, opt := range append(defaultShardedPubSubOpts, opts...) {
+		opt(&sp.opts)
+	}
+
+	sp.wg.Add(1)
+	go sp.spin()
+	return sp
+}
+
+func (sp *ShardedPubSub) err(err error) {
+	select {
+	case sp.opts.errCh <- err:
+	default:
+	}
+}
+
+// spin periodically pings all nodes, so that failed connections are noticed
+// even if no messages are published.
+func (sp *ShardedPubSub) spin() {
+	defer sp.wg.Done()
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			_ = sp.Ping()
+		case <-sp.closeCh:
+			return
+		}
+	}
+}
+
+func (sp *ShardedPubSub) publish(channel string, message []byte) {
+	sp.l.RLock()
+	defer sp.l.RUnlock()
+	m := PubSubMessage{Type: "smessage", Channel: channel, Message: message}
+	for ch := range sp.subs[channel] {
+		ch <- m
+	}
+}
+
+// node returns the node for the given address, connecting to it if there's no
+// connection yet.
+func (sp *ShardedPubSub) node(addr string) (*shardedNode, error) {
+	sp.l.RLock()
+	n, ok := sp.nodes[addr]
+	sp.l.RUnlock()
+	if ok {
+		return n, nil
+	}
+
+	conn, err := sp.opts.connFn("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	n = &shardedNode{
+		sp:           sp,
+		addr:         addr,
+		conn:         conn,
+		resCh:        make(chan shardedReply, 1),
+		doneCh:       make(chan struct{}),
+		pendingUnsub: map[string]bool{},
+	}
+
+	sp.l.Lock()
+	defer sp.l.Unlock()
+	if sp.closed {
+		conn.Close()
+		return nil, errClientClosed
+	}
+	sp.nodes[addr] = n
+	sp.wg.Add(1)
+	go n.spin()
+	return n, nil
+}
+
+// subscribe subscribes to the channel on the node serving its slot. subL must
+// be held.
+func (sp *ShardedPubSub) subscribe(channel string) error {
+	for synced := false; ; synced = true {
+		n, err := sp.node(sp.c.addrForKey(channel))
+		if err == nil {
+			err = n.do("SSUBSCRIBE", channel)
+		}
+
+		var respErr resp2.Error
+		if err == nil {
+			sp.l.Lock()
+			defer sp.l.Unlock()
+			// if the connection failed in the meantime the channel wasn't
+			// resubscribed by nodeFailed, as it wasn't known yet
+			if sp.nodes[n.addr] != n {
+				return errors.New("connection closed")
+			}
+			sp.channels[channel] = n
+			return nil
+		} else if synced || !errors.As(err, &respErr) || !strings.HasPrefix(respErr.Error(), "MOVED ") {
+			return err
+		} else if err := sp.c.Sync(); err != nil {
+			return err
+		}
+	}
+}
+
+// resubscribe subscribes to the channel again, retrying until it succeeded or
+// the channel isn't subscribed to anymore.
+func (sp *ShardedPubSub) resubscribe(channel string) {
+	for attempt := 1; ; attempt++ {
+		sp.subL.Lock()
+		sp.l.RLock()
+		_, ok := sp.channels[channel]
+		stale := sp.closed || ok || len(sp.subs[channel]) == 0
+		sp.l.RUnlock()
+		if stale {
+			sp.subL.Unlock()
+			return
+		}
+		err := sp.subscribe(channel)
+		sp.subL.Unlock()
+		if err == nil {
+			return
+		}
+		sp.err(err)
+
+		select {
+		case <-time.After(sp.opts.backoff.Next(attempt)):
+		case <-sp.closeCh:
+			return
+		}
+		// the node might have been removed from the Cluster
+		if err := sp.c.Sync(); err != nil {
+			sp.err(err)
+		}
+	}
+}
+
+// migrate is called when redis unsubscribed the channel on the given node by
+// itself, because its slot was moved.
+func (sp *ShardedPubSub) migrate(n *shardedNode, channel string) {
+	defer sp.wg.Done()
+	sp.l.Lock()
+	if sp.channels[channel] == n {
+		delete(sp.channels, channel)
+	}
+	sp.l.Unlock()
+
+	if err := sp.c.Sync(); err != nil {
+		sp.err(err)
+	}
+	sp.resubscribe(channel)
+}
+
+// nodeFailed removes the node after its connection failed, and resubscribes
+// all channels which were subscribed on it.
+func (sp *ShardedPubSub) nodeFailed(n *shardedNode, err error) {
+	n.conn.Close()
+
+	sp.l.Lock()
+	if sp.nodes[n.addr] == n {
+		delete(sp.nodes, n.addr)
+	}
+	var channels []string
+	for channel, chanNode := range sp.channels {
+		if chanNode == n {
+			delete(sp.channels, channel)
+			channels = append(channels, channel)
+		}
+	}
+	closed := sp.closed
+	sp.l.Unlock()
+	if closed {
+		return
+	}
+
+	sp.err(err)
+	for _, channel := range channels {
+		sp.wg.Add(1)
+		go func(channel string) {
+			defer sp.wg.Done()
+			sp.resubscribe(channel)
+		}(channel)
+	}
+}
+
+// SSubscribe subscribes msgCh to the given channels, each on the node serving
+// its slot. See PubSubConn's Subscribe for how subscribing the same channel
+// with multiple msgChs behaves.
+func (sp *ShardedPubSub) SSubscribe(msgCh chan<- PubSubMessage, channels ...string) error {
+	sp.subL.Lock()
+	defer sp.subL.Unlock()
+
+	sp.l.RLock()
+	if sp.closed {
+		sp.l.RUnlock()
+		return errClientClosed
+	}
+	missing := sp.subs.missing(channels)
+	sp.l.RUnlock()
+
+	for _, channel := range missing {
+		if err := sp.subscribe(channel); err != nil {
+			return err
+		}
+	}
+
+	sp.l.Lock()
+	for _, channel := range channels {
+		sp.subs.add(channel, msgCh)
+	}
+	sp.l.Unlock()
+	return nil
+}
+
+// SUnsubscribe unsubscribes msgCh from the given channels, if it was subscribed
+// at all. The same note as for PubSubConn's Unsubscribe applies.
+func (sp *ShardedPubSub) SUnsubscribe(msgCh chan<- PubSubMessage, channels ...string) error {
+	sp.subL.Lock()
+	defer sp.subL.Unlock()
+
+	sp.l.Lock()
+	if sp.closed {
+		sp.l.Unlock()
+		return errClientClosed
+	}
+	emptyNodes := map[string]*shardedNode{}
+	for _, channel := range channels {
+		if empty := sp.subs.del(channel, msgCh); !empty {
+			continue
+		} else if n, ok := sp.channels[channel]; ok {
+			emptyNodes[channel] = n
+			delete(sp.channels, channel)
+		}
+	}
+	sp.l.Unlock()
+
+	for channel, n := range emptyNodes {
+		if err := n.do("SUNSUBSCRIBE", channel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping performs a simple Ping command on the connections to all nodes,
+// returning the first error encountered.
+func (sp *ShardedPubSub) Ping() error {
+	sp.l.RLock()
+	nodes := make([]*shardedNode, 0, len(sp.nodes))
+	for _, n := range sp.nodes {
+		nodes = append(nodes, n)
+	}
+	sp.l.RUnlock()
+
+	var err error
+	for _, n := range nodes {
+		if perr := n.do("PING"); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// Close closes the connections to all nodes. The subscribed msgChs will stop
+// receiving PubSubMessages, but will not themselves be closed.
+func (sp *ShardedPubSub) Close() error {
+	sp.l.Lock()
+	if sp.closed {
+		sp.l.Unlock()
+		return errClientClosed
+	}
+	sp.closed = true
+	nodes := sp.nodes
+	sp.nodes = map[string]*shardedNode{}
+	sp.l.Unlock()
+
+	close(sp.closeCh)
+	var err error
+	for _, n := range nodes {
+		if cerr := n.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	sp.wg.Wait()
+	if sp.opts.errCh != nil {
+		close(sp.opts.errCh)
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+package radix
+
+import (
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shardedPubSubStub implements SSUBSCRIBE and SUNSUBSCRIBE for the nodes of a
+// clusterStub.
+type shardedPubSubStub struct {
+	scl *clusterStub
+
+	l     sync.Mutex
+	conns map[string]*stub // addr -> most recent conn
+	subs  map[*stub]map[string]bool
+}
+
+func newShardedPubSubStub(scl *clusterStub) *shardedPubSubStub {
+	return &shardedPubSubStub{
+		scl:   scl,
+		conns: map[string]*stub{},
+		subs:  map[*stub]map[string]bool{},
+	}
+}
+
+func (s *shardedPubSubStub) connFunc(network, addr string) (Conn, error) {
+	var conn *stub
+	conn = Stub(network, addr, func(args []string) interface{} {
+		s.l.Lock()
+		defer s.l.Unlock()
+		switch strings.ToUpper(args[0]) {
+		case "SSUBSCRIBE":
+			slot := ClusterSlot([]byte(args[1]))
+			if owner := s.scl.stubForSlot(slot); owner.addr != addr {
+				return resp2.Error{E: errors.Errorf("MOVED %d %s", slot, owner.addr)}
+			}
+			s.subs[conn][args[1]] = true
+			return []interface{}{"ssubscribe", args[1], len(s.subs[conn])}
+		case "SUNSUBSCRIBE":
+			delete(s.subs[conn], args[1])
+			return []interface{}{"sunsubscribe", args[1], len(s.subs[conn])}
+		case "PING":
+			return []string{"pong", ""}
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %#v", args)}
+	}).(*stub)
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.conns[addr] = conn
+	s.subs[conn] = map[string]bool{}
+	return conn, nil
+}
+
+// subscribedOn returns the conn the channel is subscribed on, and its address.
+func (s *shardedPubSubStub) subscribedOn(channel string) (*stub, string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for addr, conn := range s.conns {
+		if s.subs[conn][channel] {
+			return conn, addr
+		}
+	}
+	return nil, ""
+}
+
+func (s *shardedPubSubStub) waitSubscribedOn(t *T, channel, addr string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, subAddr := s.subscribedOn(channel); subAddr == addr {
+			return
+		}
+		require.True(t, time.Now().Before(deadline), "%q not subscribed on %q", channel, addr)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *shardedPubSubStub) publish(t *T, channel, message string) {
+	conn, _ := s.subscribedOn(channel)
+	require.NotNil(t, conn)
+	require.NoError(t, conn.buffer.Encode(resp2.Any{I: []string{"smessage", channel, message}}))
+}
+
+// unsubscribe unsubscribes the channel like redis does after its slot moved.
+func (s *shardedPubSubStub) unsubscribe(t *T, channel string) {
+	conn, _ := s.subscribedOn(channel)
+	require.NotNil(t, conn)
+	s.l.Lock()
+	delete(s.subs[conn], channel)
+	s.l.Unlock()
+	require.NoError(t, conn.buffer.Encode(resp2.Any{I: []interface{}{"sunsubscribe", channel, 0}}))
+}
+
+func TestShardedPubSub(t *T) {
+	c, scl := newTestCluster()
+	defer c.Close()
+	s := newShardedPubSubStub(scl)
+
+	errCh := make(chan error, 16)
+	sp := NewShardedPubSub(c,
+		ShardedPubSubConnFunc(s.connFunc),
+		ShardedPubSubErrCh(errCh),
+		ShardedPubSubBackoff(ConstantBackoff(time.Millisecond)),
+	)
+
+	chA, chB := clusterSlotKeys[0], clusterSlotKeys[numSlots-1]
+	addrA, addrB := scl.stubForSlot(0).addr, scl.stubForSlot(numSlots-1).addr
+	require.NotEqual(t, addrA, addrB)
+
+	msgCh := make(chan PubSubMessage, 1)
+	require.NoError(t, sp.SSubscribe(msgCh, chA, chB))
+	s.waitSubscribedOn(t, chA, addrA)
+	s.waitSubscribedOn(t, chB, addrB)
+
+	assertSMessage := func(channel, message string) {
+		s.publish(t, channel, message)
+		assert.Equal(t, PubSubMessage{
+			Type:    "smessage",
+			Channel: channel,
+			Message: []byte(message),
+		}, assertMsgRead(t, msgCh))
+	}
+	assertSMessage(chA, "foo")
+	assertSMessage(chB, "bar")
+
+	// once the slot is moved and redis unsubscribed the channel, it's
+	// subscribed on the new node
+	scl.migrateSlotRange(addrB, 0, 1)
+	s.unsubscribe(t, chA)
+	s.waitSubscribedOn(t, chA, addrB)
+	assertSMessage(chA, "baz")
+
+	// if the connection fails all channels are subscribed again
+	conn, _ := s.subscribedOn(chB)
+	require.NoError(t, conn.Close())
+	s.l.Lock()
+	s.subs[conn] = map[string]bool{}
+	s.l.Unlock()
+	assert.Error(t, <-errCh)
+	s.waitSubscribedOn(t, chA, addrB)
+	s.waitSubscribedOn(t, chB, addrB)
+	newConn, _ := s.subscribedOn(chB)
+	assert.True(t, conn != newConn)
+	assertSMessage(chB, "qux")
+	require.NoError(t, sp.Ping())
+
+	require.NoError(t, sp.SUnsubscribe(msgCh, chA))
+	conn, _ = s.subscribedOn(chA)
+	assert.Nil(t, conn)
+	assertSMessage(chB, "quux")
+
+	require.NoError(t, sp.Close())
+	assert.Error(t, sp.SSubscribe(msgCh, chA))
+}
@@ -19,12 +19,18 @@ type clusterScanner struct {
 // NewScanner function.
 //
 // If the cluster topology changes during a scan the Scanner may or may not
-// error out due to it, depending on the nature of the change.
+// error out due to it, depending on the nature of the change. Keys which are
+// moved between nodes during the scan may be returned twice, unless
+// ScanOpts.Dedup is set.
 func (c *Cluster) NewScanner(o ScanOpts) Scanner {
 	if strings.ToUpper(o.Command) != "SCAN" {
 		panic("Cluster.NewScanner can only perform SCAN operations")
 	}
 
+	// keys need to be deduplicated across all nodes, not per node
+	dedup := o.Dedup
+	o.Dedup = false
+
 	var addrs []string
 	for _, node := range c.Topo().Primaries() {
 		addrs = append(addrs, node.Addr)
@@ -37,6 +43,9 @@ func (c *Cluster) NewScanner(o ScanOpts) Scanner {
 	}
 	cs.nextScanner()
 
+	if dedup {
+		return newDedupScanner(cs, o.Command)
+	}
 	return cs
 }
 
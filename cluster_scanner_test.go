@@ -25,3 +25,36 @@ func TestClusterScanner(t *T) {
 
 	assert.Equal(t, exp, got)
 }
+
+func TestClusterScannerDedup(t *T) {
+	c, scl := newTestCluster()
+	defer c.Close()
+	for _, k := range clusterSlotKeys {
+		require.Nil(t, c.Do(Cmd(nil, "SET", k, "1")))
+	}
+
+	// once the first key is returned, all keys of the first node are moved to
+	// the node which is scanned next
+	scanner := c.NewScanner(ScanOpts{Command: "SCAN", Dedup: true})
+	var k string
+	require.True(t, scanner.Next(&k))
+	src := scl.stubForSlot(ClusterSlot([]byte(k))).addr
+	dst := scanner.(*dedupScanner).Scanner.(*clusterScanner).addrs[0]
+	for _, node := range c.Topo().Primaries() {
+		if node.Addr == src {
+			for _, slots := range node.Slots {
+				scl.migrateSlotRange(dst, slots[0], slots[1])
+			}
+		}
+	}
+
+	got := map[string]int{k: 1}
+	for scanner.Next(&k) {
+		got[k]++
+	}
+	require.NoError(t, scanner.Close())
+	assert.Len(t, got, len(clusterSlotKeys))
+	for k, n := range got {
+		assert.Equal(t, 1, n, "key %q returned %d times", k, n)
+	}
+}
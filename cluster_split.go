@@ -0,0 +1,197 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"sync"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// clusterSplitCmds are the multi-key commands which Cluster.Do splits into one
+// command per slot if their keys belong to different slots, mapped to the
+// number of arguments per key.
+var clusterSplitCmds = map[string]int{
+	"MGET":   1,
+	"MSET":   2,
+	"DEL":    1,
+	"UNLINK": 1,
+	"EXISTS": 1,
+	"TOUCH":  1,
+}
+
+type clusterSplitCmd struct {
+	*cmdAction
+	name       string
+	argsPerKey int
+
+	// wrap wraps each split command the same way the original command was
+	// wrapped, e.g. using WithDeadline.
+	wrap func(Action) Action
+
+	// groups contains the indexes of the keys of each slot, in the order the
+	// slots first appear in.
+	groups [][]int
+}
+
+// unwrapSplitCmd returns the cmdAction wrapped by the given Action, looking
+// through the wrappers created by WithCommandName, WithDeadline, WithWireTrace,
+// Primary and WithClientCaching, and a function which wraps another Action in
+// the same way. The returned cmdAction is nil if there is none.
+func unwrapSplitCmd(a Action) (*cmdAction, func(Action) Action) {
+	var cmd *cmdAction
+	var wrap func(Action) Action
+	switch aa := a.(type) {
+	case *cmdAction:
+		return aa, func(a Action) Action { return a }
+	case *namedAction:
+		cmd, wrap = unwrapSplitCmd(aa.Action)
+		return cmd, func(a Action) Action { cp := *aa; cp.Action = wrap(a); return &cp }
+	case *deadlineAction:
+		cmd, wrap = unwrapSplitCmd(aa.Action)
+		return cmd, func(a Action) Action { cp := *aa; cp.Action = wrap(a); return &cp }
+	case *wireTraceAction:
+		cmd, wrap = unwrapSplitCmd(aa.Action)
+		return cmd, func(a Action) Action { cp := *aa; cp.Action = wrap(a); return &cp }
+	case *primaryAction:
+		cmd, wrap = unwrapSplitCmd(aa.Action)
+		return cmd, func(a Action) Action { cp := *aa; cp.Action = wrap(a); return &cp }
+	case *cachingAction:
+		cmd, wrap = unwrapSplitCmd(aa.Action)
+		return cmd, func(a Action) Action { cp := *aa; cp.Action = wrap(a); return &cp }
+	default:
+		return nil, nil
+	}
+}
+
+// newClusterSplitCmd returns the given Action as a clusterSplitCmd, if it's a
+// command which can be split and its keys belong to more than one slot. The
+// command may be wrapped, see unwrapSplitCmd.
+func newClusterSplitCmd(a Action) (*clusterSplitCmd, bool) {
+	cmd, wrap := unwrapSplitCmd(a)
+	if cmd == nil || cmd.flat {
+		return nil, false
+	}
+
+	name := strings.ToUpper(cmd.cmd)
+	argsPerKey, ok := clusterSplitCmds[name]
+	numArgs := len(cmd.args) + len(cmd.bytesArgs)
+	if !ok || numArgs == 0 || numArgs%argsPerKey != 0 {
+		return nil, false
+	}
+
+	var groups [][]int
+	bySlot := map[uint16]int{}
+	for i := 0; i < numArgs/argsPerKey; i++ {
+		slot := ClusterSlot([]byte(cmd.arg(i * argsPerKey)))
+		g, ok := bySlot[slot]
+		if !ok {
+			g = len(groups)
+			bySlot[slot] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	if len(groups) < 2 {
+		return nil, false
+	}
+
+	return &clusterSplitCmd{
+		cmdAction:  cmd,
+		name:       name,
+		argsPerKey: argsPerKey,
+		wrap:       wrap,
+		groups:     groups,
+	}, true
+}
+
+// subCmd returns a command for the keys with the given indexes.
+func (sc *clusterSplitCmd) subCmd(rcv interface{}, idxs []int) CmdAction {
+	n := sc.argsPerKey
+	if sc.bytesArgs != nil {
+		args := make([][]byte, 0, len(idxs)*n)
+		for _, idx := range idxs {
+			args = append(args, sc.bytesArgs[idx*n:(idx+1)*n]...)
+		}
+		return CmdBytes(rcv, sc.cmd, args...)
+	}
+
+	args := make([]string, 0, len(idxs)*n)
+	for _, idx := range idxs {
+		args = append(args, sc.args[idx*n:(idx+1)*n]...)
+	}
+	return Cmd(rcv, sc.cmd, args...)
+}
+
+func (c *Cluster) doSplit(sc *clusterSplitCmd) error {
+	cmds := make([]CmdAction, len(sc.groups))
+	vals := make([][]resp2.RawMessage, len(sc.groups))
+	ints := make([]int64, len(sc.groups))
+	for i, idxs := range sc.groups {
+		switch sc.name {
+		case "MGET":
+			cmds[i] = sc.subCmd(&vals[i], idxs)
+		case "MSET":
+			cmds[i] = sc.subCmd(nil, idxs)
+		default:
+			cmds[i] = sc.subCmd(&ints[i], idxs)
+		}
+	}
+
+	errs := make([]error, len(cmds))
+	var wg sync.WaitGroup
+	for i := range cmds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.do(sc.wrap(cmds[i]))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	var reply []resp.Marshaler
+	switch sc.name {
+	case "MGET":
+		numKeys := (len(sc.args) + len(sc.bytesArgs)) / sc.argsPerKey
+		merged := make([]resp2.RawMessage, numKeys)
+		for i, idxs := range sc.groups {
+			if len(vals[i]) != len(idxs) {
+				return errors.Errorf("MGET returned %d values for %d keys", len(vals[i]), len(idxs))
+			}
+			for j, idx := range idxs {
+				merged[idx] = vals[i][j]
+			}
+		}
+		reply = append(reply, resp2.ArrayHeader{N: numKeys})
+		for _, val := range merged {
+			reply = append(reply, val)
+		}
+	case "MSET":
+		reply = append(reply, resp2.SimpleString{S: "OK"})
+	default:
+		var sum int64
+		for _, n := range ints {
+			sum += n
+		}
+		reply = append(reply, resp2.Int{I: sum})
+	}
+
+	// the merged reply is unmarshaled into the receiver as if it had been
+	// returned by redis
+	buf := new(bytes.Buffer)
+	for _, m := range reply {
+		if err := m.MarshalRESP(buf); err != nil {
+			return err
+		}
+	}
+	return resp2.Any{I: sc.rcv, Strict: sc.strict}.UnmarshalRESP(bufio.NewReader(buf))
+}
@@ -165,6 +165,37 @@ func (s *clusterNodeStub) newConn() Conn {
 				slot.kv[k] = args[2]
 				return resp2.SimpleString{S: "OK"}
 			})
+		case "MSET":
+			var ks []string
+			for i := 1; i < len(args); i += 2 {
+				ks = append(ks, args[i])
+			}
+			return s.withKeys(ks, asking, readonly, func(slot clusterSlotStub) interface{} {
+				for i := 1; i+1 < len(args); i += 2 {
+					slot.kv[args[i]] = args[i+1]
+				}
+				return resp2.SimpleString{S: "OK"}
+			})
+		case "DEL":
+			ks := args[1:]
+			return s.withKeys(ks, asking, readonly, func(slot clusterSlotStub) interface{} {
+				var n int
+				for _, k := range ks {
+					if _, ok := slot.kv[k]; ok {
+						delete(slot.kv, k)
+						n++
+					}
+				}
+				return n
+			})
+		case "EXISTS":
+			k := args[1]
+			return s.withKey(k, asking, readonly, func(slot clusterSlotStub) interface{} {
+				if _, ok := slot.kv[k]; ok {
+					return 1
+				}
+				return 0
+			})
 		case "EVALSHA":
 			return resp2.Error{E: errors.New("NOSCRIPT: clusterNodeStub does not support EVALSHA")}
 		case "EVAL":
@@ -193,6 +224,10 @@ func (s *clusterNodeStub) newConn() Conn {
 			return resp2.SimpleString{S: "OK"}
 		case "ADDR":
 			return s.addr
+		case "CONFIG":
+			if len(args) == 3 && strings.ToUpper(args[1]) == "GET" {
+				return []string{args[2], "0"}
+			}
 		case "SCAN":
 			if cur := args[1]; cur == "0" {
 				var keys []string
@@ -1,12 +1,17 @@
 package radix
 
 import (
+	"strings"
+	"sync"
+	"sync/atomic"
 	. "testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
 
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 	"github.com/mediocregopher/radix/v3/trace"
 )
 
@@ -146,6 +151,66 @@ func TestClusterSync(t *T) {
 	}
 }
 
+func TestClusterNodeForSlot(t *T) {
+	c, scl := newTestCluster()
+	defer c.Close()
+
+	for _, node := range testTopo.Primaries() {
+		for _, slot := range node.Slots {
+			for s := slot[0]; s < slot[1]; s++ {
+				addr, err := c.NodeForSlot(s)
+				require.NoError(t, err)
+				require.Equal(t, node.Addr, addr)
+
+				addr, err = c.NodeForKey(clusterSlotKeys[s])
+				require.NoError(t, err)
+				require.Equal(t, node.Addr, addr)
+			}
+		}
+	}
+
+	_, err := c.NodeForSlot(numSlots)
+	assert.Error(t, err)
+
+	// the result must reflect the topology after a sync
+	srcAddr, err := c.NodeForSlot(0)
+	require.NoError(t, err)
+	var dstAddr string
+	for _, node := range scl.topo().Primaries() {
+		if node.Addr != srcAddr {
+			dstAddr = node.Addr
+			break
+		}
+	}
+	scl.migrateSlotRange(dstAddr, 0, 10)
+	require.NoError(t, c.Sync())
+
+	addr, err := c.NodeForKey(clusterSlotKeys[5])
+	require.NoError(t, err)
+	assert.Equal(t, dstAddr, addr)
+	addr, err = c.NodeForSlot(10)
+	require.NoError(t, err)
+	assert.Equal(t, srcAddr, addr)
+}
+
+func TestClusterDoCrossSlot(t *T) {
+	c, _ := newTestCluster()
+	defer c.Close()
+
+	// "foo" and "bar" are in different slots, "{foo}bar" is in the same one
+	// as "foo"
+	err := c.Do(Cmd(nil, "BITOP", "AND", "foo", "bar"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "do not belong to the same slot")
+
+	script := NewEvalScript(2, "return 1")
+	err = c.Do(script.Cmd(nil, "foo", "bar"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "do not belong to the same slot")
+
+	require.NoError(t, c.Do(script.Cmd(nil, "foo", "{foo}bar")))
+}
+
 func TestClusterGet(t *T) {
 	c, _ := newTestCluster()
 	defer c.Close()
@@ -181,7 +246,7 @@ func TestClusterDo(t *T) {
 	{
 		var vgot string
 		cmd := Cmd(&vgot, "GET", k)
-		require.Nil(t, c.doInner(cmd, stub16k.addr, k, false, doAttempts))
+		require.Nil(t, c.doInner(cmd, stub16k.addr, k, false, nil))
 		assert.Equal(t, v, vgot)
 		assert.Equal(t, trace.ClusterRedirected{
 			Addr:          stub16k.addr,
@@ -220,6 +285,148 @@ func TestClusterDo(t *T) {
 	}
 }
 
+func TestClusterMaxRedirects(t *T) {
+	scl := newStubCluster(testTopo)
+	primaries := scl.topo().Primaries()
+	addrA, addrB := primaries[0].Addr, primaries[1].Addr
+
+	// every node redirects every command to one of the two nodes, so commands
+	// are redirected back and forth between them forever
+	pf := func(network, addr string) (Client, error) {
+		movedTo := addrA
+		if addr == addrA {
+			movedTo = addrB
+		}
+		return Stub(network, addr, func(args []string) interface{} {
+			if strings.ToUpper(args[0]) == "CLUSTER" {
+				return scl.topo()
+			}
+			return resp2.Error{E: errors.Errorf("MOVED 0 %s", movedTo)}
+		}), nil
+	}
+
+	newCluster := func(opts ...ClusterOpt) (*Cluster, *[]trace.ClusterRedirected) {
+		var redirects []trace.ClusterRedirected
+		opts = append([]ClusterOpt{
+			ClusterPoolFunc(pf),
+			ClusterWithTrace(trace.ClusterTrace{
+				Redirected: func(r trace.ClusterRedirected) {
+					redirects = append(redirects, r)
+				},
+			}),
+		}, opts...)
+		c, err := NewCluster(scl.addrs(), opts...)
+		require.NoError(t, err)
+		return c, &redirects
+	}
+
+	t.Run("default", func(t *T) {
+		c, redirects := newCluster()
+		defer c.Close()
+
+		err := c.doInner(Cmd(nil, "GET", "foo"), addrA, "foo", false, nil)
+		assert.EqualError(t, err, "cluster action redirected too many times ("+
+			strings.Join([]string{addrA, addrB, addrA, addrB, addrA, addrB, addrA}, " -> ")+
+			"): MOVED 0 "+addrA)
+		require.Len(t, *redirects, 6)
+		for i, r := range *redirects {
+			assert.Equal(t, i+1, r.RedirectCount)
+			assert.Equal(t, i == 5, r.Final)
+		}
+	})
+
+	t.Run("custom", func(t *T) {
+		c, redirects := newCluster(ClusterMaxRedirects(1))
+		defer c.Close()
+
+		err := c.doInner(Cmd(nil, "GET", "foo"), addrA, "foo", false, nil)
+		assert.EqualError(t, err, "cluster action redirected too many times ("+
+			addrA+" -> "+addrB+" -> "+addrA+"): MOVED 0 "+addrA)
+		assert.Len(t, *redirects, 2)
+	})
+
+	t.Run("disabled", func(t *T) {
+		c, redirects := newCluster(ClusterMaxRedirects(0))
+		defer c.Close()
+
+		err := c.doInner(Cmd(nil, "GET", "foo"), addrA, "foo", false, nil)
+		assert.EqualError(t, err, "MOVED 0 "+addrB)
+		require.Len(t, *redirects, 1)
+		assert.True(t, (*redirects)[0].Final)
+	})
+
+	t.Run("backoff", func(t *T) {
+		var attempts []int
+		c, _ := newCluster(ClusterMaxRedirects(3), ClusterRedirectBackoff(BackoffFunc(func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return 0
+		})))
+		defer c.Close()
+
+		err := c.doInner(Cmd(nil, "GET", "foo"), addrA, "foo", false, nil)
+		assert.Error(t, err)
+		assert.Equal(t, []int{1, 2, 3}, attempts)
+	})
+}
+
+func TestClusterLatencies(t *T) {
+	scl := newStubCluster(testTopo)
+	c := scl.newCluster(ClusterPoolFunc(func(network, addr string) (Client, error) {
+		return NewPool(network, addr, 1,
+			PoolConnFunc(func(network, addr string) (Conn, error) {
+				cl, err := scl.clientFunc()(network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return cl.(Conn), nil
+			}),
+			PoolPingInterval(10*time.Millisecond),
+		)
+	}))
+	defer c.Close()
+
+	for i := 0; i < 100 && len(c.Latencies()) < len(scl.addrs()); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, c.Latencies(), len(scl.addrs()))
+	for addr, l := range c.Latencies() {
+		assert.True(t, l > 0, "latency of %s: %v", addr, l)
+	}
+}
+
+func TestClusterDoNoKey(t *T) {
+	c, scl := newTestCluster()
+	defer c.Close()
+
+	var pong string
+	require.NoError(t, c.Do(Cmd(&pong, "PING")))
+	assert.Equal(t, "PONG", pong)
+	require.NoError(t, c.Do(Cmd(&pong, "PING", "foo")))
+
+	for _, mkCmd := range []func(rcv interface{}) CmdAction{
+		func(rcv interface{}) CmdAction { return Cmd(rcv, "CONFIG", "GET", "maxmemory") },
+		func(rcv interface{}) CmdAction { return FlatCmd(rcv, "CONFIG", "GET", "maxmemory") },
+	} {
+		var config map[string]string
+		cmd := mkCmd(&config)
+		assert.Empty(t, cmd.Keys())
+		require.NoError(t, c.Do(cmd))
+		assert.Equal(t, map[string]string{"maxmemory": "0"}, config)
+	}
+
+	// with ClusterNoKeyAddr all Actions without keys go to the same node
+	addr := scl.topo().Primaries()[1].Addr
+	c2 := scl.newCluster(ClusterNoKeyAddr(addr))
+	defer c2.Close()
+	for i := 0; i < 10; i++ {
+		var got string
+		require.NoError(t, c2.Do(Cmd(&got, "ADDR")))
+		assert.Equal(t, addr, got)
+	}
+	errs := c2.DoPipeline(Cmd(nil, "ADDR"), Cmd(nil, "PING"))
+	assert.Nil(t, errs)
+}
+
 func TestClusterDoWhenDown(t *T) {
 	var stub *clusterNodeStub
 
@@ -350,6 +557,177 @@ func TestClusterDoSecondary(t *T) {
 	assert.NoError(t, c.DoSecondary(Cmd(&res4, "GET", key)))
 	assert.Equal(t, value, res4)
 	assert.Equal(t, 2, redirects)
+
+	var res5 string
+	assert.NoError(t, c.DoSecondary(Primary(Cmd(&res5, "GET", key))))
+	assert.Equal(t, value, res5)
+	assert.Equal(t, 2, redirects)
+
+	// Primary is also detected within other wrappers
+	var res6 string
+	a := WithCommandName(WithTimeout(Primary(Cmd(&res6, "GET", key)), time.Second), "get")
+	assert.NoError(t, c.DoSecondary(a))
+	assert.Equal(t, value, res6)
+	assert.Equal(t, 2, redirects)
+}
+
+func TestClusterReadPolicy(t *T) {
+	var redirects int
+	c, _ := newTestCluster(
+		ClusterReadPolicy(ReadPolicyReplicaOnly, nil),
+		ClusterWithTrace(trace.ClusterTrace{
+			Redirected: func(trace.ClusterRedirected) {
+				redirects++
+			},
+		}),
+	)
+	defer c.Close()
+
+	key := clusterSlotKeys[0]
+	value := randStr()
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, value)))
+	require.Zero(t, redirects)
+
+	// the replica isn't in READONLY mode and redirects to the primary
+	var res string
+	require.NoError(t, c.Do(Cmd(&res, "GET", key)))
+	assert.Equal(t, value, res)
+	assert.Equal(t, 1, redirects)
+
+	require.NoError(t, c.Do(Primary(Cmd(&res, "GET", key))))
+	assert.Equal(t, 1, redirects)
+
+	var secAddr string
+	for secAddr = range c.secondaries[c.addrForKey(key)] {
+		break
+	}
+	sec, err := c.Client(secAddr)
+	require.NoError(t, err)
+	require.NoError(t, sec.Do(Cmd(nil, "READONLY")))
+
+	res = ""
+	require.NoError(t, c.Do(Cmd(&res, "GET", key)))
+	assert.Equal(t, value, res)
+	assert.Equal(t, 1, redirects)
+}
+
+func TestClusterDoOnAllSecondaries(t *T) {
+	c, scl := newTestCluster()
+	defer c.Close()
+
+	key := clusterSlotKeys[0]
+	value := randStr()
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, value)))
+
+	_, err := c.DoOnAllSecondaries("SET", key, value)
+	assert.True(t, errors.Is(err, ErrReadOnly))
+	// commands which aren't writes may still have side effects
+	_, err = c.DoOnAllSecondaries("PUBLISH", key, value)
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	results, err := c.DoOnAllSecondaries("GET", key)
+	require.NoError(t, err)
+
+	var numSecondaries int
+	for _, node := range scl.topo() {
+		if node.SecondaryOfAddr != "" {
+			numSecondaries++
+		}
+	}
+	require.Len(t, results, numSecondaries)
+
+	primAddr := c.addrForKey(key)
+	for addr, res := range results {
+		if _, ok := c.secondaries[primAddr][addr]; !ok {
+			assert.Error(t, res.Err, "addr:%q", addr)
+			continue
+		}
+		require.NoError(t, res.Err)
+		var got string
+		require.NoError(t, res.Reply.UnmarshalInto(resp2.Any{I: &got}))
+		assert.Equal(t, value, got)
+
+		// the connection isn't left in READONLY mode
+		client, err := c.Client(addr)
+		require.NoError(t, err)
+		assert.Error(t, client.Do(Cmd(nil, "GET", key)))
+	}
+}
+
+func TestClusterDoPipeline(t *T) {
+	var l sync.Mutex
+	var redirects []trace.ClusterRedirected
+	c, scl := newTestCluster(ClusterWithTrace(trace.ClusterTrace{
+		Redirected: func(r trace.ClusterRedirected) {
+			l.Lock()
+			defer l.Unlock()
+			redirects = append(redirects, r)
+		},
+	}))
+	defer c.Close()
+
+	stub0 := scl.stubForSlot(0)
+	stub16k := scl.stubForSlot(16000)
+	require.NotEqual(t, stub0.addr, stub16k.addr)
+
+	k0, k8k, k16k := clusterSlotKeys[0], clusterSlotKeys[8000], clusterSlotKeys[16000]
+	errs := c.DoPipeline(
+		Cmd(nil, "SET", k0, "a"),
+		Cmd(nil, "SET", k8k, "b"),
+		Cmd(nil, "SET", k16k, "c"),
+	)
+	require.Nil(t, errs)
+
+	t.Run("errors", func(t *T) {
+		var v0, v16k string
+		errs := c.DoPipeline(
+			Cmd(&v0, "GET", k0),
+			Cmd(nil, "FOO", k16k),
+			Cmd(&v16k, "GET", k16k),
+		)
+		require.Len(t, errs, 3)
+		assert.NoError(t, errs[0])
+		assert.Error(t, errs[1])
+		assert.NoError(t, errs[2])
+		assert.Equal(t, "a", v0)
+		assert.Equal(t, "c", v16k)
+	})
+
+	t.Run("redirects", func(t *T) {
+		// ASK for slot 0
+		scl.migrateInit(stub16k.addr, 0)
+		scl.migrateKey(k0)
+
+		// MOVED for slot 8000, since the Cluster isn't synced. The destination
+		// must not be a replica sharing the dataset of the current primary.
+		stub8k := scl.stubForSlot(8000)
+		dst := scl.randStub()
+		for dst.clusterDatasetStub == stub8k.clusterDatasetStub {
+			dst = scl.randStub()
+		}
+		scl.migrateSlotRange(dst.addr, 8000, 8001)
+
+		var v0, v8k, v16k string
+		errs := c.DoPipeline(
+			Cmd(&v0, "GET", k0),
+			Cmd(&v8k, "GET", k8k),
+			Cmd(&v16k, "GET", k16k),
+		)
+		require.Nil(t, errs)
+		assert.Equal(t, "a", v0)
+		assert.Equal(t, "b", v8k)
+		assert.Equal(t, "c", v16k)
+
+		require.Len(t, redirects, 2)
+		for _, r := range redirects {
+			if r.Key == k0 {
+				assert.True(t, r.Ask)
+			} else {
+				assert.Equal(t, k8k, r.Key)
+				assert.True(t, r.Moved)
+			}
+		}
+	})
 }
 
 var clusterAddrs []string
@@ -359,3 +737,94 @@ func ExampleClusterPoolFunc_defaultClusterConnFunc() {
 		return NewPool(network, addr, 4, PoolConnFunc(DefaultClusterConnFunc))
 	}))
 }
+
+// tryAgainClient fails the first tryAgain GET commands with a TRYAGAIN error.
+type tryAgainClient struct {
+	Client
+	tryAgain *int64
+}
+
+func (c tryAgainClient) Do(a Action) error {
+	if cmd, ok := a.(*cmdAction); ok && cmd.cmd == "GET" && atomic.AddInt64(c.tryAgain, -1) >= 0 {
+		return resp2.Error{E: errors.New("TRYAGAIN Multiple keys request during rehashing of slot")}
+	}
+	return c.Client.Do(a)
+}
+
+func TestClusterRetryPolicy(t *T) {
+	scl := newStubCluster(testTopo)
+	var tryAgain int64
+	cf := scl.clientFunc()
+	c := scl.newCluster(
+		ClusterPoolFunc(func(network, addr string) (Client, error) {
+			client, err := cf(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return tryAgainClient{Client: client, tryAgain: &tryAgain}, nil
+		}),
+		ClusterRetryPolicy(RetryPolicy{Backoff: ConstantBackoff(0)}),
+	)
+	defer c.Close()
+
+	key := clusterSlotKeys[0]
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "foo")))
+
+	var res string
+	atomic.StoreInt64(&tryAgain, 2)
+	require.NoError(t, c.Do(Cmd(&res, "GET", key)))
+	assert.Equal(t, "foo", res)
+
+	atomic.StoreInt64(&tryAgain, 3)
+	err := c.Do(Cmd(&res, "GET", key))
+	assert.True(t, strings.HasPrefix(err.Error(), "TRYAGAIN "), "err: %v", err)
+}
+
+func TestClusterDoSplit(t *T) {
+	c, _ := newTestCluster()
+	defer c.Close()
+
+	// keys of three different slots, with the first and last sharing one
+	k1, k2, k3 := clusterSlotKeys[0], clusterSlotKeys[numSlots-1], clusterSlotKeys[numSlots/2]
+	k4 := "{" + k1 + "}a"
+	require.NoError(t, c.Do(Cmd(nil, "MSET", k1, "1", k2, "2", k3, "3", k4, "4")))
+
+	var vals []string
+	require.NoError(t, c.Do(Cmd(&vals, "MGET", k4, k2, k1, k3)))
+	assert.Equal(t, []string{"4", "2", "1", "3"}, vals)
+
+	var bvals [][]byte
+	require.NoError(t, c.Do(CmdBytes(&bvals, "MGET", []byte(k3), []byte(k1))))
+	assert.Equal(t, [][]byte{[]byte("3"), []byte("1")}, bvals)
+
+	var n int
+	require.NoError(t, c.Do(Cmd(&n, "DEL", k1, k2, k4, randStr())))
+	assert.Equal(t, 3, n)
+
+	vals = nil
+	require.NoError(t, c.Do(Cmd(&vals, "MGET", k1, k2, k3)))
+	assert.Equal(t, []string{"", "", "3"}, vals)
+
+	// wrapped commands are split too, with each split command being wrapped
+	// the same way
+	vals = nil
+	a := WithTimeout(WithCommandName(Cmd(&vals, "MGET", k3, k1), "mget-all"), time.Second)
+	require.NoError(t, c.Do(a))
+	assert.Equal(t, []string{"3", ""}, vals)
+
+	sc, ok := newClusterSplitCmd(a)
+	require.True(t, ok)
+	sub := sc.wrap(sc.subCmd(nil, sc.groups[0]))
+	name, _ := commandName(sub)
+	assert.Equal(t, "mget-all", name)
+	_, ok = actionDeadline(sub)
+	assert.True(t, ok)
+
+	// commands with keys of a single slot aren't split
+	_, ok = newClusterSplitCmd(Cmd(nil, "MGET", k1, k4))
+	assert.False(t, ok)
+	_, ok = newClusterSplitCmd(Cmd(nil, "MSET", k1, "1", k2))
+	assert.False(t, ok)
+	_, ok = newClusterSplitCmd(Cmd(nil, "BITOP", "AND", k1, k2))
+	assert.False(t, ok)
+}
@@ -0,0 +1,90 @@
+package radix
+
+// CommandDoc describes a single command, as returned by COMMAND DOCS.
+type CommandDoc struct {
+	Summary    string `redis:"summary"`
+	Since      string `redis:"since"`
+	Group      string `redis:"group"`
+	Complexity string `redis:"complexity"`
+
+	// Module is the name of the module providing the command, if any.
+	Module string `redis:"module"`
+
+	// DocFlags contains flags describing the command, e.g. "deprecated".
+	DocFlags []string `redis:"doc_flags"`
+
+	// DeprecatedSince and ReplacedBy are only set for deprecated commands.
+	DeprecatedSince string `redis:"deprecated_since"`
+	ReplacedBy      string `redis:"replaced_by"`
+
+	// History contains pairs of versions and descriptions of the changes
+	// made to the command in that version.
+	History [][]string `redis:"history"`
+
+	Arguments []CommandArg `redis:"arguments"`
+
+	// Subcommands contains the docs of all subcommands of a container command,
+	// e.g. "config|get" for CONFIG, keyed by their full name.
+	Subcommands map[string]CommandDoc `redis:"subcommands"`
+}
+
+// CommandArg describes a single argument of a command, as returned by COMMAND
+// DOCS.
+type CommandArg struct {
+	Name string `redis:"name"`
+
+	// Type is one of "string", "integer", "double", "key", "pattern",
+	// "unix-time", "pure-token", "oneof" or "block". Arguments of type "oneof"
+	// and "block" contain nested Arguments.
+	Type string `redis:"type"`
+
+	DisplayText string `redis:"display_text"`
+
+	// KeySpecIndex is the index of the key specification of the command which
+	// describes this argument. It is only set for arguments of type "key".
+	KeySpecIndex int `redis:"key_spec_index"`
+
+	// Token is a constant literal preceding the argument, if any.
+	Token           string `redis:"token"`
+	Summary         string `redis:"summary"`
+	Since           string `redis:"since"`
+	DeprecatedSince string `redis:"deprecated_since"`
+
+	// Flags may contain "optional", "multiple" and "multiple_token".
+	Flags []string `redis:"flags"`
+
+	Arguments []CommandArg `redis:"arguments"`
+}
+
+// Optional returns true if the argument has the "optional" flag.
+func (ca CommandArg) Optional() bool {
+	return ca.hasFlag("optional")
+}
+
+// Multiple returns true if the argument has the "multiple" flag, meaning it can
+// be repeated.
+func (ca CommandArg) Multiple() bool {
+	return ca.hasFlag("multiple")
+}
+
+func (ca CommandArg) hasFlag(flag string) bool {
+	for _, f := range ca.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandDocs returns the docs of the given commands, keyed by their lowercase
+// names, using COMMAND DOCS. If no names are given the docs of all commands are
+// returned. Unknown commands are not included in the result.
+//
+// COMMAND DOCS is only available since redis 7.0.
+func CommandDocs(c Client, names ...string) (map[string]CommandDoc, error) {
+	docs := map[string]CommandDoc{}
+	if err := c.Do(Cmd(&docs, "COMMAND", append([]string{"DOCS"}, names...)...)); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
@@ -0,0 +1,145 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandDocs(t *T) {
+	var gotArgs []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		gotArgs = args
+		// shortened versions of the docs returned by redis 7.0
+		return []interface{}{
+			"get", []interface{}{
+				"summary", "Get the value of a key",
+				"since", "1.0.0",
+				"group", "string",
+				"complexity", "O(1)",
+				"arguments", []interface{}{
+					[]interface{}{"name", "key", "type", "key", "key_spec_index", 0},
+				},
+			},
+			"set", []interface{}{
+				"summary", "Set the string value of a key",
+				"since", "1.0.0",
+				"group", "string",
+				"complexity", "O(1)",
+				"history", []interface{}{
+					[]string{"2.6.12", "Added the `EX`, `PX`, `NX` and `XX` options."},
+				},
+				"arguments", []interface{}{
+					[]interface{}{"name", "key", "type", "key", "key_spec_index", 0},
+					[]interface{}{"name", "value", "type", "string"},
+					[]interface{}{
+						"name", "condition",
+						"type", "oneof",
+						"since", "2.6.12",
+						"flags", []string{"optional"},
+						"arguments", []interface{}{
+							[]interface{}{"name", "nx", "type", "pure-token", "token", "NX"},
+							[]interface{}{"name", "xx", "type", "pure-token", "token", "XX"},
+						},
+					},
+				},
+			},
+			"config", []interface{}{
+				"summary", "A container for server configuration commands",
+				"since", "2.0.0",
+				"group", "server",
+				"complexity", "Depends on subcommand.",
+				"subcommands", []interface{}{
+					"config|get", []interface{}{
+						"summary", "Get the values of configuration parameters",
+						"since", "2.0.0",
+						"group", "server",
+						"complexity", "O(N) when N is the number of configuration parameters provided",
+						"arguments", []interface{}{
+							[]interface{}{"name", "parameter", "type", "string", "flags", []string{"multiple"}},
+						},
+					},
+				},
+			},
+			"slaveof", []interface{}{
+				"summary", "Make the server a replica of another instance, or promote it as master.",
+				"since", "1.0.0",
+				"group", "server",
+				"complexity", "O(1)",
+				"doc_flags", []string{"deprecated"},
+				"deprecated_since", "5.0.0",
+				"replaced_by", "`REPLICAOF`",
+			},
+		}
+	})
+
+	docs, err := CommandDocs(stub, "get", "set", "config", "slaveof")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"COMMAND", "DOCS", "get", "set", "config", "slaveof"}, gotArgs)
+	assert.Equal(t, map[string]CommandDoc{
+		"get": {
+			Summary:    "Get the value of a key",
+			Since:      "1.0.0",
+			Group:      "string",
+			Complexity: "O(1)",
+			Arguments: []CommandArg{
+				{Name: "key", Type: "key"},
+			},
+		},
+		"set": {
+			Summary:    "Set the string value of a key",
+			Since:      "1.0.0",
+			Group:      "string",
+			Complexity: "O(1)",
+			History: [][]string{
+				{"2.6.12", "Added the `EX`, `PX`, `NX` and `XX` options."},
+			},
+			Arguments: []CommandArg{
+				{Name: "key", Type: "key"},
+				{Name: "value", Type: "string"},
+				{
+					Name:  "condition",
+					Type:  "oneof",
+					Since: "2.6.12",
+					Flags: []string{"optional"},
+					Arguments: []CommandArg{
+						{Name: "nx", Type: "pure-token", Token: "NX"},
+						{Name: "xx", Type: "pure-token", Token: "XX"},
+					},
+				},
+			},
+		},
+		"config": {
+			Summary:    "A container for server configuration commands",
+			Since:      "2.0.0",
+			Group:      "server",
+			Complexity: "Depends on subcommand.",
+			Subcommands: map[string]CommandDoc{
+				"config|get": {
+					Summary:    "Get the values of configuration parameters",
+					Since:      "2.0.0",
+					Group:      "server",
+					Complexity: "O(N) when N is the number of configuration parameters provided",
+					Arguments: []CommandArg{
+						{Name: "parameter", Type: "string", Flags: []string{"multiple"}},
+					},
+				},
+			},
+		},
+		"slaveof": {
+			Summary:         "Make the server a replica of another instance, or promote it as master.",
+			Since:           "1.0.0",
+			Group:           "server",
+			Complexity:      "O(1)",
+			DocFlags:        []string{"deprecated"},
+			DeprecatedSince: "5.0.0",
+			ReplacedBy:      "`REPLICAOF`",
+		},
+	}, docs)
+
+	condition := docs["set"].Arguments[2]
+	assert.True(t, condition.Optional())
+	assert.False(t, condition.Multiple())
+	assert.True(t, docs["config"].Subcommands["config|get"].Arguments[0].Multiple())
+}
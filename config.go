@@ -0,0 +1,88 @@
+package radix
+
+import (
+	"sort"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// isWrongNumArgsErr returns true if err is the error redis returns for a
+// command called with an unsupported number of arguments, as is the case when
+// using the multi-parameter forms of CONFIG SET/GET with redis versions prior to
+// 7.0.
+func isWrongNumArgsErr(err error) bool {
+	var respErr resp2.Error
+	return errors.As(err, &respErr) &&
+		strings.Contains(strings.ToLower(respErr.Error()), "wrong number of arguments")
+}
+
+// ConfigSetMulti sets all of the given config parameters using a single
+// CONFIG SET command, as supported since redis 7.0.
+//
+// If the server doesn't support setting multiple parameters at once, each
+// parameter is set using a separate CONFIG SET instead, with all commands
+// being pipelined. Unlike the single command, this is not atomic and may leave
+// some parameters changed if setting one of them fails.
+func ConfigSetMulti(c Client, params map[string]string) error {
+	if len(params) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := make([]string, 0, 1+len(params)*2)
+	args = append(args, "SET")
+	for _, name := range names {
+		args = append(args, name, params[name])
+	}
+
+	err := c.Do(Cmd(nil, "CONFIG", args...))
+	if len(params) == 1 || !isWrongNumArgsErr(err) {
+		return err
+	}
+
+	cmds := make([]CmdAction, len(names))
+	for i, name := range names {
+		cmds[i] = Cmd(nil, "CONFIG", "SET", name, params[name])
+	}
+	return c.Do(Pipeline(cmds...))
+}
+
+// ConfigGetMulti returns the values of all config parameters matching any of
+// the given parameters (which may be glob-style patterns), using a single
+// CONFIG GET command, as supported since redis 7.0.
+//
+// If the server doesn't support getting multiple parameters at once, each
+// parameter is retrieved using a separate CONFIG GET instead, with all commands
+// being pipelined.
+func ConfigGetMulti(c Client, params ...string) (map[string]string, error) {
+	res := map[string]string{}
+	if len(params) == 0 {
+		return res, nil
+	}
+
+	err := c.Do(Cmd(&res, "CONFIG", append([]string{"GET"}, params...)...))
+	if len(params) == 1 || !isWrongNumArgsErr(err) {
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	// all commands can unmarshal into the same map
+	cmds := make([]CmdAction, len(params))
+	for i, param := range params {
+		cmds[i] = Cmd(&res, "CONFIG", "GET", param)
+	}
+	if err := c.Do(Pipeline(cmds...)); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,80 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// testConfigStub returns a Conn which implements CONFIG GET/SET on top of
+// config. If multi is false using more than one parameter results in the error
+// returned by redis versions prior to 7.0.
+func testConfigStub(config map[string]string, multi bool, cmds *[][]string) Conn {
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		*cmds = append(*cmds, args)
+		if args[0] != "CONFIG" || len(args) < 3 {
+			return errors.Errorf("unexpected command %q", args)
+		}
+
+		wrongNumArgs := resp2.Error{
+			E: errors.Errorf("ERR Unknown subcommand or wrong number of arguments for '%s'. Try CONFIG HELP.", args[1]),
+		}
+		switch args[1] {
+		case "SET":
+			if !multi && len(args) > 4 {
+				return wrongNumArgs
+			}
+			for i := 2; i+1 < len(args); i += 2 {
+				config[args[i]] = args[i+1]
+			}
+			return resp2.SimpleString{S: "OK"}
+		case "GET":
+			if !multi && len(args) > 3 {
+				return wrongNumArgs
+			}
+			res := []string{}
+			for _, param := range args[2:] {
+				if v, ok := config[param]; ok {
+					res = append(res, param, v)
+				}
+			}
+			return res
+		default:
+			return errors.Errorf("unexpected command %q", args)
+		}
+	})
+}
+
+func TestConfigMulti(t *T) {
+	for _, multi := range []bool{true, false} {
+		config := map[string]string{"maxmemory": "0"}
+		var cmds [][]string
+		stub := testConfigStub(config, multi, &cmds)
+
+		require.NoError(t, ConfigSetMulti(stub, map[string]string{
+			"maxmemory":        "100mb",
+			"maxmemory-policy": "allkeys-lru",
+		}))
+		assert.Equal(t, map[string]string{
+			"maxmemory":        "100mb",
+			"maxmemory-policy": "allkeys-lru",
+		}, config)
+
+		res, err := ConfigGetMulti(stub, "maxmemory", "maxmemory-policy", "dne")
+		require.NoError(t, err)
+		assert.Equal(t, config, res)
+
+		if multi {
+			assert.Equal(t, [][]string{
+				{"CONFIG", "SET", "maxmemory", "100mb", "maxmemory-policy", "allkeys-lru"},
+				{"CONFIG", "GET", "maxmemory", "maxmemory-policy", "dne"},
+			}, cmds)
+		} else {
+			assert.Len(t, cmds, 1+2+1+3)
+		}
+	}
+}
@@ -3,13 +3,22 @@ package radix
 import (
 	"bufio"
 	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/internal/bytesutil"
 	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/mediocregopher/radix/v3/trace"
 )
 
 // Conn is a Client wrapping a single network connection which synchronously
@@ -57,7 +66,7 @@ var DefaultConnFunc = func(network, addr string) (Conn, error) {
 }
 
 func wrapDefaultConnFunc(addr string) ConnFunc {
-	_, opts := parseRedisURL(addr)
+	_, _, opts := parseRedisURL(addr)
 	return func(network, addr string) (Conn, error) {
 		return Dial(network, addr, opts...)
 	}
@@ -65,44 +74,808 @@ func wrapDefaultConnFunc(addr string) ConnFunc {
 
 type connWrap struct {
 	net.Conn
-	brw *bufio.ReadWriter
+	brw       *bufio.ReadWriter
+	cr        *countingReader
+	interner  *resp2.StringInterner
+	decoders  *resp2.Decoders
+	errMapper func(error) error
+
+	// unblock, if set, sends CLIENT UNBLOCK using a separate connection. See
+	// DialUnblockOnTimeout.
+	unblock *unblocker
+
+	// clientID is the ID of the connection as returned by CLIENT ID, 0 if
+	// not yet known or -1 if CLIENT ID isn't supported.
+	clientID int64
+
+	// unblockArmed is set while a blocking command is being performed using
+	// WithDeadline or WithTimeout.
+	unblockArmed bool
+
+	// brokenErr is set once a fatal error occurred, after which the
+	// connection can't be used anymore, see ConnErr. It is protected by
+	// brokenL, as Close may be called concurrently.
+	brokenL   sync.Mutex
+	brokenErr error
+
+	// resp3 is set if RESP3 was negotiated using DialProtocol(3), in which
+	// case push frames are discarded by decode, unless they're handled by
+	// the Unmarshaler. hello is the reply to the HELLO sent by Dial.
+	resp3 bool
+	hello *HelloInfo
+
+	// pushHandler is set if DialPushHandler was used.
+	pushHandler func(resp2.RawMessage)
+
+	// ct and traceCommon are set if DialWithTrace was used.
+	ct          trace.ConnTrace
+	traceCommon trace.ConnCommon
+
+	// libInfo holds the library name and version last set using CLIENT
+	// SETINFO. libInfoFn, libInfoInterval and libInfoNext are set if
+	// DialLibInfoUpdate was used.
+	libInfo         [2]string
+	libInfoFn       func() (name, version string)
+	libInfoInterval time.Duration
+	libInfoNext     time.Time
+
+	// initCmds are the commands the connection was set up with by Dial, see
+	// reinitConn. dialed is false for connections created using NewConn.
+	initCmds []dialCmd
+	dialed   bool
+}
+
+// anyUnmarshaler is implemented by resp.Unmarshalers which unmarshal using a
+// resp2.Any and can make use of a connection's options for it, i.e. its
+// StringInterner and Decoders. The I field of the given Any is ignored.
+type anyUnmarshaler interface {
+	unmarshalRESPWith(*bufio.Reader, resp2.Any) error
 }
 
 // NewConn takes an existing net.Conn and wraps it to support the Conn interface
 // of this package. The Read and Write methods on the original net.Conn should
 // not be used after calling this method.
 func NewConn(conn net.Conn) Conn {
+	cr := &countingReader{r: conn}
 	return &connWrap{
 		Conn: conn,
-		brw:  bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+		brw:  bufio.NewReadWriter(bufio.NewReader(cr), bufio.NewWriter(conn)),
+		cr:   cr,
 	}
 }
 
+// countingReader counts the bytes read from the wrapped io.Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	cr.n += int64(n)
+	return n, err
+}
+
+// bytesRead returns the number of bytes of replies which were read from the
+// connection so far, not counting those which are still buffered.
+func (cw *connWrap) bytesRead() int64 {
+	return cw.cr.n - int64(cw.brw.Reader.Buffered())
+}
+
 func (cw *connWrap) Do(a Action) error {
-	return a.Run(cw)
+	return cw.doOn(a, cw)
+}
+
+// onDoer is implemented by Conns which can perform an Action on a wrapper
+// around themselves, such that the wrapper is passed to the Action's Run
+// while everything else their Do does still applies.
+type onDoer interface {
+	doOn(a Action, conn Conn) error
+}
+
+// doOn performs the Action on conn, which is either cw itself or a wrapper
+// around it, e.g. the ioErrConn of a Pool.
+func (cw *connWrap) doOn(a Action, conn Conn) error {
+	if err := cw.maybeUpdateLibInfo(conn); err != nil {
+		return err
+	}
+	defer cw.extendForBlocking(a)()
+	if cw.ct.DoStarted == nil {
+		return a.Run(conn)
+	}
+	return cw.doTraced(a, conn)
+}
+
+// extendForBlocking extends the read timeout of the connection by the block
+// duration of the Action, if it's a BlockingAction, and returns a function
+// which undoes this.
+func (cw *connWrap) extendForBlocking(a Action) func() {
+	tc, ok := cw.Conn.(*timeoutConn)
+	if !ok || tc.readTimeout <= 0 {
+		return func() {}
+	}
+	d, ok := blockDuration(a)
+	if !ok {
+		return func() {}
+	}
+	tc.blocking, tc.blockDuration = true, d
+	return func() { tc.blocking = false }
+}
+
+// doTraced performs the Action on conn, which is either cw itself or a wrapper
+// around it, and calls the ConnTrace callbacks around it.
+func (cw *connWrap) doTraced(a Action, conn Conn) error {
+	name, _ := commandName(a)
+	var key string
+	if keys := a.Keys(); len(keys) > 0 {
+		key = keys[0]
+	}
+	done := cw.ct.DoStarted(trace.ConnDoStarted{
+		ConnCommon:  cw.traceCommon,
+		CommandName: name,
+		Key:         key,
+	})
+	start := time.Now()
+	err := a.Run(conn)
+	if done != nil {
+		done(trace.ConnDoCompleted{
+			ConnCommon:  cw.traceCommon,
+			CommandName: name,
+			Key:         key,
+			ElapsedTime: time.Since(start),
+			Err:         err,
+		})
+	}
+	return err
+}
+
+func (cw *connWrap) mapErr(err error) error {
+	if err == nil || cw.errMapper == nil {
+		return err
+	}
+	return cw.errMapper(err)
 }
 
 func (cw *connWrap) Encode(m resp.Marshaler) error {
-	if err := m.MarshalRESP(cw.brw); err != nil {
+	if err := cw.err(); err != nil {
 		return err
 	}
-	return cw.brw.Flush()
+	var err error
+	if p, ok := encodePipeline(m); ok && len(p) > 1 && cw.brw.Writer.Buffered() == 0 {
+		err = cw.encodePipeline(p)
+	} else if err = m.MarshalRESP(cw.brw); err == nil {
+		err = cw.brw.Flush()
+	}
+	cw.checkFatal(err)
+	return cw.mapErr(err)
+}
+
+// encodePipeline returns the commands of m, if m is one of the pipeline types
+// of this package.
+func encodePipeline(m resp.Marshaler) (pipeline, bool) {
+	switch m := m.(type) {
+	case pipeline:
+		return m, true
+	case *pipelinerPipeline:
+		return m.pipeline, true
+	}
+	return nil, false
+}
+
+// bytesWriter is an io.Writer which appends to a pooled buffer.
+type bytesWriter struct {
+	b *[]byte
+}
+
+func (bw bytesWriter) Write(b []byte) (int, error) {
+	*bw.b = append(*bw.b, b...)
+	return len(b), nil
+}
+
+// encodePipeline writes the commands of a pipeline. If their estimated size
+// exceeds what the buffered writer can hold they are written using a single
+// writev call, if the underlying connection supports it, instead of flushing
+// the buffered writer every time it fills up.
+func (cw *connWrap) encodePipeline(p pipeline) error {
+	tc, ok := cw.Conn.(*timeoutConn)
+	if !ok || !tc.canWriteBuffers() || estimatePipelineSize(p) <= cw.brw.Writer.Available() {
+		if err := p.MarshalRESP(cw.brw); err != nil {
+			return err
+		}
+		return cw.brw.Flush()
+	}
+
+	bufs := make(net.Buffers, len(p))
+	scratches := make([]*[]byte, len(p))
+	defer func() {
+		for _, scratch := range scratches {
+			if scratch != nil {
+				bytesutil.PutBytes(scratch)
+			}
+		}
+	}()
+
+	for i, cmd := range p {
+		scratches[i] = bytesutil.GetBytes()
+		if err := cmd.MarshalRESP(bytesWriter{b: scratches[i]}); err != nil {
+			return err
+		}
+		bufs[i] = *scratches[i]
+	}
+
+	// the buffered writer may still hold data, which must be written first
+	if err := cw.brw.Flush(); err != nil {
+		return err
+	}
+	_, err := tc.writeBuffers(&bufs)
+	return err
+}
+
+// estimatePipelineSize returns the approximate marshaled size of the commands
+// of a pipeline, without marshaling them. Only the arguments of commands
+// created by Cmd, CmdBytes and FlatCmd are taken into account, all other
+// commands are assumed to be small.
+func estimatePipelineSize(p pipeline) int {
+	// the size of the headers of a bulk string and its trailing CRLF is
+	// assumed to be at most this
+	const argOverhead = 16
+
+	var n int
+	for _, cmd := range p {
+		c, ok := cmd.(*cmdAction)
+		if !ok {
+			n += argOverhead
+			continue
+		}
+		n += argOverhead + len(c.cmd)
+		for _, arg := range c.args {
+			n += argOverhead + len(arg)
+		}
+		for _, arg := range c.bytesArgs {
+			n += argOverhead + len(arg)
+		}
+		if c.flat {
+			n += argOverhead + len(c.flatKey[0])
+			for _, arg := range c.flatArgs {
+				n += argOverhead
+				switch arg := arg.(type) {
+				case string:
+					n += len(arg)
+				case []byte:
+					n += len(arg)
+				case resp.LenReader:
+					n += int(arg.Len())
+				}
+			}
+		}
+	}
+	return n
 }
 
 func (cw *connWrap) Decode(u resp.Unmarshaler) error {
-	return u.UnmarshalRESP(cw.brw.Reader)
+	if err := cw.err(); err != nil {
+		return err
+	}
+	if cw.unblockArmed {
+		// if the deadline is hit before any part of the reply was read, the
+		// blocked command can be unblocked and its reply read without leaving
+		// the connection in an unknown state.
+		if _, err := cw.brw.Peek(1); err != nil {
+			if !isTimeout(err) {
+				cw.checkFatal(err)
+				return cw.mapErr(err)
+			}
+			return cw.mapErr(cw.unblockAfterTimeout(u, err))
+		}
+	}
+	return cw.decode(u)
+}
+
+// pushUnmarshaler is implemented by resp.Unmarshalers which handle RESP3 push
+// frames themselves, which are otherwise passed to the push handler or
+// discarded, see DialProtocol.
+type pushUnmarshaler interface {
+	unmarshalsPush()
+}
+
+func (cw *connWrap) decode(u resp.Unmarshaler) error {
+	if _, ok := u.(pushUnmarshaler); cw.resp3 && !ok {
+		if err := cw.handlePushes(); err != nil {
+			cw.checkFatal(err)
+			return cw.mapErr(err)
+		}
+	}
+
+	var err error
+	if au, ok := u.(anyUnmarshaler); ok && (cw.interner != nil || cw.decoders != nil) {
+		err = au.unmarshalRESPWith(cw.brw.Reader, resp2.Any{
+			StringInterner: cw.interner,
+			Decoders:       cw.decoders,
+		})
+	} else {
+		err = u.UnmarshalRESP(cw.brw.Reader)
+	}
+	cw.checkFatal(err)
+	return cw.mapErr(err)
+}
+
+// handlePushes passes all push frames preceding the next reply to the push
+// handler, or discards them if there is none.
+func (cw *connWrap) handlePushes() error {
+	for {
+		b, err := cw.brw.Peek(1)
+		if err != nil {
+			return err
+		} else if b[0] != resp2.PushPrefix[0] {
+			return nil
+		} else if err := cw.handlePush(); err != nil {
+			return err
+		}
+	}
+}
+
+// handlePush reads the next push frame and passes it to the push handler, or
+// discards it if there is none.
+func (cw *connWrap) handlePush() error {
+	if cw.pushHandler == nil {
+		return (resp2.Any{}).UnmarshalRESP(cw.brw.Reader)
+	}
+
+	var push resp2.RawMessage
+	if err := push.UnmarshalRESP(cw.brw.Reader); err != nil {
+		return err
+	}
+	cw.pushHandler(push)
+	return nil
+}
+
+// drainPushTimeout is how long DrainPushes waits for the next push frame if no
+// data is buffered.
+const drainPushTimeout = time.Millisecond
+
+// drainPushes is like handlePushes, but stops once no more data was received
+// instead of waiting for the next reply.
+func (cw *connWrap) drainPushes() (int, error) {
+	for n := 0; ; n++ {
+		if cw.brw.Reader.Buffered() == 0 {
+			if err := cw.peekWithTimeout(drainPushTimeout); isTimeout(err) {
+				return n, nil
+			} else if err != nil {
+				return n, err
+			}
+		}
+
+		if b, err := cw.brw.Peek(1); err != nil {
+			return n, err
+		} else if b[0] != resp2.PushPrefix[0] {
+			return n, nil
+		} else if err := cw.handlePush(); err != nil {
+			return n, err
+		}
+	}
+}
+
+// peekWithTimeout waits for at most the given timeout until data can be read.
+// A timeout doesn't consume anything, so the connection stays usable.
+func (cw *connWrap) peekWithTimeout(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	defer cw.Conn.SetReadDeadline(time.Time{})
+
+	// timeoutConn sets the read deadline itself on every read
+	if tc, ok := cw.Conn.(*timeoutConn); ok {
+		prev := tc.deadline
+		tc.deadline = deadline
+		defer func() { tc.deadline = prev }()
+	} else {
+		cw.Conn.SetReadDeadline(deadline)
+	}
+	_, err := cw.brw.Peek(1)
+	return err
+}
+
+// maybeUpdateLibInfo updates the library name and version of the connection
+// if DialLibInfoUpdate was used and its interval has passed. conn is either cw
+// itself or a wrapper around it.
+func (cw *connWrap) maybeUpdateLibInfo(conn Conn) error {
+	if cw.libInfoFn == nil {
+		return nil
+	} else if now := time.Now(); now.Before(cw.libInfoNext) {
+		return nil
+	} else {
+		cw.libInfoNext = now.Add(cw.libInfoInterval)
+	}
+
+	name, version := cw.libInfoFn()
+	err := setLibInfo(conn, name, version)
+	if errors.As(err, new(resp.ErrDiscarded)) {
+		// CLIENT SETINFO likely isn't supported, there's no point in trying
+		// again
+		cw.libInfoFn = nil
+		return nil
+	}
+	return err
+}
+
+// setLibInfo sets the library name and version of the connection using CLIENT
+// SETINFO, skipping values which are empty or are already set. Errors returned
+// by redis don't prevent the other value from being set, the first one is
+// returned as a resp.ErrDiscarded.
+func setLibInfo(conn Conn, name, version string) error {
+	cw := asConnWrap(conn)
+	var discardedErr error
+	for i, info := range [2][2]string{{"LIB-NAME", name}, {"LIB-VER", version}} {
+		if info[1] == "" || (cw != nil && cw.libInfo[i] == info[1]) {
+			continue
+		}
+		err := doOK(conn, "CLIENT", "SETINFO", info[0], info[1])
+		if errors.As(err, new(resp.ErrDiscarded)) {
+			if discardedErr == nil {
+				discardedErr = err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if cw != nil {
+			cw.libInfo[i] = info[1]
+		}
+	}
+	return discardedErr
 }
 
 func (cw *connWrap) NetConn() net.Conn {
 	return cw.Conn
 }
 
+func (cw *connWrap) Close() error {
+	cw.setBroken(ErrConnClosed)
+	if cw.unblock != nil {
+		cw.unblock.Close()
+	}
+	return cw.Conn.Close()
+}
+
+func (cw *connWrap) err() error {
+	cw.brokenL.Lock()
+	defer cw.brokenL.Unlock()
+	return cw.brokenErr
+}
+
+// setBroken marks the connection as unusable, unless it already is.
+func (cw *connWrap) setBroken(err error) {
+	cw.brokenL.Lock()
+	if cw.brokenErr == nil {
+		cw.brokenErr = err
+	}
+	cw.brokenL.Unlock()
+}
+
+// checkFatal marks the connection as unusable if err is a fatal error, see
+// ConnErr. Any error which isn't a resp.ErrDiscarded is fatal, as it may have
+// left part of a command unwritten or part of a reply unread.
+func (cw *connWrap) checkFatal(err error) {
+	if err == nil || errors.As(err, new(resp.ErrDiscarded)) {
+		return
+	}
+	cw.setBroken(err)
+}
+
+// isTimeout returns whether err is a timeout of a read or write.
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// peekMessage waits for the first byte of a message which isn't a reply to a
+// command, e.g. a PubSubMessage. If this times out nothing was read and no
+// reply is left pending, so the timeout is returned as a resp.ErrDiscarded,
+// which doesn't make the connection unusable.
+func peekMessage(br *bufio.Reader) ([]byte, error) {
+	b, err := br.Peek(1)
+	if err != nil && isTimeout(err) {
+		return nil, resp.ErrDiscarded{Err: err}
+	}
+	return b, err
+}
+
+// HelloInfo describes the reply to HELLO, see DialProtocol and ConnHelloInfo.
+type HelloInfo struct {
+	// Server is the name of the server, i.e. "redis".
+	Server string `redis:"server"`
+
+	// Version is the version of the server, e.g. "7.2.4".
+	Version string `redis:"version"`
+
+	// Proto is the version of the RESP protocol used by the connection.
+	Proto int `redis:"proto"`
+
+	// ID is the id of the connection, as returned by CLIENT ID.
+	ID int64 `redis:"id"`
+
+	// Mode is the mode the server runs in, i.e. "standalone", "sentinel" or
+	// "cluster".
+	Mode string `redis:"mode"`
+
+	// Role is the role of the server, i.e. "master" or "replica".
+	Role string `redis:"role"`
+}
+
+// dialHello performs the given HELLO command on the connection and records
+// its reply. If redis returned an error, e.g. because it's older than redis 6
+// and doesn't support HELLO, false is returned and the connection keeps using
+// RESP2.
+func dialHello(cw *connWrap, hello dialCmd) (bool, error) {
+	var info HelloInfo
+	err := cw.Do(Cmd(&info, hello.cmd, hello.args...))
+	if errors.As(err, new(resp2.Error)) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	cw.resp3, cw.hello = true, &info
+	return true, nil
+}
+
+// ConnProtocol returns the version of the RESP protocol used by the given Conn,
+// i.e. 3 if DialProtocol(3) was used and redis supported it, and 2 otherwise.
+//
+// ConnProtocol only supports Conns created by Dial or NewConn, and returns 2
+// for all other Conns.
+func ConnProtocol(conn Conn) int {
+	if cw := asConnWrap(conn); cw != nil && cw.resp3 {
+		return 3
+	}
+	return 2
+}
+
+// ConnHelloInfo returns the reply to the HELLO performed by Dial when using
+// DialProtocol(3), which contains e.g. the version of the server and the id of
+// the connection. ok is false if no HELLO was performed, or if redis didn't
+// support it.
+//
+// ConnHelloInfo only supports Conns created by Dial, and returns false for all
+// other Conns.
+func ConnHelloInfo(conn Conn) (info HelloInfo, ok bool) {
+	if cw := asConnWrap(conn); cw != nil && cw.hello != nil {
+		return *cw.hello, true
+	}
+	return HelloInfo{}, false
+}
+
+// ErrConnClosed is returned by ConnErr, as well as by all methods of the Conn,
+// once a Conn created by Dial or NewConn was closed.
+var ErrConnClosed = errors.New("connection is closed")
+
+// ConnErr returns the error which made the given Conn unusable, or nil if the
+// Conn can still be used. Once a Conn is unusable all calls to its Encode and
+// Decode methods, and therefore also to Do, return that error without
+// performing any I/O. This can be used to cheaply check whether a Conn is
+// still alive, without sending a command.
+//
+// A Conn becomes unusable in the following cases:
+//
+//   - Close was called, in which case ErrConnClosed is returned.
+//   - Writing a command failed, e.g. due to a timeout or a connection reset,
+//     or because it couldn't be marshaled, as part of it may have been written.
+//   - Reading a reply failed, e.g. due to the connection being closed by the
+//     server, a timeout or a malformed reply, leaving the rest of it unread.
+//   - The deadline of an Action created using WithDeadline or WithTimeout
+//     was hit while the Conn was in an unknown state.
+//
+// Errors returned by redis, e.g. WRONGTYPE, as well as all other errors which
+// left the connection in a consistent state (see resp.ErrDiscarded), never make
+// a Conn unusable. This includes read timeouts of PubSubConns which are hit
+// while waiting for the next message.
+//
+// ConnErr only supports Conns created by Dial or NewConn, and returns nil for
+// all other Conns.
+func ConnErr(conn Conn) error {
+	if cw := asConnWrap(conn); cw != nil {
+		return cw.err()
+	}
+	return nil
+}
+
+// DrainPushes passes all RESP3 push frames which the Conn already received to
+// the function set using DialPushHandler, or discards them if there is none,
+// and returns their number. Push frames are otherwise only handled when reading
+// the reply to the next command, so DrainPushes can be used to e.g. process
+// invalidation messages of CLIENT TRACKING on an otherwise idle Conn.
+//
+// DrainPushes doesn't block, apart from waiting briefly for data which is in
+// flight, and returns on the first reply which isn't a push frame without
+// consuming it. Like any other use of the Conn it must not be called
+// concurrently with Do, Encode or Decode. When using a Pool it can be called
+// using WithConn.
+//
+// DrainPushes only supports Conns created by Dial using DialProtocol(3), and
+// returns 0 for all other Conns.
+func DrainPushes(conn Conn) (int, error) {
+	cw := asConnWrap(conn)
+	if cw == nil || !cw.resp3 {
+		return 0, nil
+	}
+	n, err := cw.drainPushes()
+	cw.checkFatal(err)
+	return n, cw.mapErr(err)
+}
+
+// asConnWrap returns the connWrap underlying the given Conn, or nil if it
+// wasn't created by Dial or NewConn.
+func asConnWrap(conn Conn) *connWrap {
+	switch c := conn.(type) {
+	case *connWrap:
+		return c
+	case *ioErrConn:
+		cw, _ := c.Conn.(*connWrap)
+		return cw
+	}
+	return nil
+}
+
+// reinitConn restores the state of the given Conn after it was reset using
+// RESET, by performing the commands it was set up with by Dial again, as well
+// as CLIENT NO-EVICT for Conns of a Pool which had it enabled (see
+// PoolMaxNoEvict). false is returned if the Conn wasn't created by Dial, in
+// which case the state it was set up with is unknown and it can't be restored.
+func reinitConn(conn Conn) (bool, error) {
+	cw := asConnWrap(conn)
+	if cw == nil || !cw.dialed {
+		return false, nil
+	}
+
+	cmds := cw.initCmds
+	if ioc, ok := conn.(*ioErrConn); ok && ioc.noEvict {
+		cmds = append(cmds[:len(cmds):len(cmds)], dialCmd{cmd: "CLIENT", args: []string{"NO-EVICT", "ON"}})
+	}
+	return true, doDialCmds(cw, cmds)
+}
+
+// markBrokenOnTimeout marks the given Conn, if it was created by Dial or
+// NewConn, as broken if err is a timeout error which left the connection in an
+// unknown state, i.e. one which isn't a resp.ErrDiscarded.
+func markBrokenOnTimeout(conn Conn, err error) {
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() || errors.As(err, new(resp.ErrDiscarded)) {
+		return
+	} else if cw := asConnWrap(conn); cw != nil {
+		cw.setBroken(errors.Errorf("connection is unusable after a previous deadline was hit: %w", err))
+	}
+}
+
+// unblockReadTimeout is the time connWrap waits for the reply of a command
+// after unblocking it.
+const unblockReadTimeout = time.Second
+
+// armUnblock prepares the given Conn, if it was created by Dial, to unblock the
+// given Action if it's a blocking command and the deadline is hit while it's
+// waiting for its reply. The returned function must be called once the Action
+// completed.
+func armUnblock(conn Conn, a Action) func() {
+	cmd, ok := a.(*cmdAction)
+	if !ok || !blockingCmds[strings.ToUpper(cmd.cmd)] {
+		return func() {}
+	}
+
+	cw := asConnWrap(conn)
+	if cw == nil || cw.unblock == nil {
+		return func() {}
+	}
+
+	if cw.clientID == 0 {
+		var respErr resp2.Error
+		if err := conn.Do(Cmd(&cw.clientID, "CLIENT", "ID")); errors.As(err, &respErr) &&
+			strings.Contains(strings.ToLower(respErr.Error()), "unknown") {
+			// the server doesn't support CLIENT ID, so there's no need to ask
+			// again
+			cw.clientID = -1
+		} else if err != nil {
+			cw.clientID = 0
+		}
+	}
+	if cw.clientID <= 0 {
+		return func() {}
+	}
+
+	cw.unblockArmed = true
+	return func() { cw.unblockArmed = false }
+}
+
+// unblocker sends CLIENT UNBLOCK for a Conn using a separate connection,
+// which is created on first use and kept until the Conn is closed.
+type unblocker struct {
+	dial func() (Conn, error)
+
+	l      sync.Mutex
+	side   Conn
+	closed bool
+}
+
+func (u *unblocker) unblock(id int64) error {
+	u.l.Lock()
+	defer u.l.Unlock()
+	if u.closed {
+		return ErrConnClosed
+	}
+
+	if u.side == nil {
+		side, err := u.dial()
+		if err != nil {
+			return err
+		}
+		u.side = side
+	}
+
+	err := u.side.Do(Cmd(nil, "CLIENT", "UNBLOCK", strconv.FormatInt(id, 10), "ERROR"))
+	if err != nil && !errors.As(err, new(resp.ErrDiscarded)) && !errors.As(err, new(resp2.Error)) {
+		// the side connection is dialed again on the next unblock
+		u.side.Close()
+		u.side = nil
+	}
+	return err
+}
+
+func (u *unblocker) Close() error {
+	u.l.Lock()
+	defer u.l.Unlock()
+	u.closed = true
+	if u.side == nil {
+		return nil
+	}
+	err := u.side.Close()
+	u.side = nil
+	return err
+}
+
+func (cw *connWrap) unblockAfterTimeout(u resp.Unmarshaler, timeoutErr error) error {
+	if err := cw.unblock.unblock(cw.clientID); err != nil {
+		cw.setBroken(timeoutErr)
+		return timeoutErr
+	}
+
+	deadline := time.Now().Add(unblockReadTimeout)
+	if tc, ok := cw.Conn.(*timeoutConn); ok {
+		// the previous deadline is restored by the deadlineAction
+		tc.deadline = deadline
+	} else if err := cw.Conn.SetReadDeadline(deadline); err != nil {
+		cw.setBroken(timeoutErr)
+		return timeoutErr
+	}
+
+	// the command may have completed before it was unblocked, in which case
+	// its actual reply is read.
+	err := cw.decode(u)
+	var respErr resp2.Error
+	if !errors.As(err, &respErr) || !strings.HasPrefix(respErr.Error(), "UNBLOCKED") {
+		return err
+	}
+	return resp.ErrDiscarded{
+		Err: errors.Errorf("blocking command was unblocked using CLIENT UNBLOCK: %w", timeoutErr),
+	}
+}
+
 type dialOpts struct {
 	connectTimeout, readTimeout, writeTimeout time.Duration
 	authUser, authPass                        string
 	selectDB                                  string
+	clientName, libName, libVer               string
+	libInfoFn                                 func() (name, version string)
+	libInfoInterval                           time.Duration
 	useTLSConfig                              bool
 	tlsConfig                                 *tls.Config
+	tracking                                  *ClientTracking
+	readBuffer, writeBuffer                   int
+	readOnly                                  bool
+	internSize, internMaxLen                  int
+	decoders                                  *resp2.Decoders
+	errMapper                                 func(error) error
+	wireLogger                                io.Writer
+	wireLoggerSampled                         bool
+	initCmds                                  [][]string
+	noUnblock                                 bool
+	protocol                                  int
+	pushHandler                               func(resp2.RawMessage)
+	ct                                        trace.ConnTrace
 }
 
 // DialOpt is an optional behavior which can be applied to the Dial function to
@@ -119,6 +892,9 @@ func DialConnectTimeout(d time.Duration) DialOpt {
 
 // DialReadTimeout determines the deadline to set when reading from a dialed
 // connection. If not set then SetReadDeadline is never called.
+//
+// The timeout is extended by the block duration of blocking commands, e.g.
+// BLPOP, see BlockingAction.
 func DialReadTimeout(d time.Duration) DialOpt {
 	return func(do *dialOpts) {
 		do.readTimeout = d
@@ -127,12 +903,30 @@ func DialReadTimeout(d time.Duration) DialOpt {
 
 // DialWriteTimeout determines the deadline to set when writing to a dialed
 // connection. If not set then SetWriteDeadline is never called.
+//
+// Pipelines too large for the write buffer are written to TCP and unix
+// connections using a single writev call, to which the timeout applies as a
+// whole.
 func DialWriteTimeout(d time.Duration) DialOpt {
 	return func(do *dialOpts) {
 		do.writeTimeout = d
 	}
 }
 
+// DialSocketBuffers sets the size of the operating system's receive and send
+// buffers associated with the connection, by calling SetReadBuffer and
+// SetWriteBuffer on it after it is created. A size of zero leaves the
+// respective buffer at its default.
+//
+// This is distinct from the buffering done within the package and is only
+// applied if the underlying net.Conn supports it (e.g. a *net.TCPConn).
+func DialSocketBuffers(readBuffer, writeBuffer int) DialOpt {
+	return func(do *dialOpts) {
+		do.readBuffer = readBuffer
+		do.writeBuffer = writeBuffer
+	}
+}
+
 // DialTimeout is the equivalent to using DialConnectTimeout, DialReadTimeout,
 // and DialWriteTimeout all with the same value.
 func DialTimeout(d time.Duration) DialOpt {
@@ -169,6 +963,48 @@ func DialAuthUser(user, pass string) DialOpt {
 	}
 }
 
+// DialProtocol sets the version of the RESP protocol used by the Conn, which
+// must be either 2 or 3. It defaults to 2.
+//
+// If set to 3 Dial sends HELLO 3 once the connection is created, which also
+// performs the AUTH if DialAuthUser or DialAuthPass were given. Redis then
+// uses the additional types of RESP3 for replies, which are handled by
+// resp2.Any and therefore by all Actions of this package, e.g. a map can be
+// unmarshaled into a Go map or struct the same way as the flat array RESP2
+// replies with.
+//
+// Push frames received while reading a reply, e.g. the invalidation messages
+// of CLIENT TRACKING, are passed to the handler given to DialPushHandler. If no
+// handler was given they're silently dropped, except by PubSub. As CLIENT
+// TRACKING without a Redirect sends the invalidation messages as push frames
+// on the Conn itself, Dial returns an error if DialClientTracking is used that
+// way without DialPushHandler.
+//
+// HELLO is only available in Redis 6 and newer. If redis returns an error to
+// HELLO the Conn keeps using RESP2, and performs the AUTH using AUTH instead.
+// ConnProtocol returns the protocol which is actually used, and ConnHelloInfo
+// the reply to HELLO, e.g. the version of the server.
+func DialProtocol(protocol int) DialOpt {
+	return func(do *dialOpts) {
+		do.protocol = protocol
+	}
+}
+
+// DialPushHandler sets a function which is called with each RESP3 push frame
+// received by the Conn while reading a reply, e.g. an invalidation message of
+// CLIENT TRACKING, see DialProtocol. The raw message can be unmarshaled using
+// its UnmarshalInto method, e.g. into a []interface{}. Push frames are only
+// sent when using DialProtocol(3).
+//
+// The function is called synchronously by the go-routine reading the reply, or
+// calling DrainPushes, so it must not block for long, and must not use the Conn
+// itself.
+func DialPushHandler(fn func(push resp2.RawMessage)) DialOpt {
+	return func(do *dialOpts) {
+		do.pushHandler = fn
+	}
+}
+
 // DialSelectDB will cause Dial to perform a SELECT command once the connection
 // is created, using the given database index.
 //
@@ -180,9 +1016,186 @@ func DialSelectDB(db int) DialOpt {
 	}
 }
 
+// DialClientName will cause Dial to perform a CLIENT SETNAME command once the
+// connection is created, so that the connection can be identified by the given
+// name in the output of CLIENT LIST.
+func DialClientName(name string) DialOpt {
+	return func(do *dialOpts) {
+		do.clientName = name
+	}
+}
+
+// DialLibInfo will cause Dial to perform CLIENT SETINFO commands once the
+// connection is created, setting the library name and version shown in the
+// output of CLIENT LIST, e.g. DialLibInfo("radix", "v3"). Empty values are not
+// sent.
+//
+// CLIENT SETINFO is only available in redis 7.2 and above. Any error returned
+// from it is ignored, so that this can be safely used with older versions.
+func DialLibInfo(name, version string) DialOpt {
+	return func(do *dialOpts) {
+		do.libName = name
+		do.libVer = version
+	}
+}
+
+// DialLibInfoUpdate will cause the Conn to periodically update the library
+// name and version shown in the output of CLIENT LIST using CLIENT SETINFO,
+// with the values returned by fn, e.g. to show the last operation performed
+// using the connection or the currently deployed version of the application.
+// Empty values, and values which didn't change since they were last set, are
+// not sent. Note that redis doesn't allow spaces in either value.
+//
+// The update is performed at the start of the first call to Do after interval
+// has passed since the last update, including the very first call, so that it
+// never interferes with commands in flight. The Conn is therefore never
+// updated while it's only used using Encode and Decode, e.g. by a PubSubConn.
+//
+// If redis returns an error to CLIENT SETINFO, e.g. because it's a version
+// before 7.2, no further updates are attempted.
+//
+// This can be combined with DialLibInfo, which sets the initial values.
+func DialLibInfoUpdate(interval time.Duration, fn func() (name, version string)) DialOpt {
+	return func(do *dialOpts) {
+		do.libInfoInterval = interval
+		do.libInfoFn = fn
+	}
+}
+
+// DialReadOnly will cause Dial to perform a READONLY command once the
+// connection is created, allowing read commands to be performed on it when
+// connected to a replica of a redis cluster.
+//
+// Note that redis instances which don't have cluster support enabled will
+// return an error for READONLY, causing Dial to fail.
+func DialReadOnly() DialOpt {
+	return func(do *dialOpts) {
+		do.readOnly = true
+	}
+}
+
+// DialInitCmd will cause Dial to perform the given command once the connection
+// is created, e.g. DialInitCmd("CLIENT", "NO-EVICT", "ON"). It can be given
+// multiple times, in which case the commands are performed in the given order,
+// after the commands of all other DialOpts. Any reply other than an error is
+// accepted.
+//
+// All commands performed by Dial once the connection is created, including
+// AUTH and SELECT, are pipelined, so that only a single round-trip is needed
+// regardless of how many DialOpts are used. If any of them fails, e.g. because
+// redis returned an error, Dial fails.
+func DialInitCmd(cmd string, args ...string) DialOpt {
+	return func(do *dialOpts) {
+		do.initCmds = append(do.initCmds, append([]string{cmd}, args...))
+	}
+}
+
+// DialInternStrings causes the Conn to intern strings it unmarshals as part of
+// command replies (see resp2.StringInterner), so that repeatedly read values
+// reuse a single allocation. Up to size strings are interned, each of which may
+// be up to maxLen bytes long.
+//
+// This is useful when reading the same small set of values many times, but
+// only adds overhead if most values are distinct.
+func DialInternStrings(size, maxLen int) DialOpt {
+	return func(do *dialOpts) {
+		do.internSize = size
+		do.internMaxLen = maxLen
+	}
+}
+
+// DialDecoders causes the Conn to use the DecoderFuncs registered in the given
+// Decoders when unmarshaling command replies, in addition to those registered
+// globally using resp2.RegisterDecoder. See resp2.Decoders for how they
+// interact with types implementing resp.Unmarshaler.
+func DialDecoders(d *resp2.Decoders) DialOpt {
+	return func(do *dialOpts) {
+		do.decoders = d
+	}
+}
+
+// DialErrorMapper causes all errors returned by the Encode and Decode methods
+// of the Conn, and therefore also by Do, to be passed through the given
+// function, whose return is returned instead. This can be used to translate
+// errors into a different set of errors in a single place.
+//
+// The function should wrap the original error (e.g. using xerrors.Errorf with
+// %w) rather than replacing it, so that errors.As and errors.Is continue to
+// work. In particular errors from redis itself must keep wrapping a
+// resp.ErrDiscarded, otherwise Pool will consider the Conn broken and close it.
+func DialErrorMapper(fn func(error) error) DialOpt {
+	return func(do *dialOpts) {
+		do.errMapper = fn
+	}
+}
+
+// DialUnblockOnTimeout determines what happens when a blocking command, e.g.
+// BLPOP or XREAD with the BLOCK option, which is performed using WithDeadline or
+// WithTimeout, doesn't complete before the deadline. This is enabled by default.
+//
+// If enabled, the Conn unblocks the command using CLIENT UNBLOCK with the ERROR
+// option, sent using a separate connection created with the same options, and
+// reads the reply of the command. This leaves the Conn in a usable state, so
+// that e.g. Pool doesn't need to close it. The error returned for the command
+// wraps the original timeout error. The ID of the Conn is retrieved using CLIENT
+// ID before the first blocking command, and the separate connection is created
+// when it's first needed and kept open until the Conn is closed.
+//
+// If disabled, or if the server doesn't support CLIENT ID, it's unknown whether
+// the command will still complete and the Conn should not be used anymore.
+func DialUnblockOnTimeout(enabled bool) DialOpt {
+	return func(do *dialOpts) {
+		do.noUnblock = !enabled
+	}
+}
+
+// DialWithTrace tells the Conn to trace itself with the given ConnTrace. Note
+// that ConnTrace will block every point that you set to trace.
+//
+// Only Actions performed using the Conn's Do method are traced. This includes
+// all Actions performed by a Pool using the Conn, so to trace the Actions and
+// dials of a Pool or Cluster give DialWithTrace to the Dial call of their
+// ConnFunc. The callbacks can be called concurrently for different Conns.
+func DialWithTrace(ct trace.ConnTrace) DialOpt {
+	return func(do *dialOpts) {
+		do.ct = ct
+	}
+}
+
+// DialWireLogger causes all bytes written to and read from the connection to
+// be written to w as a hexdump, which can be used for debugging protocol level
+// issues, e.g. with proxies. Each dump is preceded by a line indicating the
+// direction ("->" for sent and "<-" for received data), the address of the
+// redis instance and the number of bytes.
+//
+// When using TLS the unencrypted data is dumped. Each dump is written using a
+// single call to w.Write, but if w is shared between multiple Conns (e.g. in a
+// Pool) it must be safe for concurrent use.
+func DialWireLogger(w io.Writer) DialOpt {
+	return func(do *dialOpts) {
+		do.wireLogger = w
+		do.wireLoggerSampled = false
+	}
+}
+
+// DialWireLoggerSampled is like DialWireLogger, but only the data written and
+// read by Actions wrapped using WithWireTrace is written to w. This allows for
+// tracing individual Actions in production, where logging the data of every
+// Action would be too noisy.
+func DialWireLoggerSampled(w io.Writer) DialOpt {
+	return func(do *dialOpts) {
+		do.wireLogger = w
+		do.wireLoggerSampled = true
+	}
+}
+
 // DialUseTLS will cause Dial to perform a TLS handshake using the provided
 // config. If config is nil the config is interpreted as equivalent to the zero
 // configuration. See https://golang.org/pkg/crypto/tls/#Config
+//
+// The handshake is performed before any AUTH or SELECT, and is bound by
+// DialConnectTimeout. If the config's ServerName is empty it will be populated
+// from the host being dialed.
 func DialUseTLS(config *tls.Config) DialOpt {
 	return func(do *dialOpts) {
 		do.tlsConfig = config
@@ -190,39 +1203,285 @@ func DialUseTLS(config *tls.Config) DialOpt {
 	}
 }
 
+// ClientTracking describes the mode in which server-assisted client side
+// caching should be enabled on a connection using CLIENT TRACKING. See
+// https://redis.io/topics/client-side-caching for how the different modes
+// affect which invalidation messages are sent.
+type ClientTracking struct {
+	// Redirect is the ID of the client (as returned by CLIENT ID) which
+	// invalidation messages should be sent to. When using RESP2 this is
+	// required, as invalidation messages can only be received by a connection
+	// which is subscribed to the __redis__:invalidate channel. When using RESP3
+	// without a Redirect they're sent to the connection itself as push
+	// frames, see DialPushHandler.
+	Redirect int64
+
+	// BCast enables broadcasting mode, in which invalidation messages are sent
+	// for all keys matching one of Prefixes, regardless of whether the
+	// connection has read them or not.
+	BCast bool
+
+	// Prefixes limits broadcasting mode to keys starting with one of the given
+	// prefixes. Prefixes may only be used together with BCast.
+	Prefixes []string
+
+	// OptIn causes keys to only be tracked if the command reading them was
+	// immediately preceded by CLIENT CACHING YES. See WithClientCaching.
+	OptIn bool
+
+	// OptOut causes keys to always be tracked, unless the command reading them
+	// was immediately preceded by CLIENT CACHING NO. See WithClientCaching.
+	OptOut bool
+
+	// NoLoop disables sending invalidation messages for keys which were
+	// modified by the connection itself.
+	NoLoop bool
+}
+
+func (ct ClientTracking) args() ([]string, error) {
+	if ct.OptIn && ct.OptOut {
+		return nil, errors.New("OptIn and OptOut can not be used at the same time")
+	} else if ct.BCast && (ct.OptIn || ct.OptOut) {
+		return nil, errors.New("OptIn and OptOut can not be used together with BCast")
+	} else if !ct.BCast && len(ct.Prefixes) > 0 {
+		return nil, errors.New("Prefixes can only be used together with BCast")
+	}
+
+	args := []string{"TRACKING", "ON"}
+	if ct.Redirect != 0 {
+		args = append(args, "REDIRECT", strconv.FormatInt(ct.Redirect, 10))
+	}
+	if ct.BCast {
+		args = append(args, "BCAST")
+	}
+	for _, prefix := range ct.Prefixes {
+		args = append(args, "PREFIX", prefix)
+	}
+	if ct.OptIn {
+		args = append(args, "OPTIN")
+	}
+	if ct.OptOut {
+		args = append(args, "OPTOUT")
+	}
+	if ct.NoLoop {
+		args = append(args, "NOLOOP")
+	}
+	return args, nil
+}
+
+// DialClientTracking will cause Dial to enable server-assisted client side
+// caching, using CLIENT TRACKING with the given options, once the connection
+// is created.
+//
+// CLIENT TRACKING is only available in Redis 6 and newer.
+func DialClientTracking(ct ClientTracking) DialOpt {
+	return func(do *dialOpts) {
+		do.tracking = &ct
+	}
+}
+
+// dialCmd is a command performed by Dial once a connection is created.
+type dialCmd struct {
+	cmd  string
+	args []string
+
+	// anyReply causes any reply other than an error to be accepted, instead
+	// of only OK.
+	anyReply bool
+
+	// ignoreErr causes errors returned by redis to be ignored.
+	ignoreErr bool
+
+	// onOK, if set, is called if the command succeeded.
+	onOK func()
+}
+
+// doDialCmds performs the given commands on the Conn as a single pipeline, so
+// that initializing a new connection only takes a single round-trip. The first
+// error, in the order of the commands, is returned.
+func doDialCmds(conn Conn, cmds []dialCmd) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	replies := make([]string, len(cmds))
+	p := make(pipeline, len(cmds))
+	for i, dc := range cmds {
+		var rcv interface{} = &replies[i]
+		if dc.anyReply {
+			rcv = nil
+		}
+		p[i] = Cmd(rcv, dc.cmd, dc.args...)
+	}
+
+	if err := conn.Encode(p); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i, dc := range cmds {
+		err := conn.Decode(p[i])
+		if err != nil && !errors.As(err, new(resp.ErrDiscarded)) {
+			// the remaining replies can't be read anymore
+			if firstErr == nil {
+				firstErr = err
+			}
+			return firstErr
+		} else if firstErr != nil {
+			continue
+		}
+
+		if err != nil {
+			if !dc.ignoreErr {
+				firstErr = err
+			}
+		} else if !dc.anyReply && replies[i] != "OK" {
+			firstErr = errors.Errorf("unexpected reply to %s: %q", dc.cmd, replies[i])
+		} else if dc.onOK != nil {
+			dc.onOK()
+		}
+	}
+	return firstErr
+}
+
+// doOK performs the given command on the Conn and returns an error if the reply
+// is anything other than OK.
+func doOK(conn Conn, cmd string, args ...string) error {
+	var reply string
+	if err := conn.Do(Cmd(&reply, cmd, args...)); err != nil {
+		return err
+	} else if reply != "OK" {
+		return errors.Errorf("unexpected reply to %s: %q", cmd, reply)
+	}
+	return nil
+}
+
 type timeoutConn struct {
 	net.Conn
 	readTimeout, writeTimeout time.Duration
+
+	// deadline is set by actions created using WithDeadline or WithTimeout
+	// for the duration of their Run.
+	deadline time.Time
+
+	// blocking is set while a BlockingAction is performed, whose block
+	// duration extends the read timeout.
+	blocking      bool
+	blockDuration time.Duration
+}
+
+// deadlineFor returns the deadline to use for a read or write with the given
+// timeout, taking the deadline set by an action into account. If the returned
+// time is zero no deadline should be set.
+func (tc *timeoutConn) deadlineFor(timeout time.Duration) time.Time {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if !tc.deadline.IsZero() && (deadline.IsZero() || tc.deadline.Before(deadline)) {
+		deadline = tc.deadline
+	}
+	return deadline
 }
 
 func (tc *timeoutConn) Read(b []byte) (int, error) {
-	if tc.readTimeout > 0 {
-		tc.Conn.SetReadDeadline(time.Now().Add(tc.readTimeout))
+	timeout := tc.readTimeout
+	if tc.blocking && timeout > 0 {
+		if tc.blockDuration == 0 {
+			timeout = 0
+		} else {
+			timeout += tc.blockDuration
+		}
+	}
+
+	// if the read timeout was removed for an indefinitely blocking Action the
+	// deadline of a previous read has to be reset
+	if deadline := tc.deadlineFor(timeout); !deadline.IsZero() || timeout != tc.readTimeout {
+		tc.Conn.SetReadDeadline(deadline)
 	}
 	return tc.Conn.Read(b)
 }
 
 func (tc *timeoutConn) Write(b []byte) (int, error) {
-	if tc.writeTimeout > 0 {
-		tc.Conn.SetWriteDeadline(time.Now().Add(tc.writeTimeout))
+	if deadline := tc.deadlineFor(tc.writeTimeout); !deadline.IsZero() {
+		tc.Conn.SetWriteDeadline(deadline)
 	}
 	return tc.Conn.Write(b)
 }
 
+// canWriteBuffers returns whether the underlying connection writes net.Buffers
+// using a single writev call.
+func (tc *timeoutConn) canWriteBuffers() bool {
+	switch tc.Conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return true
+	}
+	return false
+}
+
+// writeBuffers is like Write, but writes all of bufs using a single deadline.
+func (tc *timeoutConn) writeBuffers(bufs *net.Buffers) (int64, error) {
+	if deadline := tc.deadlineFor(tc.writeTimeout); !deadline.IsZero() {
+		tc.Conn.SetWriteDeadline(deadline)
+	}
+	return bufs.WriteTo(tc.Conn)
+}
+
+type wireLogConn struct {
+	net.Conn
+	w io.Writer
+
+	// if sampled is set data is only logged while traced is set, see
+	// WithWireTrace.
+	sampled, traced bool
+}
+
+func (wc *wireLogConn) log(dir string, b []byte) {
+	if wc.sampled && !wc.traced {
+		return
+	}
+	buf := fmt.Sprintf("%s %s %d bytes\n%s", dir, wc.Conn.RemoteAddr(), len(b), hex.Dump(b))
+	io.WriteString(wc.w, buf)
+}
+
+func (wc *wireLogConn) Read(b []byte) (int, error) {
+	n, err := wc.Conn.Read(b)
+	if n > 0 {
+		wc.log("<-", b[:n])
+	}
+	return n, err
+}
+
+func (wc *wireLogConn) Write(b []byte) (int, error) {
+	n, err := wc.Conn.Write(b)
+	if n > 0 {
+		wc.log("->", b[:n])
+	}
+	return n, err
+}
+
 var defaultDialOpts = []DialOpt{
 	DialTimeout(10 * time.Second),
 }
 
-func parseRedisURL(urlStr string) (string, []DialOpt) {
+// parseRedisURL returns the network, address and options given by the URI.
+// network is empty if urlStr isn't a URI.
+func parseRedisURL(urlStr string) (string, string, []DialOpt) {
 	// do a quick check before we bust out url.Parse, in case that is very
 	// unperformant
-	if !strings.HasPrefix(urlStr, "redis://") {
-		return urlStr, nil
+	var network string
+	switch {
+	case strings.HasPrefix(urlStr, "redis://"), strings.HasPrefix(urlStr, "rediss://"):
+		network = "tcp"
+	case strings.HasPrefix(urlStr, "unix://"), strings.HasPrefix(urlStr, "redis+unix://"):
+		network = "unix"
+	default:
+		return "", urlStr, nil
 	}
 
 	u, err := url.Parse(urlStr)
 	if err != nil {
-		return urlStr, nil
+		return "", urlStr, nil
 	}
 
 	q := u.Query()
@@ -243,8 +1502,22 @@ func parseRedisURL(urlStr string) (string, []DialOpt) {
 		DialAuthUser(username, password),
 	}
 
+	useTLS := u.Scheme == "rediss"
+	if b, err := strconv.ParseBool(q.Get("tls")); err == nil {
+		useTLS = b
+	}
+	if useTLS {
+		tlsConfig := &tls.Config{ServerName: u.Hostname()}
+		if b, _ := strconv.ParseBool(q.Get("tls_insecure_skip_verify")); b {
+			tlsConfig.InsecureSkipVerify = true
+		}
+		opts = append(opts, DialUseTLS(tlsConfig))
+	}
+
+	// for unix sockets the path is the path of the socket, so the db can only
+	// be given using the query
 	dbStr := q.Get("db")
-	if u.Path != "" && u.Path != "/" {
+	if network == "tcp" && u.Path != "" && u.Path != "/" {
 		dbStr = u.Path[1:]
 	}
 
@@ -252,7 +1525,14 @@ func parseRedisURL(urlStr string) (string, []DialOpt) {
 		opts = append(opts, DialSelectDB(dbStr))
 	}
 
-	return u.Host, opts
+	if protocol, err := strconv.Atoi(q.Get("protocol")); err == nil {
+		opts = append(opts, DialProtocol(protocol))
+	}
+
+	if network == "unix" {
+		return network, u.Path, opts
+	}
+	return network, u.Host, opts
 }
 
 // Dial is a ConnFunc which creates a Conn using net.Dial and NewConn. It takes
@@ -266,16 +1546,40 @@ func parseRedisURL(urlStr string) (string, []DialOpt) {
 // If either DialAuthPass or DialSelectDB is used it overwrites the associated
 // value passed in by the URI.
 //
+// A URI with the rediss scheme will cause Dial to use TLS, as if
+// DialUseTLS(&tls.Config{ServerName: host}) had been given. Passing in
+// DialUseTLS explicitly overwrites this. TLS can also be toggled using the tls
+// query parameter, e.g. "redis://host:6379?tls=true", and certificate
+// verification can be disabled using tls_insecure_skip_verify=true, which
+// should only be used for testing.
+//
+// The RESP protocol version can be given using the protocol query parameter,
+// e.g. "redis://host:6379?protocol=3", see DialProtocol.
+//
+// Unix sockets can be given as a URI with the unix or redis+unix scheme, e.g.
+// "unix:///var/run/redis.sock?db=9". As the path of such a URI is the path of
+// the socket the db can only be given using the db query parameter.
+//
+// If network is empty it is inferred from the URI, "unix" for unix sockets and
+// "tcp" otherwise, so that for example Dial("", "unix:///var/run/redis.sock")
+// connects to the socket. A non-empty network is always used as-is.
+//
 // The default options Dial uses are:
 //
 //	DialTimeout(10 * time.Second)
 //
-func Dial(network, addr string, opts ...DialOpt) (Conn, error) {
+func Dial(network, addr string, opts ...DialOpt) (_ Conn, retErr error) {
 	var do dialOpts
 	for _, opt := range defaultDialOpts {
 		opt(&do)
 	}
-	addr, addrOpts := parseRedisURL(addr)
+	origAddr := addr
+	urlNetwork, addr, addrOpts := parseRedisURL(addr)
+	if network == "" {
+		if network = urlNetwork; network == "" {
+			network = "tcp"
+		}
+	}
 	for _, opt := range addrOpts {
 		opt(&do)
 	}
@@ -283,6 +1587,33 @@ func Dial(network, addr string, opts ...DialOpt) (Conn, error) {
 		opt(&do)
 	}
 
+	if do.ct.DialCompleted != nil {
+		start := time.Now()
+		defer func() {
+			do.ct.DialCompleted(trace.ConnDialCompleted{
+				ConnCommon:  trace.ConnCommon{Network: network, Addr: addr},
+				ElapsedTime: time.Since(start),
+				Err:         retErr,
+			})
+		}()
+	}
+
+	if do.protocol != 0 && do.protocol != 2 && do.protocol != 3 {
+		return nil, errors.Errorf("unsupported RESP protocol version %d", do.protocol)
+	}
+
+	if do.protocol == 3 && do.tracking != nil && do.tracking.Redirect == 0 && do.pushHandler == nil {
+		return nil, errors.New("DialClientTracking without a Redirect requires DialPushHandler when using DialProtocol(3)")
+	}
+
+	var trackingArgs []string
+	if do.tracking != nil {
+		var err error
+		if trackingArgs, err = do.tracking.args(); err != nil {
+			return nil, err
+		}
+	}
+
 	var netConn net.Conn
 	var err error
 	dialer := net.Dialer{}
@@ -319,29 +1650,136 @@ func Dial(network, addr string, opts ...DialOpt) (Conn, error) {
 		}
 	}
 
+	// Same as above, but for the socket buffer sizes, which are only changed
+	// if asked for.
+	{
+		type bufferConn interface {
+			SetReadBuffer(int) error
+			SetWriteBuffer(int) error
+		}
+
+		if bConn, ok := netConn.(bufferConn); ok {
+			if do.readBuffer > 0 {
+				if err = bConn.SetReadBuffer(do.readBuffer); err != nil {
+					netConn.Close()
+					return nil, err
+				}
+			}
+			if do.writeBuffer > 0 {
+				if err = bConn.SetWriteBuffer(do.writeBuffer); err != nil {
+					netConn.Close()
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if do.wireLogger != nil {
+		netConn = &wireLogConn{Conn: netConn, w: do.wireLogger, sampled: do.wireLoggerSampled}
+	}
+
 	conn := NewConn(&timeoutConn{
 		readTimeout:  do.readTimeout,
 		writeTimeout: do.writeTimeout,
 		Conn:         netConn,
 	})
+	if do.internSize > 0 {
+		conn.(*connWrap).interner = resp2.NewStringInterner(do.internSize, do.internMaxLen)
+	}
+	conn.(*connWrap).decoders = do.decoders
+	conn.(*connWrap).pushHandler = do.pushHandler
+	conn.(*connWrap).errMapper = do.errMapper
+	if do.ct.DoStarted != nil {
+		conn.(*connWrap).ct = do.ct
+		conn.(*connWrap).traceCommon = trace.ConnCommon{Network: network, Addr: addr}
+	}
+	if !do.noUnblock {
+		conn.(*connWrap).unblock = &unblocker{dial: func() (Conn, error) {
+			// the side connection doesn't need any of the options which
+			// change the state of the connection
+			sideOpts := append(opts[:len(opts):len(opts)], func(do *dialOpts) {
+				do.tracking = nil
+				do.readOnly = false
+				do.clientName = ""
+				do.libInfoFn = nil
+				do.initCmds = nil
+				do.noUnblock = true
+			})
+			return Dial(network, origAddr, sideOpts...)
+		}}
+	}
 
-	if do.authUser != "" && do.authUser != defaultAuthUser {
-		if err := conn.Do(Cmd(nil, "AUTH", do.authUser, do.authPass)); err != nil {
-			conn.Close()
-			return nil, err
+	var helloCmds, cmds []dialCmd
+	if do.protocol == 3 {
+		args := []string{"3"}
+		if do.authPass != "" {
+			user := do.authUser
+			if user == "" {
+				user = defaultAuthUser
+			}
+			args = append(args, "AUTH", user, do.authPass)
 		}
-	} else if do.authPass != "" {
-		if err := conn.Do(Cmd(nil, "AUTH", do.authPass)); err != nil {
+		hello := dialCmd{cmd: "HELLO", args: args, anyReply: true}
+		if ok, err := dialHello(conn.(*connWrap), hello); err != nil {
 			conn.Close()
 			return nil, err
+		} else if ok {
+			helloCmds = append(helloCmds, hello)
 		}
 	}
 
+	if len(helloCmds) > 0 {
+		// the AUTH was already performed by HELLO
+	} else if do.authUser != "" && do.authUser != defaultAuthUser {
+		cmds = append(cmds, dialCmd{cmd: "AUTH", args: []string{do.authUser, do.authPass}})
+	} else if do.authPass != "" {
+		cmds = append(cmds, dialCmd{cmd: "AUTH", args: []string{do.authPass}})
+	}
+
 	if do.selectDB != "" {
-		if err := conn.Do(Cmd(nil, "SELECT", do.selectDB)); err != nil {
-			conn.Close()
-			return nil, err
+		cmds = append(cmds, dialCmd{cmd: "SELECT", args: []string{do.selectDB}})
+	}
+
+	if do.clientName != "" {
+		cmds = append(cmds, dialCmd{cmd: "CLIENT", args: []string{"SETNAME", do.clientName}})
+	}
+
+	// errors returned by redis, e.g. on versions before 7.2, are ignored
+	cw := conn.(*connWrap)
+	for i, info := range [2][2]string{{"LIB-NAME", do.libName}, {"LIB-VER", do.libVer}} {
+		if info[1] == "" {
+			continue
 		}
+		i, val := i, info[1]
+		cmds = append(cmds, dialCmd{
+			cmd:       "CLIENT",
+			args:      []string{"SETINFO", info[0], val},
+			ignoreErr: true,
+			onOK:      func() { cw.libInfo[i] = val },
+		})
+	}
+
+	if do.readOnly {
+		cmds = append(cmds, dialCmd{cmd: "READONLY"})
+	}
+
+	if trackingArgs != nil {
+		cmds = append(cmds, dialCmd{cmd: "CLIENT", args: trackingArgs})
+	}
+
+	for _, initCmd := range do.initCmds {
+		cmds = append(cmds, dialCmd{cmd: initCmd[0], args: initCmd[1:], anyReply: true})
+	}
+
+	if err := doDialCmds(conn, cmds); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	cw.initCmds, cw.dialed = append(helloCmds, cmds...), true
+
+	if do.libInfoFn != nil {
+		conn.(*connWrap).libInfoFn = do.libInfoFn
+		conn.(*connWrap).libInfoInterval = do.libInfoInterval
 	}
 
 	return conn, nil
@@ -1,13 +1,30 @@
 package radix
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	. "testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/mediocregopher/radix/v3/trace"
 )
 
 func TestCloseBehavior(t *T) {
@@ -32,6 +49,32 @@ func TestDialURI(t *T) {
 	}
 }
 
+func TestDialUnixSocket(t *T) {
+	dir, err := ioutil.TempDir("", "radix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for i, uri := range []string{"unix://%s", "redis+unix://%s?db=9"} {
+		path := filepath.Join(dir, fmt.Sprintf("redis%d.sock", i))
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		cmdCh := serveTestConn(l, func([]string) resp.Marshaler {
+			return resp2.SimpleString{S: "OK"}
+		})
+
+		c, err := Dial("", fmt.Sprintf(uri, path))
+		require.NoError(t, err, uri)
+		assert.Equal(t, "unix", c.NetConn().RemoteAddr().Network())
+		require.NoError(t, c.Do(Cmd(nil, "PING")))
+		c.Close()
+
+		if i == 1 {
+			assert.Equal(t, []string{"SELECT", "9"}, <-cmdCh)
+		}
+		assert.Equal(t, []string{"PING"}, <-cmdCh)
+	}
+}
+
 func TestDialAuth(t *T) {
 	type testCase struct {
 		url, dialOptUser, dialOptPass string
@@ -133,4 +176,931 @@ func TestDialSelect(t *T) {
 			t.Fatalf("db not set to 9 (test:%#v)", test)
 		}
 	}
+
+	// selecting a db which doesn't exist must fail the Dial
+	_, err := Dial("tcp", "redis://127.0.0.1:6379", DialSelectDB(1<<20))
+	assert.Error(t, err)
+}
+
+func TestClientTrackingArgs(t *T) {
+	tests := []struct {
+		ct     ClientTracking
+		exp    []string
+		expErr bool
+	}{
+		{ct: ClientTracking{}, exp: []string{"TRACKING", "ON"}},
+		{
+			ct:  ClientTracking{Redirect: 5, NoLoop: true},
+			exp: []string{"TRACKING", "ON", "REDIRECT", "5", "NOLOOP"},
+		},
+		{
+			ct:  ClientTracking{BCast: true, Prefixes: []string{"foo", "bar"}},
+			exp: []string{"TRACKING", "ON", "BCAST", "PREFIX", "foo", "PREFIX", "bar"},
+		},
+		{ct: ClientTracking{OptIn: true}, exp: []string{"TRACKING", "ON", "OPTIN"}},
+		{ct: ClientTracking{OptOut: true}, exp: []string{"TRACKING", "ON", "OPTOUT"}},
+		{ct: ClientTracking{OptIn: true, OptOut: true}, expErr: true},
+		{ct: ClientTracking{BCast: true, OptIn: true}, expErr: true},
+		{ct: ClientTracking{Prefixes: []string{"foo"}}, expErr: true},
+	}
+
+	for _, test := range tests {
+		args, err := test.ct.args()
+		if test.expErr {
+			assert.Error(t, err, "test:%#v", test)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.exp, args)
+	}
+}
+
+func TestDialClientTracking(t *T) {
+	c := dial()
+	defer c.Close()
+	requireRedisVersion(t, c, 6, 2, 0)
+
+	var id int64
+	require.NoError(t, c.Do(Cmd(&id, "CLIENT", "ID")))
+
+	tc := dial(DialClientTracking(ClientTracking{Redirect: id, NoLoop: true}))
+	defer tc.Close()
+
+	var info struct {
+		Flags    []string `redis:"flags"`
+		Redirect int64    `redis:"redirect"`
+	}
+	require.NoError(t, tc.Do(Cmd(&info, "CLIENT", "TRACKINGINFO")))
+	assert.Contains(t, info.Flags, "on")
+	assert.Contains(t, info.Flags, "noloop")
+	assert.Equal(t, id, info.Redirect)
+
+	_, err := Dial("tcp", "127.0.0.1:6379", DialClientTracking(ClientTracking{OptIn: true, OptOut: true}))
+	assert.Error(t, err)
+}
+
+func TestDialSocketBuffers(t *T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	c, err := Dial("tcp", l.Addr().String(), DialSocketBuffers(1<<16, 1<<16))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.IsType(t, new(net.TCPConn), c.NetConn().(*timeoutConn).Conn)
+}
+
+// dialTestServer starts a server on a random local port which accepts a single
+// connection and responds to each command on it using fn. All received commands
+// are sent on the returned channel.
+func dialTestServer(t *T, fn func(args []string) resp.Marshaler) (string, <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return l.Addr().String(), serveTestConn(l, fn)
+}
+
+// serveTestConn accepts a single connection from l, and replies to each
+// command using fn. All received commands are sent to the returned channel.
+func serveTestConn(l net.Listener, fn func(args []string) resp.Marshaler) <-chan []string {
+	cmdCh := make(chan []string, 16)
+	go func() {
+		defer l.Close()
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+
+		c := NewConn(nc)
+		for {
+			var args []string
+			if err := c.Decode(resp2.Any{I: &args}); err != nil {
+				return
+			}
+			cmdCh <- args
+			if err := c.Encode(fn(args)); err != nil {
+				return
+			}
+		}
+	}()
+
+	return cmdCh
+}
+
+func TestDialReadTimeoutBlocking(t *T) {
+	addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+		time.Sleep(100 * time.Millisecond)
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", addr, DialReadTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer c.Close()
+
+	// the read timeout is extended by the block duration
+	require.NoError(t, c.Do(Cmd(nil, "BLPOP", "foo", "0.2")))
+	require.NoError(t, c.Do(Cmd(nil, "XREAD", "BLOCK", "0", "STREAMS", "foo", "$")))
+
+	err = c.Do(Cmd(nil, "GET", "foo"))
+	var nerr net.Error
+	assert.True(t, errors.As(err, &nerr) && nerr.Timeout(), "err: %v", err)
+}
+
+func TestConnEncodePipeline(t *T) {
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		return resp2.BulkString{S: args[len(args)-1]}
+	})
+
+	c, err := Dial("tcp", addr, DialWriteTimeout(time.Second))
+	require.NoError(t, err)
+	defer c.Close()
+
+	// small pipelines are written using the buffered writer, large ones using
+	// writev. Both must arrive intact and in order.
+	for _, size := range []int{8, 4096} {
+		var cmds []CmdAction
+		rcvs := make([]string, 8)
+		for i := range rcvs {
+			cmds = append(cmds, Cmd(&rcvs[i], "ECHO", strings.Repeat(strconv.Itoa(i), size)))
+		}
+		require.NoError(t, c.Do(Pipeline(cmds...)))
+
+		for i := range rcvs {
+			exp := strings.Repeat(strconv.Itoa(i), size)
+			assert.Equal(t, []string{"ECHO", exp}, <-cmdCh)
+			assert.Equal(t, exp, rcvs[i])
+		}
+	}
+}
+
+func TestEstimatePipelineSize(t *T) {
+	large := strings.Repeat("a", 4096)
+	p := pipeline{
+		Cmd(nil, "SET", "foo", large),
+		CmdBytes(nil, "SET", []byte("foo"), []byte(large)),
+		FlatCmd(nil, "SET", "foo", large, 1, []byte(large)),
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, p.MarshalRESP(buf))
+
+	// the estimate is never below the actual size of the arguments, and not
+	// much above it
+	n := estimatePipelineSize(p)
+	assert.True(t, n >= buf.Len(), "estimate %d, size %d", n, buf.Len())
+	assert.True(t, n < buf.Len()*2, "estimate %d, size %d", n, buf.Len())
+}
+
+func TestDialReadOnly(t *T) {
+	addr, cmdCh := dialTestServer(t, func([]string) resp.Marshaler {
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", addr, DialReadOnly())
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"READONLY"}, <-cmdCh)
+}
+
+func TestDialProtocol(t *T) {
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		switch args[0] {
+		case "HELLO":
+			return resp2.RawMessage("%5\r\n" +
+				"$6\r\nserver\r\n$5\r\nredis\r\n" +
+				"$7\r\nversion\r\n$5\r\n7.2.4\r\n" +
+				"$5\r\nproto\r\n:3\r\n" +
+				"$2\r\nid\r\n:42\r\n" +
+				"$7\r\nmodules\r\n*0\r\n")
+		case "GET":
+			// a push frame, e.g. an invalidation message, preceding the reply
+			return resp2.RawMessage(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n$3\r\nbar\r\n")
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", "redis://"+addr+"?protocol=3", DialAuthPass("pass"))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"HELLO", "3", "AUTH", "default", "pass"}, <-cmdCh)
+	assert.Equal(t, 3, ConnProtocol(c))
+	info, ok := ConnHelloInfo(c)
+	assert.True(t, ok)
+	assert.Equal(t, HelloInfo{Server: "redis", Version: "7.2.4", Proto: 3, ID: 42}, info)
+
+	var val string
+	require.NoError(t, c.Do(Cmd(&val, "GET", "foo")))
+	assert.Equal(t, "bar", val)
+	assert.Equal(t, []string{"GET", "foo"}, <-cmdCh)
+
+	_, err = Dial("tcp", addr, DialProtocol(4))
+	assert.EqualError(t, err, "unsupported RESP protocol version 4")
+}
+
+func TestDialPushHandler(t *T) {
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		switch args[0] {
+		case "HELLO":
+			return resp2.RawMessage("%1\r\n$5\r\nproto\r\n:3\r\n")
+		case "GET":
+			return resp2.RawMessage(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n$3\r\nbar\r\n")
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	// without a Redirect the invalidation messages would be lost
+	_, err := Dial("tcp", addr, DialProtocol(3), DialClientTracking(ClientTracking{}))
+	assert.Error(t, err)
+
+	var pushes []interface{}
+	c, err := Dial("tcp", addr,
+		DialProtocol(3),
+		DialClientTracking(ClientTracking{}),
+		DialPushHandler(func(push resp2.RawMessage) {
+			var msg []interface{}
+			assert.NoError(t, push.UnmarshalInto(resp2.Any{I: &msg}))
+			pushes = append(pushes, msg)
+		}),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"HELLO", "3"}, <-cmdCh)
+	assert.Equal(t, []string{"CLIENT", "TRACKING", "ON"}, <-cmdCh)
+
+	var val string
+	require.NoError(t, c.Do(Cmd(&val, "GET", "foo")))
+	assert.Equal(t, "bar", val)
+	assert.Equal(t, []interface{}{
+		[]interface{}{[]byte("invalidate"), []interface{}{[]byte("foo")}},
+	}, pushes)
+}
+
+func TestDrainPushes(t *T) {
+	const push = ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		switch args[0] {
+		case "HELLO":
+			return resp2.RawMessage("%1\r\n$5\r\nproto\r\n:3\r\n")
+		case "GET":
+			// the pushes are received after the reply, while the Conn is
+			// idle
+			return resp2.RawMessage("$3\r\nbar\r\n" + push + push)
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	var pushes int
+	c, err := Dial("tcp", addr, DialProtocol(3), DialReadTimeout(time.Second),
+		DialPushHandler(func(resp2.RawMessage) { pushes++ }),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"HELLO", "3"}, <-cmdCh)
+
+	var val string
+	require.NoError(t, c.Do(Cmd(&val, "GET", "foo")))
+	assert.Equal(t, "bar", val)
+	assert.Equal(t, 0, pushes)
+
+	n, err := DrainPushes(c)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 2, pushes)
+
+	// nothing left to drain, which mustn't break the Conn
+	start := time.Now()
+	n, err = DrainPushes(c)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.NoError(t, ConnErr(c))
+
+	n, err = DrainPushes(Stub("tcp", "127.0.0.1:6379", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDialProtocolFallback(t *T) {
+	// redis before version 6 doesn't know HELLO
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		if args[0] == "HELLO" {
+			return resp2.Error{E: errors.New("ERR unknown command 'HELLO'")}
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", addr, DialProtocol(3), DialAuthPass("pass"), DialSelectDB(1))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"HELLO", "3", "AUTH", "default", "pass"}, <-cmdCh)
+	assert.Equal(t, []string{"AUTH", "pass"}, <-cmdCh)
+	assert.Equal(t, []string{"SELECT", "1"}, <-cmdCh)
+
+	assert.Equal(t, 2, ConnProtocol(c))
+	_, ok := ConnHelloInfo(c)
+	assert.False(t, ok)
+
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+}
+
+func TestDialClientInfo(t *T) {
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		if len(args) > 1 && args[1] == "SETINFO" {
+			return resp2.Error{E: errors.New("ERR unknown subcommand 'SETINFO'")}
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", addr, DialClientName("worker"), DialLibInfo("radix", "v3"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	assert.Equal(t, []string{"CLIENT", "SETNAME", "worker"}, <-cmdCh)
+	assert.Equal(t, []string{"CLIENT", "SETINFO", "LIB-NAME", "radix"}, <-cmdCh)
+	assert.Equal(t, []string{"CLIENT", "SETINFO", "LIB-VER", "v3"}, <-cmdCh)
+
+	// the Conn is still usable after the ignored errors
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+}
+
+func TestDialInitCmd(t *T) {
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		if args[0] == "CONFIG" {
+			return resp2.Int{I: 1}
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", addr,
+		DialInitCmd("CLIENT", "NO-EVICT", "ON"),
+		DialClientName("worker"),
+		DialInitCmd("CONFIG", "GET", "maxmemory"),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	assert.Equal(t, []string{"CLIENT", "SETNAME", "worker"}, <-cmdCh)
+	assert.Equal(t, []string{"CLIENT", "NO-EVICT", "ON"}, <-cmdCh)
+	assert.Equal(t, []string{"CONFIG", "GET", "maxmemory"}, <-cmdCh)
+
+	// the first error is returned, even though all commands are pipelined
+	addr, cmdCh = dialTestServer(t, func(args []string) resp.Marshaler {
+		return resp2.Error{E: errors.Errorf("ERR %s failed", args[0])}
+	})
+	_, err = Dial("tcp", addr, DialAuthPass("foo"), DialInitCmd("PING"))
+	assert.EqualError(t, err, "ERR AUTH failed")
+	assert.Equal(t, []string{"AUTH", "foo"}, <-cmdCh)
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+}
+
+func TestDialLibInfoUpdate(t *T) {
+	var setInfoErr atomic.Value
+	setInfoErr.Store(false)
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		if len(args) > 1 && args[1] == "SETINFO" && setInfoErr.Load().(bool) {
+			return resp2.Error{E: errors.New("ERR unknown subcommand 'SETINFO'")}
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	var op atomic.Value
+	op.Store("get")
+	const interval = 100 * time.Millisecond
+	c, err := Dial("tcp", addr,
+		DialLibInfo("radix", "v3"),
+		DialLibInfoUpdate(interval, func() (string, string) {
+			return "radix", op.Load().(string)
+		}),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []string{"CLIENT", "SETINFO", "LIB-NAME", "radix"}, <-cmdCh)
+	assert.Equal(t, []string{"CLIENT", "SETINFO", "LIB-VER", "v3"}, <-cmdCh)
+
+	// the first Do always updates, but the unchanged name isn't sent again
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, []string{"CLIENT", "SETINFO", "LIB-VER", "get"}, <-cmdCh)
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+
+	// nothing is sent if nothing changed
+	time.Sleep(interval)
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+
+	// once SETINFO failed no further updates are attempted
+	setInfoErr.Store(true)
+	op.Store("set")
+	time.Sleep(interval)
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, []string{"CLIENT", "SETINFO", "LIB-VER", "set"}, <-cmdCh)
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+
+	op.Store("del")
+	time.Sleep(interval)
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+}
+
+func TestConnErr(t *T) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		sc := NewConn(server)
+		var args []string
+		if err := sc.Decode(resp2.Any{I: &args}); err != nil {
+			return
+		}
+		_ = sc.Encode(resp2.Error{E: errors.New("WRONGTYPE wrong kind of value")})
+		// close the connection on the next command, without replying
+		_ = sc.Decode(resp2.Any{I: &args})
+	}()
+
+	c := NewConn(client)
+	err := c.Do(Cmd(nil, "GET", "foo"))
+	require.Error(t, err)
+	assert.True(t, errors.As(err, new(resp2.Error)))
+	assert.NoError(t, ConnErr(c))
+
+	err = c.Do(Cmd(nil, "GET", "foo"))
+	require.Error(t, err)
+	assert.Equal(t, err, ConnErr(c))
+
+	// no further I/O is done once the Conn is unusable
+	assert.Equal(t, err, c.Do(Cmd(nil, "GET", "foo")))
+
+	// closing doesn't override the first error
+	require.NoError(t, c.Close())
+	assert.Equal(t, err, ConnErr(c))
+
+	client2, server2 := net.Pipe()
+	defer server2.Close()
+	c2 := NewConn(client2)
+	require.NoError(t, c2.Close())
+	assert.True(t, errors.Is(ConnErr(c2), ErrConnClosed))
+	assert.True(t, errors.Is(c2.Do(Cmd(nil, "PING")), ErrConnClosed))
+}
+
+func TestConnErrPendingReply(t *T) {
+	newConn := func(fn func(args []string) resp.Marshaler) Conn {
+		addr, _ := dialTestServer(t, fn)
+		c, err := Dial("tcp", addr, DialReadTimeout(50*time.Millisecond))
+		require.NoError(t, err)
+		return c
+	}
+
+	// the reply is still pending once the read times out, and must not be
+	// read by the next command
+	c := newConn(func([]string) resp.Marshaler {
+		time.Sleep(100 * time.Millisecond)
+		return resp2.SimpleString{S: "OK"}
+	})
+	defer c.Close()
+	err := c.Do(Cmd(nil, "GET", "foo"))
+	assert.True(t, isTimeout(err), "err: %v", err)
+	assert.Equal(t, err, ConnErr(c))
+
+	// the same goes for a reply which is only partially read
+	c = newConn(func([]string) resp.Marshaler {
+		return resp2.RawMessage("*2\r\n:1\r\n")
+	})
+	defer c.Close()
+	var ii []int
+	err = c.Do(Cmd(&ii, "LRANGE", "foo", "0", "-1"))
+	assert.True(t, isTimeout(err), "err: %v", err)
+	assert.Equal(t, err, ConnErr(c))
+}
+
+func TestDialStrictReplies(t *T) {
+	tests := []struct {
+		opt    DialOpt
+		reply  resp.Marshaler
+		expErr string
+	}{
+		{
+			opt:    DialSelectDB(16),
+			reply:  resp2.Error{E: errors.New("ERR DB index is out of range")},
+			expErr: "ERR DB index is out of range",
+		},
+		{
+			opt:    DialSelectDB(1),
+			reply:  resp2.SimpleString{S: "QUEUED"},
+			expErr: `unexpected reply to SELECT: "QUEUED"`,
+		},
+		{
+			opt:    DialAuthPass("foo"),
+			reply:  resp2.BulkString{S: "foo"},
+			expErr: `unexpected reply to AUTH: "foo"`,
+		},
+		{
+			opt:    DialReadOnly(),
+			reply:  resp2.Int{I: 1},
+			expErr: `unexpected reply to READONLY: "1"`,
+		},
+		{
+			opt:    DialInitCmd("CLIENT", "NO-EVICT", "ON"),
+			reply:  resp2.Error{E: errors.New("ERR unknown subcommand 'NO-EVICT'")},
+			expErr: "ERR unknown subcommand 'NO-EVICT'",
+		},
+	}
+
+	for _, test := range tests {
+		addr, _ := dialTestServer(t, func([]string) resp.Marshaler {
+			return test.reply
+		})
+		_, err := Dial("tcp", addr, test.opt)
+		assert.EqualError(t, err, test.expErr)
+	}
+}
+
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+func TestDialInternStrings(t *T) {
+	addr, _ := dialTestServer(t, func([]string) resp.Marshaler {
+		return resp2.Any{I: []string{"foo", "foo"}}
+	})
+
+	c, err := Dial("tcp", addr, DialInternStrings(16, 16))
+	require.NoError(t, err)
+	defer c.Close()
+	require.NotNil(t, c.(*connWrap).interner)
+
+	var a, b []string
+	require.NoError(t, c.Do(Cmd(&a, "LRANGE", "foo", "0", "-1")))
+	require.NoError(t, c.Do(Cmd(&b, "LRANGE", "foo", "0", "-1")))
+	assert.Equal(t, []string{"foo", "foo"}, b)
+	assert.Equal(t, stringData(a[0]), stringData(b[1]))
+}
+
+func TestDialDecoders(t *T) {
+	reply := func([]string) resp.Marshaler {
+		return resp2.Any{I: []string{"1", "2"}}
+	}
+	addr, _ := dialTestServer(t, reply)
+
+	type point struct{ n int }
+	decs := resp2.NewDecoders()
+	decs.Register(reflect.TypeOf(point{}), func(br *bufio.Reader, v reflect.Value) error {
+		var n int
+		if err := (resp2.Any{I: &n}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(point{n: n * 10}))
+		return nil
+	})
+
+	c, err := Dial("tcp", addr, DialDecoders(decs), DialInternStrings(16, 16))
+	require.NoError(t, err)
+	defer c.Close()
+
+	var points []point
+	require.NoError(t, c.Do(Cmd(&points, "LRANGE", "foo", "0", "-1")))
+	assert.Equal(t, []point{{n: 10}, {n: 20}}, points)
+
+	// Conns without the Decoders don't know the type
+	addr2, _ := dialTestServer(t, reply)
+	c2, err := Dial("tcp", addr2)
+	require.NoError(t, err)
+	defer c2.Close()
+	err = c2.Do(Cmd(&points, "LRANGE", "foo", "0", "-1"))
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+}
+
+type testDomainErr struct {
+	err error
+}
+
+func (e testDomainErr) Error() string { return "domain: " + e.err.Error() }
+func (e testDomainErr) Unwrap() error { return e.err }
+
+func TestDialErrorMapper(t *T) {
+	addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+		if args[0] == "FAIL" {
+			return resp2.Error{E: errors.New("ERR failed")}
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	var mapped int
+	c, err := Dial("tcp", addr, DialErrorMapper(func(err error) error {
+		mapped++
+		return testDomainErr{err: err}
+	}))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Zero(t, mapped)
+
+	err = c.Do(Cmd(nil, "FAIL"))
+	assert.EqualError(t, err, "domain: ERR failed")
+	assert.True(t, errors.As(err, new(testDomainErr)))
+	assert.True(t, errors.As(err, new(resp2.Error)))
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	assert.Equal(t, 1, mapped)
+
+	// the Conn is still usable after the error
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+}
+
+func TestDialWithTrace(t *T) {
+	newServer := func() string {
+		addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+			if args[0] == "FAIL" {
+				return resp2.Error{E: errors.New("ERR failed")}
+			}
+			return resp2.SimpleString{S: "OK"}
+		})
+		return addr
+	}
+
+	var started []string
+	var completed []trace.ConnDoCompleted
+	var dialed []trace.ConnDialCompleted
+	ct := trace.ConnTrace{
+		DoStarted: func(ds trace.ConnDoStarted) func(trace.ConnDoCompleted) {
+			started = append(started, ds.CommandName)
+			return func(dc trace.ConnDoCompleted) {
+				completed = append(completed, dc)
+			}
+		},
+		DialCompleted: func(dc trace.ConnDialCompleted) {
+			dialed = append(dialed, dc)
+		},
+	}
+
+	t.Run("Conn", func(t *T) {
+		started, completed, dialed = nil, nil, nil
+		addr := newServer()
+		c, err := Dial("tcp", addr, DialWithTrace(ct))
+		require.NoError(t, err)
+		defer c.Close()
+		require.Len(t, dialed, 1)
+		assert.Equal(t, trace.ConnCommon{Network: "tcp", Addr: addr}, dialed[0].ConnCommon)
+		assert.NoError(t, dialed[0].Err)
+
+		require.NoError(t, c.Do(Cmd(nil, "PING")))
+		require.Error(t, c.Do(Cmd(nil, "FAIL")))
+		require.NoError(t, c.Do(WithCommandName(Pipeline(Cmd(nil, "PING")), "ping-pipeline")))
+		require.NoError(t, c.Do(Cmd(nil, "GET", "foo")))
+
+		assert.Equal(t, []string{"PING", "FAIL", "ping-pipeline", "GET"}, started)
+		require.Len(t, completed, 4)
+		for i, dc := range completed {
+			assert.Equal(t, started[i], dc.CommandName)
+			assert.Equal(t, trace.ConnCommon{Network: "tcp", Addr: addr}, dc.ConnCommon)
+			assert.True(t, dc.ElapsedTime > 0)
+		}
+		assert.NoError(t, completed[0].Err)
+		assert.True(t, errors.As(completed[1].Err, new(resp2.Error)))
+		assert.NoError(t, completed[2].Err)
+		assert.Equal(t, "", completed[0].Key)
+		assert.Equal(t, "foo", completed[3].Key)
+	})
+
+	t.Run("DialError", func(t *T) {
+		dialed = nil
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		require.NoError(t, l.Close())
+
+		_, err = Dial("tcp", addr, DialWithTrace(ct))
+		require.Error(t, err)
+		require.Len(t, dialed, 1)
+		assert.Equal(t, err, dialed[0].Err)
+	})
+
+	t.Run("Pool", func(t *T) {
+		started, completed = nil, nil
+		pool, err := NewPool("tcp", newServer(), 1,
+			PoolConnFunc(func(network, addr string) (Conn, error) {
+				return Dial(network, addr, DialWithTrace(ct))
+			}),
+			PoolPipelineWindow(0, 0),
+			PoolPingInterval(0),
+			PoolRefillInterval(0),
+		)
+		require.NoError(t, err)
+		defer pool.Close()
+
+		require.NoError(t, pool.Do(Cmd(nil, "PING")))
+		require.NoError(t, pool.Do(WithCommandName(Cmd(nil, "PING"), "named-ping")))
+		assert.Equal(t, []string{"PING", "named-ping"}, started)
+		require.Len(t, completed, 2)
+		assert.Equal(t, "named-ping", completed[1].CommandName)
+	})
+}
+
+func TestDialWireLogger(t *T) {
+	addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+		return resp2.SimpleString{S: "PONG"}
+	})
+
+	buf := new(bytes.Buffer)
+	c, err := Dial("tcp", addr, DialWireLogger(buf))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+
+	ping := []byte("*1\r\n$4\r\nPING\r\n")
+	pong := []byte("+PONG\r\n")
+	assert.Equal(t,
+		"-> "+addr+" 14 bytes\n"+hex.Dump(ping)+
+			"<- "+addr+" 7 bytes\n"+hex.Dump(pong),
+		buf.String())
+}
+
+func TestDialWireLoggerSampled(t *T) {
+	addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+		return resp2.SimpleString{S: "PONG"}
+	})
+
+	buf := new(bytes.Buffer)
+	c, err := Dial("tcp", addr, DialWireLoggerSampled(buf))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Empty(t, buf.String())
+
+	require.NoError(t, c.Do(WithWireTrace(Cmd(nil, "PING"))))
+	ping := []byte("*1\r\n$4\r\nPING\r\n")
+	pong := []byte("+PONG\r\n")
+	exp := "-> " + addr + " 14 bytes\n" + hex.Dump(ping) +
+		"<- " + addr + " 7 bytes\n" + hex.Dump(pong)
+	assert.Equal(t, exp, buf.String())
+
+	require.NoError(t, c.Do(Cmd(nil, "PING")))
+	assert.Equal(t, exp, buf.String())
+}
+
+func TestDialUnblockOnTimeout(t *T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	// the server emulates a blocked BLPOP, which is only unblocked using
+	// CLIENT UNBLOCK on another connection
+	cmdCh := make(chan []string, 16)
+	unblockCh := make(chan struct{}, 1)
+	var accepted int32
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func() {
+				defer nc.Close()
+				c := NewConn(nc)
+				for {
+					var args []string
+					if err := c.Decode(resp2.Any{I: &args}); err != nil {
+						return
+					}
+					cmdCh <- args
+
+					var reply resp.Marshaler
+					switch strings.Join(args, " ") {
+					case "CLIENT ID":
+						reply = resp2.Int{I: 7}
+					case "CLIENT UNBLOCK 7 ERROR":
+						unblockCh <- struct{}{}
+						reply = resp2.Int{I: 1}
+					case "BLPOP foo 0":
+						<-unblockCh
+						reply = resp2.Error{E: errors.New("UNBLOCKED client unblocked via CLIENT UNBLOCK")}
+					default:
+						reply = resp2.SimpleString{S: "PONG"}
+					}
+					if err := c.Encode(reply); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	assertTimeout := func(t *T, err error) {
+		var nerr net.Error
+		require.True(t, errors.As(err, &nerr), "err: %v", err)
+		assert.True(t, nerr.Timeout())
+	}
+
+	t.Run("enabled", func(t *T) {
+		c, err := Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer c.Close()
+
+		for i := 0; i < 2; i++ {
+			err = c.Do(WithTimeout(Cmd(nil, "BLPOP", "foo", "0"), 50*time.Millisecond))
+			assertTimeout(t, err)
+			assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+
+			// the connection must still be usable
+			var pong string
+			require.NoError(t, c.Do(Cmd(&pong, "PING")))
+			assert.Equal(t, "PONG", pong)
+		}
+
+		// CLIENT ID is only requested once
+		var got []string
+		for len(cmdCh) > 0 {
+			got = append(got, strings.Join(<-cmdCh, " "))
+		}
+		assert.Equal(t, []string{
+			"CLIENT ID", "BLPOP foo 0", "CLIENT UNBLOCK 7 ERROR", "PING",
+			"BLPOP foo 0", "CLIENT UNBLOCK 7 ERROR", "PING",
+		}, got)
+
+		// the side connection is reused
+		assert.Equal(t, int32(2), atomic.LoadInt32(&accepted))
+	})
+
+	t.Run("disabled", func(t *T) {
+		c, err := Dial("tcp", l.Addr().String(), DialUnblockOnTimeout(false))
+		require.NoError(t, err)
+		defer c.Close()
+
+		err = c.Do(WithTimeout(Cmd(nil, "BLPOP", "foo", "0"), 50*time.Millisecond))
+		assertTimeout(t, err)
+		assert.False(t, errors.As(err, new(resp.ErrDiscarded)))
+		assert.Equal(t, []string{"BLPOP", "foo", "0"}, <-cmdCh)
+		unblockCh <- struct{}{}
+	})
+}
+
+func TestDialUnblockClientIDErr(t *T) {
+	for _, test := range []struct {
+		err string
+		exp []string
+	}{
+		{
+			// CLIENT ID isn't supported, so it's not asked for again
+			err: "ERR unknown subcommand 'ID'",
+			exp: []string{"CLIENT ID", "BLPOP foo 0", "BLPOP foo 0"},
+		},
+		{
+			err: "NOPERM this user has no permissions to run the 'client|id' command",
+			exp: []string{"CLIENT ID", "BLPOP foo 0", "CLIENT ID", "BLPOP foo 0"},
+		},
+	} {
+		addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+			if args[0] == "CLIENT" {
+				return resp2.Error{E: errors.New(test.err)}
+			}
+			return resp2.SimpleString{S: "OK"}
+		})
+
+		c, err := Dial("tcp", addr)
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, c.Do(WithTimeout(Cmd(nil, "BLPOP", "foo", "0"), time.Second)))
+		}
+		c.Close()
+
+		var got []string
+		for range test.exp {
+			got = append(got, strings.Join(<-cmdCh, " "))
+		}
+		assert.Equal(t, test.exp, got)
+	}
+}
+
+func TestDialDeadlineBreaksConn(t *T) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	addr, cmdCh := dialTestServer(t, func(args []string) resp.Marshaler {
+		if args[0] == "GET" {
+			// the reply only arrives after the deadline has been hit
+			<-doneCh
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	c, err := Dial("tcp", addr)
+	require.NoError(t, err)
+	defer c.Close()
+
+	start := time.Now()
+	err = c.Do(WithTimeout(Cmd(nil, "GET", "foo"), 50*time.Millisecond))
+	var nerr net.Error
+	require.True(t, errors.As(err, &nerr), "err: %v", err)
+	assert.True(t, nerr.Timeout())
+	assert.WithinDuration(t, start.Add(50*time.Millisecond), time.Now(), 40*time.Millisecond)
+	assert.Equal(t, []string{"GET", "foo"}, <-cmdCh)
+
+	// further commands fail immediately, without being written
+	err = c.Do(Cmd(nil, "PING"))
+	assert.Error(t, err)
+	assert.True(t, errors.As(err, &nerr))
+	assert.Len(t, cmdCh, 0)
 }
@@ -0,0 +1,48 @@
+package radix
+
+import (
+	"strconv"
+
+	errors "golang.org/x/xerrors"
+)
+
+// ErrBoundExceeded is returned by BoundedIncr if incrementing the counter
+// would have made it exceed the given maximum.
+var ErrBoundExceeded = errors.New("counter would exceed its maximum")
+
+var boundedIncrScript = NewEvalScript(1, `
+	local cur = tonumber(redis.call("GET", KEYS[1]) or "0")
+	if cur == nil then
+		return redis.error_reply("ERR value is not an integer or out of range")
+	end
+	if cur + tonumber(ARGV[1]) > tonumber(ARGV[2]) then
+		return {0, cur}
+	end
+	return {1, redis.call("INCRBY", KEYS[1], ARGV[1])}
+`)
+
+// BoundedIncr increments the integer counter stored at key by the given amount,
+// but only if the resulting value is less than or equal to max. A key which
+// doesn't exist is treated as having the value 0. The check and the increment
+// are performed atomically using a lua script, so concurrent calls can never
+// push the counter beyond max.
+//
+// The new value of the counter is returned. If the counter was not changed
+// because it would have exceeded max, its current value is returned together
+// with ErrBoundExceeded.
+//
+// Since lua represents numbers as doubles, the comparison against max is only
+// exact for values whose magnitude is below 2^53.
+func BoundedIncr(c Client, key string, by, max int64) (int64, error) {
+	var res []int64
+	err := c.Do(boundedIncrScript.Cmd(&res, key,
+		strconv.FormatInt(by, 10), strconv.FormatInt(max, 10)))
+	if err != nil {
+		return 0, err
+	} else if len(res) != 2 {
+		return 0, errors.Errorf("malformed BoundedIncr reply: %v", res)
+	} else if res[0] == 0 {
+		return res[1], ErrBoundExceeded
+	}
+	return res[1], nil
+}
@@ -0,0 +1,54 @@
+package radix
+
+import (
+	"strconv"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestBoundedIncr(t *T) {
+	var lastArgs []string
+	m := map[string]int64{}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		lastArgs = args
+		switch args[0] {
+		case "EVALSHA":
+			return resp2.Error{E: errors.New("NOSCRIPT")}
+		case "EVAL":
+			// emulates boundedIncrScript
+			key := args[3]
+			by, _ := strconv.ParseInt(args[4], 10, 64)
+			max, _ := strconv.ParseInt(args[5], 10, 64)
+			if m[key]+by > max {
+				return []int64{0, m[key]}
+			}
+			m[key] += by
+			return []int64{1, m[key]}
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	n, err := BoundedIncr(stub, "quota", 3, 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, []string{"quota", "3", "5"}, lastArgs[3:])
+
+	n, err = BoundedIncr(stub, "quota", 2, 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+
+	n, err = BoundedIncr(stub, "quota", 1, 5)
+	assert.True(t, errors.Is(err, ErrBoundExceeded))
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, int64(5), m["quota"])
+
+	n, err = BoundedIncr(stub, "quota", -4, 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
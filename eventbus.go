@@ -0,0 +1,183 @@
+package radix
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	errors "golang.org/x/xerrors"
+)
+
+// EventCodec is used by EventBus to marshal the payloads of published events
+// and to unmarshal them for the subscribed handlers.
+type EventCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+// JSONCodec is an EventCodec which uses encoding/json. It is the default codec
+// used by EventBus.
+var JSONCodec EventCodec = jsonCodec{}
+
+// EventBus is a thin layer on top of PUBLISH and SUBSCRIBE which allows for
+// publishing Go values to topics (i.e. pubsub channels) and subscribing to
+// them using handlers which receive the unmarshaled values, so that code using
+// it can deal with its own types rather than raw bytes.
+//
+// Handlers are called synchronously in the order the events were received,
+// from a single go-routine, so a slow handler delays all other handlers.
+type EventBus struct {
+	c     Client
+	ps    PubSubConn
+	codec EventCodec
+
+	// ErrCh is a channel onto which errors encountered while handling events
+	// are written, e.g. when a payload can't be unmarshaled. If the channel
+	// is full the error is dropped.
+	ErrCh chan error
+
+	msgCh     chan PubSubMessage
+	closeOnce sync.Once
+	doneCh    chan struct{}
+
+	l        sync.RWMutex
+	handlers map[string]reflect.Value
+}
+
+// NewEventBus initializes and returns an EventBus, which uses the given Client
+// for publishing events and the given PubSubConn for subscribing to them. If
+// codec is nil JSONCodec is used.
+//
+// The EventBus does not take ownership of c and ps, they must still be closed
+// by the caller after the EventBus was closed.
+func NewEventBus(c Client, ps PubSubConn, codec EventCodec) *EventBus {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	b := &EventBus{
+		c:        c,
+		ps:       ps,
+		codec:    codec,
+		ErrCh:    make(chan error, 1),
+		msgCh:    make(chan PubSubMessage, 16),
+		doneCh:   make(chan struct{}),
+		handlers: map[string]reflect.Value{},
+	}
+	go b.spin()
+	return b
+}
+
+func (b *EventBus) err(err error) {
+	select {
+	case b.ErrCh <- err:
+	default:
+	}
+}
+
+func (b *EventBus) spin() {
+	defer close(b.doneCh)
+	for m := range b.msgCh {
+		b.l.RLock()
+		handler, ok := b.handlers[m.Channel]
+		b.l.RUnlock()
+		if !ok {
+			continue
+		}
+
+		payload := reflect.New(handler.Type().In(0))
+		if err := b.codec.Unmarshal(m.Message, payload.Interface()); err != nil {
+			b.err(errors.Errorf("unmarshaling event on topic %q: %w", m.Channel, err))
+			continue
+		}
+		handler.Call([]reflect.Value{payload.Elem()})
+	}
+}
+
+// Publish marshals the given payload using the EventBus' codec and publishes
+// it to the given topic.
+func (b *EventBus) Publish(topic string, payload interface{}) error {
+	msg, err := b.codec.Marshal(payload)
+	if err != nil {
+		return errors.Errorf("marshaling event for topic %q: %w", topic, err)
+	}
+	return b.c.Do(Cmd(nil, "PUBLISH", topic, string(msg)))
+}
+
+// Subscribe subscribes to the given topic, calling handler with the payload of
+// each event published to it. handler must be a function taking a single
+// argument, into which the payload is unmarshaled, e.g.
+//
+//	err := bus.Subscribe("users", func(u User) {
+//		log.Printf("user %q was updated", u.Name)
+//	})
+//
+// Calling Subscribe for a topic which was already subscribed to replaces its
+// handler.
+func (b *EventBus) Subscribe(topic string, handler interface{}) error {
+	hv := reflect.ValueOf(handler)
+	if hv.Kind() != reflect.Func || hv.Type().NumIn() != 1 || hv.Type().NumOut() != 0 {
+		return errors.Errorf("event handler must be a function with one argument and no return values, got %T", handler)
+	}
+
+	b.l.Lock()
+	_, subscribed := b.handlers[topic]
+	b.handlers[topic] = hv
+	b.l.Unlock()
+	if subscribed {
+		return nil
+	}
+
+	if err := b.ps.Subscribe(b.msgCh, topic); err != nil {
+		b.l.Lock()
+		delete(b.handlers, topic)
+		b.l.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unsubscribe unsubscribes from the given topic, after which its handler is
+// not called anymore.
+func (b *EventBus) Unsubscribe(topic string) error {
+	b.l.Lock()
+	delete(b.handlers, topic)
+	b.l.Unlock()
+	return b.ps.Unsubscribe(b.msgCh, topic)
+}
+
+// Close unsubscribes from all topics and waits for the currently running
+// handler, if any, to return. The EventBus must not be used after calling
+// Close.
+func (b *EventBus) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		b.l.Lock()
+		topics := make([]string, 0, len(b.handlers))
+		for topic := range b.handlers {
+			topics = append(topics, topic)
+		}
+		b.handlers = map[string]reflect.Value{}
+		b.l.Unlock()
+
+		if len(topics) > 0 {
+			if err = b.ps.Unsubscribe(b.msgCh, topics...); err != nil {
+				// the PubSubConn might still write to msgCh, so it can't be
+				// closed
+				return
+			}
+		}
+		close(b.msgCh)
+		<-b.doneCh
+	})
+	return err
+}
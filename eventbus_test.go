@@ -0,0 +1,65 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+)
+
+func TestEventBus(t *T) {
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	conn, stubCh := PubSubStub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return errors.Errorf("unexpected command %q", args)
+	})
+	ps := PubSub(conn)
+	defer ps.Close()
+
+	client := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if strings.ToUpper(args[0]) == "PUBLISH" {
+			stubCh <- PubSubMessage{Type: "message", Channel: args[1], Message: []byte(args[2])}
+			return 1
+		}
+		return errors.Errorf("unexpected command %q", args)
+	})
+
+	bus := NewEventBus(client, ps, nil)
+	defer bus.Close()
+
+	assert.Error(t, bus.Subscribe("users", func(u user) error { return nil }))
+
+	usersCh := make(chan user, 1)
+	require.NoError(t, bus.Subscribe("users", func(u user) { usersCh <- u }))
+
+	require.NoError(t, bus.Publish("users", user{Name: "alice", Age: 32}))
+	select {
+	case u := <-usersCh:
+		assert.Equal(t, user{Name: "alice", Age: 32}, u)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	// payloads which can't be unmarshaled are reported on ErrCh
+	require.NoError(t, bus.Publish("users", "not a user"))
+	select {
+	case err := <-bus.ErrCh:
+		assert.Contains(t, err.Error(), `topic "users"`)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for error")
+	}
+
+	require.NoError(t, bus.Unsubscribe("users"))
+	require.NoError(t, bus.Publish("users", user{Name: "bob"}))
+	select {
+	case u := <-usersCh:
+		t.Fatalf("unexpected event after unsubscribing: %+v", u)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,213 @@
+package radix
+
+import (
+	"sync"
+	"time"
+)
+
+// FailoverPolicy describes when a FailoverClient switches from its primary to
+// its secondary Client and back. The zero value is a valid policy using the
+// defaults documented on each field.
+type FailoverPolicy struct {
+	// FailureThreshold is the number of consecutive connection errors returned
+	// by the primary after which the circuit is opened and Actions are sent to
+	// the secondary instead. Errors returned by redis itself, e.g. WRONGTYPE,
+	// don't count as failures. Defaults to 3.
+	FailureThreshold int
+
+	// ProbeInterval is how often the primary is probed using PING while the
+	// circuit is open. Defaults to 5 seconds.
+	ProbeInterval time.Duration
+
+	// RecoveryThreshold is the number of consecutive successful probes needed
+	// before switching back to the primary. A single failed probe resets the
+	// count, so that a flapping primary doesn't cause Actions to be switched
+	// back and forth constantly. Defaults to 3.
+	RecoveryThreshold int
+
+	// Writes causes write commands to also be sent to the secondary while the
+	// circuit is open. By default only read commands are sent to the
+	// secondary, and write commands continue to be sent to the primary.
+	//
+	// Actions for which the commands can't be determined, e.g. WithConn or
+	// EvalScript, are treated like write commands.
+	Writes bool
+
+	// IsWrite is used to determine whether a command is a write command. If
+	// nil IsWriteCommand is used.
+	IsWrite func(cmd string) bool
+}
+
+func (p FailoverPolicy) withDefaults() FailoverPolicy {
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = 3
+	}
+	if p.ProbeInterval <= 0 {
+		p.ProbeInterval = 5 * time.Second
+	}
+	if p.RecoveryThreshold <= 0 {
+		p.RecoveryThreshold = 3
+	}
+	if p.IsWrite == nil {
+		p.IsWrite = IsWriteCommand
+	}
+	return p
+}
+
+// FailoverClient is a Client which sends all Actions to a primary Client, but
+// switches over to a secondary Client once the primary is failing, and back
+// again once the primary has recovered. See NewFailoverClient.
+type FailoverClient struct {
+	primary, secondary Client
+	policy             FailoverPolicy
+
+	l        sync.Mutex
+	failures int
+	open     bool
+	openCh   chan struct{}
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	wg        sync.WaitGroup
+}
+
+var _ Client = new(FailoverClient)
+
+// NewFailoverClient returns a FailoverClient which sends Actions to primary
+// until it returned policy.FailureThreshold connection errors in a row. At that
+// point the circuit is opened and read commands (and optionally write
+// commands, see FailoverPolicy.Writes) are sent to secondary instead.
+//
+// While the circuit is open the primary is probed in the background, and once
+// policy.RecoveryThreshold probes in a row succeeded all Actions are sent to the
+// primary again.
+//
+// This is intended for setups with a standby redis instance which isn't a
+// replica of the primary, e.g. for disaster recovery. No data is synchronized
+// between the two Clients.
+func NewFailoverClient(primary, secondary Client, policy FailoverPolicy) *FailoverClient {
+	fc := &FailoverClient{
+		primary:   primary,
+		secondary: secondary,
+		policy:    policy.withDefaults(),
+		openCh:    make(chan struct{}, 1),
+		closeCh:   make(chan struct{}),
+	}
+	fc.wg.Add(1)
+	go fc.spin()
+	return fc
+}
+
+// FailedOver returns true if the circuit is currently open, i.e. if Actions are
+// being sent to the secondary Client.
+func (fc *FailoverClient) FailedOver() bool {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	return fc.open
+}
+
+// isWrite returns whether the given Action may perform a write command.
+func (fc *FailoverClient) isWrite(a Action) bool {
+	if p, ok := a.(pipeline); ok {
+		for _, cmd := range p {
+			if fc.isWrite(cmd) {
+				return true
+			}
+		}
+		return false
+	}
+
+	_, a = commandName(a)
+	ca, ok := a.(*cmdAction)
+	return !ok || fc.policy.IsWrite(ca.cmd)
+}
+
+// Do implements the method for the Client interface.
+func (fc *FailoverClient) Do(a Action) error {
+	fc.l.Lock()
+	open := fc.open
+	fc.l.Unlock()
+
+	if open && (fc.policy.Writes || !fc.isWrite(a)) {
+		return fc.secondary.Do(a)
+	}
+
+	err := fc.primary.Do(a)
+	fc.record(err)
+	return err
+}
+
+// record updates the failure count of the primary using the result of an
+// Action, opening the circuit if necessary.
+func (fc *FailoverClient) record(err error) {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	if err == nil || !isConnErr(err) {
+		fc.failures = 0
+		return
+	}
+
+	fc.failures++
+	if !fc.open && fc.failures >= fc.policy.FailureThreshold {
+		fc.open = true
+		select {
+		case fc.openCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (fc *FailoverClient) spin() {
+	defer fc.wg.Done()
+	for {
+		select {
+		case <-fc.openCh:
+		case <-fc.closeCh:
+			return
+		}
+		if !fc.probe() {
+			return
+		}
+	}
+}
+
+// probe pings the primary until it recovered, after which the circuit is
+// closed again. Returns false if the FailoverClient was closed in the meantime.
+func (fc *FailoverClient) probe() bool {
+	ticker := time.NewTicker(fc.policy.ProbeInterval)
+	defer ticker.Stop()
+
+	var successes int
+	for successes < fc.policy.RecoveryThreshold {
+		select {
+		case <-ticker.C:
+		case <-fc.closeCh:
+			return false
+		}
+
+		if err := fc.primary.Do(Cmd(nil, "PING")); err != nil {
+			successes = 0
+		} else {
+			successes++
+		}
+	}
+
+	fc.l.Lock()
+	fc.open = false
+	fc.failures = 0
+	fc.l.Unlock()
+	return true
+}
+
+// Close implements the method for the Client interface. It closes both the
+// primary and the secondary Client.
+func (fc *FailoverClient) Close() error {
+	fc.closeOnce.Do(func() { close(fc.closeCh) })
+	fc.wg.Wait()
+
+	err := fc.primary.Close()
+	if serr := fc.secondary.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
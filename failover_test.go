@@ -0,0 +1,133 @@
+package radix
+
+import (
+	"io"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+)
+
+// flakyClient wraps a Client and returns io.EOF for all Actions while down is
+// set.
+type flakyClient struct {
+	Client
+	down int32
+}
+
+func (fc *flakyClient) setDown(down bool) {
+	var i int32
+	if down {
+		i = 1
+	}
+	atomic.StoreInt32(&fc.down, i)
+}
+
+func (fc *flakyClient) Do(a Action) error {
+	if atomic.LoadInt32(&fc.down) == 1 {
+		return io.EOF
+	}
+	return fc.Client.Do(a)
+}
+
+func TestFailoverClient(t *T) {
+	newStub := func(name string) Client {
+		pool, err := NewPool("tcp", name, 1, PoolConnFunc(func(string, string) (Conn, error) {
+			return Stub("tcp", name, func(args []string) interface{} {
+				switch args[0] {
+				case "PING":
+					return "PONG"
+				case "BAD":
+					return errors.New("ERR bad command")
+				}
+				return name
+			}), nil
+		}))
+		require.NoError(t, err)
+		return pool
+	}
+
+	primary := &flakyClient{Client: newStub("primary")}
+	secondary := newStub("secondary")
+	fc := NewFailoverClient(primary, secondary, FailoverPolicy{
+		FailureThreshold:  2,
+		ProbeInterval:     10 * time.Millisecond,
+		RecoveryThreshold: 3,
+	})
+	defer fc.Close()
+
+	assertFrom := func(exp string, a func(*string) Action) {
+		var res string
+		require.NoError(t, fc.Do(a(&res)))
+		assert.Equal(t, exp, res)
+	}
+	get := func(res *string) Action { return Cmd(res, "GET", "foo") }
+	pipe := func(res *string) Action { return Pipeline(Cmd(res, "GET", "foo"), Cmd(nil, "GET", "bar")) }
+
+	assertFrom("primary", get)
+	assert.False(t, fc.FailedOver())
+
+	// a single failure isn't enough to open the circuit, and redis errors
+	// don't count as failures
+	primary.setDown(true)
+	assert.Equal(t, io.EOF, fc.Do(get(nil)))
+	assert.False(t, fc.FailedOver())
+	primary.setDown(false)
+	assert.Error(t, fc.Do(Cmd(nil, "BAD")))
+	primary.setDown(true)
+	assert.Equal(t, io.EOF, fc.Do(get(nil)))
+	assert.False(t, fc.FailedOver())
+	assert.Equal(t, io.EOF, fc.Do(get(nil)))
+	assert.True(t, fc.FailedOver())
+
+	// reads go to the secondary, writes still to the primary
+	assertFrom("secondary", get)
+	assertFrom("secondary", pipe)
+	assert.Equal(t, io.EOF, fc.Do(Cmd(nil, "SET", "foo", "bar")))
+	assert.Equal(t, io.EOF, fc.Do(Pipeline(Cmd(nil, "GET", "foo"), Cmd(nil, "DEL", "foo"))))
+
+	// the primary needs to stay up for multiple probes before switching back
+	primary.setDown(false)
+	time.Sleep(15 * time.Millisecond)
+	primary.setDown(true)
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, fc.FailedOver())
+	assertFrom("secondary", get)
+
+	primary.setDown(false)
+	for deadline := time.Now().Add(time.Second); fc.FailedOver(); {
+		require.True(t, time.Now().Before(deadline), "primary was not switched back to")
+		time.Sleep(5 * time.Millisecond)
+	}
+	assertFrom("primary", get)
+}
+
+func TestFailoverClientWrites(t *T) {
+	primary := &flakyClient{Client: Stub("tcp", "primary", func([]string) interface{} {
+		return "primary"
+	})}
+	secondary := Stub("tcp", "secondary", func([]string) interface{} {
+		return "secondary"
+	})
+	fc := NewFailoverClient(primary, secondary, FailoverPolicy{
+		FailureThreshold: 1,
+		ProbeInterval:    time.Hour,
+		Writes:           true,
+	})
+	defer fc.Close()
+
+	primary.setDown(true)
+	assert.Equal(t, io.EOF, fc.Do(Cmd(nil, "SET", "foo", "bar")))
+	assert.True(t, fc.FailedOver())
+
+	var res string
+	require.NoError(t, fc.Do(Cmd(&res, "SET", "foo", "bar")))
+	assert.Equal(t, "secondary", res)
+	require.NoError(t, fc.Do(WithConn("foo", func(conn Conn) error {
+		return conn.Do(Cmd(&res, "EVAL", "return 1", "0"))
+	})))
+	assert.Equal(t, "secondary", res)
+}
@@ -0,0 +1,207 @@
+package radix
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// FunctionLibrary contains the code of a library of redis functions, see
+// FUNCTION LOAD. Call Function on a FunctionLibrary to create a Function, whose
+// Actions call one of the functions of the library. Functions require redis 7.0
+// or above.
+type FunctionLibrary struct {
+	code string
+}
+
+// NewFunctionLibrary initializes a FunctionLibrary instance. The code must start
+// with the shebang line of the library, e.g. "#!lua name=mylib".
+func NewFunctionLibrary(code string) FunctionLibrary {
+	return FunctionLibrary{code: code}
+}
+
+// Load returns an Action which loads the library using FUNCTION LOAD, and
+// unmarshals the name of the library into rcv. If replace is true an already
+// loaded library with the same name is replaced, otherwise loading it fails.
+//
+// Loading the library explicitly is optional, as the Actions created by a
+// Function load it if redis reports the function as missing, e.g. after a
+// failover to a replica which didn't have the library yet. When using a Cluster
+// each primary only loads the library once it's needed there. The library is
+// never loaded on a replica, see Function.ReadOnly.
+func (fl FunctionLibrary) Load(rcv interface{}, replace bool) CmdAction {
+	if replace {
+		return Cmd(rcv, "FUNCTION", "LOAD", "REPLACE", fl.code)
+	}
+	return Cmd(rcv, "FUNCTION", "LOAD", fl.code)
+}
+
+// Function calls a single function of a FunctionLibrary. Call Cmd on a Function
+// to actually create an Action which can be run.
+type Function struct {
+	lib      FunctionLibrary
+	name     string
+	numKeys  int
+	readOnly bool
+}
+
+// Function initializes a Function instance for the function with the given
+// name. numKeys corresponds to the number of arguments which will be keys when
+// Cmd is called.
+func (fl FunctionLibrary) Function(numKeys int, name string) Function {
+	return Function{
+		lib:     fl,
+		name:    name,
+		numKeys: numKeys,
+	}
+}
+
+// ReadOnly returns a copy of the Function which uses FCALL_RO instead of FCALL,
+// which redis only allows for functions declared with the no-writes flag. Such
+// calls can also be performed on replicas, e.g. using Cluster.DoSecondary.
+//
+// Since a library can only be loaded on a primary, an Action of a read-only
+// Function checks the ROLE of the instance before loading the library, and
+// returns redis' error as-is on a replica. Cluster.DoSecondary and
+// Sentinel.DoSecondary then perform the Action on the primary instead, which
+// loads the library there.
+func (f Function) ReadOnly() Function {
+	f.readOnly = true
+	return f
+}
+
+var (
+	fcall   = []byte("FCALL")
+	fcallRO = []byte("FCALL_RO")
+)
+
+type fcallAction struct {
+	Function
+	keys, args []string
+	rcv        interface{}
+
+	flat     bool
+	flatArgs []interface{}
+}
+
+// Cmd is like the top-level Cmd but it uses the Function to perform an FCALL
+// command, loading the library if it's missing. keysAndArgs must be at least as
+// long as the numKeys argument of Function.
+func (f Function) Cmd(rcv interface{}, keysAndArgs ...string) Action {
+	if len(keysAndArgs) < f.numKeys {
+		panic("not enough arguments passed into Function.Cmd")
+	}
+	return &fcallAction{
+		Function: f,
+		keys:     keysAndArgs[:f.numKeys],
+		args:     keysAndArgs[f.numKeys:],
+		rcv:      rcv,
+	}
+}
+
+// FlatCmd is like the top level FlatCmd except it uses the Function to perform
+// an FCALL command, loading the library if it's missing. keys must be as long
+// as the numKeys argument of Function.
+func (f Function) FlatCmd(rcv interface{}, keys []string, args ...interface{}) Action {
+	if len(keys) != f.numKeys {
+		panic("incorrect number of keys passed into Function.FlatCmd")
+	}
+	return &fcallAction{
+		Function: f,
+		keys:     keys,
+		flatArgs: args,
+		flat:     true,
+		rcv:      rcv,
+	}
+}
+
+func (fa *fcallAction) Keys() []string {
+	return fa.keys
+}
+
+func (fa *fcallAction) MarshalRESP(w io.Writer) error {
+	// FCALL(_RO) name numkeys keys... args...
+	ah := resp2.ArrayHeader{N: 3 + len(fa.keys)}
+	if fa.flat {
+		ah.N += (resp2.Any{I: fa.flatArgs}).NumElems()
+	} else {
+		ah.N += len(fa.args)
+	}
+
+	if err := ah.MarshalRESP(w); err != nil {
+		return err
+	}
+
+	var err error
+	if fa.readOnly {
+		err = marshalBulkStringBytes(err, w, fcallRO)
+	} else {
+		err = marshalBulkStringBytes(err, w, fcall)
+	}
+	err = marshalBulkString(err, w, fa.name)
+	err = marshalBulkString(err, w, strconv.Itoa(fa.numKeys))
+	for i := range fa.keys {
+		err = marshalBulkString(err, w, fa.keys[i])
+	}
+	if err != nil {
+		return err
+	}
+
+	if fa.flat {
+		err = (resp2.Any{
+			I:                     fa.flatArgs,
+			MarshalBulkString:     true,
+			MarshalNoArrayHeaders: true,
+		}).MarshalRESP(w)
+	} else {
+		for i := range fa.args {
+			err = marshalBulkString(err, w, fa.args[i])
+		}
+	}
+	return err
+}
+
+func (fa *fcallAction) Run(conn Conn) error {
+	run := func() error {
+		if err := conn.Encode(fa); err != nil {
+			return err
+		}
+		return conn.Decode(resp2.Any{I: fa.rcv})
+	}
+
+	err := run()
+	if !isFunctionNotFound(err) {
+		return err
+	}
+
+	if fa.readOnly {
+		// FCALL_RO may be performed on a replica, where FUNCTION LOAD would
+		// either be rejected or, on a writable replica, load the library on
+		// the replica only
+		var role RoleResult
+		if roleErr := Cmd(&role, "ROLE").Run(conn); roleErr != nil || role.Role != "master" {
+			return err
+		}
+	}
+
+	// REPLACE, in case the library was loaded concurrently
+	if err = fa.lib.Load(nil, true).Run(conn); err == nil {
+		err = run()
+	}
+	return err
+}
+
+// isFunctionNotFound returns true if the given error was returned by redis
+// because the function called using FCALL or FCALL_RO doesn't exist.
+func isFunctionNotFound(err error) bool {
+	var rerr resp2.Error
+	return errors.As(err, &rerr) && strings.HasPrefix(rerr.Error(), "ERR Function not found")
+}
+
+func (fa *fcallAction) ClusterCanRetry() bool {
+	return true
+}
@@ -0,0 +1,124 @@
+package radix
+
+import (
+	"sync"
+	. "testing"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunction(t *T) {
+	const code = "#!lua name=mylib\nredis.register_function('myfunc', function(keys, args) return args[1] end)"
+	lib := NewFunctionLibrary(code)
+
+	var loaded bool
+	var calls [][]string
+	conn := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		calls = append(calls, args)
+		switch args[0] {
+		case "FUNCTION":
+			loaded = true
+			return "mylib"
+		case "FCALL", "FCALL_RO":
+			if !loaded {
+				return resp2.Error{E: errors.New("ERR Function not found")}
+			}
+			return args[len(args)-1]
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %#v", args)}
+	})
+
+	// the library is loaded once redis reports the function as missing
+	var res string
+	a := lib.Function(1, "myfunc").Cmd(&res, "foo", "bar")
+	assert.Equal(t, []string{"foo"}, a.Keys())
+	require.NoError(t, conn.Do(a))
+	assert.Equal(t, "bar", res)
+	assert.Equal(t, [][]string{
+		{"FCALL", "myfunc", "1", "foo", "bar"},
+		{"FUNCTION", "LOAD", "REPLACE", code},
+		{"FCALL", "myfunc", "1", "foo", "bar"},
+	}, calls)
+
+	calls = nil
+	require.NoError(t, conn.Do(lib.Function(1, "myfunc").ReadOnly().FlatCmd(&res, []string{"foo"}, 1, 2)))
+	assert.Equal(t, "2", res)
+	assert.Equal(t, [][]string{{"FCALL_RO", "myfunc", "1", "foo", "1", "2"}}, calls)
+
+	var name string
+	calls = nil
+	require.NoError(t, conn.Do(lib.Load(&name, false)))
+	assert.Equal(t, "mylib", name)
+	assert.Equal(t, [][]string{{"FUNCTION", "LOAD", code}}, calls)
+}
+
+func TestFunctionSecondary(t *T) {
+	const code = "#!lua name=mylib\nredis.register_function{function_name='myfunc', callback=function(keys, args) return args[1] end, flags={'no-writes'}}"
+	lib := NewFunctionLibrary(code)
+	fn := lib.Function(0, "myfunc").ReadOnly()
+
+	const primAddr = "127.0.0.1:9736"
+	var l sync.Mutex
+	calls := map[string][][]string{}
+	loaded := map[string]bool{}
+	stubFn := func(addr string) func([]string) interface{} {
+		return func(args []string) interface{} {
+			l.Lock()
+			defer l.Unlock()
+			calls[addr] = append(calls[addr], args)
+			switch args[0] {
+			case "ROLE":
+				if addr == primAddr {
+					return []interface{}{"master", int64(0), []interface{}{}}
+				}
+				return []interface{}{"slave", "127.0.0.1", int64(9736), "connected", int64(0)}
+			case "FUNCTION":
+				loaded[addr] = true
+				return "mylib"
+			case "FCALL_RO":
+				if !loaded[addr] {
+					return resp2.Error{E: errors.New("ERR Function not found")}
+				}
+				return args[len(args)-1]
+			}
+			return resp2.Error{E: errors.Errorf("unknown command %#v", args)}
+		}
+	}
+
+	// the library isn't loaded on a replica
+	replica := Stub("tcp", "127.0.0.2:9736", stubFn("127.0.0.2:9736"))
+	err := replica.Do(fn.Cmd(nil, "foo"))
+	assert.True(t, isFunctionNotFound(err), "err: %v", err)
+	assert.Equal(t, [][]string{
+		{"FCALL_RO", "myfunc", "0", "foo"},
+		{"ROLE"},
+	}, calls["127.0.0.2:9736"])
+
+	// DoSecondary loads the library on the primary instead
+	stub := newSentinelStub(
+		primAddr,
+		[]string{"127.0.0.2:9736", "127.0.0.3:9736"},                    // secAddrs
+		[]string{"127.0.0.1:29736", "127.0.0.2:9736", "127.0.0.3:9736"}, // sentAddrs
+	)
+	scc, err := NewSentinel(
+		"stub",
+		stub.sentAddrs,
+		SentinelConnFunc(stub.newConn),
+		SentinelPoolFunc(func(network, addr string) (Client, error) {
+			return Stub(network, addr, stubFn(addr)), nil
+		}),
+	)
+	require.NoError(t, err)
+	defer scc.Close()
+
+	var res string
+	require.NoError(t, scc.DoSecondary(fn.Cmd(&res, "foo")))
+	assert.Equal(t, "foo", res)
+	assert.True(t, loaded[primAddr])
+	assert.False(t, loaded["127.0.0.2:9736"])
+	assert.False(t, loaded["127.0.0.3:9736"])
+}
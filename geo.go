@@ -0,0 +1,230 @@
+package radix
+
+import (
+	"bufio"
+	"strconv"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// GeoLocation describes a single member of a geospatial index, as returned by
+// GeoRadius and GeoRadiusByMember.
+type GeoLocation struct {
+	Member string
+
+	// Dist is the distance to the center of the search area, in the unit the
+	// radius was given in. Only set if GeoRadiusOpts.WithDist was set.
+	Dist float64
+
+	// Hash is the raw geohash-encoded sorted set score of the member. Only set
+	// if GeoRadiusOpts.WithHash was set.
+	Hash int64
+
+	// Longitude and Latitude are only set if GeoRadiusOpts.WithCoord was set.
+	Longitude, Latitude float64
+}
+
+// GeoRadiusOpts contains optional parameters for GeoRadius, GeoRadiusByMember
+// and their Store variants.
+type GeoRadiusOpts struct {
+	// Unit is the unit of the radius and of returned distances, one of "m",
+	// "km", "mi" or "ft". Defaults to "m".
+	Unit string
+
+	// Count, if not zero, limits the number of returned or stored members. If
+	// Any is also set the search stops once Count members were found, instead
+	// of finding all members first and returning the closest ones.
+	Count int
+	Any   bool
+
+	// Sort, if not empty, sorts the members by their distance, either "ASC"
+	// for nearest to farthest or "DESC" for farthest to nearest.
+	Sort string
+
+	// WithCoord, WithDist and WithHash cause the respective fields of the
+	// returned GeoLocations to be set. They are ignored when storing.
+	WithCoord, WithDist, WithHash bool
+
+	// UseGeoSearch causes GEOSEARCH and GEOSEARCHSTORE to be used instead of
+	// GEORADIUS and GEORADIUSBYMEMBER, which are deprecated since redis 6.2.
+	// The results are the same.
+	UseGeoSearch bool
+}
+
+func (opts GeoRadiusOpts) unit() string {
+	if opts.Unit == "" {
+		return "m"
+	}
+	return opts.Unit
+}
+
+// args returns the command and its arguments. from is either a longitude and
+// latitude or a member, and store is the destination key if not empty.
+func (opts GeoRadiusOpts) args(key string, from []string, radius float64, store string, storeDist bool) (string, []string) {
+	radiusStr := strconv.FormatFloat(radius, 'f', -1, 64)
+
+	var cmd string
+	var args []string
+	if opts.UseGeoSearch {
+		if store != "" {
+			cmd, args = "GEOSEARCHSTORE", []string{store, key}
+		} else {
+			cmd, args = "GEOSEARCH", []string{key}
+		}
+		if len(from) == 2 {
+			args = append(args, "FROMLONLAT", from[0], from[1])
+		} else {
+			args = append(args, "FROMMEMBER", from[0])
+		}
+		args = append(args, "BYRADIUS", radiusStr, opts.unit())
+	} else {
+		if cmd = "GEORADIUS"; len(from) == 1 {
+			cmd = "GEORADIUSBYMEMBER"
+		}
+		args = append([]string{key}, from...)
+		args = append(args, radiusStr, opts.unit())
+	}
+
+	if store == "" {
+		if opts.WithCoord {
+			args = append(args, "WITHCOORD")
+		}
+		if opts.WithDist {
+			args = append(args, "WITHDIST")
+		}
+		if opts.WithHash {
+			args = append(args, "WITHHASH")
+		}
+	}
+	if opts.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(opts.Count))
+		if opts.Any {
+			args = append(args, "ANY")
+		}
+	}
+	if opts.Sort != "" {
+		args = append(args, opts.Sort)
+	}
+
+	switch {
+	case store == "":
+	case opts.UseGeoSearch && storeDist:
+		args = append(args, "STOREDIST")
+	case opts.UseGeoSearch:
+	case storeDist:
+		args = append(args, "STOREDIST", store)
+	default:
+		args = append(args, "STORE", store)
+	}
+	return cmd, args
+}
+
+// geoLocations unmarshals the reply of GEORADIUS or GEOSEARCH, whose elements
+// are either just the members or arrays containing the requested fields.
+type geoLocations struct {
+	opts GeoRadiusOpts
+	locs []GeoLocation
+}
+
+func (gl *geoLocations) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 0 {
+		return nil
+	}
+
+	withAny := gl.opts.WithCoord || gl.opts.WithDist || gl.opts.WithHash
+	gl.locs = make([]GeoLocation, ah.N)
+	for i := range gl.locs {
+		loc := &gl.locs[i]
+		var err error
+		if !withAny {
+			err = resp2.Any{I: &loc.Member}.UnmarshalRESP(br)
+		} else {
+			// the fields are always returned in this order, regardless of the
+			// order of the options
+			t := Tuple{&loc.Member}
+			if gl.opts.WithDist {
+				t = append(t, &loc.Dist)
+			}
+			if gl.opts.WithHash {
+				t = append(t, &loc.Hash)
+			}
+			if gl.opts.WithCoord {
+				t = append(t, Tuple{&loc.Longitude, &loc.Latitude})
+			}
+			err = t.UnmarshalRESP(br)
+		}
+		if err != nil {
+			if !errors.As(err, new(resp.ErrDiscarded)) {
+				return err
+			}
+			for j := i + 1; j < ah.N; j++ {
+				if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+					return err
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func geoRadius(c Client, key string, from []string, radius float64, opts GeoRadiusOpts) ([]GeoLocation, error) {
+	cmd, args := opts.args(key, from, radius, "", false)
+	gl := geoLocations{opts: opts}
+	if err := c.Do(Cmd(&gl, cmd, args...)); err != nil {
+		return nil, err
+	}
+	return gl.locs, nil
+}
+
+func geoRadiusStore(c Client, key string, from []string, radius float64, dst string, storeDist bool, opts GeoRadiusOpts) (int64, error) {
+	cmd, args := opts.args(key, from, radius, dst, storeDist)
+	var n int64
+	err := c.Do(Cmd(&n, cmd, args...))
+	return n, err
+}
+
+func geoLonLat(longitude, latitude float64) []string {
+	return []string{
+		strconv.FormatFloat(longitude, 'f', -1, 64),
+		strconv.FormatFloat(latitude, 'f', -1, 64),
+	}
+}
+
+// GeoRadius returns the members of the geospatial index stored at key which are
+// within radius of the given longitude and latitude, using GEORADIUS (or
+// GEOSEARCH, see GeoRadiusOpts.UseGeoSearch).
+func GeoRadius(c Client, key string, longitude, latitude, radius float64, opts GeoRadiusOpts) ([]GeoLocation, error) {
+	return geoRadius(c, key, geoLonLat(longitude, latitude), radius, opts)
+}
+
+// GeoRadiusByMember is like GeoRadius, but the center of the search area is the
+// position of the given member of the index, using GEORADIUSBYMEMBER (or
+// GEOSEARCH).
+func GeoRadiusByMember(c Client, key, member string, radius float64, opts GeoRadiusOpts) ([]GeoLocation, error) {
+	return geoRadius(c, key, []string{member}, radius, opts)
+}
+
+// GeoRadiusStore is like GeoRadius, but instead of returning the found members
+// they are stored in the sorted set at dst, using the STORE option (or
+// GEOSEARCHSTORE), and the number of stored members is returned.
+//
+// If storeDist is true the scores of the stored members are their distances
+// to the center of the search area, using the STOREDIST option, instead of
+// their geohashes, meaning dst can't be used as a geospatial index itself.
+func GeoRadiusStore(c Client, key string, longitude, latitude, radius float64, dst string, storeDist bool, opts GeoRadiusOpts) (int64, error) {
+	return geoRadiusStore(c, key, geoLonLat(longitude, latitude), radius, dst, storeDist, opts)
+}
+
+// GeoRadiusByMemberStore is like GeoRadiusStore, but the center of the search
+// area is the position of the given member of the index, as with
+// GeoRadiusByMember.
+func GeoRadiusByMemberStore(c Client, key, member string, radius float64, dst string, storeDist bool, opts GeoRadiusOpts) (int64, error) {
+	return geoRadiusStore(c, key, []string{member}, radius, dst, storeDist, opts)
+}
@@ -0,0 +1,113 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoRadiusOptsArgs(t *T) {
+	tests := []struct {
+		opts      GeoRadiusOpts
+		from      []string
+		store     string
+		storeDist bool
+		exp       []string
+	}{
+		{
+			from: []string{"13.5", "38"},
+			exp:  []string{"GEORADIUS", "geo", "13.5", "38", "200", "m"},
+		},
+		{
+			opts: GeoRadiusOpts{Unit: "km", WithCoord: true, WithDist: true, WithHash: true, Count: 2, Any: true, Sort: "DESC"},
+			from: []string{"Palermo"},
+			exp: []string{
+				"GEORADIUSBYMEMBER", "geo", "Palermo", "200", "km",
+				"WITHCOORD", "WITHDIST", "WITHHASH", "COUNT", "2", "ANY", "DESC",
+			},
+		},
+		{
+			opts:  GeoRadiusOpts{WithDist: true, Sort: "ASC"},
+			from:  []string{"13.5", "38"},
+			store: "dst",
+			exp:   []string{"GEORADIUS", "geo", "13.5", "38", "200", "m", "ASC", "STORE", "dst"},
+		},
+		{
+			from:      []string{"Palermo"},
+			store:     "dst",
+			storeDist: true,
+			exp:       []string{"GEORADIUSBYMEMBER", "geo", "Palermo", "200", "m", "STOREDIST", "dst"},
+		},
+		{
+			opts: GeoRadiusOpts{UseGeoSearch: true, WithDist: true, Count: 1},
+			from: []string{"13.5", "38"},
+			exp: []string{
+				"GEOSEARCH", "geo", "FROMLONLAT", "13.5", "38", "BYRADIUS", "200", "m",
+				"WITHDIST", "COUNT", "1",
+			},
+		},
+		{
+			opts:      GeoRadiusOpts{UseGeoSearch: true, Unit: "mi"},
+			from:      []string{"Palermo"},
+			store:     "dst",
+			storeDist: true,
+			exp: []string{
+				"GEOSEARCHSTORE", "dst", "geo", "FROMMEMBER", "Palermo", "BYRADIUS", "200", "mi",
+				"STOREDIST",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		cmd, args := test.opts.args("geo", test.from, 200, test.store, test.storeDist)
+		assert.Equal(t, test.exp, append([]string{cmd}, args...))
+	}
+}
+
+func TestGeoRadius(t *T) {
+	var lastArgs []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		lastArgs = args
+		switch {
+		case args[0] == "GEOSEARCHSTORE" || args[len(args)-2] == "STORE":
+			return 2
+		case args[len(args)-1] == "WITHHASH":
+			return []interface{}{
+				[]interface{}{"Palermo", "190.4424", 3479099956230698, []string{"13.36138933897018433", "38.11555639549629859"}},
+				[]interface{}{"Catania", "56.4413", 3479447370796909, []string{"15.08726745843887329", "37.50266842333162032"}},
+			}
+		default:
+			return []string{"Palermo", "Catania"}
+		}
+	})
+
+	locs, err := GeoRadius(stub, "Sicily", 15, 37, 200, GeoRadiusOpts{Unit: "km"})
+	require.NoError(t, err)
+	assert.Equal(t, []GeoLocation{{Member: "Palermo"}, {Member: "Catania"}}, locs)
+
+	locs, err = GeoRadiusByMember(stub, "Sicily", "Agrigento", 200, GeoRadiusOpts{
+		Unit: "km", WithCoord: true, WithDist: true, WithHash: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "GEORADIUSBYMEMBER", lastArgs[0])
+	assert.Equal(t, []GeoLocation{
+		{
+			Member: "Palermo", Dist: 190.4424, Hash: 3479099956230698,
+			Longitude: 13.36138933897018433, Latitude: 38.11555639549629859,
+		},
+		{
+			Member: "Catania", Dist: 56.4413, Hash: 3479447370796909,
+			Longitude: 15.08726745843887329, Latitude: 37.50266842333162032,
+		},
+	}, locs)
+
+	n, err := GeoRadiusStore(stub, "Sicily", 15, 37, 200, "dst", false, GeoRadiusOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	n, err = GeoRadiusByMemberStore(stub, "Sicily", "Agrigento", 200, "dst", true, GeoRadiusOpts{UseGeoSearch: true})
+	require.NoError(t, err)
+	assert.Equal(t, "GEOSEARCHSTORE", lastArgs[0])
+	assert.Equal(t, int64(2), n)
+}
@@ -0,0 +1,122 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// HFieldMissing can be passed to HCompareAndSet as the expected value, in which
+// case the field is only set if it doesn't exist yet. As a consequence a field
+// can't be compared against this exact value.
+const HFieldMissing = "\x00radix:hfieldmissing\x00"
+
+var hCompareAndSetScript = NewEvalScript(1, `
+	local cur = redis.call("HGET", KEYS[1], ARGV[1])
+	if ARGV[4] == "1" then
+		if cur then
+			return 0
+		end
+	elseif cur ~= ARGV[2] then
+		return 0
+	end
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+	return 1
+`)
+
+// HCompareAndSet sets the given field of the hash stored at key to newValue,
+// but only if its current value is equal to expected. The comparison and the
+// update are performed atomically using a lua script, which allows for
+// optimistic concurrency on individual fields without having to WATCH the
+// whole key.
+//
+// A field which doesn't exist, including when the key itself doesn't exist, is
+// never equal to expected, unless expected is HFieldMissing.
+//
+// The returned bool indicates whether the field was set.
+func HCompareAndSet(c Client, key, field, expected, newValue string) (bool, error) {
+	missing := "0"
+	if expected == HFieldMissing {
+		expected, missing = "", "1"
+	}
+	var set bool
+	err := c.Do(hCompareAndSetScript.Cmd(&set, key, field, expected, newValue, missing))
+	return set, err
+}
+
+// HMGetStruct reads the fields of the hash stored at key which correspond to
+// the fields of the struct pointed to by rcv using HMGET, and unmarshals their
+// values into the struct.
+//
+// The names of the hash fields are derived the same way as when unmarshaling
+// the reply of HGETALL into a struct, i.e. the name of an exported field is
+// used unless it has a `redis:"name"` tag, and embedded structs are descended
+// into. Fields tagged with `redis:"-"` are skipped. Struct fields whose hash
+// field doesn't exist are left untouched.
+//
+// To read all fields of a hash into a struct HGETALL can be used with the
+// struct pointer as receiver, and a struct can be written to a hash using
+// FlatCmd, e.g. FlatCmd(nil, "HSET", key, s).
+func HMGetStruct(c Client, key string, rcv interface{}) error {
+	names := structFieldNames(reflect.TypeOf(rcv))
+	if len(names) == 0 {
+		return nil
+	}
+
+	vals := make([]resp2.RawMessage, 0, len(names))
+	if err := c.Do(Cmd(&vals, "HMGET", append([]string{key}, names...)...)); err != nil {
+		return err
+	}
+
+	// the values are unmarshaled into rcv as if HGETALL had returned them
+	var n int
+	pairs := new(bytes.Buffer)
+	for i := range vals {
+		if i < len(names) && !vals[i].IsNil() {
+			n++
+		}
+	}
+	if err := (resp2.ArrayHeader{N: n * 2}).MarshalRESP(pairs); err != nil {
+		return err
+	}
+	for i := range vals {
+		if i >= len(names) || vals[i].IsNil() {
+			continue
+		} else if err := (resp2.BulkString{S: names[i]}).MarshalRESP(pairs); err != nil {
+			return err
+		} else if err := vals[i].MarshalRESP(pairs); err != nil {
+			return err
+		}
+	}
+	return resp2.Any{I: rcv}.UnmarshalRESP(bufio.NewReader(pairs))
+}
+
+// structFieldNames returns the names of the hash fields of the given struct
+// type, or pointer to a struct type, in the order they were declared in.
+func structFieldNames(tt reflect.Type) []string {
+	for tt != nil && tt.Kind() == reflect.Ptr {
+		tt = tt.Elem()
+	}
+	if tt == nil || tt.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var names []string
+	for i := 0; i < tt.NumField(); i++ {
+		ft := tt.Field(i)
+		tag := ft.Tag.Get("redis")
+		if ft.Anonymous {
+			names = append(names, structFieldNames(ft.Type)...)
+			continue
+		} else if ft.PkgPath != "" || tag == "-" {
+			continue
+		} else if tag != "" {
+			names = append(names, tag)
+		} else {
+			names = append(names, ft.Name)
+		}
+	}
+	return names
+}
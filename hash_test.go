@@ -0,0 +1,129 @@
+package radix
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestHCompareAndSet(t *T) {
+	h := map[string]string{}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "EVALSHA":
+			return resp2.Error{E: errors.New("NOSCRIPT")}
+		case "EVAL":
+			// emulates hCompareAndSetScript
+			field, expected, newValue, missing := args[4], args[5], args[6], args[7]
+			cur, ok := h[field]
+			if missing == "1" && ok || missing == "0" && (!ok || cur != expected) {
+				return 0
+			}
+			h[field] = newValue
+			return 1
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	set, err := HCompareAndSet(stub, "h", "f", "", "a")
+	require.NoError(t, err)
+	assert.False(t, set, "missing field must not equal the empty string")
+
+	set, err = HCompareAndSet(stub, "h", "f", HFieldMissing, "a")
+	require.NoError(t, err)
+	assert.True(t, set)
+	assert.Equal(t, "a", h["f"])
+
+	set, err = HCompareAndSet(stub, "h", "f", HFieldMissing, "b")
+	require.NoError(t, err)
+	assert.False(t, set)
+
+	set, err = HCompareAndSet(stub, "h", "f", "b", "c")
+	require.NoError(t, err)
+	assert.False(t, set)
+	assert.Equal(t, "a", h["f"])
+
+	set, err = HCompareAndSet(stub, "h", "f", "a", "c")
+	require.NoError(t, err)
+	assert.True(t, set)
+	assert.Equal(t, "c", h["f"])
+}
+
+func TestHMGetStruct(t *T) {
+	type Inner struct {
+		Count int64 `redis:"count"`
+	}
+	type user struct {
+		*Inner
+		Name    string    `redis:"name"`
+		Score   float64   `redis:"score"`
+		Created time.Time `redis:"created"`
+		Missing string    `redis:"missing"`
+		Skipped string    `redis:"-"`
+		Age     uint8
+		private string
+	}
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := map[string]string{}
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch args[0] {
+		case "HSET":
+			for i := 2; i < len(args); i += 2 {
+				h[args[i]] = args[i+1]
+			}
+			return (len(args) - 2) / 2
+		case "HGETALL":
+			return h
+		case "HMGET":
+			vals := make([]interface{}, len(args)-2)
+			for i, field := range args[2:] {
+				if v, ok := h[field]; ok {
+					vals[i] = v
+				}
+			}
+			return vals
+		default:
+			return resp2.Error{E: errors.Errorf("unexpected command %q", args[0])}
+		}
+	})
+
+	in := user{
+		Inner:   &Inner{Count: 3},
+		Name:    "foo",
+		Score:   1.5,
+		Created: created,
+		Skipped: "skipped",
+		Age:     42,
+	}
+	require.NoError(t, stub.Do(FlatCmd(nil, "HSET", "user", in)))
+	delete(h, "missing")
+
+	// fields which don't exist are left untouched
+	out := user{Missing: "untouched"}
+	require.NoError(t, HMGetStruct(stub, "user", &out))
+	assert.Equal(t,
+		[]string{"HMGET", "user", "count", "name", "score", "created", "missing", "Age"},
+		cmds[len(cmds)-1])
+	assert.Equal(t, user{
+		Inner:   &Inner{Count: 3},
+		Name:    "foo",
+		Score:   1.5,
+		Created: created,
+		Missing: "untouched",
+		Age:     42,
+	}, out)
+
+	var all user
+	require.NoError(t, stub.Do(Cmd(&all, "HGETALL", "user")))
+	assert.Equal(t, out.Created, all.Created)
+	assert.Equal(t, int64(3), all.Count)
+}
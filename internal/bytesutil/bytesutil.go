@@ -56,10 +56,19 @@ func GetBytes() *[]byte {
 	return bytePool.Get().(*[]byte)
 }
 
+// maxPooledBytes is the maximum capacity of byte slices which are put back into
+// the pool by PutBytes. Larger ones are dropped, so that a single large value
+// doesn't keep its memory alive for as long as it's pooled.
+const maxPooledBytes = 64 * 1024
+
 // PutBytes puts the given byte slice pointer into a pool that can be accessed via GetBytes.
+// Byte slices with a capacity above 64KiB are not put back.
 //
 // After calling PutBytes the given pointer and byte slice must not be accessed anymore.
 func PutBytes(b *[]byte) {
+	if cap(*b) > maxPooledBytes {
+		return
+	}
 	*b = (*b)[:0]
 	bytePool.Put(b)
 }
@@ -0,0 +1,291 @@
+package radix
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	errors "golang.org/x/xerrors"
+)
+
+// KeyspaceEvent is a keyspace notification for a single key, as delivered by a
+// KeyspaceWatcher.
+type KeyspaceEvent struct {
+	// DB is the index of the database the key belongs to.
+	DB int
+
+	// Key is the key which was affected by the event.
+	Key string
+
+	// Event is the name of the event, e.g. "set", "del" or "expired".
+	Event string
+}
+
+// parseKeyspaceEvent parses a message received on a __keyspace@<db>__:<key> or
+// __keyevent@<db>__:<event> channel. false is returned if the message isn't a
+// keyspace notification.
+func parseKeyspaceEvent(m PubSubMessage) (KeyspaceEvent, bool) {
+	var keyspace bool
+	rest := m.Channel
+	if strings.HasPrefix(rest, "__keyspace@") {
+		keyspace, rest = true, rest[len("__keyspace@"):]
+	} else if strings.HasPrefix(rest, "__keyevent@") {
+		rest = rest[len("__keyevent@"):]
+	} else {
+		return KeyspaceEvent{}, false
+	}
+
+	i := strings.Index(rest, "__:")
+	if i < 0 {
+		return KeyspaceEvent{}, false
+	}
+	db, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return KeyspaceEvent{}, false
+	}
+
+	ev := KeyspaceEvent{DB: db}
+	if keyspace {
+		ev.Key, ev.Event = rest[i+3:], string(m.Message)
+	} else {
+		ev.Key, ev.Event = string(m.Message), rest[i+3:]
+	}
+	return ev, true
+}
+
+// KeyspaceWatcherOpts are options which can be passed in to NewKeyspaceWatcher.
+type KeyspaceWatcherOpts struct {
+	// DB is the index of the database whose keys are watched. Defaults to 0.
+	DB int
+
+	// AllDBs, if set, causes the keys of all databases to be watched, in which
+	// case DB is ignored.
+	AllDBs bool
+
+	// NotifyKeyspaceEvents, if set, contains the flags of the
+	// notify-keyspace-events config parameter which are needed by the user of
+	// the KeyspaceWatcher, e.g. "Kg$" for generic and string commands. Watch
+	// requires "K" and WatchEvents requires "E". NewKeyspaceWatcher adds all
+	// flags which aren't already enabled using CONFIG SET, keeping the ones
+	// which are.
+	NotifyKeyspaceEvents string
+}
+
+// keyspaceAllFlags are the flags enabled by the "A" flag of
+// notify-keyspace-events.
+const keyspaceAllFlags = "g$lshzxetd"
+
+// missingKeyspaceFlags returns the flags of want which aren't enabled by the
+// notify-keyspace-events value have.
+func missingKeyspaceFlags(have, want string) string {
+	var missing []byte
+	for i := 0; i < len(want); i++ {
+		flag := want[i]
+		if strings.IndexByte(have, flag) >= 0 || strings.IndexByte(string(missing), flag) >= 0 {
+			continue
+		} else if strings.IndexByte(have, 'A') >= 0 && strings.IndexByte(keyspaceAllFlags, flag) >= 0 {
+			continue
+		}
+		missing = append(missing, flag)
+	}
+	return string(missing)
+}
+
+// KeyspaceWatcher subscribes to keyspace notifications using a PubSubConn and
+// delivers them as KeyspaceEvents, so that code using it doesn't have to deal
+// with the naming scheme of the notification channels.
+//
+// See https://redis.io/docs/manual/keyspace-notifications/ for which events are
+// generated by redis.
+type KeyspaceWatcher struct {
+	ps    PubSubConn
+	db    string
+	msgCh chan PubSubMessage
+
+	closeOnce sync.Once
+	doneCh    chan struct{}
+
+	l    sync.Mutex
+	subs map[string]map[chan<- KeyspaceEvent]bool
+}
+
+// NewKeyspaceWatcher initializes and returns a KeyspaceWatcher, which uses the
+// given PubSubConn to subscribe to keyspace notifications. The given Client is
+// only used to check and enable the notify-keyspace-events config parameter if
+// opts.NotifyKeyspaceEvents is set, and may be nil otherwise.
+//
+// The KeyspaceWatcher does not take ownership of c and ps, they must still be
+// closed by the caller after the KeyspaceWatcher was closed.
+func NewKeyspaceWatcher(c Client, ps PubSubConn, opts KeyspaceWatcherOpts) (*KeyspaceWatcher, error) {
+	if opts.NotifyKeyspaceEvents != "" {
+		var cfg map[string]string
+		if err := c.Do(Cmd(&cfg, "CONFIG", "GET", "notify-keyspace-events")); err != nil {
+			return nil, errors.Errorf("getting notify-keyspace-events: %w", err)
+		}
+		have := cfg["notify-keyspace-events"]
+		if missing := missingKeyspaceFlags(have, opts.NotifyKeyspaceEvents); missing != "" {
+			err := c.Do(Cmd(nil, "CONFIG", "SET", "notify-keyspace-events", have+missing))
+			if err != nil {
+				return nil, errors.Errorf("setting notify-keyspace-events: %w", err)
+			}
+		}
+	}
+
+	db := strconv.Itoa(opts.DB)
+	if opts.AllDBs {
+		db = "*"
+	}
+
+	w := &KeyspaceWatcher{
+		ps:     ps,
+		db:     db,
+		msgCh:  make(chan PubSubMessage, 16),
+		doneCh: make(chan struct{}),
+		subs:   map[string]map[chan<- KeyspaceEvent]bool{},
+	}
+	go w.spin()
+	return w, nil
+}
+
+func (w *KeyspaceWatcher) spin() {
+	defer close(w.doneCh)
+	var chs []chan<- KeyspaceEvent
+	for m := range w.msgCh {
+		ev, ok := parseKeyspaceEvent(m)
+		if !ok {
+			continue
+		}
+
+		// the channels are collected first, so that Watch and Unwatch aren't
+		// blocked by a slow reader
+		chs = chs[:0]
+		w.l.Lock()
+		for ch := range w.subs[m.Pattern] {
+			chs = append(chs, ch)
+		}
+		w.l.Unlock()
+
+		for _, ch := range chs {
+			ch <- ev
+		}
+	}
+}
+
+func (w *KeyspaceWatcher) patterns(kind string, ss []string) []string {
+	patterns := make([]string, len(ss))
+	for i, s := range ss {
+		patterns[i] = "__" + kind + "@" + w.db + "__:" + s
+	}
+	return patterns
+}
+
+func (w *KeyspaceWatcher) watch(ch chan<- KeyspaceEvent, patterns []string) error {
+	var added []string
+	w.l.Lock()
+	for _, pattern := range patterns {
+		if w.subs[pattern] == nil {
+			w.subs[pattern] = map[chan<- KeyspaceEvent]bool{}
+		}
+		if !w.subs[pattern][ch] {
+			w.subs[pattern][ch] = true
+			added = append(added, pattern)
+		}
+	}
+	w.l.Unlock()
+
+	if err := w.ps.PSubscribe(w.msgCh, patterns...); err != nil {
+		w.l.Lock()
+		for _, pattern := range added {
+			if delete(w.subs[pattern], ch); len(w.subs[pattern]) == 0 {
+				delete(w.subs, pattern)
+			}
+		}
+		w.l.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (w *KeyspaceWatcher) unwatch(ch chan<- KeyspaceEvent, patterns []string) error {
+	var unsub []string
+	w.l.Lock()
+	for _, pattern := range patterns {
+		if !w.subs[pattern][ch] {
+			continue
+		}
+		delete(w.subs[pattern], ch)
+		if len(w.subs[pattern]) == 0 {
+			delete(w.subs, pattern)
+			unsub = append(unsub, pattern)
+		}
+	}
+	w.l.Unlock()
+
+	if len(unsub) == 0 {
+		return nil
+	}
+	return w.ps.PUnsubscribe(w.msgCh, unsub...)
+}
+
+// Watch causes an event to be written to ch for each keyspace notification of a
+// key matching any of the given glob-style patterns, e.g. "user:*". This
+// requires the "K" flag of notify-keyspace-events to be enabled, as well as
+// the flags of the events which should be delivered.
+//
+// Events are written to ch from a single go-routine, which blocks until ch is
+// read from, delaying the events of all other channels.
+func (w *KeyspaceWatcher) Watch(ch chan<- KeyspaceEvent, keyPatterns ...string) error {
+	return w.watch(ch, w.patterns("keyspace", keyPatterns))
+}
+
+// Unwatch stops the delivery of the events of keys matching the given patterns
+// to ch, which were previously passed to Watch.
+func (w *KeyspaceWatcher) Unwatch(ch chan<- KeyspaceEvent, keyPatterns ...string) error {
+	return w.unwatch(ch, w.patterns("keyspace", keyPatterns))
+}
+
+// WatchEvents causes an event to be written to ch for each keyspace
+// notification whose event name matches any of the given glob-style patterns,
+// e.g. "expired", regardless of the key. This requires the "E" flag of
+// notify-keyspace-events to be enabled, as well as the flags of the events
+// which should be delivered.
+//
+// Events are delivered to ch the same way as for Watch. If the same
+// notification is watched using both Watch and WatchEvents, it is delivered
+// twice.
+func (w *KeyspaceWatcher) WatchEvents(ch chan<- KeyspaceEvent, events ...string) error {
+	return w.watch(ch, w.patterns("keyevent", events))
+}
+
+// UnwatchEvents stops the delivery of the given events to ch, which were
+// previously passed to WatchEvents.
+func (w *KeyspaceWatcher) UnwatchEvents(ch chan<- KeyspaceEvent, events ...string) error {
+	return w.unwatch(ch, w.patterns("keyevent", events))
+}
+
+// Close unsubscribes from all notifications and waits for the event currently
+// being delivered, if any. The KeyspaceWatcher must not be used after calling
+// Close.
+func (w *KeyspaceWatcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		w.l.Lock()
+		patterns := make([]string, 0, len(w.subs))
+		for pattern := range w.subs {
+			patterns = append(patterns, pattern)
+		}
+		w.subs = map[string]map[chan<- KeyspaceEvent]bool{}
+		w.l.Unlock()
+
+		if len(patterns) > 0 {
+			if err = w.ps.PUnsubscribe(w.msgCh, patterns...); err != nil {
+				// the PubSubConn might still write to msgCh, so it can't be
+				// closed
+				return
+			}
+		}
+		close(w.msgCh)
+		<-w.doneCh
+	})
+	return err
+}
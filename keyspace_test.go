@@ -0,0 +1,119 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+)
+
+func TestParseKeyspaceEvent(t *T) {
+	tests := []struct {
+		m     PubSubMessage
+		exp   KeyspaceEvent
+		expOK bool
+	}{
+		{
+			m:     PubSubMessage{Channel: "__keyspace@0__:foo:bar", Message: []byte("set")},
+			exp:   KeyspaceEvent{DB: 0, Key: "foo:bar", Event: "set"},
+			expOK: true,
+		},
+		{
+			m:     PubSubMessage{Channel: "__keyevent@12__:expired", Message: []byte("foo")},
+			exp:   KeyspaceEvent{DB: 12, Key: "foo", Event: "expired"},
+			expOK: true,
+		},
+		{m: PubSubMessage{Channel: "__keyspace@x__:foo", Message: []byte("set")}},
+		{m: PubSubMessage{Channel: "__keyspace@0", Message: []byte("set")}},
+		{m: PubSubMessage{Channel: "foo", Message: []byte("set")}},
+	}
+
+	for _, test := range tests {
+		ev, ok := parseKeyspaceEvent(test.m)
+		assert.Equal(t, test.exp, ev, "channel: %q", test.m.Channel)
+		assert.Equal(t, test.expOK, ok, "channel: %q", test.m.Channel)
+	}
+}
+
+func TestMissingKeyspaceFlags(t *T) {
+	assert.Equal(t, "Kg$", missingKeyspaceFlags("", "Kg$"))
+	assert.Equal(t, "K", missingKeyspaceFlags("Eg$", "Kg$"))
+	assert.Equal(t, "", missingKeyspaceFlags("AK", "Kg$x"))
+	assert.Equal(t, "m", missingKeyspaceFlags("AK", "Km"))
+	assert.Equal(t, "E", missingKeyspaceFlags("KA", "EEx"))
+}
+
+func assertKeyspaceEvent(t *T, ch <-chan KeyspaceEvent, exp KeyspaceEvent) {
+	select {
+	case ev := <-ch:
+		assert.Equal(t, exp, ev)
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %+v", exp)
+	}
+}
+
+func TestKeyspaceWatcher(t *T) {
+	conn, stubCh := PubSubStub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return errors.Errorf("unexpected command %q", args)
+	})
+	ps := PubSub(conn)
+	defer ps.Close()
+
+	notifyEvents := "Eg"
+	var configCmds [][]string
+	client := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		configCmds = append(configCmds, args)
+		if strings.ToUpper(args[1]) == "GET" {
+			return map[string]string{"notify-keyspace-events": notifyEvents}
+		}
+		notifyEvents = args[3]
+		return "OK"
+	})
+
+	w, err := NewKeyspaceWatcher(client, ps, KeyspaceWatcherOpts{
+		DB:                   1,
+		NotifyKeyspaceEvents: "Kg$",
+	})
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, [][]string{
+		{"CONFIG", "GET", "notify-keyspace-events"},
+		{"CONFIG", "SET", "notify-keyspace-events", "EgK$"},
+	}, configCmds)
+
+	keysCh := make(chan KeyspaceEvent, 1)
+	require.NoError(t, w.Watch(keysCh, "user:*"))
+	expiredCh := make(chan KeyspaceEvent, 1)
+	require.NoError(t, w.WatchEvents(expiredCh, "expired"))
+
+	stubCh <- PubSubMessage{
+		Pattern: "__keyspace@1__:user:*",
+		Channel: "__keyspace@1__:user:1",
+		Message: []byte("set"),
+	}
+	assertKeyspaceEvent(t, keysCh, KeyspaceEvent{DB: 1, Key: "user:1", Event: "set"})
+
+	stubCh <- PubSubMessage{
+		Pattern: "__keyevent@1__:expired",
+		Channel: "__keyevent@1__:expired",
+		Message: []byte("session:2"),
+	}
+	assertKeyspaceEvent(t, expiredCh, KeyspaceEvent{DB: 1, Key: "session:2", Event: "expired"})
+
+	require.NoError(t, w.Unwatch(keysCh, "user:*"))
+	stubCh <- PubSubMessage{
+		Pattern: "__keyspace@1__:user:*",
+		Channel: "__keyspace@1__:user:1",
+		Message: []byte("del"),
+	}
+	select {
+	case ev := <-keysCh:
+		t.Fatalf("unexpected event after unwatching: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, w.Close())
+}
@@ -0,0 +1,166 @@
+package radix
+
+import (
+	"bufio"
+	"math"
+	"sync/atomic"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// Latency measures the round-trip time of a single PING performed using the
+// given Client.
+func Latency(c Client) (time.Duration, error) {
+	start := time.Now()
+	if err := c.Do(Cmd(nil, "PING")); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// latencyWeight is the weight given to each new latency measurement when
+// updating an exponentially weighted moving average of latencies.
+const latencyWeight = 0.2
+
+// observeLatency updates the exponentially weighted moving average of
+// latencies stored in avg, in nanoseconds, using the given measurement. avg
+// must only be accessed atomically, a value of 0 means no latency was observed
+// yet.
+func observeLatency(avg *int64, d time.Duration) {
+	if d <= 0 {
+		d = 1 // reserve 0 for "not observed yet"
+	}
+	for {
+		old := atomic.LoadInt64(avg)
+		next := int64(d)
+		if old > 0 {
+			next = int64(math.Round(latencyWeight*float64(d) + (1-latencyWeight)*float64(old)))
+		}
+		if atomic.CompareAndSwapInt64(avg, old, next) {
+			return
+		}
+	}
+}
+
+// LatencyEvent describes the latest and the maximum latency spike of an event
+// monitored by the latency monitor, as returned by LATENCY LATEST.
+type LatencyEvent struct {
+	// Name is the name of the event, e.g. "command" or "fork".
+	Name string
+
+	// Timestamp is the time of the latest latency spike of the event.
+	Timestamp time.Time
+
+	// Latest is the duration of the latest latency spike, Max the duration of
+	// the longest one since the event was last reset.
+	Latest, Max time.Duration
+}
+
+// discardLatencyElems discards n elements after an invalid LATENCY reply
+// element was encountered.
+func discardLatencyElems(br *bufio.Reader, n int, name string) error {
+	for i := 0; i < n; i++ {
+		if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+	return resp.ErrDiscarded{Err: errors.Errorf("invalid %s with %d elements", name, n)}
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (le *LatencyEvent) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N != 4 {
+		return discardLatencyElems(br, ah.N, "latency event")
+	}
+
+	var timestamp, latest, max int64
+	*le = LatencyEvent{}
+	for _, rcv := range []interface{}{&le.Name, &timestamp, &latest, &max} {
+		if err := (resp2.Any{I: rcv}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+
+	le.Timestamp = time.Unix(timestamp, 0)
+	le.Latest = time.Duration(latest) * time.Millisecond
+	le.Max = time.Duration(max) * time.Millisecond
+	return nil
+}
+
+// LatencySample is a single latency spike of an event, as returned by LATENCY
+// HISTORY.
+type LatencySample struct {
+	Timestamp time.Time
+	Latency   time.Duration
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (ls *LatencySample) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N != 2 {
+		return discardLatencyElems(br, ah.N, "latency sample")
+	}
+
+	var timestamp, latency int64
+	for _, rcv := range []interface{}{&timestamp, &latency} {
+		if err := (resp2.Any{I: rcv}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+
+	ls.Timestamp = time.Unix(timestamp, 0)
+	ls.Latency = time.Duration(latency) * time.Millisecond
+	return nil
+}
+
+// LatencyLatest returns the latest latency spike of each event recorded by the
+// latency monitor, using LATENCY LATEST. The latency monitor must be enabled
+// using the latency-monitor-threshold config for events to be recorded.
+func LatencyLatest(c Client) ([]LatencyEvent, error) {
+	var events []LatencyEvent
+	if err := c.Do(Cmd(&events, "LATENCY", "LATEST")); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// LatencyHistory returns the latency spikes recorded for the given event,
+// oldest first, using LATENCY HISTORY. redis only keeps the 160 most recent
+// spikes of each event.
+func LatencyHistory(c Client, event string) ([]LatencySample, error) {
+	var samples []LatencySample
+	if err := c.Do(Cmd(&samples, "LATENCY", "HISTORY", event)); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// LatencyReset removes all recorded latency spikes of the given events, or of
+// all events if none are given, using LATENCY RESET. It returns the number of
+// events which were reset.
+func LatencyReset(c Client, events ...string) (int64, error) {
+	var n int64
+	if err := c.Do(Cmd(&n, "LATENCY", append([]string{"RESET"}, events...)...)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// LatencyDoctor returns the human readable analysis of the recorded latency
+// spikes generated by LATENCY DOCTOR.
+func LatencyDoctor(c Client) (string, error) {
+	var report string
+	if err := c.Do(Cmd(&report, "LATENCY", "DOCTOR")); err != nil {
+		return "", err
+	}
+	return report, nil
+}
@@ -0,0 +1,134 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+func TestLatency(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		time.Sleep(5 * time.Millisecond)
+		return "PONG"
+	})
+
+	l, err := Latency(stub)
+	require.NoError(t, err)
+	assert.True(t, l >= 5*time.Millisecond, "latency: %v", l)
+}
+
+func TestObserveLatency(t *T) {
+	var avg int64
+	observeLatency(&avg, 100)
+	assert.Equal(t, int64(100), avg)
+	observeLatency(&avg, 200)
+	assert.Equal(t, int64(120), avg)
+	observeLatency(&avg, 20)
+	assert.Equal(t, int64(100), avg)
+
+	avg = 0
+	observeLatency(&avg, 0)
+	assert.Equal(t, int64(1), avg)
+}
+
+func TestPoolLatency(t *T) {
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func(args []string) interface{} {
+				time.Sleep(5 * time.Millisecond)
+				return "PONG"
+			}), nil
+		}),
+		PoolPingInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	assert.Zero(t, pool.Latency())
+	for i := 0; i < 100 && pool.Latency() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, pool.Latency() >= 5*time.Millisecond, "latency: %v", pool.Latency())
+}
+
+func TestLatencyMonitor(t *T) {
+	var got [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = append(got, args)
+		switch strings.ToUpper(args[1]) {
+		case "LATEST":
+			return []interface{}{
+				[]interface{}{"command", 1405067976, 251, 1001},
+				[]interface{}{"fork", 1405067822, 9, 9},
+			}
+		case "HISTORY":
+			return []interface{}{
+				[]interface{}{1405067822, 251},
+				[]interface{}{1405067941, 1001},
+			}
+		case "RESET":
+			return len(args) - 2
+		case "DOCTOR":
+			return "Dave, no latency spike was observed"
+		default:
+			return errors.Errorf("unexpected command %q", args)
+		}
+	})
+
+	events, err := LatencyLatest(stub)
+	require.NoError(t, err)
+	assert.Equal(t, []LatencyEvent{
+		{
+			Name:      "command",
+			Timestamp: time.Unix(1405067976, 0),
+			Latest:    251 * time.Millisecond,
+			Max:       1001 * time.Millisecond,
+		},
+		{
+			Name:      "fork",
+			Timestamp: time.Unix(1405067822, 0),
+			Latest:    9 * time.Millisecond,
+			Max:       9 * time.Millisecond,
+		},
+	}, events)
+
+	samples, err := LatencyHistory(stub, "command")
+	require.NoError(t, err)
+	assert.Equal(t, []LatencySample{
+		{Timestamp: time.Unix(1405067822, 0), Latency: 251 * time.Millisecond},
+		{Timestamp: time.Unix(1405067941, 0), Latency: 1001 * time.Millisecond},
+	}, samples)
+	assert.Equal(t, []string{"LATENCY", "HISTORY", "command"}, got[len(got)-1])
+
+	n, err := LatencyReset(stub, "command", "fork")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, []string{"LATENCY", "RESET", "command", "fork"}, got[len(got)-1])
+
+	report, err := LatencyDoctor(stub)
+	require.NoError(t, err)
+	assert.Equal(t, "Dave, no latency spike was observed", report)
+}
+
+func TestLatencySampleInvalid(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "PING" {
+			return "PONG"
+		}
+		return []interface{}{[]interface{}{1405067822}}
+	})
+
+	_, err := LatencyHistory(stub, "command")
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+
+	// the whole reply was discarded
+	var pong string
+	require.NoError(t, stub.Do(Cmd(&pong, "PING")))
+	assert.Equal(t, "PONG", pong)
+}
@@ -0,0 +1,74 @@
+package radix
+
+import (
+	"strconv"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// ListDirection describes one of the two ends of a list, as used by commands
+// like LMOVE.
+type ListDirection string
+
+// All possible ListDirection values.
+const (
+	ListLeft  ListDirection = "LEFT"
+	ListRight ListDirection = "RIGHT"
+)
+
+// LMove atomically pops an element from the given end of the list at src and
+// pushes it onto the given end of the list at dst using LMOVE, returning the
+// moved element. If the list at src is empty ok will be false.
+//
+// When used with a Cluster src and dst must belong to the same slot.
+func LMove(c Client, src, dst string, from, to ListDirection) (elem string, ok bool, err error) {
+	mn := MaybeNil{Rcv: &elem}
+	if err := c.Do(Cmd(&mn, "LMOVE", src, dst, string(from), string(to))); err != nil {
+		return "", false, err
+	}
+	return elem, !mn.Nil, nil
+}
+
+// BLMove is like LMove, but uses BLMOVE to block until an element is available
+// in the list at src or until the timeout is reached, in which case ok will be
+// false. A timeout of zero blocks indefinitely.
+//
+// Since BLMOVE is a blocking command it will not be implicitly pipelined by
+// Pool, but will use a connection exclusively until it returns.
+func BLMove(c Client, src, dst string, from, to ListDirection, timeout time.Duration) (elem string, ok bool, err error) {
+	timeoutStr := strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64)
+	mn := MaybeNil{Rcv: &elem}
+	if err := c.Do(Cmd(&mn, "BLMOVE", src, dst, string(from), string(to), timeoutStr)); err != nil {
+		return "", false, err
+	}
+	return elem, !mn.Nil, nil
+}
+
+var pushCappedScript = NewEvalScript(1, `
+	local n = redis.call("LPUSH", KEYS[1], ARGV[1])
+	local max = tonumber(ARGV[2])
+	if n > max then
+		redis.call("LTRIM", KEYS[1], 0, max - 1)
+		n = max
+	end
+	return n
+`)
+
+// PushCapped pushes value onto the head of the list at key and trims the list
+// to at most maxLen elements, removing the oldest ones from the tail, and
+// returns the resulting length of the list. This can be used for e.g. feeds of
+// recent activity.
+//
+// Unlike performing LPUSH and LTRIM separately, PushCapped runs both commands
+// atomically using a lua script, so that the list never exceeds maxLen, even
+// when pushing concurrently.
+func PushCapped(c Client, key, value string, maxLen int) (int64, error) {
+	if maxLen < 1 {
+		return 0, errors.Errorf("invalid maxLen %d, must be at least 1", maxLen)
+	}
+
+	var n int64
+	err := c.Do(pushCappedScript.Cmd(&n, key, value, strconv.Itoa(maxLen)))
+	return n, err
+}
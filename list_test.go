@@ -0,0 +1,93 @@
+package radix
+
+import (
+	"strconv"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestLMove(t *T) {
+	var lastArgs []string
+	lists := map[string][]string{"src": {"a", "b"}}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		lastArgs = args
+		src := lists[args[1]]
+		if len(src) == 0 {
+			return nil
+		}
+		elem := src[0]
+		lists[args[1]] = src[1:]
+		lists[args[2]] = append(lists[args[2]], elem)
+		return elem
+	})
+
+	elem, ok, err := LMove(stub, "src", "dst", ListLeft, ListRight)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", elem)
+	assert.Equal(t, []string{"LMOVE", "src", "dst", "LEFT", "RIGHT"}, lastArgs)
+
+	elem, ok, err = BLMove(stub, "src", "dst", ListRight, ListLeft, 1500*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "b", elem)
+	assert.Equal(t, []string{"BLMOVE", "src", "dst", "RIGHT", "LEFT", "1.5"}, lastArgs)
+
+	elem, ok, err = BLMove(stub, "src", "dst", ListRight, ListLeft, 0)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, elem)
+	assert.Equal(t, "0", lastArgs[5])
+
+	t.Run("keys", func(t *T) {
+		assert.Equal(t, []string{"src", "dst"}, Cmd(nil, "LMOVE", "src", "dst", "LEFT", "RIGHT").Keys())
+		assert.Equal(t, []string{"src", "dst"}, Cmd(nil, "BLMOVE", "src", "dst", "LEFT", "RIGHT", "0").Keys())
+	})
+
+	t.Run("cluster", func(t *T) {
+		c, _ := newTestCluster()
+		defer c.Close()
+
+		_, _, err := LMove(c, clusterSlotKeys[0], clusterSlotKeys[1], ListLeft, ListRight)
+		assert.Error(t, err)
+	})
+}
+
+func TestPushCapped(t *T) {
+	lists := map[string][]string{}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "EVALSHA":
+			return resp2.Error{E: errors.New("NOSCRIPT")}
+		case "EVAL":
+			// emulates pushCappedScript
+			key, value := args[3], args[4]
+			max, _ := strconv.Atoi(args[5])
+			l := append([]string{value}, lists[key]...)
+			if len(l) > max {
+				l = l[:max]
+			}
+			lists[key] = l
+			return len(l)
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	for i, exp := range []int64{1, 2, 3, 3, 3} {
+		n, err := PushCapped(stub, "feed", strconv.Itoa(i), 3)
+		require.NoError(t, err)
+		assert.Equal(t, exp, n)
+	}
+	assert.Equal(t, []string{"4", "3", "2"}, lists["feed"])
+
+	_, err := PushCapped(stub, "feed", "5", 0)
+	assert.Error(t, err)
+	assert.Len(t, lists["feed"], 3)
+}
@@ -0,0 +1,407 @@
+package radix
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// SetNXEX sets key to value using SET with the NX and PX options, so that key
+// is only set if it doesn't exist yet and expires after the given ttl. The ttl
+// is rounded down to milliseconds, but is at least one millisecond, since redis
+// rejects a PX of zero.
+//
+// acquired will be false if the key already existed, in which case it will not
+// have been changed. Together with ReleaseLock this can be used to implement a
+// simple lock, where value is a token unique to the holder of the lock.
+func SetNXEX(c Client, key, value string, ttl time.Duration) (acquired bool, err error) {
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	ms := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	mn := MaybeNil{}
+	if err := c.Do(Cmd(&mn, "SET", key, value, "NX", "PX", ms)); err != nil {
+		return false, err
+	}
+	return !mn.Nil, nil
+}
+
+var releaseLockScript = NewEvalScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+// ReleaseLock deletes key if, and only if, it is still set to value, which is
+// checked atomically using a lua script. This makes it possible to release a
+// lock acquired using SetNXEX without accidentally releasing a lock acquired by
+// someone else after the original one expired.
+//
+// released will be false if the key didn't exist or had a different value.
+func ReleaseLock(c Client, key, value string) (released bool, err error) {
+	if err := c.Do(releaseLockScript.Cmd(&released, key, value)); err != nil {
+		return false, err
+	}
+	return released, nil
+}
+
+// ErrMutexTimeout is returned by Mutex.Lock if the lock could not be acquired
+// within the given timeout.
+var ErrMutexTimeout = errors.New("timed out acquiring the lock")
+
+// ErrMutexNotHeld is returned by Mutex.Unlock and Mutex.Extend if the lock is
+// not held by the Mutex, e.g. because it already expired. It is also written to
+// the channel given to MutexErrCh if a lock which was extended in the
+// background was lost.
+var ErrMutexNotHeld = errors.New("lock not held")
+
+type mutexOpts struct {
+	ttl            time.Duration
+	retryBackoff   Backoff
+	extendInterval time.Duration
+	errCh          chan<- error
+}
+
+// MutexOpt is an optional behavior which can be applied to the NewMutex
+// function to effect a Mutex's behavior.
+type MutexOpt func(*mutexOpts)
+
+// MutexTTL sets the duration after which a lock held by the Mutex expires,
+// unless it is extended using Extend.
+func MutexTTL(ttl time.Duration) MutexOpt {
+	return func(mo *mutexOpts) {
+		mo.ttl = ttl
+	}
+}
+
+// MutexRetryInterval sets the interval at which Lock retries acquiring the lock
+// while it is held by someone else. This is equivalent to using
+// MutexRetryBackoff with a ConstantBackoff.
+func MutexRetryInterval(d time.Duration) MutexOpt {
+	return MutexRetryBackoff(ConstantBackoff(d))
+}
+
+// MutexRetryBackoff sets the Backoff used by Lock to determine how long to wait
+// before retrying to acquire the lock while it is held by someone else. A nil
+// Backoff is ignored.
+func MutexRetryBackoff(b Backoff) MutexOpt {
+	return func(mo *mutexOpts) {
+		if b != nil {
+			mo.retryBackoff = b
+		}
+	}
+}
+
+// MutexAutoExtend causes the Mutex to extend the lock in the background every
+// interval while it is held, so that it doesn't expire while it's in use. The
+// interval should be well below the TTL of the Mutex, e.g. a third of it, so
+// that a failed extension can be retried before the lock expires.
+//
+// Extension stops once the lock is released using Unlock, or once it turns out
+// to have been lost, in which case ErrMutexNotHeld is written to the channel
+// given to MutexErrCh.
+func MutexAutoExtend(interval time.Duration) MutexOpt {
+	return func(mo *mutexOpts) {
+		mo.extendInterval = interval
+	}
+}
+
+// MutexErrCh takes a channel which errors encountered while extending the lock
+// in the background, see MutexAutoExtend, can be read off of. If the channel
+// blocks the error will be dropped.
+func MutexErrCh(errCh chan<- error) MutexOpt {
+	return func(mo *mutexOpts) {
+		mo.errCh = errCh
+	}
+}
+
+var extendLockScript = NewEvalScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+// Mutex is a lock stored in one or more redis instances, which can be used to
+// synchronize access to some resource between multiple processes.
+//
+// The lock is acquired using SetNXEX with a random token which is generated on
+// every acquisition, and released using ReleaseLock, so that a Mutex will never
+// release a lock which was acquired by someone else after its own lock expired.
+//
+// The methods of a Mutex must not be called concurrently, but they may be
+// called while the lock is extended in the background, see MutexAutoExtend.
+type Mutex struct {
+	cs   []Client
+	key  string
+	opts mutexOpts
+
+	l      sync.Mutex
+	token  string
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewMutex returns a Mutex for the lock stored at the given key.
+//
+// The default options NewMutex uses are:
+//
+//	MutexTTL(10 * time.Second)
+//	MutexRetryInterval(100 * time.Millisecond)
+//
+func NewMutex(c Client, key string, opts ...MutexOpt) *Mutex {
+	return NewRedlock([]Client{c}, key, opts...)
+}
+
+// NewRedlock returns a Mutex for the lock stored at the given key on each of
+// the given Clients, following the Redlock algorithm described at
+// https://redis.io/docs/manual/patterns/distributed-locks/. The Clients should
+// be independent redis instances, and not replicas of each other or nodes of
+// the same Cluster.
+//
+// The lock is acquired if it could be set on a majority of the instances
+// before its TTL, minus an allowance for the clock drift between the
+// instances, ran out. Otherwise it is released on all instances again.
+// Likewise Extend and Unlock only succeed if they succeeded on a majority of
+// the instances, and an error is only returned if a majority of the instances
+// failed.
+//
+// NewRedlock uses the same default options as NewMutex.
+func NewRedlock(cs []Client, key string, opts ...MutexOpt) *Mutex {
+	m := &Mutex{cs: cs, key: key}
+
+	defaultMutexOpts := []MutexOpt{
+		MutexTTL(10 * time.Second),
+		MutexRetryInterval(100 * time.Millisecond),
+	}
+	for _, opt := range append(defaultMutexOpts, opts...) {
+		opt(&m.opts)
+	}
+	return m
+}
+
+// newLockToken returns a random token identifying a single acquisition of a
+// lock.
+func newLockToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// doAll calls fn for each of the Mutex's Clients, and returns whether fn
+// returned true for a majority of them. An error is only returned if a
+// majority couldn't be reached because fn returned an error.
+func (m *Mutex) doAll(fn func(Client) (bool, error)) (bool, error) {
+	quorum := len(m.cs)/2 + 1
+	var ok, failed int
+	var firstErr error
+	for _, c := range m.cs {
+		if res, err := fn(c); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else if res {
+			ok++
+		}
+	}
+
+	if failed > len(m.cs)-quorum {
+		return false, firstErr
+	}
+	return ok >= quorum, nil
+}
+
+func (m *Mutex) currToken() string {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.token
+}
+
+func (m *Mutex) setToken(token string) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.token = token
+	if m.opts.extendInterval > 0 {
+		m.stopCh = make(chan struct{})
+		m.doneCh = make(chan struct{})
+		go m.autoExtend(token, m.stopCh, m.doneCh)
+	}
+}
+
+// clearToken unsets the token if it's still the given one, stopping the
+// background extension, and returns whether it did. The returned channel is
+// closed once the background extension has stopped, i.e. once it won't
+// extend the lock anymore. It's nil if there is no background extension.
+func (m *Mutex) clearToken(token string) (bool, <-chan struct{}) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if token == "" || m.token != token {
+		return false, nil
+	}
+	m.token = ""
+	doneCh := m.doneCh
+	if m.stopCh != nil {
+		close(m.stopCh)
+		m.stopCh, m.doneCh = nil, nil
+	}
+	return true, doneCh
+}
+
+// waitDone waits for the given channel returned by clearToken to be closed.
+func waitDone(doneCh <-chan struct{}) {
+	if doneCh != nil {
+		<-doneCh
+	}
+}
+
+// TryLock tries to acquire the lock once, returning whether the lock was
+// acquired.
+func (m *Mutex) TryLock() (bool, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	acquired, err := m.doAll(func(c Client) (bool, error) {
+		return SetNXEX(c, m.key, token, m.opts.ttl)
+	})
+	if acquired && len(m.cs) > 1 {
+		drift := m.opts.ttl/100 + 2*time.Millisecond
+		acquired = time.Since(start)+drift < m.opts.ttl
+	}
+
+	if acquired {
+		m.setToken(token)
+		return true, nil
+	} else if len(m.cs) > 1 {
+		// the lock might have been set on some of the instances
+		m.release(token)
+	}
+	return false, err
+}
+
+// Lock acquires the lock, retrying until either the lock was acquired or the
+// timeout is reached, in which case ErrMutexTimeout is returned. A timeout of
+// zero causes Lock to retry indefinitely.
+func (m *Mutex) Lock(timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if acquired, err := m.TryLock(); err != nil {
+			return err
+		} else if acquired {
+			return nil
+		}
+		wait := m.opts.retryBackoff.Next(attempt)
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			return ErrMutexTimeout
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (m *Mutex) release(token string) (bool, error) {
+	return m.doAll(func(c Client) (bool, error) {
+		return ReleaseLock(c, m.key, token)
+	})
+}
+
+// Unlock releases the lock. If the lock isn't held by the Mutex anymore
+// ErrMutexNotHeld is returned. If the lock is extended in the background, see
+// MutexAutoExtend, Unlock waits for an extension in progress to finish first.
+func (m *Mutex) Unlock() error {
+	token := m.currToken()
+	ok, doneCh := m.clearToken(token)
+	if !ok {
+		return ErrMutexNotHeld
+	}
+
+	// an extension which is already in progress must not reach redis after
+	// the lock was released
+	waitDone(doneCh)
+	if released, err := m.release(token); err != nil {
+		return err
+	} else if !released {
+		return ErrMutexNotHeld
+	}
+	return nil
+}
+
+func (m *Mutex) extend(token string) error {
+	ms := strconv.FormatInt(int64(m.opts.ttl/time.Millisecond), 10)
+	extended, err := m.doAll(func(c Client) (bool, error) {
+		var extended bool
+		err := c.Do(extendLockScript.Cmd(&extended, m.key, token, ms))
+		return extended, err
+	})
+	if err != nil {
+		return err
+	} else if !extended {
+		return ErrMutexNotHeld
+	}
+	return nil
+}
+
+// Extend resets the expiry of the lock to the Mutex's TTL. If the lock isn't
+// held by the Mutex anymore ErrMutexNotHeld is returned.
+func (m *Mutex) Extend() error {
+	token := m.currToken()
+	if token == "" {
+		return ErrMutexNotHeld
+	}
+	err := m.extend(token)
+	if err == ErrMutexNotHeld {
+		_, doneCh := m.clearToken(token)
+		waitDone(doneCh)
+	}
+	return err
+}
+
+func (m *Mutex) autoExtend(token string, stopCh <-chan struct{}, doneCh chan<- struct{}) {
+	defer close(doneCh)
+	t := time.NewTicker(m.opts.extendInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+
+		err := m.extend(token)
+		if err == ErrMutexNotHeld {
+			// if the token was already cleared the lock was released while it
+			// was being extended, and the error is expected
+			if ok, _ := m.clearToken(token); ok {
+				m.reportErr(err)
+			}
+			return
+		} else if err != nil {
+			select {
+			case <-stopCh:
+				return
+			default:
+				m.reportErr(err)
+			}
+		}
+	}
+}
+
+func (m *Mutex) reportErr(err error) {
+	select {
+	case m.opts.errCh <- err:
+	default:
+	}
+}
@@ -0,0 +1,220 @@
+package radix
+
+import (
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// testLockStub returns a Conn which supports the commands used by SetNXEX and
+// ReleaseLock, as well as the scripts used by Mutex, on top of m. All received
+// commands are passed to onCmd, if set.
+func testLockStub(m map[string]string, onCmd func([]string)) Conn {
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if onCmd != nil {
+			onCmd(args)
+		}
+		switch args[0] {
+		case "SET":
+			if _, ok := m[args[1]]; ok {
+				return nil
+			}
+			m[args[1]] = args[2]
+			return resp2.SimpleString{S: "OK"}
+		case "DEL":
+			delete(m, args[1])
+			return 1
+		case "EVALSHA":
+			return resp2.Error{E: errors.New("NOSCRIPT")}
+		case "EVAL":
+			key, value := args[3], args[4]
+			if m[key] != value {
+				return 0
+			} else if strings.Contains(args[1], "DEL") {
+				delete(m, key)
+			}
+			return 1
+		default:
+			return errors.Errorf("testLockStub doesn't support command %q", args[0])
+		}
+	})
+}
+
+func TestSetNXEXReleaseLock(t *T) {
+	var lastArgs []string
+	m := map[string]string{}
+	stub := testLockStub(m, func(args []string) { lastArgs = args })
+
+	acquired, err := SetNXEX(stub, "lock", "a", 1500*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, []string{"SET", "lock", "a", "NX", "PX", "1500"}, lastArgs)
+
+	acquired, err = SetNXEX(stub, "lock", "b", time.Second)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, "a", m["lock"])
+
+	released, err := ReleaseLock(stub, "lock", "b")
+	require.NoError(t, err)
+	assert.False(t, released)
+	assert.Equal(t, "a", m["lock"])
+
+	released, err = ReleaseLock(stub, "lock", "a")
+	require.NoError(t, err)
+	assert.True(t, released)
+	assert.NotContains(t, m, "lock")
+
+	// ttls below a millisecond would result in an invalid PX of zero
+	acquired, err = SetNXEX(stub, "lock", "c", time.Microsecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, []string{"SET", "lock", "c", "NX", "PX", "1"}, lastArgs)
+}
+
+func TestMutex(t *T) {
+	m := map[string]string{}
+	stub := testLockStub(m, nil)
+
+	mu1 := NewMutex(stub, "lock", MutexTTL(time.Second), MutexRetryInterval(time.Millisecond))
+	mu2 := NewMutex(stub, "lock", MutexRetryInterval(time.Millisecond))
+
+	require.NoError(t, mu1.Lock(0))
+	token := m["lock"]
+	assert.Len(t, token, 32)
+
+	acquired, err := mu2.TryLock()
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, ErrMutexTimeout, mu2.Lock(10*time.Millisecond))
+	assert.Equal(t, ErrMutexNotHeld, mu2.Unlock())
+	assert.Equal(t, ErrMutexNotHeld, mu2.Extend())
+
+	require.NoError(t, mu1.Extend())
+	require.NoError(t, mu1.Unlock())
+	assert.NotContains(t, m, "lock")
+	assert.Equal(t, ErrMutexNotHeld, mu1.Unlock())
+
+	// simulate the lock expiring and being acquired by someone else
+	require.NoError(t, mu1.Lock(0))
+	assert.NotEqual(t, token, m["lock"])
+	delete(m, "lock")
+	require.NoError(t, mu2.Lock(0))
+	assert.Equal(t, ErrMutexNotHeld, mu1.Extend())
+	assert.Equal(t, ErrMutexNotHeld, mu1.Unlock())
+	require.NoError(t, mu2.Unlock())
+}
+
+func TestMutexRetryBackoffNil(t *T) {
+	stub := testLockStub(map[string]string{}, nil)
+	mu1 := NewMutex(stub, "lock")
+	mu2 := NewMutex(stub, "lock", MutexRetryBackoff(nil))
+
+	// the default backoff is kept, so Lock retries until the timeout
+	require.NoError(t, mu1.Lock(0))
+	assert.Equal(t, ErrMutexTimeout, mu2.Lock(10*time.Millisecond))
+}
+
+// lockedClient serializes calls to Do, so that a Stub can be used concurrently
+// by a Mutex extending its lock in the background.
+type lockedClient struct {
+	l sync.Mutex
+	Client
+}
+
+func (lc *lockedClient) Do(a Action) error {
+	lc.l.Lock()
+	defer lc.l.Unlock()
+	return lc.Client.Do(a)
+}
+
+func TestMutexAutoExtend(t *T) {
+	var extends int
+	m := map[string]string{}
+	c := &lockedClient{Client: testLockStub(m, func(args []string) {
+		if args[0] == "EVAL" && strings.Contains(args[1], "PEXPIRE") {
+			extends++
+		}
+	})}
+	numExtends := func() int {
+		c.l.Lock()
+		defer c.l.Unlock()
+		return extends
+	}
+
+	errCh := make(chan error, 1)
+	mu := NewMutex(c, "lock", MutexAutoExtend(5*time.Millisecond), MutexErrCh(errCh))
+	require.NoError(t, mu.Lock(0))
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, numExtends() > 1, "extends: %d", numExtends())
+
+	// no more extensions after Unlock
+	require.NoError(t, mu.Unlock())
+	n := numExtends()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, n, numExtends())
+
+	// losing the lock is reported and stops the extension
+	require.NoError(t, mu.Lock(0))
+	require.NoError(t, c.Do(Cmd(nil, "DEL", "lock")))
+	select {
+	case err := <-errCh:
+		assert.Equal(t, ErrMutexNotHeld, err)
+	case <-time.After(time.Second):
+		t.Fatal("lost lock not reported")
+	}
+	assert.Equal(t, ErrMutexNotHeld, mu.Unlock())
+	select {
+	case err := <-errCh:
+		t.Fatalf("unexpected error: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestRedlock(t *T) {
+	ms := []map[string]string{{}, {}, {}}
+	cs := make([]Client, len(ms))
+	for i := range ms {
+		cs[i] = testLockStub(ms[i], nil)
+	}
+
+	// acquiring on a majority is enough
+	ms[0]["lock"] = "other"
+	mu := NewRedlock(cs, "lock", MutexRetryInterval(time.Millisecond))
+	require.NoError(t, mu.Lock(0))
+	assert.Equal(t, ms[1]["lock"], ms[2]["lock"])
+	require.NoError(t, mu.Extend())
+	require.NoError(t, mu.Unlock())
+	assert.Equal(t, "other", ms[0]["lock"])
+	assert.NotContains(t, ms[1], "lock")
+	assert.NotContains(t, ms[2], "lock")
+
+	// without a majority the partially acquired lock is released again
+	ms[1]["lock"] = "other"
+	acquired, err := mu.TryLock()
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	assert.NotContains(t, ms[2], "lock")
+	delete(ms[0], "lock")
+	delete(ms[1], "lock")
+
+	// a single failed instance is tolerated, a majority is not
+	down := Stub("tcp", "127.0.0.1:6379", func([]string) interface{} {
+		return errors.New("instance down")
+	})
+	mu = NewRedlock([]Client{cs[0], cs[1], down}, "lock")
+	require.NoError(t, mu.Lock(0))
+	require.NoError(t, mu.Unlock())
+
+	mu = NewRedlock([]Client{cs[0], down, down}, "lock")
+	_, err = mu.TryLock()
+	assert.EqualError(t, err, "instance down")
+	assert.NotContains(t, ms[0], "lock")
+}
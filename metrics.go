@@ -0,0 +1,256 @@
+package radix
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetricsBuckets are the upper bounds of the latency histogram buckets
+// used by a MetricsRegistry, unless MetricsLatencyBuckets is given.
+var DefaultMetricsBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// MetricsOtherCommand is the name under which Actions are recorded in a
+// MetricsRegistry if their command isn't allowed by MetricsAllowCommands, or
+// if the command of the Action can't be determined, e.g. for WithConn.
+const MetricsOtherCommand = "OTHER"
+
+// MetricsPipelineCommand is the name under which Pipelines are recorded in a
+// MetricsRegistry.
+const MetricsPipelineCommand = "PIPELINE"
+
+type metricsOpts struct {
+	allowed map[string]bool
+	buckets []time.Duration
+}
+
+// MetricsOpt is an optional behavior which can be applied to the
+// NewMetricsRegistry function to effect its behavior.
+type MetricsOpt func(*metricsOpts)
+
+// MetricsAllowCommands limits the command names recorded separately by the
+// MetricsRegistry to the given ones, so that the number of recorded commands
+// stays bounded. All other commands are recorded under MetricsOtherCommand.
+//
+// By default all commands are recorded separately.
+func MetricsAllowCommands(cmds ...string) MetricsOpt {
+	return func(mo *metricsOpts) {
+		mo.allowed = make(map[string]bool, len(cmds))
+		for _, cmd := range cmds {
+			mo.allowed[strings.ToUpper(cmd)] = true
+		}
+	}
+}
+
+// MetricsLatencyBuckets sets the upper bounds of the buckets of the latency
+// histograms recorded by the MetricsRegistry. Defaults to
+// DefaultMetricsBuckets.
+func MetricsLatencyBuckets(buckets ...time.Duration) MetricsOpt {
+	return func(mo *metricsOpts) {
+		mo.buckets = append([]time.Duration(nil), buckets...)
+		sort.Slice(mo.buckets, func(i, j int) bool { return mo.buckets[i] < mo.buckets[j] })
+	}
+}
+
+// LatencyBucket is a single bucket of a latency histogram.
+type LatencyBucket struct {
+	// Le is the upper bound (inclusive) of the bucket.
+	Le time.Duration
+
+	// Count is the number of Actions whose latency was at most Le. As with
+	// cumulative histograms this includes the Actions of all smaller buckets.
+	Count int64
+}
+
+// CommandMetrics contains the metrics recorded for a single command by a
+// MetricsRegistry.
+type CommandMetrics struct {
+	// Count is the number of times the command was performed, and Errors the
+	// number of times it returned an error.
+	Count, Errors int64
+
+	// TotalLatency is the sum of the latencies of all performed commands.
+	TotalLatency time.Duration
+
+	// Buckets is the latency histogram. Actions slower than the largest bucket
+	// are only included in Count.
+	Buckets []LatencyBucket
+
+	// ReplyBytes is the total size of all replies in bytes, as sent by redis.
+	ReplyBytes int64
+}
+
+// MetricsRegistry records metrics for the Actions performed on Conns wrapped
+// using MeteredConn. It is safe for concurrent use, and a single
+// MetricsRegistry may be shared by any number of Conns.
+type MetricsRegistry struct {
+	opts metricsOpts
+
+	l    sync.Mutex
+	cmds map[string]*CommandMetrics
+}
+
+// NewMetricsRegistry returns a new, empty MetricsRegistry.
+func NewMetricsRegistry(opts ...MetricsOpt) *MetricsRegistry {
+	var mo metricsOpts
+	defaultOpts := []MetricsOpt{
+		MetricsLatencyBuckets(DefaultMetricsBuckets...),
+	}
+	for _, opt := range append(defaultOpts, opts...) {
+		opt(&mo)
+	}
+	return &MetricsRegistry{opts: mo, cmds: map[string]*CommandMetrics{}}
+}
+
+func (r *MetricsRegistry) record(cmd string, d time.Duration, replyBytes int64, err error) {
+	cmd = strings.ToUpper(cmd)
+	if cmd == "" || (r.opts.allowed != nil && !r.opts.allowed[cmd] && cmd != MetricsPipelineCommand) {
+		cmd = MetricsOtherCommand
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	cm, ok := r.cmds[cmd]
+	if !ok {
+		cm = &CommandMetrics{Buckets: make([]LatencyBucket, len(r.opts.buckets))}
+		for i, le := range r.opts.buckets {
+			cm.Buckets[i].Le = le
+		}
+		r.cmds[cmd] = cm
+	}
+
+	cm.Count++
+	if err != nil {
+		cm.Errors++
+	}
+	cm.TotalLatency += d
+	cm.ReplyBytes += replyBytes
+	for i := range cm.Buckets {
+		if d <= cm.Buckets[i].Le {
+			cm.Buckets[i].Count++
+		}
+	}
+}
+
+// Snapshot returns a copy of the metrics recorded so far, keyed by the
+// upper-cased command name.
+func (r *MetricsRegistry) Snapshot() map[string]CommandMetrics {
+	r.l.Lock()
+	defer r.l.Unlock()
+	snap := make(map[string]CommandMetrics, len(r.cmds))
+	for cmd, cm := range r.cmds {
+		cmCopy := *cm
+		cmCopy.Buckets = append([]LatencyBucket(nil), cm.Buckets...)
+		snap[cmd] = cmCopy
+	}
+	return snap
+}
+
+// Reset removes all metrics recorded so far.
+func (r *MetricsRegistry) Reset() {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.cmds = map[string]*CommandMetrics{}
+}
+
+type meteredConn struct {
+	Conn
+	r *MetricsRegistry
+}
+
+// MeteredConn wraps the given Conn such that the latency, errors and reply
+// sizes of all Actions performed using Do are recorded in r. Pipelines are
+// recorded as a whole under MetricsPipelineCommand.
+//
+// Reply sizes are only recorded if conn was created by Dial, NewConn or Stub,
+// for other Conns they are always zero.
+//
+// MeteredConn is intended as a simple alternative to DialWithTrace for
+// collecting basic metrics. To use MeteredConn with a Pool, wrap the Conns
+// created by its ConnFunc:
+//
+//	reg := radix.NewMetricsRegistry()
+//	connFunc := func(network, addr string) (radix.Conn, error) {
+//		conn, err := radix.Dial(network, addr)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return radix.MeteredConn(conn, reg), nil
+//	}
+//
+func MeteredConn(conn Conn, r *MetricsRegistry) Conn {
+	return &meteredConn{Conn: conn, r: r}
+}
+
+func (mc *meteredConn) Do(a Action) error {
+	return mc.doOn(a, mc)
+}
+
+// replyCounter is implemented by Conns which count the bytes of the replies
+// they decoded, i.e. those created by Dial, NewConn and Stub.
+type replyCounter interface {
+	bytesRead() int64
+}
+
+func (mc *meteredConn) doOn(a Action, conn Conn) error {
+	var name string
+	if _, ok := a.(pipeline); ok {
+		name = MetricsPipelineCommand
+	} else {
+		name, _ = commandName(a)
+	}
+
+	// the Action is performed using the Do of the wrapped Conn, so that
+	// everything it does, like extending the read timeout of blocking
+	// commands, still applies
+	ma := &meteredAction{Action: a, mc: mc, conn: conn}
+	start := time.Now()
+	var err error
+	if od, ok := mc.Conn.(onDoer); ok {
+		err = od.doOn(ma, conn)
+	} else {
+		err = mc.Conn.Do(ma)
+	}
+	mc.r.record(name, time.Since(start), ma.replyBytes, err)
+	return err
+}
+
+func (mc *meteredConn) bytesRead() int64 {
+	if rc, ok := mc.Conn.(replyCounter); ok {
+		return rc.bytesRead()
+	}
+	return 0
+}
+
+// meteredAction wraps an Action performed using a meteredConn, and records
+// the size of the replies decoded during its Run.
+type meteredAction struct {
+	Action
+	mc         *meteredConn
+	conn       Conn
+	replyBytes int64
+}
+
+func (ma *meteredAction) Run(conn Conn) error {
+	// the Action must be run with the meteredConn (or its wrapper) instead of
+	// the wrapped Conn, so Actions like WithConn which call Do again are
+	// recorded too
+	if conn == ma.mc.Conn {
+		conn = ma.conn
+	}
+	start := ma.mc.bytesRead()
+	err := ma.Action.Run(conn)
+	ma.replyBytes = ma.mc.bytesRead() - start
+	return err
+}
@@ -0,0 +1,121 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestMeteredConn(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			return "bar"
+		case "INCR":
+			return resp2.Error{E: errors.New("ERR value is not an integer or out of range")}
+		default:
+			return resp2.SimpleString{S: "OK"}
+		}
+	})
+
+	reg := NewMetricsRegistry(
+		MetricsAllowCommands("get", "INCR"),
+		MetricsLatencyBuckets(time.Hour, time.Nanosecond),
+	)
+	conn := MeteredConn(stub, reg)
+
+	var res string
+	require.NoError(t, conn.Do(Cmd(&res, "GET", "foo")))
+	assert.Equal(t, "bar", res)
+	require.NoError(t, conn.Do(Cmd(nil, "get", "foo")))
+	assert.Error(t, conn.Do(Cmd(nil, "INCR", "foo")))
+	require.NoError(t, conn.Do(Cmd(nil, "SET", "foo", "bar")))
+	require.NoError(t, conn.Do(WithConn("", func(conn Conn) error {
+		return conn.Do(Cmd(nil, "PING"))
+	})))
+	require.NoError(t, conn.Do(Pipeline(
+		Cmd(nil, "GET", "foo"),
+		Cmd(nil, "SET", "foo", "bar"),
+	)))
+
+	// an error decoding the reply doesn't break the Conn
+	var n int
+	assert.Error(t, conn.Do(Cmd(&n, "GET", "foo")))
+	require.NoError(t, conn.Do(Cmd(&res, "GET", "foo")))
+
+	snap := reg.Snapshot()
+	assert.Len(t, snap, 4)
+
+	get := snap["GET"]
+	assert.Equal(t, int64(4), get.Count)
+	assert.Equal(t, int64(1), get.Errors)
+	assert.Equal(t, int64(4*len("$3\r\nbar\r\n")), get.ReplyBytes)
+	assert.True(t, get.TotalLatency > 0)
+	require.Len(t, get.Buckets, 2)
+	assert.Equal(t, time.Nanosecond, get.Buckets[0].Le)
+	assert.Equal(t, time.Hour, get.Buckets[1].Le)
+	assert.Equal(t, int64(4), get.Buckets[1].Count)
+
+	assert.Equal(t, int64(1), snap["INCR"].Count)
+	assert.Equal(t, int64(1), snap["INCR"].Errors)
+
+	// SET, WithConn and the PING within it aren't allowed
+	assert.Equal(t, int64(3), snap[MetricsOtherCommand].Count)
+	assert.Equal(t, int64(len("+OK\r\n")*3), snap[MetricsOtherCommand].ReplyBytes)
+
+	pipe := snap[MetricsPipelineCommand]
+	assert.Equal(t, int64(1), pipe.Count)
+	assert.Equal(t, int64(len("$3\r\nbar\r\n+OK\r\n")), pipe.ReplyBytes)
+
+	// snapshots are copies
+	get.Buckets[1].Count = 100
+	assert.Equal(t, int64(4), reg.Snapshot()["GET"].Buckets[1].Count)
+
+	reg.Reset()
+	assert.Empty(t, reg.Snapshot())
+}
+
+func TestMeteredConnPool(t *T) {
+	addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+		if args[0] == "BLPOP" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	reg := NewMetricsRegistry()
+	pool, err := NewPool("tcp", addr, 1,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			conn, err := Dial(network, addr, DialReadTimeout(50*time.Millisecond))
+			if err != nil {
+				return nil, err
+			}
+			return MeteredConn(conn, reg), nil
+		}),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolPipelineWindow(0, 0),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// the read timeout is still extended by the block duration
+	require.NoError(t, pool.Do(Cmd(nil, "BLPOP", "foo", "0.2")))
+	require.NoError(t, pool.Do(WithConn("", func(conn Conn) error {
+		return conn.Do(Cmd(nil, "SET", "foo", "bar"))
+	})))
+
+	snap := reg.Snapshot()
+	assert.Equal(t, int64(1), snap["BLPOP"].Count)
+	assert.Equal(t, int64(0), snap["BLPOP"].Errors)
+	assert.Equal(t, int64(len("+OK\r\n")), snap["BLPOP"].ReplyBytes)
+	assert.Equal(t, int64(1), snap["SET"].Count)
+	assert.Equal(t, int64(len("+OK\r\n")), snap[MetricsOtherCommand].ReplyBytes)
+}
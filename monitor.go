@@ -0,0 +1,160 @@
+package radix
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// MonitorEntry describes a single command processed by redis, as sent to
+// connections in monitor mode (see Monitor).
+type MonitorEntry struct {
+	// Time is the time at which the command was processed.
+	Time time.Time
+
+	// DB is the database the command was processed in.
+	DB int
+
+	// Addr is the address of the client which sent the command. For commands
+	// called by scripts this is "lua".
+	Addr string
+
+	// Args contains the command and its arguments.
+	Args []string
+}
+
+func parseMonitorEntry(line string) (MonitorEntry, error) {
+	var me MonitorEntry
+	errInvalid := errors.Errorf("invalid MONITOR line %q", line)
+
+	i := strings.Index(line, " [")
+	j := strings.Index(line, "] ")
+	if i < 0 || j < i {
+		return me, errInvalid
+	}
+
+	secs, err := strconv.ParseFloat(line[:i], 64)
+	if err != nil {
+		return me, errInvalid
+	}
+	me.Time = time.Unix(0, int64(secs*1e6)*int64(time.Microsecond))
+
+	dbAddr := strings.SplitN(line[i+2:j], " ", 2)
+	if len(dbAddr) != 2 {
+		return me, errInvalid
+	} else if me.DB, err = strconv.Atoi(dbAddr[0]); err != nil {
+		return me, errInvalid
+	}
+	me.Addr = dbAddr[1]
+
+	// each argument is quoted and escaped in a way strconv.Unquote understands
+	for rest := line[j+2:]; rest != ""; {
+		if rest[0] == ' ' {
+			rest = rest[1:]
+			continue
+		} else if rest[0] != '"' {
+			return me, errInvalid
+		}
+
+		end := 1
+		for ; end < len(rest) && rest[end] != '"'; end++ {
+			if rest[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(rest) {
+			return me, errInvalid
+		}
+
+		arg, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return me, errInvalid
+		}
+		me.Args = append(me.Args, arg)
+		rest = rest[end+1:]
+	}
+	return me, nil
+}
+
+var resetCmd = []byte("*1\r\n$5\r\nRESET\r\n")
+
+// Monitor puts the given Conn into monitor mode using MONITOR and calls fn for
+// each command processed by redis, until stopCh is closed or an error occurs.
+//
+// Once stopCh is closed Monitor leaves monitor mode using RESET, which is only
+// available in redis 6.2 and above. As RESET also resets all other state of the
+// connection, e.g. the selected database, the commands which the Conn was set
+// up with by Dial (e.g. because of DialAuthPass or DialSelectDB) are then
+// performed again, after which the Conn can be used normally again. If RESET
+// isn't supported, if the Conn wasn't created by Dial, or if an error occurred,
+// the Conn is closed instead.
+//
+// Monitor returns nil if it was stopped using stopCh, even if the Conn had to
+// be closed. As a closed Conn is never put back into a Pool, Monitor can be
+// safely used with one using WithConn:
+//
+//	err := pool.Do(radix.WithConn("", func(conn radix.Conn) error {
+//		return radix.Monitor(conn, stopCh, func(me radix.MonitorEntry) {
+//			log.Printf("%s: %q", me.Addr, me.Args)
+//		})
+//	}))
+//
+func Monitor(conn Conn, stopCh <-chan struct{}, fn func(MonitorEntry)) error {
+	if err := conn.Do(Cmd(nil, "MONITOR")); err != nil {
+		return err
+	}
+
+	// RESET is written from a separate go-routine, as the main one is blocked
+	// reading the monitored commands. It's written to the underlying net.Conn
+	// directly, which is safe to do concurrently, unlike using the Conn itself.
+	doneCh, resettingCh := make(chan struct{}), make(chan struct{})
+	exitedCh := make(chan struct{})
+	go func() {
+		defer close(exitedCh)
+		select {
+		case <-stopCh:
+			close(resettingCh)
+			if _, err := conn.NetConn().Write(resetCmd); err != nil {
+				conn.NetConn().Close()
+			}
+		case <-doneCh:
+		}
+	}()
+	defer func() {
+		close(doneCh)
+		<-exitedCh
+	}()
+
+	for {
+		var line string
+		if err := conn.Decode(resp2.Any{I: &line}); err != nil {
+			conn.Close()
+			select {
+			case <-resettingCh:
+				// RESET likely isn't supported, the connection was still in
+				// monitor mode
+				return nil
+			default:
+				return err
+			}
+		} else if line == "RESET" {
+			// monitored commands always start with a timestamp, so this can
+			// only be the reply to RESET
+			if ok, err := reinitConn(conn); !ok || err != nil {
+				conn.Close()
+			}
+			return nil
+		}
+
+		me, err := parseMonitorEntry(line)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		fn(me)
+	}
+}
@@ -0,0 +1,166 @@
+package radix
+
+import (
+	"net"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestParseMonitorEntry(t *T) {
+	me, err := parseMonitorEntry(`1339518083.107412 [0 127.0.0.1:60866] "set" "foo" "bar \"baz\"\\\x00"`)
+	require.NoError(t, err)
+	assert.Equal(t, MonitorEntry{
+		Time: time.Unix(1339518083, 107412000),
+		DB:   0,
+		Addr: "127.0.0.1:60866",
+		Args: []string{"set", "foo", "bar \"baz\"\\\x00"},
+	}, me)
+
+	me, err = parseMonitorEntry(`1339518083.000001 [3 lua] "get" ""`)
+	require.NoError(t, err)
+	assert.Equal(t, 3, me.DB)
+	assert.Equal(t, "lua", me.Addr)
+	assert.Equal(t, []string{"get", ""}, me.Args)
+
+	for _, line := range []string{
+		"",
+		"OK",
+		`1339518083.107412 [0 127.0.0.1:60866] "set" "foo`,
+		`1339518083.107412 [0 127.0.0.1:60866] set`,
+		`foo [0 127.0.0.1:60866] "set"`,
+	} {
+		_, err := parseMonitorEntry(line)
+		assert.Error(t, err, "line: %q", line)
+	}
+}
+
+func TestMonitor(t *T) {
+	// the server replies to MONITOR with two monitored commands, and to RESET
+	// depending on supportsReset. All commands are sent to the returned
+	// channel.
+	newServer := func(t *T, supportsReset bool) (string, <-chan []string) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		cmdCh := make(chan []string, 16)
+		go func() {
+			defer l.Close()
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer nc.Close()
+
+			c := NewConn(nc)
+			for {
+				var args []string
+				if err := c.Decode(resp2.Any{I: &args}); err != nil {
+					return
+				}
+				cmdCh <- args
+
+				var reply string
+				switch args[0] {
+				case "MONITOR":
+					reply = "+OK\r\n" +
+						"+1339518083.107412 [0 127.0.0.1:60866] \"SET\" \"foo\" \"bar\"\r\n" +
+						"+1339518084.000000 [1 127.0.0.1:60867] \"GET\" \"foo\"\r\n"
+				case "RESET":
+					if !supportsReset {
+						reply = "-ERR unknown command 'RESET'\r\n"
+					} else {
+						reply = "+RESET\r\n"
+					}
+				case "PING":
+					reply = "+PONG\r\n"
+				default:
+					reply = "+OK\r\n"
+				}
+				if _, err := nc.Write([]byte(reply)); err != nil {
+					return
+				}
+			}
+		}()
+		return l.Addr().String(), cmdCh
+	}
+
+	monitor := func(t *T, conn Conn) {
+		stopCh := make(chan struct{})
+		var entries []MonitorEntry
+		err := Monitor(conn, stopCh, func(me MonitorEntry) {
+			entries = append(entries, me)
+			if len(entries) == 2 {
+				close(stopCh)
+			}
+		})
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, []string{"SET", "foo", "bar"}, entries[0].Args)
+		assert.Equal(t, 1, entries[1].DB)
+		assert.Equal(t, []string{"GET", "foo"}, entries[1].Args)
+	}
+
+	// nextCmds returns the commands received by the server until, and
+	// including, the given one.
+	nextCmds := func(t *T, cmdCh <-chan []string, until string) [][]string {
+		var cmds [][]string
+		for {
+			select {
+			case cmd := <-cmdCh:
+				cmds = append(cmds, cmd)
+				if cmd[0] == until {
+					return cmds
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s not received, got %v", until, cmds)
+			}
+		}
+	}
+
+	t.Run("reset", func(t *T) {
+		addr, cmdCh := newServer(t, true)
+		conn, err := Dial("tcp", addr, DialSelectDB(2))
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Equal(t, [][]string{{"SELECT", "2"}}, nextCmds(t, cmdCh, "SELECT"))
+
+		monitor(t, conn)
+
+		// the state RESET reset is restored
+		var pong string
+		require.NoError(t, conn.Do(Cmd(&pong, "PING")))
+		assert.Equal(t, "PONG", pong)
+		assert.Equal(t, [][]string{
+			{"MONITOR"},
+			{"RESET"},
+			{"SELECT", "2"},
+			{"PING"},
+		}, nextCmds(t, cmdCh, "PING"))
+	})
+
+	t.Run("no reset", func(t *T) {
+		addr, _ := newServer(t, false)
+		conn, err := Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		monitor(t, conn)
+		assert.Error(t, conn.Do(Cmd(nil, "PING")))
+	})
+
+	t.Run("unknown state", func(t *T) {
+		// the state of a Conn created using NewConn can't be restored
+		addr, _ := newServer(t, true)
+		nc, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn := NewConn(nc)
+		defer conn.Close()
+
+		monitor(t, conn)
+		assert.Error(t, conn.Do(Cmd(nil, "PING")))
+	})
+}
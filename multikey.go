@@ -0,0 +1,273 @@
+package radix
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// doEach performs the CmdAction returned by fn for each of the given keys,
+// writing them all using a single Pipeline.
+//
+// If c is a *Cluster the CmdActions are performed using Cluster.DoPipeline,
+// which groups them by the node serving their key and handles MOVED and ASK
+// errors for each CmdAction individually. The first error encountered is
+// returned.
+func doEach(c Client, keys []string, fn func(i int, key string) CmdAction) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	cmds := make([]CmdAction, len(keys))
+	for i, key := range keys {
+		cmds[i] = fn(i, key)
+	}
+
+	cl, ok := c.(*Cluster)
+	if !ok {
+		return c.Do(Pipeline(cmds...))
+	}
+
+	for _, err := range cl.DoPipeline(cmds...) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExistsEach checks for each of the given keys whether it exists, returning
+// the results in the same order as the keys.
+//
+// Unlike calling EXISTS with multiple keys, which only returns the number of
+// keys which exist, ExistsEach pipelines a separate EXISTS command for each key.
+// If c is a *Cluster the commands are grouped by the node serving each key.
+func ExistsEach(c Client, keys ...string) ([]bool, error) {
+	res := make([]bool, len(keys))
+	err := doEach(c, keys, func(i int, key string) CmdAction {
+		return Cmd(&res[i], "EXISTS", key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ExpireEach sets the TTL of each of the given keys, returning the number of
+// keys which existed and so had their TTL refreshed.
+//
+// ExpireEach pipelines a separate PEXPIRE command for each key. If c is a
+// *Cluster the commands are grouped by the node serving each key.
+func ExpireEach(c Client, keys []string, ttl time.Duration) (int, error) {
+	ms := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	res := make([]bool, len(keys))
+	err := doEach(c, keys, func(i int, key string) CmdAction {
+		return Cmd(&res[i], "PEXPIRE", key, ms)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var refreshed int
+	for _, ok := range res {
+		if ok {
+			refreshed++
+		}
+	}
+	return refreshed, nil
+}
+
+// PipeEach performs the CmdAction returned by build for each of the given keys
+// using a single Pipeline, with each CmdAction decoding its reply into the
+// element of out with the same index as the key. out must be a pointer to a
+// slice, which is resized to the number of keys. The rcv passed to build is a
+// pointer to the element, and should be used as the receiver of the CmdAction:
+//
+//	var vals []string
+//	errs := radix.PipeEach(client, keys, &vals, func(rcv interface{}, key string) radix.CmdAction {
+//		return radix.Cmd(rcv, "GET", key)
+//	})
+//
+// An error for one CmdAction doesn't affect any of the other CmdActions. If all
+// CmdActions succeeded nil is returned, otherwise the returned slice contains
+// the error of each CmdAction (or nil), in the same order as the keys.
+//
+// If c is a *Cluster the CmdActions are performed using Cluster.DoPipeline,
+// which groups them by the node serving their key.
+func PipeEach(c Client, keys []string, out interface{}, build func(rcv interface{}, key string) CmdAction) []error {
+	outV := reflect.ValueOf(out)
+	if outV.Kind() != reflect.Ptr || outV.Elem().Kind() != reflect.Slice {
+		panic(fmt.Sprintf("PipeEach expected pointer to slice, got %T", out))
+	}
+	outV = outV.Elem()
+	outV.Set(reflect.MakeSlice(outV.Type(), len(keys), len(keys)))
+
+	cmds := make([]CmdAction, len(keys))
+	for i, key := range keys {
+		cmds[i] = build(outV.Index(i).Addr().Interface(), key)
+	}
+
+	if len(cmds) == 0 {
+		return nil
+	} else if cl, ok := c.(*Cluster); ok {
+		return cl.DoPipeline(cmds...)
+	}
+
+	cp := &clusterPipeline{cmds: cmds, errs: make([]error, len(cmds))}
+	err := c.Do(cp)
+
+	var failed bool
+	for i := range cp.errs {
+		if cp.errs[i] == nil {
+			cp.errs[i] = err
+		}
+		failed = failed || cp.errs[i] != nil
+	}
+	if !failed {
+		return nil
+	}
+	return cp.errs
+}
+
+// DefaultChunkSize is the chunk size used by MSetChunked, HSetChunked and
+// SAddChunked if the given chunk size is not positive.
+const DefaultChunkSize = 1000
+
+// doChunks performs the given CmdActions, each containing a single chunk, in a
+// single Pipeline, or using Cluster.DoPipeline if c is a *Cluster. The first
+// error encountered is returned.
+func doChunks(c Client, cmds []CmdAction) error {
+	if len(cmds) == 0 {
+		return nil
+	} else if cl, ok := c.(*Cluster); ok {
+		for _, err := range cl.DoPipeline(cmds...) {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c.Do(Pipeline(cmds...))
+}
+
+// chunkArgs splits args, consisting of groups of n elements each, into chunks
+// of at most chunkSize groups, each prefixed by the given prefix.
+func chunkArgs(prefix []string, args []string, n, chunkSize int) [][]string {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	var chunks [][]string
+	for len(args) > 0 {
+		l := chunkSize * n
+		if l > len(args) {
+			l = len(args)
+		}
+		chunk := make([]string, 0, len(prefix)+l)
+		chunk = append(chunk, prefix...)
+		chunks = append(chunks, append(chunk, args[:l]...))
+		args = args[l:]
+	}
+	return chunks
+}
+
+// sortedPairs returns the keys and values of m as a flat slice of key/value
+// pairs, sorted by key.
+func sortedPairs(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(m)*2)
+	for _, k := range keys {
+		pairs = append(pairs, k, m[k])
+	}
+	return pairs
+}
+
+// MSetChunked sets all of the given keys to their values, like MSET, but
+// splits the keys into multiple MSET commands of at most chunkSize keys each,
+// which are then performed in a single Pipeline. This avoids a single huge
+// command blocking redis for a long time. If chunkSize is not positive
+// DefaultChunkSize is used.
+//
+// Unlike a single MSET the keys are not set atomically, and if an error is
+// returned only some of the keys may have been set.
+//
+// If c is a *Cluster the keys are first grouped by their slot, so that each
+// MSET only contains keys of a single slot, and the commands are performed
+// using Cluster.DoPipeline.
+func MSetChunked(c Client, kvs map[string]string, chunkSize int) error {
+	pairs := sortedPairs(kvs)
+
+	groups := [][]string{pairs}
+	if _, ok := c.(*Cluster); ok {
+		bySlot := map[uint16][]string{}
+		var slots []uint16
+		for i := 0; i < len(pairs); i += 2 {
+			slot := ClusterSlot([]byte(pairs[i]))
+			if _, ok := bySlot[slot]; !ok {
+				slots = append(slots, slot)
+			}
+			bySlot[slot] = append(bySlot[slot], pairs[i], pairs[i+1])
+		}
+		groups = groups[:0]
+		for _, slot := range slots {
+			groups = append(groups, bySlot[slot])
+		}
+	}
+
+	var cmds []CmdAction
+	for _, group := range groups {
+		for _, chunk := range chunkArgs(nil, group, 2, chunkSize) {
+			cmds = append(cmds, Cmd(nil, "MSET", chunk...))
+		}
+	}
+	return doChunks(c, cmds)
+}
+
+// sumChunks performs a command for each chunk of args on the given key and
+// returns the sum of their integer replies. The sum only includes the replies
+// of commands which succeeded.
+func sumChunks(c Client, cmd, key string, args []string, n, chunkSize int) (int64, error) {
+	chunks := chunkArgs([]string{key}, args, n, chunkSize)
+	res := make([]int64, len(chunks))
+	cmds := make([]CmdAction, len(chunks))
+	for i, chunk := range chunks {
+		cmds[i] = Cmd(&res[i], cmd, chunk...)
+	}
+
+	err := doChunks(c, cmds)
+	var sum int64
+	for _, n := range res {
+		sum += n
+	}
+	return sum, err
+}
+
+// HSetChunked sets the given fields of the hash stored at key, like HSET, but
+// splits the fields into multiple HSET commands of at most chunkSize fields
+// each, which are then performed in a single Pipeline. If chunkSize is not
+// positive DefaultChunkSize is used.
+//
+// The number of fields which were newly added to the hash across all commands
+// is returned. If an error is returned only some of the fields may have been
+// set, and the returned number only includes those.
+func HSetChunked(c Client, key string, fields map[string]string, chunkSize int) (int64, error) {
+	return sumChunks(c, "HSET", key, sortedPairs(fields), 2, chunkSize)
+}
+
+// SAddChunked adds the given members to the set stored at key, like SADD, but
+// splits the members into multiple SADD commands of at most chunkSize members
+// each, which are then performed in a single Pipeline. If chunkSize is not
+// positive DefaultChunkSize is used.
+//
+// The number of members which were newly added to the set across all commands
+// is returned. If an error is returned only some of the members may have been
+// added, and the returned number only includes those.
+func SAddChunked(c Client, key string, members []string, chunkSize int) (int64, error) {
+	return sumChunks(c, "SADD", key, members, 1, chunkSize)
+}
@@ -0,0 +1,206 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestExistsEach(t *T) {
+	t.Run("conn", func(t *T) {
+		m := map[string]string{"foo": "1", "baz": "2"}
+		stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+			if _, ok := m[args[1]]; ok {
+				return 1
+			}
+			return 0
+		})
+
+		res, err := ExistsEach(stub, "foo", "bar", "baz")
+		require.NoError(t, err)
+		assert.Equal(t, []bool{true, false, true}, res)
+
+		res, err = ExistsEach(stub)
+		require.NoError(t, err)
+		assert.Empty(t, res)
+	})
+
+	t.Run("cluster", func(t *T) {
+		c, scl := newTestCluster()
+		defer c.Close()
+
+		keys := []string{clusterSlotKeys[0], clusterSlotKeys[1], clusterSlotKeys[8000], clusterSlotKeys[16000]}
+		require.NoError(t, c.Do(Cmd(nil, "SET", keys[0], "a")))
+		require.NoError(t, c.Do(Cmd(nil, "SET", keys[3], "b")))
+
+		res, err := ExistsEach(c, keys...)
+		require.NoError(t, err)
+		assert.Equal(t, []bool{true, false, false, true}, res)
+
+		// redirects are followed for each key individually
+		scl.migrateInit(scl.stubForSlot(16000).addr, 0)
+		scl.migrateKey(keys[0])
+		res, err = ExistsEach(c, keys...)
+		require.NoError(t, err)
+		assert.Equal(t, []bool{true, false, false, true}, res)
+	})
+}
+
+func TestExpireEach(t *T) {
+	m := map[string]string{"foo": "1", "baz": "2"}
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		if _, ok := m[args[1]]; ok {
+			return 1
+		}
+		return 0
+	})
+
+	n, err := ExpireEach(stub, []string{"foo", "bar", "baz"}, 90*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, [][]string{
+		{"PEXPIRE", "foo", "90000"},
+		{"PEXPIRE", "bar", "90000"},
+		{"PEXPIRE", "baz", "90000"},
+	}, cmds)
+
+	n, err = ExpireEach(stub, nil, time.Second)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestPipeEach(t *T) {
+	getEach := func(c Client, keys ...string) ([]int, []error) {
+		var res []int
+		errs := PipeEach(c, keys, &res, func(rcv interface{}, key string) CmdAction {
+			return Cmd(rcv, "GET", key)
+		})
+		return res, errs
+	}
+
+	t.Run("conn", func(t *T) {
+		m := map[string]string{"a": "1", "b": "2", "c": "three"}
+		stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+			if strings.HasPrefix(args[1], "err") {
+				return resp2.Error{E: errors.New("ERR " + args[1])}
+			}
+			return m[args[1]]
+		})
+
+		res, errs := getEach(stub, "a", "b")
+		assert.Nil(t, errs)
+		assert.Equal(t, []int{1, 2}, res)
+
+		res, errs = getEach(stub, "a", "err1", "c", "b")
+		require.Len(t, errs, 4)
+		assert.NoError(t, errs[0])
+		assert.True(t, errors.As(errs[1], new(resp2.Error)))
+		assert.Error(t, errs[2])
+		assert.NoError(t, errs[3])
+		assert.Equal(t, []int{1, 0, 0, 2}, res)
+
+		res, errs = getEach(stub)
+		assert.Nil(t, errs)
+		assert.Empty(t, res)
+	})
+
+	t.Run("cluster", func(t *T) {
+		c, _ := newTestCluster()
+		defer c.Close()
+
+		keys := []string{clusterSlotKeys[0], clusterSlotKeys[8000], clusterSlotKeys[16000]}
+		require.NoError(t, c.Do(Cmd(nil, "SET", keys[0], "1")))
+		require.NoError(t, c.Do(Cmd(nil, "SET", keys[2], "3")))
+
+		res, errs := getEach(c, keys...)
+		assert.Nil(t, errs)
+		assert.Equal(t, []int{1, 0, 3}, res)
+	})
+}
+
+func TestMSetChunked(t *T) {
+	t.Run("conn", func(t *T) {
+		var cmds [][]string
+		stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+			cmds = append(cmds, args)
+			return resp2.SimpleString{S: "OK"}
+		})
+
+		kvs := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5"}
+		require.NoError(t, MSetChunked(stub, kvs, 2))
+		assert.Equal(t, [][]string{
+			{"MSET", "a", "1", "b", "2"},
+			{"MSET", "c", "3", "d", "4"},
+			{"MSET", "e", "5"},
+		}, cmds)
+
+		cmds = nil
+		require.NoError(t, MSetChunked(stub, nil, 2))
+		assert.Empty(t, cmds)
+	})
+
+	t.Run("cluster", func(t *T) {
+		c, _ := newTestCluster()
+		defer c.Close()
+
+		// the keys are spread across both nodes, and two of them share a slot
+		kvs := map[string]string{
+			clusterSlotKeys[0]:              "a",
+			"{" + clusterSlotKeys[0] + "}x": "b",
+			clusterSlotKeys[1]:              "c",
+			clusterSlotKeys[8000]:           "d",
+			clusterSlotKeys[16000]:          "e",
+		}
+		require.NoError(t, MSetChunked(c, kvs, 2))
+
+		for k, v := range kvs {
+			var got string
+			require.NoError(t, c.Do(Cmd(&got, "GET", k)))
+			assert.Equal(t, v, got, "key %q", k)
+		}
+	})
+}
+
+func TestHSetSAddChunked(t *T) {
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch {
+		case args[1] == "bad":
+			return resp2.Error{E: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")}
+		case args[0] == "HSET":
+			return (len(args) - 2) / 2
+		default:
+			return len(args) - 3 // the first member already exists
+		}
+	})
+
+	n, err := HSetChunked(stub, "h", map[string]string{"a": "1", "b": "2", "c": "3"}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, [][]string{
+		{"HSET", "h", "a", "1", "b", "2"},
+		{"HSET", "h", "c", "3"},
+	}, cmds)
+
+	cmds = nil
+	n, err = SAddChunked(stub, "s", []string{"a", "b", "c", "d", "e"}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1+1+0), n)
+	assert.Equal(t, [][]string{
+		{"SADD", "s", "a", "b"},
+		{"SADD", "s", "c", "d"},
+		{"SADD", "s", "e"},
+	}, cmds)
+
+	_, err = SAddChunked(stub, "bad", []string{"a"}, 0)
+	assert.Error(t, err)
+}
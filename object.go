@@ -0,0 +1,133 @@
+package radix
+
+import (
+	"strconv"
+)
+
+// ObjectRefCount returns the number of references of the value stored at the
+// given key, as returned by OBJECT REFCOUNT.
+func ObjectRefCount(c Client, key string) (int64, error) {
+	var n int64
+	if err := c.Do(Cmd(&n, "OBJECT", "REFCOUNT", key)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// DebugQuicklistPackedThreshold sets the threshold above which elements of a
+// quicklist (the encoding used for lists) are stored as plain nodes rather than
+// packed ones, using DEBUG QUICKLIST-PACKED-THRESHOLD. The size may have a unit
+// suffix, e.g. "1k" or "2mb".
+//
+// This is mostly useful for tests which need to force a list to change its
+// encoding without having to write large amounts of data.
+func DebugQuicklistPackedThreshold(c Client, size string) error {
+	return c.Do(Cmd(nil, "DEBUG", "QUICKLIST-PACKED-THRESHOLD", size))
+}
+
+// ConfigSetListMaxListpackSize sets the list-max-listpack-size config option,
+// which determines the maximum size of each listpack node within a list. A
+// positive value limits the number of elements per node, while a value between
+// -1 and -5 limits the size of each node to between 4 and 64 kilobytes.
+func ConfigSetListMaxListpackSize(c Client, size int) error {
+	return c.Do(Cmd(nil, "CONFIG", "SET", "list-max-listpack-size", strconv.Itoa(size)))
+}
+
+// KeyEncoding is a key together with the internal encoding of its value, as
+// returned by an EncodingScanner.
+type KeyEncoding struct {
+	Key string
+
+	// Encoding is the encoding of the key's value as returned by OBJECT
+	// ENCODING, e.g. "listpack" or "hashtable".
+	Encoding string
+}
+
+// EncodingScanner is used to iterate through keys together with the encoding
+// of their values.
+//
+// Once created, repeatedly call Next() on it to fill the passed in KeyEncoding
+// pointer with the next key and its encoding. Next will return false if there's
+// no more keys to retrieve or if an error occurred, at which point Close should
+// be called to retrieve any error.
+type EncodingScanner interface {
+	Next(*KeyEncoding) bool
+	Close() error
+}
+
+type encodingScanner struct {
+	c         Client
+	s         Scanner
+	batchSize int
+
+	batch []KeyEncoding
+	done  bool
+	err   error
+}
+
+// NewEncodingScanner creates a new EncodingScanner, which iterates over all
+// keys returned by the given Scanner and reads the encoding of each key using
+// OBJECT ENCODING. The Scanner must be scanning over keys, i.e. be created
+// using a ScanOpts with the "SCAN" Command, and can be created using either
+// NewScanner or Cluster.NewScanner.
+//
+// Keys are read from the Scanner in batches of batchSize keys, and the OBJECT
+// ENCODING commands for each batch are pipelined (see ExistsEach for how this
+// works with a Cluster). If batchSize is less than 1 a batch size of 100 is
+// used. Keys which are deleted after being returned by the Scanner are skipped.
+//
+// This is useful for finding keys which still use an old encoding, e.g. after
+// changing the config options which determine the encoding of new values.
+func NewEncodingScanner(c Client, s Scanner, batchSize int) EncodingScanner {
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	return &encodingScanner{c: c, s: s, batchSize: batchSize}
+}
+
+func (es *encodingScanner) Next(ke *KeyEncoding) bool {
+	for len(es.batch) == 0 {
+		if es.done || es.err != nil {
+			return false
+		}
+		es.fill()
+	}
+	*ke, es.batch = es.batch[0], es.batch[1:]
+	return true
+}
+
+func (es *encodingScanner) fill() {
+	keys := make([]string, 0, es.batchSize)
+	var key string
+	for len(keys) < es.batchSize {
+		if !es.s.Next(&key) {
+			es.done = true
+			break
+		}
+		keys = append(keys, key)
+	}
+
+	encs := make([]string, len(keys))
+	mns := make([]MaybeNil, len(keys))
+	es.err = doEach(es.c, keys, func(i int, key string) CmdAction {
+		mns[i] = MaybeNil{Rcv: &encs[i]}
+		return Cmd(&mns[i], "OBJECT", "ENCODING", key)
+	})
+	if es.err != nil {
+		return
+	}
+
+	es.batch = es.batch[:0]
+	for i, key := range keys {
+		if !mns[i].Nil {
+			es.batch = append(es.batch, KeyEncoding{Key: key, Encoding: encs[i]})
+		}
+	}
+}
+
+func (es *encodingScanner) Close() error {
+	if err := es.s.Close(); err != nil {
+		return err
+	}
+	return es.err
+}
@@ -0,0 +1,73 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+)
+
+func TestObjectHelpers(t *T) {
+	var got [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = append(got, args)
+		if strings.ToUpper(args[0]) == "OBJECT" {
+			return 2
+		}
+		return "OK"
+	})
+
+	n, err := ObjectRefCount(stub, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	require.NoError(t, DebugQuicklistPackedThreshold(stub, "1k"))
+	require.NoError(t, ConfigSetListMaxListpackSize(stub, -2))
+
+	assert.Equal(t, [][]string{
+		{"OBJECT", "REFCOUNT", "foo"},
+		{"DEBUG", "QUICKLIST-PACKED-THRESHOLD", "1k"},
+		{"CONFIG", "SET", "list-max-listpack-size", "-2"},
+	}, got)
+}
+
+func TestEncodingScanner(t *T) {
+	encodings := map[string]string{"a": "listpack", "b": "hashtable", "d": "embstr"}
+	keys := []string{"a", "b", "gone", "d"}
+
+	var batches [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "SCAN":
+			return []interface{}{"0", keys}
+		case "OBJECT":
+			if n := len(batches); n == 0 || len(batches[n-1]) == 2 {
+				batches = append(batches, nil)
+			}
+			batches[len(batches)-1] = append(batches[len(batches)-1], args[2])
+			if enc, ok := encodings[args[2]]; ok {
+				return enc
+			}
+			return nil
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	es := NewEncodingScanner(stub, NewScanner(stub, ScanAllKeys), 2)
+	var got []KeyEncoding
+	var ke KeyEncoding
+	for es.Next(&ke) {
+		got = append(got, ke)
+	}
+	require.NoError(t, es.Close())
+
+	assert.Equal(t, []KeyEncoding{
+		{Key: "a", Encoding: "listpack"},
+		{Key: "b", Encoding: "hashtable"},
+		{Key: "d", Encoding: "embstr"},
+	}, got)
+	assert.Equal(t, [][]string{{"a", "b"}, {"gone", "d"}}, batches)
+}
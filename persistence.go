@@ -0,0 +1,93 @@
+package radix
+
+import (
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// The helpers in this file are mostly useful for tests and administrative
+// tooling, e.g. to verify that data survives being persisted and loaded again.
+// All of them are expensive and should not be used on production instances
+// unless you know what you are doing.
+
+// DebugReload performs DEBUG RELOAD, which saves the dataset to disk, flushes
+// it and then loads it again from disk. It blocks the instance while doing so.
+//
+// Since redis 7 the DEBUG command must be enabled using the
+// enable-debug-command config option.
+func DebugReload(c Client) error {
+	return c.Do(Cmd(nil, "DEBUG", "RELOAD"))
+}
+
+// BGSave performs BGSAVE, which saves the dataset to disk in the background.
+// Use WaitForBGSave to wait for the save to complete.
+func BGSave(c Client) error {
+	return c.Do(Cmd(nil, "BGSAVE"))
+}
+
+// BGRewriteAOF performs BGREWRITEAOF, which rewrites the append only file in
+// the background.
+func BGRewriteAOF(c Client) error {
+	return c.Do(Cmd(nil, "BGREWRITEAOF"))
+}
+
+// LastSave returns the time of the last successful save to disk, as returned by
+// LASTSAVE.
+func LastSave(c Client) (time.Time, error) {
+	var unix int64
+	if err := c.Do(Cmd(&unix, "LASTSAVE")); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// ErrBGSaveTimeout is returned by WaitForBGSave if the background save didn't
+// complete within the given timeout.
+var ErrBGSaveTimeout = errors.New("timed out waiting for background save")
+
+// bgSaveInterval is the interval at which WaitForBGSave checks if the
+// background save completed.
+const bgSaveInterval = 10 * time.Millisecond
+
+// WaitForBGSave waits until no background save is in progress, by checking the
+// rdb_bgsave_in_progress field returned by INFO persistence every 10
+// milliseconds. If a save is still in progress after the given timeout,
+// ErrBGSaveTimeout is returned.
+//
+// Note that WaitForBGSave can't distinguish between a save which hasn't started
+// yet and one which already completed, so it should only be called after
+// BGSave returned.
+func WaitForBGSave(c Client, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var info string
+		if err := c.Do(Cmd(&info, "INFO", "persistence")); err != nil {
+			return err
+		}
+
+		inProgress, ok := infoField(info, "rdb_bgsave_in_progress")
+		if !ok {
+			return errors.New("INFO persistence is missing rdb_bgsave_in_progress")
+		} else if inProgress == "0" {
+			return nil
+		} else if !time.Now().Add(bgSaveInterval).Before(deadline) {
+			return ErrBGSaveTimeout
+		}
+		time.Sleep(bgSaveInterval)
+	}
+}
+
+// infoField returns the value of the given field from the reply of INFO.
+func infoField(info, field string) (string, bool) {
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, "#") {
+			continue
+		} else if i := strings.IndexByte(line, ':'); i >= 0 && line[:i] == field {
+			return line[i+1:], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,55 @@
+package radix
+
+import (
+	"fmt"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+)
+
+func TestPersistence(t *T) {
+	var cmds [][]string
+	var bgsaves int
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch args[0] {
+		case "DEBUG", "BGREWRITEAOF":
+			return "OK"
+		case "BGSAVE":
+			bgsaves = 3
+			return "Background saving started"
+		case "LASTSAVE":
+			return int64(1600000000)
+		case "INFO":
+			inProgress := 0
+			if bgsaves > 0 {
+				bgsaves--
+				inProgress = 1
+			}
+			return fmt.Sprintf("# Persistence\r\nloading:0\r\nrdb_bgsave_in_progress:%d\r\nrdb_last_bgsave_status:ok\r\n", inProgress)
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	require.NoError(t, DebugReload(stub))
+	require.NoError(t, BGRewriteAOF(stub))
+
+	lastSave, err := LastSave(stub)
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1600000000, 0), lastSave)
+
+	require.NoError(t, BGSave(stub))
+	require.NoError(t, WaitForBGSave(stub, time.Second))
+	assert.Zero(t, bgsaves)
+
+	require.NoError(t, BGSave(stub))
+	assert.Equal(t, ErrBGSaveTimeout, WaitForBGSave(stub, 0))
+
+	assert.Equal(t, []string{"DEBUG", "RELOAD"}, cmds[0])
+	assert.Equal(t, []string{"BGREWRITEAOF"}, cmds[1])
+	assert.Equal(t, []string{"INFO", "persistence"}, cmds[4])
+}
@@ -17,6 +17,7 @@ var blockingCmds = map[string]bool{
 	"BLPOP":      true,
 	"BRPOP":      true,
 	"BRPOPLPUSH": true,
+	"BLMOVE":     true,
 
 	"BZPOPMIN": true,
 	"BZPOPMAX": true,
@@ -2,7 +2,9 @@ package radix
 
 import (
 	"io"
+	"math/rand"
 	"net"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,6 +18,10 @@ import (
 // ErrPoolEmpty is used by Pools created using the PoolOnEmptyErrAfter option
 var ErrPoolEmpty = errors.New("connection pool is empty")
 
+// ErrTooManyInFlight is used by Pools created using the PoolMaxInFlight or
+// PoolMaxInFlightErrAfter options
+var ErrTooManyInFlight = errors.New("too many actions in flight")
+
 var errPoolFull = errors.New("connection pool is full")
 
 // ioErrConn is a Conn which tracks the last net.Error which was seen either
@@ -28,6 +34,22 @@ type ioErrConn struct {
 	// level error, e.g. a timeout, disconnect, etc... Close is automatically
 	// called on the client when it encounters a critical network error
 	lastIOErr error
+
+	// The time after which the Pool closes the connection instead of putting
+	// it back, or zero if the connection doesn't expire. See PoolMaxLifetime.
+	expiresAt time.Time
+
+	// The time the connection was last added to the idleConns, protected by
+	// the idleConns' lock. See PoolIdleTimeout.
+	idleSince time.Time
+
+	// noEvict is true if CLIENT NO-EVICT was enabled on the connection, see
+	// PoolMaxNoEvict.
+	noEvict bool
+
+	// state is used by Pool.Inspect, and is protected by stateL.
+	stateL sync.Mutex
+	state  PoolConnState
 }
 
 func newIOErrConn(c Conn) *ioErrConn {
@@ -59,6 +81,11 @@ func (ioc *ioErrConn) Decode(m resp.Unmarshaler) error {
 }
 
 func (ioc *ioErrConn) Do(a Action) error {
+	// the inner Conn's Do can't be used, as the Action must use ioc for
+	// errors to be tracked, but everything else it does should still apply
+	if od, ok := ioc.Conn.(onDoer); ok {
+		return od.doOn(a, ioc)
+	}
 	return a.Run(ioc)
 }
 
@@ -69,10 +96,109 @@ func (ioc *ioErrConn) Close() error {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// idleConns holds the connections which are available in a Pool, and hands
+// them out in either FIFO or LIFO order, see PoolCheckoutLIFO.
+//
+// availCh holds a token for each connection in conns, so that callers can wait
+// for a connection to become available using select. A token is only ever
+// sent after its connection was added, so there are never more tokens than
+// connections.
+type idleConns struct {
+	lifo    bool
+	availCh chan struct{}
+
+	l     sync.Mutex
+	conns []*ioErrConn
+}
+
+func newIdleConns(size int, lifo bool) *idleConns {
+	return &idleConns{
+		lifo:    lifo,
+		availCh: make(chan struct{}, size),
+		conns:   make([]*ioErrConn, 0, size),
+	}
+}
+
+// push adds the connection, returning false if there's no room for it.
+func (ic *idleConns) push(ioc *ioErrConn) bool {
+	ic.l.Lock()
+	if len(ic.conns) == cap(ic.availCh) {
+		ic.l.Unlock()
+		return false
+	}
+	ioc.idleSince = time.Now()
+	ic.conns = append(ic.conns, ioc)
+	ic.l.Unlock()
+	ic.availCh <- struct{}{}
+	return true
+}
+
+// take removes and returns a connection, after a token for it has been
+// received from availCh. If oldest is true the least recently added
+// connection is returned, regardless of the order being used.
+func (ic *idleConns) take(oldest bool) *ioErrConn {
+	ic.l.Lock()
+	defer ic.l.Unlock()
+	if ic.lifo && !oldest {
+		ioc := ic.conns[len(ic.conns)-1]
+		ic.conns[len(ic.conns)-1] = nil
+		ic.conns = ic.conns[:len(ic.conns)-1]
+		return ioc
+	}
+	ioc := ic.conns[0]
+	copy(ic.conns, ic.conns[1:])
+	ic.conns[len(ic.conns)-1] = nil
+	ic.conns = ic.conns[:len(ic.conns)-1]
+	return ioc
+}
+
+// tryTake returns a connection if one is available, or nil.
+func (ic *idleConns) tryTake(oldest bool) *ioErrConn {
+	select {
+	case <-ic.availCh:
+		return ic.take(oldest)
+	default:
+		return nil
+	}
+}
+
+// takeIdle removes and returns the least recently added connection if it was
+// added before t, or nil.
+func (ic *idleConns) takeIdle(t time.Time) *ioErrConn {
+	select {
+	case <-ic.availCh:
+	default:
+		return nil
+	}
+
+	ic.l.Lock()
+	if !ic.conns[0].idleSince.Before(t) {
+		ic.l.Unlock()
+		// the token was taken above, so there's always room to give it back
+		ic.availCh <- struct{}{}
+		return nil
+	}
+	ioc := ic.conns[0]
+	copy(ic.conns, ic.conns[1:])
+	ic.conns[len(ic.conns)-1] = nil
+	ic.conns = ic.conns[:len(ic.conns)-1]
+	ic.l.Unlock()
+	return ioc
+}
+
+func (ic *idleConns) len() int {
+	return len(ic.availCh)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 type poolOpts struct {
 	cf                    ConnFunc
 	pingInterval          time.Duration
 	refillInterval        time.Duration
+	maxLifetime           time.Duration
+	lifetimeJitter        float64
+	idleTimeout           time.Duration
 	overflowDrainInterval time.Duration
 	overflowSize          int
 	onEmptyWait           time.Duration
@@ -80,6 +206,15 @@ type poolOpts struct {
 	pipelineConcurrency   int
 	pipelineLimit         int
 	pipelineWindow        time.Duration
+	rateLimit             int
+	rateLimitBurst        int
+	maxInFlight           int
+	inFlightWait          time.Duration
+	retryIdempotent       bool
+	retryPolicy           *RetryPolicy
+	debugCallers          bool
+	checkoutLIFO          bool
+	maxNoEvict            int
 	pt                    trace.PoolTrace
 }
 
@@ -98,11 +233,12 @@ func PoolConnFunc(cf ConnFunc) PoolOpt {
 
 // PoolPingInterval specifies the interval at which a ping event happens. On
 // each ping event the Pool calls the PING redis command over one of it's
-// available connections.
+// available connections. The round-trip times of the pings are tracked and can
+// be retrieved using the Latency method.
 //
-// Since connections are used in LIFO order, the ping interval * pool size is
-// the duration of time it takes to ping every connection once when the pool is
-// idle.
+// Since connections are used in FIFO order by default, the ping interval * pool
+// size is the duration of time it takes to ping every connection once when the
+// pool is idle. See PoolCheckoutLIFO for how this changes when using LIFO order.
 //
 // A shorter interval means connections are pinged more frequently, but also
 // means more traffic with the server.
@@ -121,6 +257,43 @@ func PoolRefillInterval(d time.Duration) PoolOpt {
 	}
 }
 
+// PoolMaxLifetime specifies the maximum amount of time a connection may be
+// used for. Once a connection has reached its lifetime it is closed the next
+// time it is returned to the Pool, and replaced by a new connection during the
+// next refill event.
+//
+// To avoid all connections created around the same time, e.g. during
+// initialization, being closed and recreated at once, the lifetime of each
+// connection is shortened by a random amount of up to jitter * lifetime, with
+// jitter being a fraction between 0 and 1. A jitter of 0.2 for example means
+// each connection is closed after between 80% and 100% of the lifetime.
+//
+// Connections which are idle are checked whenever they are pinged, see
+// PoolPingInterval.
+func PoolMaxLifetime(lifetime time.Duration, jitter float64) PoolOpt {
+	return func(po *poolOpts) {
+		po.maxLifetime = lifetime
+		po.lifetimeJitter = jitter
+	}
+}
+
+// PoolIdleTimeout specifies the maximum amount of time a connection may sit
+// in the Pool without being used. Connections which have been idle for longer
+// are closed, and replaced by new connections during the following refill
+// events. This avoids using connections which were already timed out by the
+// server (see the timeout config) or by a load balancer in between.
+//
+// The Pool checks for idle connections every d/2, but at most once per
+// millisecond, so a connection is closed after having been idle for between d
+// and 1.5*d. Pings count as use, see
+// PoolPingInterval, so the timeout only has an effect if it's shorter than the
+// time it takes to ping all connections.
+func PoolIdleTimeout(d time.Duration) PoolOpt {
+	return func(po *poolOpts) {
+		po.idleTimeout = d
+	}
+}
+
 // PoolOnEmptyWait effects the Pool's behavior when there are no available
 // connections in the Pool. The effect is to cause actions to block as long as
 // it takes until a connection becomes available.
@@ -188,6 +361,50 @@ func PoolOnFullBuffer(size int, drainInterval time.Duration) PoolOpt {
 	}
 }
 
+// PoolCheckoutFIFO tells the Pool to hand out its available connections in
+// first-in-first-out order, i.e. the connection which was least recently put
+// back into the pool is used next. This spreads the load evenly over all
+// connections and keeps all of them warm. This is the default.
+func PoolCheckoutFIFO() PoolOpt {
+	return func(po *poolOpts) {
+		po.checkoutLIFO = false
+	}
+}
+
+// PoolCheckoutLIFO tells the Pool to hand out its available connections in
+// last-in-first-out order, i.e. the connection which was most recently put back
+// into the pool is used next. Under light load this keeps a small set of
+// connections busy while the rest stay idle.
+//
+// This is useful if the pool is oversized, as the connections which aren't
+// needed stay idle and are the first to be closed when the overflow buffer is
+// drained (see PoolOnFullBuffer). It also means that pings (see
+// PoolPingInterval) will mostly hit the most recently used connections, so that
+// broken or expired idle connections are only noticed once they're used again.
+func PoolCheckoutLIFO() PoolOpt {
+	return func(po *poolOpts) {
+		po.checkoutLIFO = true
+	}
+}
+
+// PoolMaxNoEvict tells the Pool to enable CLIENT NO-EVICT on up to max of its
+// connections, so that redis doesn't evict them under memory pressure (see
+// the maxmemory-clients config). CLIENT NO-EVICT requires redis 7.0 or above.
+//
+// The limit is separate from the size of the Pool, so that a Pool doesn't
+// exempt more of its connections from eviction than intended, and once a
+// marked connection is closed the next connection created takes its place.
+// Connections created because the Pool was empty (see PoolOnEmptyCreateAfter)
+// are never marked, as they are only meant to be used temporarily
+// and would otherwise take the place of regular connections.
+//
+// If max is 0, which is the default, no connections are marked.
+func PoolMaxNoEvict(max int) PoolOpt {
+	return func(po *poolOpts) {
+		po.maxNoEvict = max
+	}
+}
+
 // PoolPipelineConcurrency sets the maximum number of pipelines that can be
 // executed concurrently.
 //
@@ -213,6 +430,95 @@ func PoolPipelineWindow(window time.Duration, limit int) PoolOpt {
 	}
 }
 
+// PoolRateLimit limits the rate at which Actions are performed using the Pool
+// to perSecond Actions per second, across all connections. Up to burst Actions
+// can be performed at once before the limit kicks in, with burst being raised
+// to 1 if smaller. Calls to Do which exceed the limit block until they are
+// allowed to proceed. Note that a Pipeline or other Action containing multiple
+// commands only counts as a single Action.
+//
+// This can be used to protect a redis instance from being overwhelmed by
+// non-critical users, e.g. background jobs. Pings done by the Pool itself are
+// not limited.
+//
+// If perSecond is 0 then Actions are not rate limited, which is the default.
+func PoolRateLimit(perSecond, burst int) PoolOpt {
+	return func(po *poolOpts) {
+		po.rateLimit = perSecond
+		po.rateLimitBurst = burst
+	}
+}
+
+// PoolMaxInFlight limits the number of Actions the Pool performs at the same
+// time, including Actions waiting for a connection to become available and
+// commands queued by the implicit pipeline, see PoolPipelineWindow. Once the
+// limit is hit Do blocks until another Action completed. This prevents an
+// unbounded number of Actions from piling up, and their replies from being
+// held in memory, while the redis instance is slow to respond.
+//
+// If the Action was created using WithDeadline or WithTimeout, Do only blocks
+// until its deadline, after which ErrTooManyInFlight is returned.
+//
+// If max is 0 then the number of Actions is not limited, which is the default.
+func PoolMaxInFlight(max int) PoolOpt {
+	return func(po *poolOpts) {
+		po.maxInFlight = max
+		po.inFlightWait = -1
+	}
+}
+
+// PoolMaxInFlightErrAfter is like PoolMaxInFlight, but Do only blocks for at
+// most the given duration, after which ErrTooManyInFlight is returned.
+//
+// If wait is 0 then ErrTooManyInFlight is returned immediately once the limit
+// is hit.
+func PoolMaxInFlightErrAfter(max int, wait time.Duration) PoolOpt {
+	return func(po *poolOpts) {
+		po.maxInFlight = max
+		po.inFlightWait = wait
+	}
+}
+
+// PoolRetryIdempotentOnce tells the Pool to retry an Action once, using a newly
+// created connection, if it failed due to a connection error, e.g. because the
+// connection was closed by the server. Only Actions created using Cmd, FlatCmd
+// or CmdBytes are retried, and only if their command is idempotent, as
+// determined by IsIdempotentCommand. Errors returned by redis itself never cause
+// a retry.
+//
+// This can be used to hide brief network problems or restarts of the redis
+// instance from the user, without risking that a command modifying data is
+// processed twice.
+func PoolRetryIdempotentOnce() PoolOpt {
+	return func(po *poolOpts) {
+		po.retryIdempotent = true
+	}
+}
+
+// PoolRetryPolicy tells the Pool to retry Actions which failed due to a
+// transient error, e.g. because the redis instance is still loading its dataset
+// after a restart, as described by the given RetryPolicy. Each attempt is
+// tracked separately by the DoCompleted trace.
+//
+// This can be combined with PoolRetryIdempotentOnce, in which case each attempt
+// may itself be retried once on a new connection.
+func PoolRetryPolicy(rp RetryPolicy) PoolOpt {
+	return func(po *poolOpts) {
+		rp = rp.withDefaults()
+		po.retryPolicy = &rp
+	}
+}
+
+// PoolDebugCallers tells the Pool to record the call stack of the caller of Do
+// each time a connection is taken out of the pool, which is then returned as
+// part of Pool.Inspect. This is useful for finding the code holding on to
+// connections, but has a noticeable overhead.
+func PoolDebugCallers() PoolOpt {
+	return func(po *poolOpts) {
+		po.debugCallers = true
+	}
+}
+
 // PoolWithTrace tells the Pool to trace itself with the given PoolTrace
 // Note that PoolTrace will block every point that you set to trace.
 func PoolWithTrace(pt trace.PoolTrace) PoolOpt {
@@ -243,7 +549,14 @@ type Pool struct {
 	// Atomic fields must be at the beginning of the struct since they must be
 	// correctly aligned or else access may cause panics on 32-bit architectures
 	// See https://golang.org/pkg/sync/atomic/#pkg-note-BUG
-	totalConns int64 // atomic, must only be access using functions from sync/atomic
+	totalConns   int64 // atomic, must only be access using functions from sync/atomic
+	latency      int64 // atomic, see observeLatency
+	noEvictConns int64 // atomic, number of connections marked with CLIENT NO-EVICT
+	waitCount    int64 // atomic, see PoolStats
+	waitDuration int64 // atomic, see PoolStats
+	dialErrors   int64 // atomic, see PoolStats
+	inFlight     int64 // atomic, see PoolStats
+	rejected     int64 // atomic, see PoolStats
 
 	opts          poolOpts
 	network, addr string
@@ -252,10 +565,17 @@ type Pool struct {
 	l sync.RWMutex
 	// pool is read-protected by l, and should not be written to or read from
 	// when closed is true (closed is also protected by l)
-	pool   chan *ioErrConn
+	pool   *idleConns
 	closed bool
 
+	// conns contains all connections created by the Pool which haven't been
+	// closed yet, whether they're in pool or not. See Inspect.
+	connsL sync.Mutex
+	conns  map[*ioErrConn]struct{}
+
 	pipeliner *pipeliner
+	limiter   *rateLimiter
+	inFlightC chan struct{} // see PoolMaxInFlight
 
 	wg       sync.WaitGroup
 	closeCh  chan bool
@@ -280,6 +600,7 @@ type Pool struct {
 //	PoolPingInterval(5 * time.Second / (size+1))
 //	PoolPipelineConcurrency(size)
 //	PoolPipelineWindow(150 * time.Microsecond, 0)
+//	PoolCheckoutFIFO()
 //
 // The recommended size of the pool depends on the number of concurrent
 // goroutines that will use the pool and whether implicit pipelining is
@@ -296,6 +617,7 @@ func NewPool(network, addr string, size int, opts ...PoolOpt) (*Pool, error) {
 		size:     size,
 		closeCh:  make(chan bool),
 		initDone: make(chan struct{}),
+		conns:    map[*ioErrConn]struct{}{},
 		ErrCh:    make(chan error, 1),
 	}
 
@@ -320,7 +642,7 @@ func NewPool(network, addr string, size int, opts ...PoolOpt) (*Pool, error) {
 	}
 
 	totalSize := size + p.opts.overflowSize
-	p.pool = make(chan *ioErrConn, totalSize)
+	p.pool = newIdleConns(totalSize, p.opts.checkoutLIFO)
 
 	// make one Conn synchronously to ensure there's actually a redis instance
 	// present. The rest will be created asynchronously.
@@ -369,8 +691,14 @@ func NewPool(network, addr string, size int, opts ...PoolOpt) (*Pool, error) {
 			p.opts.pipelineWindow,
 		)
 	}
+	if p.opts.rateLimit > 0 {
+		p.limiter = newRateLimiter(p.opts.rateLimit, p.opts.rateLimitBurst)
+	}
+	if p.opts.maxInFlight > 0 {
+		p.inFlightC = make(chan struct{}, p.opts.maxInFlight)
+	}
 	if p.opts.pingInterval > 0 && size > 0 {
-		p.atIntervalDo(p.opts.pingInterval, func() { p.Do(Cmd(nil, "PING")) })
+		p.atIntervalDo(p.opts.pingInterval, p.doPing)
 	}
 	if p.opts.refillInterval > 0 && size > 0 {
 		p.atIntervalDo(p.opts.refillInterval, p.doRefill)
@@ -378,6 +706,15 @@ func NewPool(network, addr string, size int, opts ...PoolOpt) (*Pool, error) {
 	if p.opts.overflowSize > 0 && p.opts.overflowDrainInterval > 0 {
 		p.atIntervalDo(p.opts.overflowDrainInterval, p.doOverflowDrain)
 	}
+	if p.opts.idleTimeout > 0 {
+		// time.NewTicker panics for a non-positive interval, which d/2 would
+		// be for d == 1ns.
+		d := p.opts.idleTimeout / 2
+		if d < time.Millisecond {
+			d = time.Millisecond
+		}
+		p.atIntervalDo(d, p.doIdleClose)
+	}
 	return p, nil
 }
 
@@ -385,7 +722,7 @@ func (p *Pool) traceInitCompleted(elapsedTime time.Duration) {
 	if p.opts.pt.InitCompleted != nil {
 		p.opts.pt.InitCompleted(trace.PoolInitCompleted{
 			PoolCommon:  p.traceCommon(),
-			AvailCount:  len(p.pool),
+			AvailCount:  p.pool.len(),
 			ElapsedTime: elapsedTime,
 		})
 	}
@@ -416,11 +753,20 @@ func (p *Pool) traceConnCreated(connectTime time.Duration, reason trace.PoolConn
 	}
 }
 
+func (p *Pool) traceGetTimedOut(waitTime time.Duration) {
+	if p.opts.pt.GetTimedOut != nil {
+		p.opts.pt.GetTimedOut(trace.PoolGetTimedOut{
+			PoolCommon: p.traceCommon(),
+			WaitTime:   waitTime,
+		})
+	}
+}
+
 func (p *Pool) traceConnClosed(reason trace.PoolConnClosedReason) {
 	if p.opts.pt.ConnClosed != nil {
 		p.opts.pt.ConnClosed(trace.PoolConnClosed{
 			PoolCommon: p.traceCommon(),
-			AvailCount: len(p.pool),
+			AvailCount: p.pool.len(),
 			Reason:     reason,
 		})
 	}
@@ -429,16 +775,79 @@ func (p *Pool) traceConnClosed(reason trace.PoolConnClosedReason) {
 func (p *Pool) newConn(reason trace.PoolConnCreatedReason) (*ioErrConn, error) {
 	start := time.Now()
 	c, err := p.opts.cf(p.network, p.addr)
+	var noEvict bool
+	if err == nil {
+		noEvict, err = p.markNoEvict(c, reason)
+	}
 	elapsed := time.Since(start)
 	p.traceConnCreated(elapsed, reason, err)
 	if err != nil {
+		atomic.AddInt64(&p.dialErrors, 1)
 		return nil, err
 	}
 	ioc := newIOErrConn(c)
+	ioc.noEvict = noEvict
+	ioc.state.NoEvict = noEvict
+	ioc.state.CreatedAt = time.Now()
+	if p.opts.maxLifetime > 0 {
+		ioc.expiresAt = ioc.state.CreatedAt.Add(p.lifetime())
+	}
 	atomic.AddInt64(&p.totalConns, 1)
+	p.connsL.Lock()
+	p.conns[ioc] = struct{}{}
+	p.connsL.Unlock()
 	return ioc, nil
 }
 
+// markNoEvict enables CLIENT NO-EVICT on the given new connection if the limit
+// set using PoolMaxNoEvict hasn't been reached yet, returning whether it did.
+// If enabling it fails the connection is closed.
+func (p *Pool) markNoEvict(c Conn, reason trace.PoolConnCreatedReason) (bool, error) {
+	if p.opts.maxNoEvict <= 0 || reason == trace.PoolConnCreatedReasonPoolEmpty {
+		return false, nil
+	}
+
+	for {
+		n := atomic.LoadInt64(&p.noEvictConns)
+		if n >= int64(p.opts.maxNoEvict) {
+			return false, nil
+		} else if atomic.CompareAndSwapInt64(&p.noEvictConns, n, n+1) {
+			break
+		}
+	}
+
+	if err := c.Do(Cmd(nil, "CLIENT", "NO-EVICT", "ON")); err != nil {
+		atomic.AddInt64(&p.noEvictConns, -1)
+		c.Close()
+		return false, err
+	}
+	return true, nil
+}
+
+// closeConn closes a connection created by the Pool which is not in the pool.
+func (p *Pool) closeConn(ioc *ioErrConn, reason trace.PoolConnClosedReason) {
+	ioc.Close()
+	p.traceConnClosed(reason)
+	atomic.AddInt64(&p.totalConns, -1)
+	if ioc.noEvict {
+		atomic.AddInt64(&p.noEvictConns, -1)
+	}
+	p.connsL.Lock()
+	delete(p.conns, ioc)
+	p.connsL.Unlock()
+}
+
+// lifetime returns the lifetime of a new connection, with the jitter applied.
+func (p *Pool) lifetime() time.Duration {
+	jitter := p.opts.lifetimeJitter
+	if jitter < 0 {
+		jitter = 0
+	} else if jitter > 1 {
+		jitter = 1
+	}
+	return p.opts.maxLifetime - time.Duration(rand.Float64()*jitter*float64(p.opts.maxLifetime))
+}
+
 func (p *Pool) atIntervalDo(d time.Duration, do func()) {
 	p.wg.Add(1)
 	go func() {
@@ -456,6 +865,13 @@ func (p *Pool) atIntervalDo(d time.Duration, do func()) {
 	}()
 }
 
+func (p *Pool) doPing() {
+	start := time.Now()
+	if err := p.do(Cmd(nil, "PING")); err == nil {
+		observeLatency(&p.latency, time.Since(start))
+	}
+}
+
 func (p *Pool) doRefill() {
 	if atomic.LoadInt64(&p.totalConns) >= int64(p.size) {
 		return
@@ -473,37 +889,51 @@ func (p *Pool) doOverflowDrain() {
 	// it manually
 	p.l.RLock()
 
-	if p.closed || len(p.pool) <= p.size {
+	if p.closed || p.pool.len() <= p.size {
 		p.l.RUnlock()
 		return
 	}
 
 	// pop a connection off and close it, if there's any to pop off
-	var ioc *ioErrConn
-	select {
-	case ioc = <-p.pool:
-	default:
-		// pool is empty, nothing to drain
-	}
+	// in LIFO order the oldest connection is the one least recently used,
+	// which is the one that should go
+	ioc := p.pool.tryTake(true)
 	p.l.RUnlock()
 
 	if ioc == nil {
 		return
 	}
 
-	ioc.Close()
-	p.traceConnClosed(trace.PoolConnClosedReasonBufferDrain)
-	atomic.AddInt64(&p.totalConns, -1)
+	p.closeConn(ioc, trace.PoolConnClosedReasonBufferDrain)
+}
+
+func (p *Pool) doIdleClose() {
+	for {
+		p.l.RLock()
+		if p.closed {
+			p.l.RUnlock()
+			return
+		}
+		// the least recently added connection is the one which has been idle
+		// the longest, so once it's not idle none of the others are
+		ioc := p.pool.takeIdle(time.Now().Add(-p.opts.idleTimeout))
+		p.l.RUnlock()
+
+		if ioc == nil {
+			return
+		}
+		p.closeConn(ioc, trace.PoolConnClosedReasonIdleTimeout)
+	}
 }
 
 func (p *Pool) getExisting() (*ioErrConn, error) {
 	// Fast-path if the pool is not empty. Return error if pool has been closed.
 	select {
-	case ioc, ok := <-p.pool:
+	case _, ok := <-p.pool.availCh:
 		if !ok {
 			return nil, errClientClosed
 		}
-		return ioc, nil
+		return p.pool.take(false), nil
 	default:
 	}
 
@@ -522,13 +952,20 @@ func (p *Pool) getExisting() (*ioErrConn, error) {
 		tc = t.C
 	}
 
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&p.waitCount, 1)
+		atomic.AddInt64(&p.waitDuration, int64(time.Since(start)))
+	}()
+
 	select {
-	case ioc, ok := <-p.pool:
+	case _, ok := <-p.pool.availCh:
 		if !ok {
 			return nil, errClientClosed
 		}
-		return ioc, nil
+		return p.pool.take(false), nil
 	case <-tc:
+		p.traceGetTimedOut(time.Since(start))
 		return nil, p.opts.errOnEmpty
 	}
 }
@@ -537,31 +974,61 @@ func (p *Pool) get() (*ioErrConn, error) {
 	ioc, err := p.getExisting()
 	if err != nil {
 		return nil, err
-	} else if ioc != nil {
-		return ioc, nil
+	} else if ioc == nil {
+		if ioc, err = p.newConn(trace.PoolConnCreatedReasonPoolEmpty); err != nil {
+			return nil, err
+		}
 	}
-	return p.newConn(trace.PoolConnCreatedReasonPoolEmpty)
+	p.checkout(ioc)
+	return ioc, nil
+}
+
+// checkout updates the state of a connection which is being taken out of the
+// pool to be used.
+func (p *Pool) checkout(ioc *ioErrConn) {
+	var callers []uintptr
+	if p.opts.debugCallers {
+		callers = make([]uintptr, 32)
+		// skip runtime.Callers, checkout and get
+		callers = callers[:runtime.Callers(3, callers)]
+	}
+
+	ioc.stateL.Lock()
+	ioc.state.InUse = true
+	ioc.state.LastUsedAt = time.Now()
+	ioc.state.Served++
+	ioc.state.Callers = callers
+	ioc.stateL.Unlock()
 }
 
 // returns true if the connection was put back, false if it was closed and
 // discarded.
 func (p *Pool) put(ioc *ioErrConn) bool {
+	ioc.stateL.Lock()
+	if ioc.state.InUse {
+		ioc.state.InUse = false
+		ioc.state.LastUsedAt = time.Now()
+		ioc.state.Callers = nil
+	}
+	ioc.stateL.Unlock()
+
+	if !ioc.expiresAt.IsZero() && !time.Now().Before(ioc.expiresAt) {
+		p.closeConn(ioc, trace.PoolConnClosedReasonMaxLifetime)
+		return false
+	}
+
 	p.l.RLock()
 	if ioc.lastIOErr == nil && !p.closed {
-		select {
-		case p.pool <- ioc:
+		if p.pool.push(ioc) {
 			p.l.RUnlock()
 			return true
-		default:
 		}
 	}
 	p.l.RUnlock()
 
 	// the pool might close here, but that's fine, because all that's happening
 	// at this point is that the connection is being closed
-	ioc.Close()
-	p.traceConnClosed(trace.PoolConnClosedReasonPoolFull)
-	atomic.AddInt64(&p.totalConns, -1)
+	p.closeConn(ioc, trace.PoolConnClosedReasonPoolFull)
 	return false
 }
 
@@ -579,10 +1046,75 @@ func (p *Pool) put(ioc *ioErrConn) bool {
 // Due to a limitation in the implementation, custom CmdAction implementations
 // are currently not automatically pipelined.
 func (p *Pool) Do(a Action) error {
+	if p.limiter != nil && !p.limiter.wait(p.closeCh) {
+		return errClientClosed
+	}
+	if p.inFlightC != nil {
+		if err := p.acquireInFlight(a); err != nil {
+			return err
+		}
+		defer func() { <-p.inFlightC }()
+	}
+	atomic.AddInt64(&p.inFlight, 1)
+	defer atomic.AddInt64(&p.inFlight, -1)
+
+	if p.opts.retryPolicy != nil {
+		return p.opts.retryPolicy.do(a, p.do)
+	}
+	return p.do(a)
+}
+
+// acquireInFlight waits for the number of Actions in flight to drop below the
+// limit set using PoolMaxInFlight, and reserves a slot for the given Action.
+func (p *Pool) acquireInFlight(a Action) error {
+	select {
+	case p.inFlightC <- struct{}{}:
+		return nil
+	default:
+	}
+
+	wait := p.opts.inFlightWait
+	if deadline, ok := actionDeadline(a); ok {
+		d := time.Until(deadline)
+		if d < 0 {
+			d = 0
+		}
+		if wait < 0 || d < wait {
+			wait = d
+		}
+	}
+
+	var timeoutCh <-chan time.Time
+	if wait >= 0 {
+		if wait == 0 {
+			atomic.AddInt64(&p.rejected, 1)
+			return ErrTooManyInFlight
+		}
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeoutCh = t.C
+	}
+
+	select {
+	case p.inFlightC <- struct{}{}:
+		return nil
+	case <-timeoutCh:
+		atomic.AddInt64(&p.rejected, 1)
+		return ErrTooManyInFlight
+	case <-p.closeCh:
+		return errClientClosed
+	}
+}
+
+func (p *Pool) do(a Action) error {
 	startTime := time.Now()
-	if p.pipeliner != nil && p.pipeliner.CanDo(a) {
-		err := p.pipeliner.Do(a)
-		p.traceDoCompleted(time.Since(startTime), err)
+	name, inner := commandName(a)
+	if p.pipeliner != nil && p.pipeliner.CanDo(inner) {
+		err := p.pipeliner.Do(inner)
+		if p.canRetry(inner, err) {
+			err = p.retry(inner)
+		}
+		p.traceDoCompleted(name, time.Since(startTime), err)
 
 		return err
 	}
@@ -592,28 +1124,176 @@ func (p *Pool) Do(a Action) error {
 		return err
 	}
 
+	// the Action is performed as given, so that the Conn's own traces see the
+	// name given using WithCommandName as well
 	err = c.Do(a)
 	p.put(c)
-	p.traceDoCompleted(time.Since(startTime), err)
+	if p.canRetry(inner, err) {
+		err = p.retry(a)
+	}
+	p.traceDoCompleted(name, time.Since(startTime), err)
 
 	return err
 }
 
-func (p *Pool) traceDoCompleted(elapsedTime time.Duration, err error) {
+// canRetry returns true if the given Action, which failed with the given error,
+// should be retried as described in PoolRetryIdempotentOnce.
+func (p *Pool) canRetry(a Action, err error) bool {
+	if !p.opts.retryIdempotent || err == nil {
+		return false
+	}
+
+	cmd, ok := a.(*cmdAction)
+	if !ok || !IsIdempotentCommand(cmd.cmd) {
+		return false
+	}
+
+	return isConnErr(err)
+}
+
+// retry performs the Action again on a newly created connection.
+func (p *Pool) retry(a Action) error {
+	c, err := p.newConn(trace.PoolConnCreatedReasonRetry)
+	if err != nil {
+		return err
+	}
+	p.checkout(c)
+	err = c.Do(a)
+	p.put(c)
+	return err
+}
+
+func (p *Pool) traceDoCompleted(name string, elapsedTime time.Duration, err error) {
 	if p.opts.pt.DoCompleted != nil {
 		p.opts.pt.DoCompleted(trace.PoolDoCompleted{
-			PoolCommon:  p.traceCommon(),
-			AvailCount:  len(p.pool),
-			ElapsedTime: elapsedTime,
-			Err:         err,
+			PoolCommon:    p.traceCommon(),
+			AvailCount:    p.pool.len(),
+			InFlightCount: int(atomic.LoadInt64(&p.inFlight)),
+			CommandName:   name,
+			ElapsedTime:   elapsedTime,
+			Err:           err,
 		})
 	}
 }
 
+// Latency returns an exponentially weighted moving average of the round-trip
+// times of the pings done by the Pool, see PoolPingInterval. Zero is returned
+// if no ping has succeeded yet.
+func (p *Pool) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.latency))
+}
+
 // NumAvailConns returns the number of connections currently available in the
 // pool, as well as in the overflow buffer if that option is enabled.
 func (p *Pool) NumAvailConns() int {
-	return len(p.pool)
+	return p.pool.len()
+}
+
+// PoolStats contains statistics about a Pool, as returned by Pool.Stats.
+type PoolStats struct {
+	// TotalConns is the number of connections the Pool currently holds open.
+	TotalConns int
+
+	// IdleConns is the number of connections which are currently available in
+	// the pool, including the overflow buffer.
+	IdleConns int
+
+	// InUseConns is the number of connections which are currently taken out of
+	// the pool.
+	InUseConns int
+
+	// WaitCount is the total number of times an Action had to wait for a
+	// connection to become available, because the pool was empty.
+	WaitCount int64
+
+	// WaitDuration is the total amount of time spent waiting for connections
+	// to become available.
+	WaitDuration time.Duration
+
+	// DialErrors is the total number of times creating a new connection failed.
+	DialErrors int64
+
+	// InFlight is the number of Actions currently being performed by the
+	// Pool, see PoolMaxInFlight.
+	InFlight int
+
+	// TooManyInFlight is the total number of times Do returned
+	// ErrTooManyInFlight.
+	TooManyInFlight int64
+}
+
+// Stats returns a snapshot of the Pool's current statistics. The counters in
+// PoolStats only ever increase, so rates can be derived by comparing
+// subsequent snapshots.
+func (p *Pool) Stats() PoolStats {
+	total := int(atomic.LoadInt64(&p.totalConns))
+	idle := p.pool.len()
+	inUse := total - idle
+	if inUse < 0 {
+		// total and idle aren't read at the same time, so a connection created
+		// in between can be counted as idle without being counted in total
+		inUse = 0
+	}
+	return PoolStats{
+		TotalConns:      total,
+		IdleConns:       idle,
+		InUseConns:      inUse,
+		WaitCount:       atomic.LoadInt64(&p.waitCount),
+		WaitDuration:    time.Duration(atomic.LoadInt64(&p.waitDuration)),
+		DialErrors:      atomic.LoadInt64(&p.dialErrors),
+		InFlight:        int(atomic.LoadInt64(&p.inFlight)),
+		TooManyInFlight: atomic.LoadInt64(&p.rejected),
+	}
+}
+
+// PoolConnState describes the state of a single connection of a Pool, as
+// returned by Pool.Inspect.
+type PoolConnState struct {
+	// InUse is true if the connection is currently taken out of the pool.
+	InUse bool
+
+	// CreatedAt is the time the connection was created.
+	CreatedAt time.Time
+
+	// LastUsedAt is the time the connection was last taken out of or put back
+	// into the pool, or zero if it was never used.
+	LastUsedAt time.Time
+
+	// Served is the number of times the connection was taken out of the pool.
+	// As implicitly pipelined commands share a connection each pipeline only
+	// counts once.
+	Served int
+
+	// NoEvict is true if CLIENT NO-EVICT was enabled on the connection, see
+	// PoolMaxNoEvict.
+	NoEvict bool
+
+	// Callers contains the program counters of the call stack which took the
+	// connection out of the pool, if PoolDebugCallers was used and InUse is
+	// true. It can be resolved using runtime.CallersFrames. For implicitly
+	// pipelined commands this is the stack of the Pool's pipeliner.
+	Callers []uintptr
+}
+
+// Inspect returns the state of each connection which the Pool currently holds
+// open, whether it's in the pool or in use. This is primarily useful for
+// debugging connection leaks and stuck connections. The order of the returned
+// states is undefined.
+func (p *Pool) Inspect() []PoolConnState {
+	p.connsL.Lock()
+	conns := make([]*ioErrConn, 0, len(p.conns))
+	for ioc := range p.conns {
+		conns = append(conns, ioc)
+	}
+	p.connsL.Unlock()
+
+	states := make([]PoolConnState, len(conns))
+	for i, ioc := range conns {
+		ioc.stateL.Lock()
+		states[i] = ioc.state
+		ioc.stateL.Unlock()
+	}
+	return states
 }
 
 // Close implements the Close method of the Client
@@ -626,20 +1306,12 @@ func (p *Pool) Close() error {
 	p.closed = true
 	close(p.closeCh)
 
-	// at this point get and put won't work anymore, so it's safe to empty and
-	// close the pool channel
-emptyLoop:
-	for {
-		select {
-		case ioc := <-p.pool:
-			ioc.Close()
-			atomic.AddInt64(&p.totalConns, -1)
-			p.traceConnClosed(trace.PoolConnClosedReasonPoolClosed)
-		default:
-			close(p.pool)
-			break emptyLoop
-		}
+	// at this point get and put won't work anymore, so it's safe to empty the
+	// pool and close its channel
+	for ioc := p.pool.tryTake(false); ioc != nil; ioc = p.pool.tryTake(false) {
+		p.closeConn(ioc, trace.PoolConnClosedReasonPoolClosed)
 	}
+	close(p.pool.availCh)
 	p.l.Unlock()
 
 	if p.pipeliner != nil {
@@ -654,3 +1326,66 @@ emptyLoop:
 	close(p.ErrCh)
 	return nil
 }
+
+////////////////////////////////////////////////////////////////////////////////
+
+// rateLimiter is a token bucket, used to implement PoolRateLimit.
+type rateLimiter struct {
+	l        sync.Mutex
+	interval time.Duration // time it takes to add a single token
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(perSecond, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		interval: time.Second / time.Duration(perSecond),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// reserve takes a token from the bucket and returns how long the caller must
+// wait before the token becomes available. If there are no tokens left the
+// token is borrowed from the future, so callers are served in order.
+func (rl *rateLimiter) reserve() time.Duration {
+	rl.l.Lock()
+	defer rl.l.Unlock()
+
+	now := time.Now()
+	rl.tokens += float64(now.Sub(rl.last)) / float64(rl.interval)
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+
+	rl.tokens--
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens * float64(rl.interval))
+}
+
+// wait blocks until a token is available. It returns false if closeCh is
+// closed while waiting.
+func (rl *rateLimiter) wait(closeCh <-chan bool) bool {
+	d := rl.reserve()
+	if d <= 0 {
+		return true
+	}
+
+	t := getTimer(d)
+	defer putTimer(t)
+
+	select {
+	case <-t.C:
+		return true
+	case <-closeCh:
+		return false
+	}
+}
@@ -1,7 +1,10 @@
 package radix
 
 import (
+	"bytes"
 	"io"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	. "testing"
@@ -142,46 +145,47 @@ func TestPoolOnFull(t *T) {
 			}}),
 		)
 		defer pool.Close()
-		assert.Equal(t, 1, len(pool.pool))
+		assert.Equal(t, 1, pool.pool.len())
 
 		spc, err := pool.newConn("TEST")
 		assert.NoError(t, err)
 		pool.put(spc)
-		assert.Equal(t, 1, len(pool.pool))
+		assert.Equal(t, 1, pool.pool.len())
 		assert.Equal(t, trace.PoolConnClosedReasonPoolFull, reason)
 	})
 
 	t.Run("onFullBuffer", func(t *T) {
 		pool := testPool(1, PoolOnFullBuffer(1, 1*time.Second))
 		defer pool.Close()
-		assert.Equal(t, 1, len(pool.pool))
+		assert.Equal(t, 1, pool.pool.len())
 
 		// putting a conn should overflow
 		spc, err := pool.newConn("TEST")
 		assert.NoError(t, err)
 		pool.put(spc)
-		assert.Equal(t, 2, len(pool.pool))
+		assert.Equal(t, 2, pool.pool.len())
 
 		// another shouldn't, overflow is full
 		spc, err = pool.newConn("TEST")
 		assert.NoError(t, err)
 		pool.put(spc)
-		assert.Equal(t, 2, len(pool.pool))
+		assert.Equal(t, 2, pool.pool.len())
 
 		// retrieve from the pool, drain shouldn't do anything because the
 		// overflow is empty now
-		<-pool.pool
-		assert.Equal(t, 1, len(pool.pool))
+		<-pool.pool.availCh
+		pool.pool.take(false)
+		assert.Equal(t, 1, pool.pool.len())
 		time.Sleep(2 * time.Second)
-		assert.Equal(t, 1, len(pool.pool))
+		assert.Equal(t, 1, pool.pool.len())
 
 		// if both are full then drain should remove the overflow one
 		spc, err = pool.newConn("TEST")
 		assert.NoError(t, err)
 		pool.put(spc)
-		assert.Equal(t, 2, len(pool.pool))
+		assert.Equal(t, 2, pool.pool.len())
 		time.Sleep(2 * time.Second)
-		assert.Equal(t, 1, len(pool.pool))
+		assert.Equal(t, 1, pool.pool.len())
 	})
 }
 
@@ -316,3 +320,704 @@ func TestIoErrConn(t *T) {
 		require.Nil(t, err2)
 	})
 }
+
+func TestPoolDoCompletedCommandName(t *T) {
+	var names []string
+	var l sync.Mutex
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func([]string) interface{} {
+				return "OK"
+			}), nil
+		}),
+		PoolPipelineWindow(0, 0),
+		PoolWithTrace(trace.PoolTrace{
+			DoCompleted: func(completed trace.PoolDoCompleted) {
+				l.Lock()
+				defer l.Unlock()
+				names = append(names, completed.CommandName)
+			},
+		}),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	require.NoError(t, pool.Do(Cmd(nil, "GET", "foo")))
+	require.NoError(t, pool.Do(FlatCmd(nil, "SET", "foo", 1)))
+	require.NoError(t, pool.Do(Pipeline(Cmd(nil, "GET", "foo"))))
+	require.NoError(t, pool.Do(WithCommandName(Cmd(nil, "GET", "foo"), "get_foo")))
+	require.NoError(t, pool.Do(WithCommandName(Pipeline(Cmd(nil, "GET", "foo")), "pipe_foo")))
+
+	assert.Equal(t, []string{"GET", "SET", "", "get_foo", "pipe_foo"}, names)
+}
+
+func TestPoolConcurrentNoCrossTalk(t *T) {
+	newPool := func(opts ...PoolOpt) *Pool {
+		opts = append([]PoolOpt{PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func(args []string) interface{} {
+				return args[1]
+			}), nil
+		})}, opts...)
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 4, opts...)
+		require.NoError(t, err)
+		return pool
+	}
+
+	hammer := func(t *T, pool *Pool) {
+		defer pool.Close()
+		var wg sync.WaitGroup
+		errCh := make(chan error, 50)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					exp := randStr()
+					var out string
+					if err := pool.Do(Cmd(&out, "ECHO", exp)); err != nil {
+						errCh <- err
+						return
+					} else if out != exp {
+						errCh <- errors.Errorf("expected %q, got %q", exp, out)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errCh)
+		for err := range errCh {
+			assert.NoError(t, err)
+		}
+	}
+
+	t.Run("pipelined", func(t *T) { hammer(t, newPool()) })
+	t.Run("unpipelined", func(t *T) { hammer(t, newPool(PoolPipelineWindow(0, 0))) })
+}
+
+func TestPoolInspect(t *T) {
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 2,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func([]string) interface{} {
+				return "OK"
+			}), nil
+		}),
+		PoolPipelineWindow(0, 0),
+		PoolPingInterval(0),
+		PoolDebugCallers(),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+
+	states := pool.Inspect()
+	require.Len(t, states, 2)
+	for _, state := range states {
+		assert.False(t, state.InUse)
+		assert.False(t, state.CreatedAt.IsZero())
+		assert.Zero(t, state.Served)
+	}
+
+	var inUse []PoolConnState
+	require.NoError(t, pool.Do(WithConn("", func(Conn) error {
+		for _, state := range pool.Inspect() {
+			if state.InUse {
+				inUse = append(inUse, state)
+			}
+		}
+		return nil
+	})))
+	require.Len(t, inUse, 1)
+	assert.Equal(t, 1, inUse[0].Served)
+	assert.False(t, inUse[0].LastUsedAt.IsZero())
+
+	var funcs []string
+	frames := runtime.CallersFrames(inUse[0].Callers)
+	for {
+		frame, more := frames.Next()
+		funcs = append(funcs, frame.Function)
+		if !more {
+			break
+		}
+	}
+	assert.Contains(t, funcs, "github.com/mediocregopher/radix/v3.TestPoolInspect")
+
+	var served int
+	for _, state := range pool.Inspect() {
+		assert.False(t, state.InUse)
+		assert.Nil(t, state.Callers)
+		served += state.Served
+	}
+	assert.Equal(t, 1, served)
+}
+
+func TestPoolCheckoutOrder(t *T) {
+	const size = 3
+	newPool := func(opt PoolOpt) *Pool {
+		pool, err := NewPool("tcp", "127.0.0.1:6379", size,
+			PoolConnFunc(func(network, addr string) (Conn, error) {
+				return Stub(network, addr, func([]string) interface{} {
+					return "OK"
+				}), nil
+			}),
+			PoolPipelineWindow(0, 0),
+			PoolPingInterval(0),
+			PoolRefillInterval(0),
+			opt,
+		)
+		require.NoError(t, err)
+		<-pool.initDone
+		require.Equal(t, size, pool.NumAvailConns())
+		return pool
+	}
+
+	// usedConns returns the number of distinct connections used for n
+	// sequential actions
+	usedConns := func(pool *Pool, n int) int {
+		used := map[Conn]bool{}
+		for i := 0; i < n; i++ {
+			require.NoError(t, pool.Do(WithConn("", func(c Conn) error {
+				used[c] = true
+				return nil
+			})))
+		}
+		return len(used)
+	}
+
+	t.Run("FIFO", func(t *T) {
+		pool := newPool(PoolCheckoutFIFO())
+		defer pool.Close()
+		assert.Equal(t, size, usedConns(pool, size*2))
+	})
+
+	t.Run("LIFO", func(t *T) {
+		pool := newPool(PoolCheckoutLIFO())
+		defer pool.Close()
+		assert.Equal(t, 1, usedConns(pool, size*2))
+		assert.Equal(t, size, pool.NumAvailConns())
+	})
+}
+
+func TestPoolMaxLifetime(t *T) {
+	const size = 10
+	const lifetime = 200 * time.Millisecond
+	var closed int64
+	start := time.Now()
+	pool, err := NewPool("tcp", "127.0.0.1:6379", size,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func([]string) interface{} {
+				return "OK"
+			}), nil
+		}),
+		PoolMaxLifetime(lifetime, 0.5),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolOnEmptyCreateAfter(0),
+		PoolPipelineWindow(0, 0),
+		PoolWithTrace(trace.PoolTrace{
+			ConnClosed: func(cc trace.PoolConnClosed) {
+				if cc.Reason == trace.PoolConnClosedReasonMaxLifetime {
+					atomic.AddInt64(&closed, 1)
+				}
+			},
+		}),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+
+	// each connection must expire between half of and the full lifetime after
+	// creation, and not all at the same time
+	expiries := map[time.Time]bool{}
+	var iocs []*ioErrConn
+	for i := 0; i < size; i++ {
+		<-pool.pool.availCh
+		ioc := pool.pool.take(false)
+		iocs = append(iocs, ioc)
+		expiries[ioc.expiresAt] = true
+		assert.False(t, ioc.expiresAt.Before(start.Add(lifetime/2)))
+		assert.False(t, ioc.expiresAt.After(time.Now().Add(lifetime)))
+	}
+	assert.True(t, len(expiries) > 1)
+
+	for _, ioc := range iocs {
+		assert.True(t, pool.put(ioc))
+	}
+	assert.Zero(t, atomic.LoadInt64(&closed))
+
+	time.Sleep(lifetime)
+	for i := 0; i < size; i++ {
+		require.NoError(t, pool.Do(Cmd(nil, "PING")))
+	}
+	assert.Equal(t, int64(size), atomic.LoadInt64(&closed))
+}
+
+func TestPoolIdleTimeout(t *T) {
+	const size = 4
+	const idleTimeout = 100 * time.Millisecond
+	var closed int64
+	pool, err := NewPool("tcp", "127.0.0.1:6379", size,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func([]string) interface{} {
+				return "OK"
+			}), nil
+		}),
+		PoolIdleTimeout(idleTimeout),
+		PoolCheckoutLIFO(),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolOnEmptyCreateAfter(0),
+		PoolPipelineWindow(0, 0),
+		PoolWithTrace(trace.PoolTrace{
+			ConnClosed: func(cc trace.PoolConnClosed) {
+				if cc.Reason == trace.PoolConnClosedReasonIdleTimeout {
+					atomic.AddInt64(&closed, 1)
+				}
+			},
+		}),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+
+	// with LIFO order the same connection is used for each Do and never
+	// closed, while all others are
+	deadline := time.Now().Add(3 * idleTimeout)
+	for time.Now().Before(deadline) {
+		require.NoError(t, pool.Do(Cmd(nil, "PING")))
+		time.Sleep(idleTimeout / 10)
+	}
+	assert.Equal(t, int64(size-1), atomic.LoadInt64(&closed))
+	assert.Equal(t, 1, pool.NumAvailConns())
+}
+
+func TestPoolIdleTimeoutTiny(t *T) {
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func([]string) interface{} {
+				return "OK"
+			}), nil
+		}),
+		PoolIdleTimeout(1),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.NoError(t, pool.Do(Cmd(nil, "PING")))
+}
+
+func TestPoolMaxNoEvict(t *T) {
+	const size, maxNoEvict = 4, 2
+	var noEvicts int64
+	newPool := func(fail bool) (*Pool, error) {
+		return NewPool("tcp", "127.0.0.1:6379", size,
+			PoolConnFunc(func(network, addr string) (Conn, error) {
+				return Stub(network, addr, func(args []string) interface{} {
+					if strings.Join(args, " ") != "CLIENT NO-EVICT ON" {
+						return "OK"
+					} else if fail {
+						return resp2.Error{E: errors.New("ERR unknown subcommand 'NO-EVICT'")}
+					}
+					atomic.AddInt64(&noEvicts, 1)
+					return "OK"
+				}), nil
+			}),
+			PoolMaxNoEvict(maxNoEvict),
+			PoolPingInterval(0),
+			PoolRefillInterval(0),
+			PoolPipelineWindow(0, 0),
+		)
+	}
+
+	pool, err := newPool(false)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+
+	var inspected int
+	for _, state := range pool.Inspect() {
+		if state.NoEvict {
+			inspected++
+		}
+	}
+	assert.Equal(t, maxNoEvict, inspected)
+
+	var taken, marked []*ioErrConn
+	for i := 0; i < size; i++ {
+		<-pool.pool.availCh
+		ioc := pool.pool.take(false)
+		if ioc.noEvict {
+			marked = append(marked, ioc)
+		} else {
+			taken = append(taken, ioc)
+		}
+	}
+	assert.Len(t, marked, maxNoEvict)
+	assert.Equal(t, int64(maxNoEvict), atomic.LoadInt64(&noEvicts))
+
+	// connections created because the pool is empty are never marked
+	ioc, err := pool.newConn(trace.PoolConnCreatedReasonPoolEmpty)
+	require.NoError(t, err)
+	assert.False(t, ioc.noEvict)
+	pool.closeConn(ioc, trace.PoolConnClosedReasonPoolFull)
+
+	// once a marked connection is closed the next one takes its place
+	ioc, err = pool.newConn(trace.PoolConnCreatedReasonRefill)
+	require.NoError(t, err)
+	assert.False(t, ioc.noEvict)
+	pool.closeConn(ioc, trace.PoolConnClosedReasonPoolFull)
+
+	pool.closeConn(marked[0], trace.PoolConnClosedReasonPoolFull)
+	ioc, err = pool.newConn(trace.PoolConnCreatedReasonRefill)
+	require.NoError(t, err)
+	assert.True(t, ioc.noEvict)
+	assert.Equal(t, int64(maxNoEvict+1), atomic.LoadInt64(&noEvicts))
+
+	for _, ioc := range append(taken, marked[1], ioc) {
+		assert.True(t, pool.put(ioc))
+	}
+
+	// if CLIENT NO-EVICT isn't supported creating the pool fails
+	_, err = newPool(true)
+	assert.Error(t, err)
+}
+
+func TestPoolStats(t *T) {
+	const size = 2
+	const wait = 20 * time.Millisecond
+	var dialFail int64
+	var timedOut []trace.PoolGetTimedOut
+	pool, err := NewPool("tcp", "127.0.0.1:6379", size,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			if atomic.LoadInt64(&dialFail) == 1 {
+				return nil, errors.New("dial failed")
+			}
+			return Stub(network, addr, func([]string) interface{} {
+				return "OK"
+			}), nil
+		}),
+		PoolOnEmptyErrAfter(wait),
+		PoolOnFullClose(),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolPipelineWindow(0, 0),
+		PoolWithTrace(trace.PoolTrace{
+			GetTimedOut: func(gt trace.PoolGetTimedOut) {
+				timedOut = append(timedOut, gt)
+			},
+		}),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+	assert.Equal(t, PoolStats{TotalConns: size, IdleConns: size}, pool.Stats())
+
+	ioc, err := pool.get()
+	require.NoError(t, err)
+	assert.Equal(t, PoolStats{TotalConns: size, IdleConns: size - 1, InUseConns: 1}, pool.Stats())
+
+	// once the pool is empty Actions need to wait, until they time out
+	ioc2, err := pool.get()
+	require.NoError(t, err)
+	assert.Equal(t, ErrPoolEmpty, pool.Do(Cmd(nil, "PING")))
+	stats := pool.Stats()
+	assert.Equal(t, 0, stats.IdleConns)
+	assert.Equal(t, size, stats.InUseConns)
+	assert.Equal(t, int64(1), stats.WaitCount)
+	assert.True(t, stats.WaitDuration >= wait)
+	require.Len(t, timedOut, 1)
+	assert.True(t, timedOut[0].WaitTime >= wait)
+	assert.Equal(t, size, timedOut[0].PoolSize)
+
+	pool.put(ioc)
+	pool.put(ioc2)
+
+	atomic.StoreInt64(&dialFail, 1)
+	_, err = pool.newConn(trace.PoolConnCreatedReasonRefill)
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), pool.Stats().DialErrors)
+}
+
+func TestPoolReadTimeoutBlocking(t *T) {
+	addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+		if args[0] == "BLPOP" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	pool, err := NewPool("tcp", addr, 1,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Dial(network, addr, DialReadTimeout(50*time.Millisecond))
+		}),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolPipelineWindow(0, 0),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// the read timeout is extended by the block duration
+	require.NoError(t, pool.Do(Cmd(nil, "BLPOP", "foo", "0.2")))
+}
+
+func TestPoolWireTrace(t *T) {
+	addr, _ := dialTestServer(t, func(args []string) resp.Marshaler {
+		return resp2.SimpleString{S: "PONG"}
+	})
+
+	buf := new(bytes.Buffer)
+	pool, err := NewPool("tcp", addr, 1,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Dial(network, addr, DialWireLoggerSampled(buf))
+		}),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	require.NoError(t, pool.Do(Cmd(nil, "PING")))
+	assert.Empty(t, buf.String())
+
+	require.NoError(t, pool.Do(WithWireTrace(Cmd(nil, "PING"))))
+	assert.Contains(t, buf.String(), "-> "+addr+" 14 bytes\n")
+	assert.Contains(t, buf.String(), "<- "+addr+" 7 bytes\n")
+}
+
+func TestPoolMaxInFlight(t *T) {
+	releaseCh := make(chan struct{})
+	newPool := func(opt PoolOpt) *Pool {
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 4,
+			PoolConnFunc(func(network, addr string) (Conn, error) {
+				return Stub(network, addr, func(args []string) interface{} {
+					if args[0] == "BLPOP" {
+						<-releaseCh
+					}
+					return "OK"
+				}), nil
+			}),
+			opt,
+			PoolPingInterval(0),
+			PoolPipelineWindow(0, 0),
+		)
+		require.NoError(t, err)
+		<-pool.initDone
+		return pool
+	}
+
+	// occupies all slots of the pool, returning a func which waits for the
+	// Actions to complete
+	fill := func(pool *Pool, n int) func() {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, pool.Do(Cmd(nil, "BLPOP", "foo", "0")))
+			}()
+		}
+		deadline := time.Now().Add(time.Second)
+		for pool.Stats().InFlight < n {
+			require.True(t, time.Now().Before(deadline), "actions never in flight")
+			time.Sleep(time.Millisecond)
+		}
+		return wg.Wait
+	}
+
+	t.Run("errAfter", func(t *T) {
+		pool := newPool(PoolMaxInFlightErrAfter(2, 0))
+		defer pool.Close()
+		wait := fill(pool, 2)
+
+		assert.Equal(t, ErrTooManyInFlight, pool.Do(Cmd(nil, "GET", "foo")))
+		assert.Equal(t, int64(1), pool.Stats().TooManyInFlight)
+
+		for i := 0; i < 2; i++ {
+			releaseCh <- struct{}{}
+		}
+		wait()
+		assert.NoError(t, pool.Do(Cmd(nil, "GET", "foo")))
+		assert.Equal(t, 0, pool.Stats().InFlight)
+	})
+
+	t.Run("wait", func(t *T) {
+		pool := newPool(PoolMaxInFlight(2))
+		defer pool.Close()
+		wait := fill(pool, 2)
+
+		// Actions with a deadline only wait until their deadline
+		start := time.Now()
+		err := pool.Do(WithTimeout(Cmd(nil, "GET", "foo"), 20*time.Millisecond))
+		assert.Equal(t, ErrTooManyInFlight, err)
+		assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+		// an expired deadline fails immediately
+		err = pool.Do(WithDeadline(Cmd(nil, "GET", "foo"), time.Now().Add(-time.Second)))
+		assert.Equal(t, ErrTooManyInFlight, err)
+
+		// all other Actions wait for a slot to become available
+		doneCh := make(chan error, 1)
+		go func() { doneCh <- pool.Do(Cmd(nil, "GET", "foo")) }()
+		select {
+		case err := <-doneCh:
+			t.Fatalf("Do returned early: %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		releaseCh <- struct{}{}
+		assert.NoError(t, <-doneCh)
+
+		releaseCh <- struct{}{}
+		wait()
+		assert.Equal(t, PoolStats{TotalConns: 4, IdleConns: 4, TooManyInFlight: 2}, pool.Stats())
+	})
+}
+
+func TestPoolRateLimit(t *T) {
+	const perSecond, burst = 50, 5
+	var cmds int64
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 2,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func([]string) interface{} {
+				atomic.AddInt64(&cmds, 1)
+				return "OK"
+			}), nil
+		}),
+		PoolRateLimit(perSecond, burst),
+		PoolPingInterval(0),
+	)
+	require.NoError(t, err)
+	<-pool.initDone
+
+	// the first burst Actions are performed immediately, all others need to
+	// wait for a new token each
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < burst+10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, pool.Do(Cmd(nil, "GET", "foo")))
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	assert.Equal(t, int64(burst+10), atomic.LoadInt64(&cmds))
+	assert.True(t, elapsed >= 10*time.Second/perSecond-5*time.Millisecond, "elapsed: %v", elapsed)
+
+	// Actions blocked on the limit return once the Pool is closed
+	block := make(chan error)
+	go func() {
+		for {
+			if err := pool.Do(Cmd(nil, "GET", "foo")); err != nil {
+				block <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, pool.Close())
+	assert.Equal(t, errClientClosed, <-block)
+}
+
+// brokenConn is a Conn which fails with an io.EOF when decoding.
+type brokenConn struct {
+	Conn
+}
+
+func (bc brokenConn) Decode(resp.Unmarshaler) error {
+	return io.EOF
+}
+
+func TestPoolRetryIdempotentOnce(t *T) {
+	for _, pipelineWindow := range []time.Duration{0, 150 * time.Microsecond} {
+		var created []trace.PoolConnCreatedReason
+		var cmds []string
+		nextBroken := true
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+			PoolConnFunc(func(network, addr string) (Conn, error) {
+				c := Stub(network, addr, func(args []string) interface{} {
+					cmds = append(cmds, args[0])
+					return 1
+				})
+				if nextBroken {
+					nextBroken = false
+					return brokenConn{c}, nil
+				}
+				return c, nil
+			}),
+			PoolRetryIdempotentOnce(),
+			PoolPingInterval(0),
+			PoolRefillInterval(0),
+			PoolPipelineWindow(pipelineWindow, 0),
+			PoolWithTrace(trace.PoolTrace{
+				ConnCreated: func(cc trace.PoolConnCreated) {
+					created = append(created, cc.Reason)
+				},
+			}),
+		)
+		require.NoError(t, err)
+		<-pool.initDone
+
+		var n int
+		require.NoError(t, pool.Do(Cmd(&n, "GET", "foo")))
+		assert.Equal(t, 1, n)
+		// the stub processes commands when they are written, so the broken
+		// connection still sees the command
+		assert.Equal(t, []string{"GET", "GET"}, cmds)
+		assert.Equal(t, []trace.PoolConnCreatedReason{
+			trace.PoolConnCreatedReasonInitialization,
+			trace.PoolConnCreatedReasonRetry,
+		}, created)
+
+		// INCR isn't idempotent and must not be retried
+		nextBroken = true
+		ioc, err := pool.newConn(trace.PoolConnCreatedReasonRefill)
+		require.NoError(t, err)
+		<-pool.pool.availCh
+		pool.pool.take(false)
+		pool.put(ioc)
+
+		err = pool.Do(Cmd(nil, "INCR", "foo"))
+		assert.True(t, errors.Is(err, io.EOF), "err: %v", err)
+		assert.Equal(t, []string{"GET", "GET", "INCR"}, cmds)
+		assert.Len(t, created, 3)
+
+		pool.Close()
+	}
+}
+
+func TestPoolRetryPolicy(t *T) {
+	var cmds []string
+	var loading int
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			return Stub(network, addr, func(args []string) interface{} {
+				cmds = append(cmds, args[0])
+				if loading > 0 {
+					loading--
+					return resp2.Error{E: errors.New("LOADING Redis is loading the dataset in memory")}
+				}
+				return 1
+			}), nil
+		}),
+		PoolRetryPolicy(RetryPolicy{Backoff: ConstantBackoff(0)}),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolPipelineWindow(0, 0),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+
+	var n int
+	loading = 2
+	require.NoError(t, pool.Do(Cmd(&n, "INCR", "foo")))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"INCR", "INCR", "INCR"}, cmds)
+
+	// the default of 3 attempts is exhausted
+	cmds, loading = nil, 3
+	err = pool.Do(Cmd(&n, "INCR", "foo"))
+	assert.True(t, strings.HasPrefix(err.Error(), "LOADING "), "err: %v", err)
+	assert.Equal(t, []string{"INCR", "INCR", "INCR"}, cmds)
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"io"
-	"net"
 	"sync"
 	"time"
 
@@ -20,6 +19,14 @@ type PubSubMessage struct {
 	Pattern string // will be set if Type is "pmessage"
 	Channel string
 	Message []byte
+
+	// Messages is set instead of Message if the published message is an array,
+	// which is the case for the invalidation messages sent to the
+	// __redis__:invalidate channel when client tracking is used with a
+	// redirect (see DialClientTracking). Each element is one of the
+	// invalidated keys. If all keys were invalidated, e.g. due to FLUSHALL,
+	// redis sends a nil array, in which case Messages is empty but not nil.
+	Messages [][]byte
 }
 
 // MarshalRESP implements the Marshaler interface.
@@ -42,12 +49,22 @@ func (m PubSubMessage) MarshalRESP(w io.Writer) error {
 		return errors.New("unknown message Type")
 	}
 	marshal(resp2.BulkString{S: m.Channel})
-	marshal(resp2.BulkStringBytes{B: m.Message})
+	switch {
+	case m.Messages == nil:
+		marshal(resp2.BulkStringBytes{B: m.Message})
+	case len(m.Messages) == 0:
+		marshal(resp2.ArrayHeader{N: -1})
+	default:
+		marshal(resp2.Any{I: m.Messages})
+	}
 	return err
 }
 
 var errNotPubSubMessage = errors.New("message is not a PubSubMessage")
 
+// in RESP3 all messages are sent as push frames
+func (m *PubSubMessage) unmarshalsPush() {}
+
 // UnmarshalRESP implements the Unmarshaler interface
 func (m *PubSubMessage) UnmarshalRESP(br *bufio.Reader) error {
 	// This method will fully consume the message on the wire, regardless of if
@@ -61,7 +78,7 @@ func (m *PubSubMessage) UnmarshalRESP(br *bufio.Reader) error {
 	// fine, since the driver will still only allow the 5 commands, except PING
 	// will return a simple string when in the non-subscribed state. So this
 	// needs to check for that.
-	if prefix, err := br.Peek(1); err != nil {
+	if prefix, err := peekMessage(br); err != nil {
 		return err
 	} else if bytes.Equal(prefix, resp2.SimpleStringPrefix) {
 		// if it's a simple string, discard it (it's probably PONG) and error
@@ -107,7 +124,7 @@ func (m *PubSubMessage) UnmarshalRESP(br *bufio.Reader) error {
 				return err
 			}
 		}
-		return errNotPubSubMessage
+		return resp.ErrDiscarded{Err: errNotPubSubMessage}
 	}
 
 	var channel resp2.BulkString
@@ -116,11 +133,23 @@ func (m *PubSubMessage) UnmarshalRESP(br *bufio.Reader) error {
 	}
 	m.Channel = channel.S
 
+	if prefix, err := peekMessage(br); err != nil {
+		return err
+	} else if bytes.Equal(prefix, resp2.ArrayPrefix) {
+		m.Message = nil
+		if err := (resp2.Any{I: &m.Messages}).UnmarshalRESP(br); err != nil {
+			return err
+		} else if m.Messages == nil {
+			m.Messages = [][]byte{}
+		}
+		return nil
+	}
+
 	var msg resp2.BulkStringBytes
 	if err := msg.UnmarshalRESP(br); err != nil {
 		return err
 	}
-	m.Message = msg.B
+	m.Message, m.Messages = msg.B, nil
 
 	return nil
 }
@@ -309,7 +338,7 @@ func (c *pubSubConn) spin() {
 	for {
 		var m PubSubMessage
 		err := c.conn.Decode(&m)
-		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		if isTimeout(err) {
 			c.testEvent("timeout")
 			continue
 		} else if errors.Is(err, errNotPubSubMessage) {
@@ -159,6 +159,19 @@ func (s *pubSubStub) Close() error {
 	return s.closeErr
 }
 
+// encodeMessages buffers a PubSubMessage with an array payload directly, as it
+// can't be passed through the string based callback like other messages.
+func (s *pubSubStub) encodeMessages(m PubSubMessage) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if m.Type == "pmessage" && !s.psubbed[m.Pattern] {
+		return nil
+	} else if m.Type == "message" && !s.subbed[m.Channel] {
+		return nil
+	}
+	return s.Conn.(*stub).buffer.Encode(m)
+}
+
 func (s *pubSubStub) spin() {
 	for {
 		select {
@@ -173,7 +186,13 @@ func (s *pubSubStub) spin() {
 					m.Type = "pmessage"
 				}
 			}
-			if err := s.Conn.Encode(m); err != nil {
+			var err error
+			if m.Messages != nil {
+				err = s.encodeMessages(m)
+			} else {
+				err = s.Conn.Encode(m)
+			}
+			if err != nil {
 				panic(fmt.Sprintf("error encoding message in PubSubStub: %s", err))
 			}
 			select {
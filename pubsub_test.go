@@ -1,8 +1,11 @@
 package radix
 
 import (
+	"bufio"
+	"bytes"
 	"log"
 	"math/rand"
+	"net"
 	"strconv"
 	"sync"
 	. "testing"
@@ -10,6 +13,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
 
 func publish(t *T, c Conn, ch, msg string) {
@@ -262,6 +267,100 @@ func TestPubSubTimeout(t *T) {
 	assert.Equal(t, msgStr, string(msg.Message))
 }
 
+// Ensure that read timeouts while waiting for a message don't make the Conn
+// unusable
+func TestPubSubTimeoutStub(t *T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		defer l.Close()
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+
+		var args []string
+		if err := NewConn(nc).Decode(resp2.Any{I: &args}); err != nil {
+			return
+		}
+		nc.Write([]byte("*3\r\n$9\r\nsubscribe\r\n$3\r\nfoo\r\n:1\r\n"))
+		time.Sleep(100 * time.Millisecond)
+		nc.Write([]byte("*3\r\n$7\r\nmessage\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"))
+		nc.Read(make([]byte, 1))
+	}()
+
+	conn, err := Dial("tcp", l.Addr().String(), DialReadTimeout(30*time.Millisecond))
+	require.NoError(t, err)
+	c := PubSub(conn)
+	defer c.Close()
+	// buffered, as the read times out multiple times before the message
+	c.(*pubSubConn).testEventCh = make(chan string, 16)
+
+	msgCh := make(chan PubSubMessage, 1)
+	require.NoError(t, c.Subscribe(msgCh, "foo"))
+	assert.Equal(t, "timeout", <-c.(*pubSubConn).testEventCh)
+	msg := assertMsgRead(t, msgCh)
+	assert.Equal(t, "bar", string(msg.Message))
+	assert.NoError(t, ConnErr(conn))
+}
+
+// Ensure that with RESP3 the subscription confirmations and messages, which
+// are all sent as push frames, are handled by the PubSubConn instead of being
+// discarded as unrelated pushes.
+func TestPubSubResp3(t *T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	cmdCh := make(chan []string, 4)
+	go func() {
+		defer l.Close()
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+
+		c := NewConn(nc)
+		for {
+			var args []string
+			if err := c.Decode(resp2.Any{I: &args}); err != nil {
+				return
+			}
+			cmdCh <- args
+			switch args[0] {
+			case "HELLO":
+				nc.Write([]byte("%1\r\n$5\r\nproto\r\n:3\r\n"))
+			case "SUBSCRIBE":
+				nc.Write([]byte(">3\r\n$9\r\nsubscribe\r\n$3\r\nfoo\r\n:1\r\n"))
+			case "PING":
+				// in RESP3 regular commands can be used while subscribed
+				nc.Write([]byte(">3\r\n$7\r\nmessage\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"))
+				nc.Write([]byte("+PONG\r\n"))
+			case "UNSUBSCRIBE":
+				nc.Write([]byte(">3\r\n$11\r\nunsubscribe\r\n$3\r\nfoo\r\n:0\r\n"))
+			}
+		}
+	}()
+
+	conn, err := Dial("tcp", l.Addr().String(), DialProtocol(3))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HELLO", "3"}, <-cmdCh)
+	c := PubSub(conn)
+	defer c.Close()
+
+	msgCh := make(chan PubSubMessage, 1)
+	require.NoError(t, c.Subscribe(msgCh, "foo"))
+	assert.Equal(t, []string{"SUBSCRIBE", "foo"}, <-cmdCh)
+	require.NoError(t, c.Ping())
+	assert.Equal(t, []string{"PING"}, <-cmdCh)
+	msg := assertMsgRead(t, msgCh)
+	assert.Equal(t, PubSubMessage{Type: "message", Channel: "foo", Message: []byte("bar")}, msg)
+
+	require.NoError(t, c.Unsubscribe(msgCh, "foo"))
+	assert.Equal(t, []string{"UNSUBSCRIBE", "foo"}, <-cmdCh)
+	assert.NoError(t, ConnErr(conn))
+}
+
 // This attempts to catch weird race conditions which might occur due to
 // subscribing/unsubscribing quickly on an active channel.
 func TestPubSubChaotic(t *T) {
@@ -399,3 +498,18 @@ func ExamplePersistentPubSub_cluster() {
 		log.Printf("publish to channel %q received: %q", msg.Channel, msg.Message)
 	}
 }
+
+func TestPubSubMessageArray(t *T) {
+	for _, m := range []PubSubMessage{
+		{Type: "message", Channel: "foo", Message: []byte("bar")},
+		{Type: "message", Channel: "foo", Messages: [][]byte{[]byte("a"), []byte("b")}},
+		{Type: "message", Channel: "foo", Messages: [][]byte{}},
+	} {
+		buf := new(bytes.Buffer)
+		require.Nil(t, m.MarshalRESP(buf))
+
+		var into PubSubMessage
+		require.Nil(t, into.UnmarshalRESP(bufio.NewReader(buf)))
+		assert.Equal(t, m, into)
+	}
+}
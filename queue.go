@@ -0,0 +1,273 @@
+package radix
+
+import (
+	"strconv"
+	"time"
+)
+
+type reliableQueueOpts struct {
+	pollTimeout     time.Duration
+	consumerTimeout time.Duration
+	sweepInterval   time.Duration
+}
+
+// ReliableQueueOpt is an optional behavior which can be applied to the
+// NewReliableQueue function to effect a ReliableQueue's behavior.
+type ReliableQueueOpt func(*reliableQueueOpts)
+
+// ReliableQueuePollTimeout sets the timeout of each BLMOVE performed by
+// Consume, which determines how quickly Consume returns once it was stopped
+// while the queue is empty.
+func ReliableQueuePollTimeout(d time.Duration) ReliableQueueOpt {
+	return func(o *reliableQueueOpts) {
+		o.pollTimeout = d
+	}
+}
+
+// ReliableQueueConsumerTimeout sets the duration after which a consumer is
+// considered to have crashed if it didn't send a heartbeat, after which the
+// items it was processing are re-queued by the sweeper. Consumers send a
+// heartbeat every third of this duration. Durations shorter than a millisecond
+// are ignored.
+func ReliableQueueConsumerTimeout(d time.Duration) ReliableQueueOpt {
+	return func(o *reliableQueueOpts) {
+		if d >= time.Millisecond {
+			o.consumerTimeout = d
+		}
+	}
+}
+
+// ReliableQueueSweepInterval sets the interval at which Consume calls Sweep in
+// the background. If zero the sweeper is disabled, in which case Sweep must be
+// called manually in order to recover the items of crashed consumers.
+func ReliableQueueSweepInterval(d time.Duration) ReliableQueueOpt {
+	return func(o *reliableQueueOpts) {
+		o.sweepInterval = d
+	}
+}
+
+// ReliableQueue implements the reliable queue pattern on top of redis lists,
+// providing at-least-once processing of the items of a queue.
+//
+// Consumers atomically move each item from the queue into their own
+// processing list using BLMOVE, and only remove it from there once it was
+// processed successfully. If a consumer crashes while processing an item, the
+// item remains in its processing list and is moved back into the queue by the
+// sweeper once the consumer's heartbeat expired. An item may therefore be
+// processed more than once.
+//
+// Apart from the list at the key of the queue, a ReliableQueue uses the
+// following keys:
+//
+//	<key>:consumers              set of the names of all known consumers
+//	<key>:processing:<consumer>  processing list of a consumer
+//	<key>:heartbeat:<consumer>   heartbeat of a consumer
+//
+// When used with a Cluster the key must contain a hash tag, e.g. "{jobs}", so
+// that all of these keys belong to the same slot.
+type ReliableQueue struct {
+	c    Client
+	key  string
+	opts reliableQueueOpts
+}
+
+// NewReliableQueue returns a ReliableQueue for the list at the given key.
+//
+// NewReliableQueue takes in a number of options which can overwrite its
+// default behavior. The default options NewReliableQueue uses are:
+//
+//	ReliableQueuePollTimeout(1 * time.Second)
+//	ReliableQueueConsumerTimeout(30 * time.Second)
+//	ReliableQueueSweepInterval(30 * time.Second)
+//
+func NewReliableQueue(c Client, key string, opts ...ReliableQueueOpt) *ReliableQueue {
+	q := &ReliableQueue{c: c, key: key}
+	defaultReliableQueueOpts := []ReliableQueueOpt{
+		ReliableQueuePollTimeout(1 * time.Second),
+		ReliableQueueConsumerTimeout(30 * time.Second),
+		ReliableQueueSweepInterval(30 * time.Second),
+	}
+	for _, opt := range append(defaultReliableQueueOpts, opts...) {
+		opt(&q.opts)
+	}
+	return q
+}
+
+func (q *ReliableQueue) consumersKey() string {
+	return q.key + ":consumers"
+}
+
+func (q *ReliableQueue) processingKey(consumer string) string {
+	return q.key + ":processing:" + consumer
+}
+
+func (q *ReliableQueue) heartbeatKey(consumer string) string {
+	return q.key + ":heartbeat:" + consumer
+}
+
+// Push adds the given items to the end of the queue.
+func (q *ReliableQueue) Push(items ...string) error {
+	return q.c.Do(Cmd(nil, "LPUSH", append([]string{q.key}, items...)...))
+}
+
+// the keys are the processing list, the queue, the consumers set and the
+// heartbeat key, the args the name of the consumer and whether to requeue even
+// if the consumer's heartbeat didn't expire yet. Items are requeued at the
+// front of the queue, oldest first.
+var requeueProcessingScript = NewEvalScript(4, `
+	if ARGV[2] ~= "1" and redis.call("EXISTS", KEYS[4]) == 1 then
+		return -1
+	end
+	local n = 0
+	while true do
+		local item = redis.call("LPOP", KEYS[1])
+		if not item then
+			break
+		end
+		redis.call("RPUSH", KEYS[2], item)
+		n = n + 1
+	end
+	if ARGV[2] ~= "1" then
+		redis.call("SREM", KEYS[3], ARGV[1])
+	end
+	return n
+`)
+
+// the keys are the processing list and the queue, the arg is the item, which
+// is only moved to the end of the queue if it's still in the processing list.
+var requeueItemScript = NewEvalScript(2, `
+	if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 1 then
+		redis.call("LPUSH", KEYS[2], ARGV[1])
+	end
+	return redis.status_reply("OK")
+`)
+
+func (q *ReliableQueue) requeueProcessing(consumer string, force bool) (int64, error) {
+	forceStr := "0"
+	if force {
+		forceStr = "1"
+	}
+	var n int64
+	err := q.c.Do(requeueProcessingScript.Cmd(&n,
+		q.processingKey(consumer), q.key, q.consumersKey(), q.heartbeatKey(consumer),
+		consumer, forceStr,
+	))
+	return n, err
+}
+
+// Sweep moves the items of all consumers whose heartbeat expired from their
+// processing lists back to the front of the queue, and returns the number of
+// requeued items. It is called periodically by Consume, see
+// ReliableQueueSweepInterval.
+func (q *ReliableQueue) Sweep() (int64, error) {
+	var consumers []string
+	if err := q.c.Do(Cmd(&consumers, "SMEMBERS", q.consumersKey())); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, consumer := range consumers {
+		n, err := q.requeueProcessing(consumer, false)
+		if err != nil {
+			return total, err
+		} else if n > 0 {
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// heartbeat also adds the consumer to the consumers set, as Sweep removes it
+// from there if a heartbeat expired without the consumer having crashed, e.g.
+// because it was partitioned from redis for a while. Without this the
+// consumer's processing list would never be swept again.
+func (q *ReliableQueue) heartbeat(consumer string) error {
+	ms := strconv.FormatInt(int64(q.opts.consumerTimeout/time.Millisecond), 10)
+	return q.c.Do(Pipeline(
+		Cmd(nil, "SADD", q.consumersKey(), consumer),
+		Cmd(nil, "SET", q.heartbeatKey(consumer), "1", "PX", ms),
+	))
+}
+
+// Consume processes the items of the queue using the given handler, until
+// stopCh is closed or an error occurs. Each item is moved into the processing
+// list of the given consumer before calling handler, and removed from it once
+// handler returned nil. If handler returns an error the item is moved back to
+// the end of the queue instead.
+//
+// The name of the consumer must be unique among all consumers of the queue,
+// but should be stable across restarts, e.g. the hostname. When Consume is
+// called any items left in the consumer's processing list, e.g. by a previous
+// crash, are moved back to the front of the queue first.
+//
+// While Consume is running it sends heartbeats for the consumer (see
+// ReliableQueueConsumerTimeout) and periodically calls Sweep (see
+// ReliableQueueSweepInterval) in the background. Errors returned from these
+// background calls are ignored, as they are retried regularly.
+//
+// Consume returns nil if it was stopped using stopCh. In that case the item
+// currently being processed, if any, is processed to completion first.
+func (q *ReliableQueue) Consume(consumer string, stopCh <-chan struct{}, handler func(item string) error) error {
+	if _, err := q.requeueProcessing(consumer, true); err != nil {
+		return err
+	} else if err := q.heartbeat(consumer); err != nil {
+		return err
+	}
+
+	doneCh, exitedCh := make(chan struct{}), make(chan struct{})
+	go q.background(consumer, doneCh, exitedCh)
+	defer func() {
+		close(doneCh)
+		<-exitedCh
+	}()
+
+	processing := q.processingKey(consumer)
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+
+		item, ok, err := BLMove(q.c, q.key, processing, ListRight, ListLeft, q.opts.pollTimeout)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		if handler(item) != nil {
+			err = q.c.Do(requeueItemScript.Cmd(nil, processing, q.key, item))
+		} else {
+			err = q.c.Do(Cmd(nil, "LREM", processing, "1", item))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (q *ReliableQueue) background(consumer string, doneCh <-chan struct{}, exitedCh chan<- struct{}) {
+	defer close(exitedCh)
+
+	heartbeatTicker := time.NewTicker(q.opts.consumerTimeout / 3)
+	defer heartbeatTicker.Stop()
+
+	var sweepCh <-chan time.Time
+	if q.opts.sweepInterval > 0 {
+		sweepTicker := time.NewTicker(q.opts.sweepInterval)
+		defer sweepTicker.Stop()
+		sweepCh = sweepTicker.C
+	}
+
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-heartbeatTicker.C:
+			_ = q.heartbeat(consumer)
+		case <-sweepCh:
+			_, _ = q.Sweep()
+		}
+	}
+}
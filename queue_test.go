@@ -0,0 +1,199 @@
+package radix
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// queueStub emulates the commands and scripts used by ReliableQueue. Lists
+// are stored with their left end first.
+type queueStub struct {
+	l        sync.Mutex
+	lists    map[string][]string
+	sets     map[string]map[string]bool
+	expireAt map[string]time.Time
+}
+
+func (s *queueStub) fn(args []string) interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "LPUSH":
+		for _, item := range args[2:] {
+			s.lists[args[1]] = append([]string{item}, s.lists[args[1]]...)
+		}
+		return len(s.lists[args[1]])
+	case "BLMOVE":
+		src, dst := s.lists[args[1]], args[2]
+		if len(src) == 0 {
+			s.l.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			s.l.Lock()
+			return resp2.Array{}
+		}
+		item := src[len(src)-1]
+		s.lists[args[1]] = src[:len(src)-1]
+		s.lists[dst] = append([]string{item}, s.lists[dst]...)
+		return item
+	case "LREM":
+		return s.lrem(args[1], args[3])
+	case "SADD":
+		if s.sets[args[1]] == nil {
+			s.sets[args[1]] = map[string]bool{}
+		}
+		s.sets[args[1]][args[2]] = true
+		return 1
+	case "SMEMBERS":
+		var members []string
+		for member := range s.sets[args[1]] {
+			members = append(members, member)
+		}
+		return members
+	case "SET":
+		ms, _ := strconv.Atoi(args[4])
+		s.expireAt[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return resp2.SimpleString{S: "OK"}
+	case "EVALSHA":
+		return resp2.Error{E: errors.New("NOSCRIPT")}
+	case "EVAL":
+		if args[2] == "2" {
+			// requeueItemScript
+			if s.lrem(args[3], args[5]) == 1 {
+				s.lists[args[4]] = append([]string{args[5]}, s.lists[args[4]]...)
+			}
+			return resp2.SimpleString{S: "OK"}
+		}
+		// requeueProcessingScript
+		processing, queue, consumers, heartbeat := args[3], args[4], args[5], args[6]
+		consumer, force := args[7], args[8] == "1"
+		if !force && time.Now().Before(s.expireAt[heartbeat]) {
+			return -1
+		}
+		items := s.lists[processing]
+		for i := 0; i < len(items); i++ {
+			s.lists[queue] = append(s.lists[queue], items[i])
+		}
+		delete(s.lists, processing)
+		if !force {
+			delete(s.sets[consumers], consumer)
+		}
+		return len(items)
+	}
+	return errors.Errorf("unexpected command %q", args)
+}
+
+func (s *queueStub) lrem(key, item string) int {
+	list := s.lists[key]
+	for i := range list {
+		if list[i] == item {
+			s.lists[key] = append(list[:i:i], list[i+1:]...)
+			return 1
+		}
+	}
+	return 0
+}
+
+func TestReliableQueue(t *T) {
+	s := &queueStub{
+		lists: map[string][]string{
+			// left over from a previous run of w1, and from a consumer which
+			// crashed
+			"q:processing:w1":   {"y"},
+			"q:processing:dead": {"x"},
+		},
+		sets:     map[string]map[string]bool{"q:consumers": {"dead": true}},
+		expireAt: map[string]time.Time{},
+	}
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 2, PoolConnFunc(func(network, addr string) (Conn, error) {
+		return Stub(network, addr, s.fn), nil
+	}))
+	require.NoError(t, err)
+	defer pool.Close()
+
+	q := NewReliableQueue(pool, "q",
+		ReliableQueuePollTimeout(10*time.Millisecond),
+		ReliableQueueConsumerTimeout(90*time.Millisecond),
+		ReliableQueueSweepInterval(20*time.Millisecond),
+	)
+	require.NoError(t, q.Push("a", "b", "c"))
+
+	var handled []string
+	stopCh := make(chan struct{})
+	failedB := false
+	err = q.Consume("w1", stopCh, func(item string) error {
+		handled = append(handled, item)
+		if item == "b" && !failedB {
+			failedB = true
+			return errors.New("failed")
+		}
+		if len(handled) == 6 {
+			close(stopCh)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// y was left over in the consumer's own processing list and is therefore
+	// processed first, the failed b is processed again after c
+	assert.Equal(t, []string{"y", "a", "b", "c", "b"}, handled[:5])
+	assert.Equal(t, "x", handled[5])
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	assert.Empty(t, s.lists["q"])
+	assert.Empty(t, s.lists["q:processing:w1"])
+	assert.Empty(t, s.lists["q:processing:dead"])
+	assert.Equal(t, map[string]bool{"w1": true}, s.sets["q:consumers"])
+}
+
+func TestReliableQueueSweepResumedConsumer(t *T) {
+	s := &queueStub{
+		lists:    map[string][]string{},
+		sets:     map[string]map[string]bool{},
+		expireAt: map[string]time.Time{},
+	}
+	q := NewReliableQueue(Stub("tcp", "127.0.0.1:6379", s.fn), "q")
+	expire := func() {
+		s.l.Lock()
+		defer s.l.Unlock()
+		s.expireAt["q:heartbeat:w1"] = time.Now().Add(-time.Second)
+	}
+
+	// the heartbeat of w1 expires without it having crashed, so it's removed
+	// from the consumers by the sweep
+	require.NoError(t, q.heartbeat("w1"))
+	expire()
+	n, err := q.Sweep()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	assert.Empty(t, s.sets["q:consumers"])
+
+	// once w1 resumes sending heartbeats it must be known again
+	require.NoError(t, q.heartbeat("w1"))
+	assert.Equal(t, map[string]bool{"w1": true}, s.sets["q:consumers"])
+
+	// so that its processing list is recovered after it actually crashed
+	s.lists["q:processing:w1"] = []string{"a"}
+	expire()
+	n, err = q.Sweep()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, []string{"a"}, s.lists["q"])
+	assert.Empty(t, s.lists["q:processing:w1"])
+}
+
+func TestReliableQueueConsumerTimeout(t *T) {
+	for _, d := range []time.Duration{0, -time.Second, time.Microsecond} {
+		q := NewReliableQueue(nil, "q", ReliableQueueConsumerTimeout(d))
+		assert.Equal(t, 30*time.Second, q.opts.consumerTimeout, "d: %v", d)
+	}
+}
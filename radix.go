@@ -34,6 +34,10 @@
 //	var barI int
 //	err := client.Do(radix.Cmd(&barI, "INCR", "bar"))
 //
+//	// replies of 0 and 1 are unmarshaled into false and true respectively
+//	var isMember bool
+//	err := client.Do(radix.Cmd(&isMember, "SISMEMBER", "qux", "someval"))
+//
 //	var bazEls []string
 //	err := client.Do(radix.Cmd(&bazEls, "LRANGE", "baz", "0", "-1"))
 //
@@ -42,7 +46,8 @@
 //
 // FlatCmd can also be used if you wish to use non-string arguments like
 // integers, slices, maps, or structs, and have them automatically be flattened
-// into a single string slice.
+// into a single string slice. CmdBytes can be used to pass binary arguments as
+// byte slices, without converting them to strings first.
 //
 // Struct Scanning
 //
@@ -86,9 +91,10 @@
 // Transactions
 //
 // There are two ways to perform transactions in redis. The first is with the
-// MULTI/EXEC commands, which can be done using the WithConn Action (see its
-// example). The second is using EVAL with lua scripting, which can be done
-// using the EvalScript Action (again, see its example).
+// MULTI/EXEC commands, which can be done using the Txn Action, or the WatchTxn
+// Action if WATCH is needed, which also retries aborted transactions. The
+// second is using EVAL with lua scripting, which can be done using the
+// EvalScript Action (again, see its example).
 //
 // EVAL with lua scripting is recommended in almost all cases. It only requires
 // a single round-trip, it's infinitely more flexible than MULTI/EXEC, it's
@@ -0,0 +1,472 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// ErrReadOnly is returned by Conns created using ReadOnlyConn when a write
+// command is about to be sent.
+var ErrReadOnly = errors.New("write command on read-only connection")
+
+// writeCmds contains the commands of redis itself, as of redis 8.0, which are
+// flagged as "write" in the reply of COMMAND INFO.
+var writeCmds = map[string]bool{
+	"APPEND":      true,
+	"BITFIELD":    true,
+	"BITOP":       true,
+	"COPY":        true,
+	"DECR":        true,
+	"DECRBY":      true,
+	"DEL":         true,
+	"GETDEL":      true,
+	"GETEX":       true,
+	"GETSET":      true,
+	"INCR":        true,
+	"INCRBY":      true,
+	"INCRBYFLOAT": true,
+	"MSET":        true,
+	"MSETNX":      true,
+	"PSETEX":      true,
+	"SET":         true,
+	"SETBIT":      true,
+	"SETEX":       true,
+	"SETNX":       true,
+	"SETRANGE":    true,
+	"UNLINK":      true,
+
+	"EXPIRE":         true,
+	"EXPIREAT":       true,
+	"MIGRATE":        true,
+	"MOVE":           true,
+	"PERSIST":        true,
+	"PEXPIRE":        true,
+	"PEXPIREAT":      true,
+	"RENAME":         true,
+	"RENAMENX":       true,
+	"RESTORE":        true,
+	"RESTORE-ASKING": true,
+	"SORT":           true,
+
+	"FLUSHALL": true,
+	"FLUSHDB":  true,
+	"SWAPDB":   true,
+
+	"HDEL":         true,
+	"HEXPIRE":      true,
+	"HEXPIREAT":    true,
+	"HGETDEL":      true,
+	"HGETEX":       true,
+	"HINCRBY":      true,
+	"HINCRBYFLOAT": true,
+	"HMSET":        true,
+	"HPERSIST":     true,
+	"HPEXPIRE":     true,
+	"HPEXPIREAT":   true,
+	"HSET":         true,
+	"HSETEX":       true,
+	"HSETNX":       true,
+
+	"BLMOVE":     true,
+	"BLMPOP":     true,
+	"BLPOP":      true,
+	"BRPOP":      true,
+	"BRPOPLPUSH": true,
+	"LINSERT":    true,
+	"LMOVE":      true,
+	"LMPOP":      true,
+	"LPOP":       true,
+	"LPUSH":      true,
+	"LPUSHX":     true,
+	"LREM":       true,
+	"LSET":       true,
+	"LTRIM":      true,
+	"RPOP":       true,
+	"RPOPLPUSH":  true,
+	"RPUSH":      true,
+	"RPUSHX":     true,
+
+	"SADD":        true,
+	"SDIFFSTORE":  true,
+	"SINTERSTORE": true,
+	"SMOVE":       true,
+	"SPOP":        true,
+	"SREM":        true,
+	"SUNIONSTORE": true,
+
+	"BZMPOP":           true,
+	"BZPOPMAX":         true,
+	"BZPOPMIN":         true,
+	"ZADD":             true,
+	"ZDIFFSTORE":       true,
+	"ZINCRBY":          true,
+	"ZINTERSTORE":      true,
+	"ZMPOP":            true,
+	"ZPOPMAX":          true,
+	"ZPOPMIN":          true,
+	"ZRANGESTORE":      true,
+	"ZREM":             true,
+	"ZREMRANGEBYLEX":   true,
+	"ZREMRANGEBYRANK":  true,
+	"ZREMRANGEBYSCORE": true,
+	"ZUNIONSTORE":      true,
+
+	"GEOADD":            true,
+	"GEORADIUS":         true,
+	"GEORADIUSBYMEMBER": true,
+	"GEOSEARCHSTORE":    true,
+
+	"PFADD":   true,
+	"PFDEBUG": true,
+	"PFMERGE": true,
+
+	"XACK":       true,
+	"XADD":       true,
+	"XAUTOCLAIM": true,
+	"XCLAIM":     true,
+	"XDEL":       true,
+	"XGROUP":     true,
+	"XREADGROUP": true,
+	"XSETID":     true,
+	"XTRIM":      true,
+}
+
+// IsWriteCommand returns true if the given command may modify data, i.e. if
+// it is flagged as "write" by redis, and would therefore be rejected by a
+// read-only replica. The command name is case-insensitive.
+//
+// IsWriteCommand uses a fixed list of the commands of redis 8.0, so commands
+// of modules or of newer redis versions are never considered write commands.
+// WriteCommands can be used to get the write commands from the redis instance
+// itself instead.
+func IsWriteCommand(cmd string) bool {
+	return writeCmds[strings.ToUpper(cmd)]
+}
+
+// WriteCommands performs COMMAND using the given Client and returns a function
+// which, like IsWriteCommand, returns true if the given command is flagged as
+// "write", based on the reply. Unlike IsWriteCommand this includes the commands
+// of modules and of redis versions newer than the one IsWriteCommand knows
+// about. A command with subcommands, e.g. XGROUP, is considered a write
+// command if any of its subcommands is one.
+//
+// The returned function can be passed to ReadOnlyConn, for example.
+func WriteCommands(c Client) (func(cmd string) bool, error) {
+	var infos []commandFlags
+	if err := c.Do(Cmd(&infos, "COMMAND")); err != nil {
+		return nil, err
+	}
+
+	cmds := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		if info.write {
+			cmds[strings.ToUpper(info.name)] = true
+		}
+	}
+	return func(cmd string) bool {
+		return cmds[strings.ToUpper(cmd)]
+	}, nil
+}
+
+// commandFlags unmarshals the name of a command, and whether it or any of its
+// subcommands is flagged as "write", from an element of the reply of COMMAND.
+type commandFlags struct {
+	name  string
+	write bool
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (cf *commandFlags) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 3 {
+		err := resp.ErrDiscarded{Err: errors.Errorf("invalid COMMAND reply with %d elements", ah.N)}
+		return discardAfterErr(br, ah.N, err)
+	}
+
+	*cf = commandFlags{}
+	var flags []string
+	if err := (resp2.Any{I: &cf.name}).UnmarshalRESP(br); err != nil {
+		return discardAfterErr(br, ah.N-1, err)
+	} else if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+		return discardAfterErr(br, ah.N-2, err)
+	} else if err := (resp2.Any{I: &flags}).UnmarshalRESP(br); err != nil {
+		return discardAfterErr(br, ah.N-3, err)
+	}
+	for _, flag := range flags {
+		cf.write = cf.write || flag == "write"
+	}
+
+	// since redis 7 the 10th element contains the subcommands, in the same
+	// format as the commands themselves
+	for i := 3; i < ah.N; i++ {
+		var rcv interface{}
+		var subCmds []commandFlags
+		if i == 9 {
+			rcv = &subCmds
+		}
+		if err := (resp2.Any{I: rcv}).UnmarshalRESP(br); err != nil {
+			return discardAfterErr(br, ah.N-i-1, err)
+		}
+		for _, subCmd := range subCmds {
+			cf.write = cf.write || subCmd.write
+		}
+	}
+	return nil
+}
+
+// idempotentCmds contains commands which only read data and have no other side
+// effects, and can therefore safely be sent again if it's unknown whether they
+// were processed.
+var idempotentCmds = map[string]bool{
+	"BITCOUNT": true,
+	"BITPOS":   true,
+	"GET":      true,
+	"GETBIT":   true,
+	"GETRANGE": true,
+	"MGET":     true,
+	"STRLEN":   true,
+
+	"DBSIZE":    true,
+	"DUMP":      true,
+	"EXISTS":    true,
+	"KEYS":      true,
+	"PTTL":      true,
+	"RANDOMKEY": true,
+	"SCAN":      true,
+	"TTL":       true,
+	"TYPE":      true,
+
+	"HEXISTS": true,
+	"HGET":    true,
+	"HGETALL": true,
+	"HKEYS":   true,
+	"HLEN":    true,
+	"HMGET":   true,
+	"HSCAN":   true,
+	"HSTRLEN": true,
+	"HVALS":   true,
+
+	"LINDEX": true,
+	"LLEN":   true,
+	"LPOS":   true,
+	"LRANGE": true,
+
+	"SCARD":       true,
+	"SDIFF":       true,
+	"SINTER":      true,
+	"SISMEMBER":   true,
+	"SMEMBERS":    true,
+	"SMISMEMBER":  true,
+	"SRANDMEMBER": true,
+	"SSCAN":       true,
+	"SUNION":      true,
+
+	"ZCARD":            true,
+	"ZCOUNT":           true,
+	"ZLEXCOUNT":        true,
+	"ZMSCORE":          true,
+	"ZRANGE":           true,
+	"ZRANGEBYLEX":      true,
+	"ZRANGEBYSCORE":    true,
+	"ZRANK":            true,
+	"ZREVRANGE":        true,
+	"ZREVRANGEBYLEX":   true,
+	"ZREVRANGEBYSCORE": true,
+	"ZREVRANK":         true,
+	"ZSCAN":            true,
+	"ZSCORE":           true,
+
+	"GEODIST":   true,
+	"GEOHASH":   true,
+	"GEOPOS":    true,
+	"GEOSEARCH": true,
+
+	"XLEN":      true,
+	"XRANGE":    true,
+	"XREVRANGE": true,
+
+	"ECHO": true,
+	"PING": true,
+	"TIME": true,
+}
+
+// IsIdempotentCommand returns true if the given command only reads data and
+// has no other side effects, meaning it can safely be retried if it's unknown
+// whether redis processed it, e.g. after a connection error. The command name is
+// case-insensitive.
+//
+// Note that commands which aren't write commands, see IsWriteCommand, aren't
+// necessarily idempotent, e.g. PUBLISH or EVAL.
+func IsIdempotentCommand(cmd string) bool {
+	return idempotentCmds[strings.ToUpper(cmd)]
+}
+
+type readOnlyConn struct {
+	Conn
+	isWrite func(string) bool
+}
+
+// ReadOnlyConn wraps the given Conn such that any write command passed to
+// Encode is rejected with ErrReadOnly, without being sent to redis. This is
+// useful when connecting to read-only replicas, where a write command would
+// otherwise fail with a less obvious READONLY error from redis.
+//
+// isWrite is used to determine whether a command is a write command. If nil
+// IsWriteCommand is used. Commands within scripts are not checked.
+//
+// To use ReadOnlyConn with a Pool, wrap the Conns created by its ConnFunc:
+//
+//	connFunc := func(network, addr string) (radix.Conn, error) {
+//		conn, err := radix.Dial(network, addr)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return radix.ReadOnlyConn(conn, nil), nil
+//	}
+//
+func ReadOnlyConn(conn Conn, isWrite func(cmd string) bool) Conn {
+	if isWrite == nil {
+		isWrite = IsWriteCommand
+	}
+	return &readOnlyConn{Conn: conn, isWrite: isWrite}
+}
+
+func (roc *readOnlyConn) Do(a Action) error {
+	return a.Run(roc)
+}
+
+func (roc *readOnlyConn) Encode(m resp.Marshaler) error {
+	if cmd, ok := m.(*cmdAction); ok {
+		if err := roc.check(cmd.cmd); err != nil {
+			return err
+		}
+		return roc.Conn.Encode(m)
+	}
+
+	// for all other Marshalers (e.g. Pipelines) the commands can only be
+	// determined by marshaling them first. The marshaled commands are then
+	// sent as-is, so that the Marshaler is only marshaled once.
+	buf := new(bytes.Buffer)
+	if err := m.MarshalRESP(buf); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		var args []string
+		if err := (resp2.Any{I: &args}).UnmarshalRESP(br); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		} else if len(args) == 0 {
+			continue
+		} else if err := roc.check(args[0]); err != nil {
+			return err
+		}
+	}
+	return roc.Conn.Encode(resp2.RawMessage(buf.Bytes()))
+}
+
+func (roc *readOnlyConn) check(cmd string) error {
+	if roc.isWrite(cmd) {
+		return errors.Errorf("refusing to send %s: %w", strings.ToUpper(cmd), ErrReadOnly)
+	}
+	return nil
+}
+
+// ReadPolicy determines which instances Cluster and Sentinel perform read-only
+// commands on, when they're passed to Do. See ClusterReadPolicy and
+// SentinelReadPolicy.
+type ReadPolicy int
+
+// All possible values of ReadPolicy.
+const (
+	// ReadPolicyPrimary performs all commands on the primary. This is the
+	// default.
+	ReadPolicyPrimary ReadPolicy = iota
+
+	// ReadPolicyPreferReplica performs read-only commands on a random replica,
+	// or on the primary if there is no replica.
+	ReadPolicyPreferReplica
+
+	// ReadPolicyReplicaOnly performs read-only commands on a random replica,
+	// and fails with ErrNoReplica if there is no replica.
+	ReadPolicyReplicaOnly
+
+	// ReadPolicyNearest performs read-only commands on the instance with the
+	// lowest latency, whether it's the primary or a replica. Latencies are
+	// only known for Clients with a Latency method, like *Pool, and instances
+	// without a known latency are skipped. If no latency is known at all the
+	// primary is used.
+	ReadPolicyNearest
+)
+
+// ErrNoReplica is returned when a read-only command can't be performed because
+// ReadPolicyReplicaOnly is used and there is no replica.
+var ErrNoReplica = errors.New("no replica available")
+
+// readRouter decides whether an Action is subject to its ReadPolicy.
+type readRouter struct {
+	policy ReadPolicy
+	isRead func(string) bool
+}
+
+func newReadRouter(policy ReadPolicy, isRead func(cmd string) bool) readRouter {
+	if isRead == nil {
+		isRead = IsIdempotentCommand
+	}
+	return readRouter{policy: policy, isRead: isRead}
+}
+
+// routed returns true if the Action is a read-only command which the policy
+// applies to. Only commands created using Cmd or FlatCmd, possibly wrapped
+// using WithCommandName, are considered, and never those wrapped using Primary.
+func (rr readRouter) routed(a Action) bool {
+	if rr.policy == ReadPolicyPrimary {
+		return false
+	}
+	_, inner := commandName(a)
+	cmd, ok := inner.(*cmdAction)
+	return ok && rr.isRead(cmd.cmd)
+}
+
+// pick returns the address the policy chooses among the primary and its
+// replicas, using latency to get the latency of an address.
+func (rr readRouter) pick(primAddr string, secAddrs []string, latency func(string) time.Duration) (string, error) {
+	switch rr.policy {
+	case ReadPolicyPreferReplica, ReadPolicyReplicaOnly:
+		if len(secAddrs) > 0 {
+			return secAddrs[rand.Intn(len(secAddrs))], nil
+		} else if rr.policy == ReadPolicyReplicaOnly {
+			return "", ErrNoReplica
+		}
+	case ReadPolicyNearest:
+		addr, min := primAddr, latency(primAddr)
+		for _, secAddr := range secAddrs {
+			if l := latency(secAddr); l > 0 && (min <= 0 || l < min) {
+				addr, min = secAddr, l
+			}
+		}
+		return addr, nil
+	}
+	return primAddr, nil
+}
+
+// clientLatency returns the latency of the given Client, or 0 if it's unknown.
+func clientLatency(client Client) time.Duration {
+	if lc, ok := client.(interface{ Latency() time.Duration }); ok {
+		return lc.Latency()
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+package radix
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestReadOnlyConn(t *T) {
+	var got [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = append(got, args)
+		return "bar"
+	})
+
+	conn := ReadOnlyConn(stub, nil)
+
+	var res string
+	require.NoError(t, conn.Do(Cmd(&res, "GET", "foo")))
+	assert.Equal(t, "bar", res)
+
+	err := conn.Do(Cmd(nil, "SET", "foo", "bar"))
+	assert.True(t, errors.Is(err, ErrReadOnly))
+	assert.Equal(t, "refusing to send SET: write command on read-only connection", err.Error())
+
+	err = conn.Do(FlatCmd(nil, "hset", "foo", map[string]int{"a": 1}))
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	var res1, res2 string
+	require.NoError(t, conn.Do(Pipeline(
+		Cmd(&res1, "GET", "foo"),
+		Cmd(&res2, "HGET", "foo", "a"),
+	)))
+	assert.Equal(t, "bar", res1)
+	assert.Equal(t, "bar", res2)
+
+	err = conn.Do(Pipeline(
+		Cmd(nil, "GET", "foo"),
+		Cmd(nil, "DEL", "foo"),
+	))
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	err = conn.Do(WithConn("foo", func(conn Conn) error {
+		return conn.Do(Cmd(nil, "INCR", "foo"))
+	}))
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	assert.Equal(t, [][]string{
+		{"GET", "foo"},
+		{"GET", "foo"},
+		{"HGET", "foo", "a"},
+	}, got)
+
+	t.Run("custom", func(t *T) {
+		conn := ReadOnlyConn(stub, func(cmd string) bool {
+			return cmd == "GET" || IsWriteCommand(cmd)
+		})
+		err := conn.Do(Cmd(nil, "GET", "foo"))
+		assert.True(t, errors.Is(err, ErrReadOnly))
+		assert.NoError(t, conn.Do(Cmd(nil, "HGET", "foo", "a")))
+	})
+}
+
+func TestReadRouter(t *T) {
+	latencies := map[string]time.Duration{"prim": 0, "sec1": 3, "sec2": 2}
+	latency := func(addr string) time.Duration { return latencies[addr] }
+	secAddrs := []string{"sec1", "sec2"}
+
+	rr := newReadRouter(ReadPolicyPrimary, nil)
+	assert.False(t, rr.routed(Cmd(nil, "GET", "foo")))
+	addr, err := rr.pick("prim", secAddrs, latency)
+	require.NoError(t, err)
+	assert.Equal(t, "prim", addr)
+
+	rr = newReadRouter(ReadPolicyPreferReplica, nil)
+	assert.True(t, rr.routed(Cmd(nil, "GET", "foo")))
+	assert.True(t, rr.routed(WithCommandName(FlatCmd(nil, "GET", "foo"), "get-foo")))
+	assert.False(t, rr.routed(Cmd(nil, "SET", "foo", "bar")))
+	assert.False(t, rr.routed(Primary(Cmd(nil, "GET", "foo"))))
+	assert.False(t, rr.routed(Pipeline(Cmd(nil, "GET", "foo"))))
+	addr, err = rr.pick("prim", secAddrs, latency)
+	require.NoError(t, err)
+	assert.Contains(t, secAddrs, addr)
+	addr, err = rr.pick("prim", nil, latency)
+	require.NoError(t, err)
+	assert.Equal(t, "prim", addr)
+
+	rr = newReadRouter(ReadPolicyReplicaOnly, func(cmd string) bool { return cmd == "FOO" })
+	assert.True(t, rr.routed(Cmd(nil, "FOO")))
+	assert.False(t, rr.routed(Cmd(nil, "GET", "foo")))
+	_, err = rr.pick("prim", nil, latency)
+	assert.Equal(t, ErrNoReplica, err)
+
+	// instances with an unknown latency are skipped, and the primary is used if
+	// no latency is known at all
+	rr = newReadRouter(ReadPolicyNearest, nil)
+	addr, err = rr.pick("prim", secAddrs, latency)
+	require.NoError(t, err)
+	assert.Equal(t, "sec2", addr)
+	latencies["prim"] = 1
+	addr, _ = rr.pick("prim", secAddrs, latency)
+	assert.Equal(t, "prim", addr)
+	addr, _ = rr.pick("prim", []string{"unknown"}, latency)
+	assert.Equal(t, "prim", addr)
+}
+
+func TestWriteCommands(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		require.Equal(t, []string{"COMMAND"}, args)
+		return []interface{}{
+			[]interface{}{"get", int64(2), []string{"readonly", "fast"}, int64(1), int64(1), int64(1)},
+			[]interface{}{"mod.set", int64(-3), []string{"write"}, int64(1), int64(1), int64(1)},
+			[]interface{}{
+				"xgroup", int64(-2), []string{}, int64(0), int64(0), int64(0),
+				[]string{"@stream"}, []string{}, []string{},
+				[]interface{}{
+					[]interface{}{"xgroup|help", int64(2), []string{"loading"}, int64(0), int64(0), int64(0)},
+					[]interface{}{"xgroup|create", int64(-5), []string{"write"}, int64(2), int64(2), int64(1)},
+				},
+			},
+		}
+	})
+
+	isWrite, err := WriteCommands(stub)
+	require.NoError(t, err)
+	assert.False(t, isWrite("GET"))
+	assert.True(t, isWrite("MOD.SET"))
+	assert.True(t, isWrite("xgroup"))
+	assert.False(t, isWrite("SET"))
+
+	conn := ReadOnlyConn(stub, isWrite)
+	err = conn.Do(Cmd(nil, "MOD.SET", "foo", "bar"))
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	t.Run("malformed", func(t *T) {
+		var infos []commandFlags
+		err := unmarshalReply(t, "*2\r\n*1\r\n$3\r\nget\r\n*3\r\n$3\r\nset\r\n:-3\r\n*1\r\n$5\r\nwrite\r\n", resp2.Any{I: &infos})
+		assert.True(t, errors.As(err, new(resp.ErrDiscarded)), "err: %v", err)
+	})
+}
@@ -0,0 +1,327 @@
+package radix
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+// isConnErr returns true if the given error was caused by the connection
+// itself, e.g. because it was closed by the server, rather than being an error
+// returned by redis.
+func isConnErr(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+type reconnectOpts struct {
+	cf          ConnFunc
+	maxAttempts int
+	backoff     Backoff
+	onRedial    func(error)
+}
+
+// ReconnectOpt is an optional behavior which can be applied to the
+// NewReconnectingConn function to effect its behavior.
+type ReconnectOpt func(*reconnectOpts)
+
+// ReconnectConnFunc tells the Conn to use the given ConnFunc when creating new
+// connections, both initially and when reconnecting. Any AUTH, SELECT, etc...
+// done by the ConnFunc is therefore also done on each new connection.
+func ReconnectConnFunc(cf ConnFunc) ReconnectOpt {
+	return func(ro *reconnectOpts) {
+		ro.cf = cf
+	}
+}
+
+// ReconnectMaxAttempts specifies how often the Conn tries to create a new
+// connection after the previous one broke, before giving up and returning the
+// error. Between attempts the Conn waits for backoff, which doubles after each
+// failed attempt, i.e. this also sets ReconnectBackoff to an
+// ExponentialBackoff without a maximum.
+//
+// If all attempts failed the next call to Do starts over.
+func ReconnectMaxAttempts(maxAttempts int, backoff time.Duration) ReconnectOpt {
+	return func(ro *reconnectOpts) {
+		ro.maxAttempts = maxAttempts
+		ro.backoff = ExponentialBackoff(backoff, 0)
+	}
+}
+
+// ReconnectBackoff specifies how long the Conn waits between attempts to
+// create a new connection, see ReconnectMaxAttempts. A nil Backoff is ignored.
+func ReconnectBackoff(b Backoff) ReconnectOpt {
+	return func(ro *reconnectOpts) {
+		if b != nil {
+			ro.backoff = b
+		}
+	}
+}
+
+// ReconnectOnRedial specifies a callback which is called after each attempt to
+// create a new connection, with the error of the attempt or nil if it
+// succeeded. The callback is called synchronously.
+func ReconnectOnRedial(fn func(err error)) ReconnectOpt {
+	return func(ro *reconnectOpts) {
+		ro.onRedial = fn
+	}
+}
+
+type reconnectingConn struct {
+	network, addr string
+	opts          reconnectOpts
+
+	// closeCh is closed by Close before acquiring l, so that redial can stop
+	// waiting between attempts.
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	// doL is held while an Action is performed using Do, so that Actions are
+	// performed one after the other. l isn't held at the same time, so that
+	// Close can interrupt an Action, e.g. a blocking command.
+	doL sync.Mutex
+
+	l      sync.Mutex
+	conn   Conn // nil if broken
+	closed bool
+
+	// gen is incremented each time conn is replaced, so that only the first
+	// of multiple concurrent failures on the same connection replaces it.
+	gen uint64
+
+	// pending is the number of replies to calls to Encode which weren't
+	// decoded yet. If the connection breaks they're lost, in which case the
+	// next lost calls to Decode fail with lostErr instead of waiting for a
+	// reply on the new connection, which would never arrive.
+	pending, lost int
+	lostErr       error
+}
+
+// NewReconnectingConn creates a new connection to the redis instance at the
+// given address, and returns a Conn wrapping it which transparently creates a
+// new connection if the current one broke, e.g. because the server was
+// restarted or the connection was reset.
+//
+// If an Action performed using Do fails due to a connection error (but not due
+// to an error returned by redis, like WRONGTYPE), the connection is closed and
+// replaced by a new one. If the Action was created using Cmd, FlatCmd or
+// CmdBytes, and its command is idempotent (see IsIdempotentCommand), it is then
+// performed again on the new connection, so that the error is never seen by the
+// caller. As it's unknown whether redis processed a command before the
+// connection broke, other Actions aren't retried and the error is returned, but
+// the next call to Do will use the new connection. Close can be called while
+// an Action is being performed, e.g. to interrupt a blocking command like
+// BLPOP, or while waiting between attempts to create a new connection, which
+// stops any further attempts.
+//
+// Unlike other Conns, the returned Conn's Do method may be called from
+// multiple go-routines at once, in which case the Actions are performed one
+// after the other. If multiple Actions fail at once only a single new
+// connection is created.
+//
+// Calls to Encode and Decode are passed to the current connection as-is. If
+// they fail due to a connection error the connection is replaced before the
+// next call, and the Decode calls for all replies which were still pending on
+// the broken connection return the error instead of waiting on the new one.
+//
+// NewReconnectingConn takes in a number of options which can overwrite its
+// default behavior. The default options NewReconnectingConn uses are:
+//
+//	ReconnectConnFunc(DefaultConnFunc)
+//	ReconnectMaxAttempts(3, 100 * time.Millisecond)
+//
+func NewReconnectingConn(network, addr string, opts ...ReconnectOpt) (Conn, error) {
+	rc := &reconnectingConn{network: network, addr: addr, closeCh: make(chan struct{})}
+
+	defaultReconnectOpts := []ReconnectOpt{
+		ReconnectConnFunc(DefaultConnFunc),
+		ReconnectMaxAttempts(3, 100*time.Millisecond),
+	}
+	for _, opt := range append(defaultReconnectOpts, opts...) {
+		opt(&rc.opts)
+	}
+
+	conn, err := rc.opts.cf(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	rc.conn = conn
+	return rc, nil
+}
+
+// redial replaces the current connection, if any, with a new one. rc.l must
+// be held.
+func (rc *reconnectingConn) redial() error {
+	if rc.conn != nil {
+		rc.conn.Close()
+		rc.conn = nil
+	}
+
+	var err error
+	for i := 0; i < rc.opts.maxAttempts || i == 0; i++ {
+		if i > 0 {
+			timer := time.NewTimer(rc.opts.backoff.Next(i))
+			select {
+			case <-timer.C:
+			case <-rc.closeCh:
+				timer.Stop()
+				return errClientClosed
+			}
+		}
+
+		var conn Conn
+		conn, err = rc.opts.cf(rc.network, rc.addr)
+		if rc.opts.onRedial != nil {
+			rc.opts.onRedial(err)
+		}
+		if err == nil {
+			rc.conn = conn
+			rc.gen++
+			return nil
+		}
+	}
+	return err
+}
+
+// get returns the current connection and its generation, creating a new one if
+// the previous one broke.
+func (rc *reconnectingConn) get() (Conn, uint64, error) {
+	rc.l.Lock()
+	defer rc.l.Unlock()
+	if rc.closed {
+		return nil, 0, errClientClosed
+	} else if rc.conn == nil {
+		if err := rc.redial(); err != nil {
+			return nil, 0, err
+		}
+	}
+	return rc.conn, rc.gen, nil
+}
+
+// current returns true if the connection of the given generation is still the
+// current one. rc.l must be held.
+func (rc *reconnectingConn) current(gen uint64) bool {
+	return rc.conn != nil && rc.gen == gen
+}
+
+// checkErr marks the connection of the given generation as broken if err is a
+// connection error, in which case all pending replies are lost. rc.l must be
+// held.
+func (rc *reconnectingConn) checkErr(gen uint64, err error) {
+	if !isConnErr(err) || !rc.current(gen) {
+		return
+	}
+	rc.conn.Close()
+	rc.conn = nil
+	rc.lost, rc.pending, rc.lostErr = rc.lost+rc.pending, 0, err
+}
+
+// doConn performs the Action on the connection of the given generation, and
+// marks it as broken if that fails due to a connection error.
+func (rc *reconnectingConn) doConn(conn Conn, gen uint64, a Action) error {
+	err := conn.Do(a)
+	if isConnErr(err) {
+		rc.l.Lock()
+		rc.checkErr(gen, err)
+		rc.l.Unlock()
+	}
+	return err
+}
+
+func (rc *reconnectingConn) Do(a Action) error {
+	rc.doL.Lock()
+	defer rc.doL.Unlock()
+
+	// a cmdAction may be reused once it has been performed, so this must be
+	// checked beforehand
+	cmd, ok := a.(*cmdAction)
+	canRetry := ok && IsIdempotentCommand(cmd.cmd)
+
+	conn, gen, err := rc.get()
+	if err != nil {
+		return err
+	}
+	if err = rc.doConn(conn, gen, a); !isConnErr(err) {
+		return err
+	}
+
+	// the new connection is created right away, even if the Action can't be
+	// retried, so that the next call doesn't have to wait for it
+	conn, gen, rerr := rc.get()
+	if rerr != nil || !canRetry {
+		return err
+	}
+	return rc.doConn(conn, gen, a)
+}
+
+func (rc *reconnectingConn) Encode(m resp.Marshaler) error {
+	conn, gen, err := rc.get()
+	if err != nil {
+		return err
+	}
+	err = conn.Encode(m)
+
+	rc.l.Lock()
+	defer rc.l.Unlock()
+	if rc.current(gen) {
+		// even if Encode failed the command may have been written, so its
+		// reply is still expected
+		rc.pending++
+	}
+	rc.checkErr(gen, err)
+	return err
+}
+
+func (rc *reconnectingConn) Decode(u resp.Unmarshaler) error {
+	rc.l.Lock()
+	if rc.lost > 0 {
+		rc.lost--
+		err := rc.lostErr
+		rc.l.Unlock()
+		return err
+	}
+	rc.l.Unlock()
+
+	conn, gen, err := rc.get()
+	if err != nil {
+		return err
+	}
+	err = conn.Decode(u)
+
+	rc.l.Lock()
+	defer rc.l.Unlock()
+	if rc.current(gen) && rc.pending > 0 {
+		rc.pending--
+	}
+	rc.checkErr(gen, err)
+	return err
+}
+
+// NetConn returns the underlying net.Conn of the current connection, or nil if
+// the connection broke and no new one could be created.
+func (rc *reconnectingConn) NetConn() net.Conn {
+	conn, _, err := rc.get()
+	if err != nil {
+		return nil
+	}
+	return conn.NetConn()
+}
+
+func (rc *reconnectingConn) Close() error {
+	rc.closeOnce.Do(func() { close(rc.closeCh) })
+	rc.l.Lock()
+	defer rc.l.Unlock()
+	if rc.closed {
+		return errClientClosed
+	}
+	rc.closed = true
+	if rc.conn == nil {
+		return nil
+	}
+	return rc.conn.Close()
+}
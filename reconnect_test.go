@@ -0,0 +1,239 @@
+package radix
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// reconnectTestServer starts a server which replies OK to most commands. DROP
+// closes the connection without replying, CLOSE closes the connection after
+// replying, ERR replies with an error, and BLOCK never replies.
+func reconnectTestServer(t *T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		defer l.Close()
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				c := NewConn(nc)
+				for {
+					var args []string
+					if err := c.Decode(resp2.Any{I: &args}); err != nil {
+						return
+					}
+
+					var reply resp.Marshaler = resp2.SimpleString{S: "OK"}
+					switch args[0] {
+					case "DROP":
+						return
+					case "BLOCK":
+						// wait for the client to close the connection
+						_ = c.Decode(resp2.Any{})
+						return
+					case "ERR":
+						reply = resp2.Error{E: errors.New("ERR failed")}
+					}
+					if err := c.Encode(reply); err != nil || args[0] == "CLOSE" {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestReconnectingConn(t *T) {
+	addr := reconnectTestServer(t)
+	var l sync.Mutex
+	var redials []error
+	conn, err := NewReconnectingConn("tcp", addr, ReconnectOnRedial(func(err error) {
+		l.Lock()
+		redials = append(redials, err)
+		l.Unlock()
+	}))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assertRedials := func(t *T, n int) {
+		t.Helper()
+		l.Lock()
+		defer l.Unlock()
+		assert.Len(t, redials, n)
+		for _, err := range redials {
+			assert.NoError(t, err)
+		}
+		redials = nil
+	}
+
+	t.Run("redis error", func(t *T) {
+		err := conn.Do(Cmd(nil, "ERR"))
+		assert.True(t, errors.As(err, new(resp2.Error)), "err: %v", err)
+		require.NoError(t, conn.Do(Cmd(nil, "PING")))
+		assertRedials(t, 0)
+	})
+
+	t.Run("non-idempotent", func(t *T) {
+		err := conn.Do(Cmd(nil, "DROP"))
+		assert.True(t, isConnErr(err), "err: %v", err)
+		assertRedials(t, 1)
+		require.NoError(t, conn.Do(Cmd(nil, "PING")))
+		assertRedials(t, 0)
+	})
+
+	t.Run("idempotent", func(t *T) {
+		require.NoError(t, conn.Do(Cmd(nil, "CLOSE")))
+		require.NoError(t, conn.Do(Cmd(nil, "GET", "foo")))
+		assertRedials(t, 1)
+	})
+
+	t.Run("concurrent", func(t *T) {
+		require.NoError(t, conn.Do(Cmd(nil, "CLOSE")))
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, conn.Do(Cmd(nil, "GET", "foo")))
+			}()
+		}
+		wg.Wait()
+		assertRedials(t, 1)
+	})
+
+	t.Run("redial fails", func(t *T) {
+		addr := reconnectTestServer(t)
+		var attempts int
+		conn, err := NewReconnectingConn("tcp", addr,
+			ReconnectConnFunc(func(network, addr string) (Conn, error) {
+				if attempts++; attempts > 1 {
+					return nil, errors.New("dial failed")
+				}
+				return Dial(network, addr)
+			}),
+			ReconnectMaxAttempts(2, 0),
+		)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.Do(Cmd(nil, "CLOSE")))
+		err = conn.Do(Cmd(nil, "GET", "foo"))
+		assert.True(t, isConnErr(err), "err: %v", err)
+		assert.Equal(t, 3, attempts)
+
+		err = conn.Do(Cmd(nil, "GET", "foo"))
+		assert.EqualError(t, err, "dial failed")
+		assert.Equal(t, 5, attempts)
+	})
+
+	t.Run("close during backoff", func(t *T) {
+		addr := reconnectTestServer(t)
+		var attempts int32
+		conn, err := NewReconnectingConn("tcp", addr,
+			ReconnectConnFunc(func(network, addr string) (Conn, error) {
+				if atomic.AddInt32(&attempts, 1) > 1 {
+					return nil, errors.New("dial failed")
+				}
+				return Dial(network, addr)
+			}),
+			ReconnectMaxAttempts(3, time.Hour),
+		)
+		require.NoError(t, err)
+
+		require.NoError(t, conn.Do(Cmd(nil, "CLOSE")))
+		errCh := make(chan error, 1)
+		go func() { errCh <- conn.Do(Cmd(nil, "GET", "foo")) }()
+		for atomic.LoadInt32(&attempts) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// Close doesn't wait for the backoff, and stops the redial
+		require.NoError(t, conn.Close())
+		assert.Error(t, <-errCh)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("pending replies", func(t *T) {
+		require.NoError(t, conn.Encode(Cmd(nil, "DROP")))
+		// the connection may already be closed by the server when encoding
+		// the second command, either way its reply is lost
+		_ = conn.Encode(Cmd(nil, "PING"))
+
+		dropErr := conn.Decode(resp2.Any{})
+		assert.True(t, isConnErr(dropErr), "err: %v", dropErr)
+		err := conn.Decode(resp2.Any{})
+		assert.True(t, isConnErr(err), "err: %v", err)
+
+		require.NoError(t, conn.Do(Cmd(nil, "PING")))
+		assertRedials(t, 1)
+	})
+}
+
+func TestReconnectBackoffNil(t *T) {
+	addr := reconnectTestServer(t)
+	var attempts int32
+	conn, err := NewReconnectingConn("tcp", addr,
+		ReconnectConnFunc(func(network, addr string) (Conn, error) {
+			if atomic.AddInt32(&attempts, 1) == 2 {
+				return nil, errors.New("dial failed")
+			}
+			return Dial(network, addr)
+		}),
+		ReconnectMaxAttempts(3, time.Millisecond),
+		ReconnectBackoff(nil),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the default backoff is kept, so the failed attempt is retried
+	require.NoError(t, conn.Do(Cmd(nil, "CLOSE")))
+	require.NoError(t, conn.Do(Cmd(nil, "PING")))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestReconnectingConnCloseBlocking(t *T) {
+	addr := reconnectTestServer(t)
+	conn, err := NewReconnectingConn("tcp", addr)
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- conn.Do(Cmd(nil, "BLOCK"))
+	}()
+
+	// give Do a chance to start blocking
+	time.Sleep(50 * time.Millisecond)
+	closeErrCh := make(chan error, 1)
+	go func() {
+		closeErrCh <- conn.Close()
+	}()
+
+	select {
+	case err := <-closeErrCh:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on Do")
+	}
+	select {
+	case err := <-errCh:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Do wasn't interrupted by Close")
+	}
+	assert.Equal(t, errClientClosed, conn.Do(Cmd(nil, "PING")))
+}
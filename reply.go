@@ -0,0 +1,130 @@
+package radix
+
+import (
+	"bufio"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// NullString is a receiver for replies which are either a string or nil, e.g.
+// the reply to GET. Valid is false if the reply was nil, which allows an empty
+// value to be told apart from a missing key.
+type NullString struct {
+	String string
+	Valid  bool
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ns *NullString) UnmarshalRESP(br *bufio.Reader) error {
+	*ns = NullString{}
+	mn := MaybeNil{Rcv: &ns.String}
+	if err := mn.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	ns.Valid = !mn.Nil
+	return nil
+}
+
+// NullInt is a receiver for replies which are either an integer or nil, e.g.
+// the reply to ZRANK or to GET of a counter. Valid is false if the reply was
+// nil.
+type NullInt struct {
+	Int   int64
+	Valid bool
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ni *NullInt) UnmarshalRESP(br *bufio.Reader) error {
+	*ni = NullInt{}
+	mn := MaybeNil{Rcv: &ni.Int}
+	if err := mn.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	ni.Valid = !mn.Nil
+	return nil
+}
+
+// OK is a receiver for replies which are either an OK status or nil, e.g. the
+// reply to SET with the NX or XX option. It is set to true if the reply was
+// OK and to false if it was nil. Any other reply is discarded and results in
+// an error.
+type OK bool
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ok *OK) UnmarshalRESP(br *bufio.Reader) error {
+	var s string
+	mn := MaybeNil{Rcv: &s}
+	if err := mn.UnmarshalRESP(br); err != nil {
+		return err
+	} else if !mn.Nil && s != "OK" {
+		return resp.ErrDiscarded{Err: errors.Errorf("unexpected reply %q", s)}
+	}
+	*ok = OK(!mn.Nil)
+	return nil
+}
+
+// TTLReply is a receiver for the reply to TTL or PTTL, which unmarshals the
+// remaining time to live of a key into a time.Duration. See also the TTL and
+// PTTL functions. Unix timestamps, e.g. the reply to EXPIRETIME, can be
+// unmarshaled into a time.Time using UnixSeconds or UnixMillis.
+type TTLReply struct {
+	// Unit is the unit of the reply, i.e. time.Second for TTL and
+	// time.Millisecond for PTTL. It must be set before unmarshaling and
+	// defaults to time.Second.
+	Unit time.Duration
+
+	// Duration is the remaining time to live of the key. It is zero if
+	// Missing or NoExpiry is set.
+	Duration time.Duration
+
+	// Missing is set if the key doesn't exist.
+	Missing bool
+
+	// NoExpiry is set if the key exists but has no expiry.
+	NoExpiry bool
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ttl *TTLReply) UnmarshalRESP(br *bufio.Reader) error {
+	unit := ttl.Unit
+	if unit == 0 {
+		unit = time.Second
+	}
+
+	var n int64
+	if err := (resp2.Any{I: &n}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	*ttl = TTLReply{Unit: ttl.Unit}
+	switch n {
+	case -2:
+		ttl.Missing = true
+	case -1:
+		ttl.NoExpiry = true
+	default:
+		ttl.Duration = time.Duration(n) * unit
+	}
+	return nil
+}
+
+// discardAfterErr discards the next n elements of an array, after one of its
+// elements failed to unmarshal with the given error. If the error is a
+// resp.ErrDiscarded, i.e. the failed element was read in full, it's returned
+// once the elements were discarded. Otherwise the reply can't be read any
+// further and the error is returned as-is.
+func discardAfterErr(br *bufio.Reader, n int, err error) error {
+	if !errors.As(err, new(resp.ErrDiscarded)) {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if discardErr := (resp2.Any{}).UnmarshalRESP(br); discardErr != nil {
+			return discardErr
+		}
+	}
+	return err
+}
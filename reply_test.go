@@ -0,0 +1,95 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func unmarshalReply(t *T, b string, u resp.Unmarshaler) error {
+	br := bufio.NewReader(bytes.NewBufferString(b))
+	err := u.UnmarshalRESP(br)
+	require.Zero(t, br.Buffered(), "reply not fully consumed")
+	return err
+}
+
+func TestNullReplies(t *T) {
+	var ns NullString
+	require.NoError(t, unmarshalReply(t, "$3\r\nfoo\r\n", &ns))
+	assert.Equal(t, NullString{String: "foo", Valid: true}, ns)
+	require.NoError(t, unmarshalReply(t, "$0\r\n\r\n", &ns))
+	assert.Equal(t, NullString{Valid: true}, ns)
+	require.NoError(t, unmarshalReply(t, "$-1\r\n", &ns))
+	assert.Equal(t, NullString{}, ns)
+
+	var ni NullInt
+	require.NoError(t, unmarshalReply(t, ":5\r\n", &ni))
+	assert.Equal(t, NullInt{Int: 5, Valid: true}, ni)
+	require.NoError(t, unmarshalReply(t, "$1\r\n0\r\n", &ni))
+	assert.Equal(t, NullInt{Valid: true}, ni)
+	require.NoError(t, unmarshalReply(t, "*-1\r\n", &ni))
+	assert.Equal(t, NullInt{}, ni)
+
+	err := unmarshalReply(t, "-ERR foo\r\n", &ni)
+	assert.True(t, errors.As(err, new(resp2.Error)), "err: %v", err)
+}
+
+func TestOKReply(t *T) {
+	var ok OK
+	require.NoError(t, unmarshalReply(t, "+OK\r\n", &ok))
+	assert.True(t, bool(ok))
+	require.NoError(t, unmarshalReply(t, "$-1\r\n", &ok))
+	assert.False(t, bool(ok))
+
+	err := unmarshalReply(t, "+QUEUED\r\n", &ok)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)), "err: %v", err)
+}
+
+func TestTTLReply(t *T) {
+	ttl := TTLReply{}
+	require.NoError(t, unmarshalReply(t, ":10\r\n", &ttl))
+	assert.Equal(t, TTLReply{Duration: 10 * time.Second}, ttl)
+	require.NoError(t, unmarshalReply(t, ":-1\r\n", &ttl))
+	assert.Equal(t, TTLReply{NoExpiry: true}, ttl)
+	require.NoError(t, unmarshalReply(t, ":-2\r\n", &ttl))
+	assert.Equal(t, TTLReply{Missing: true}, ttl)
+
+	pttl := TTLReply{Unit: time.Millisecond}
+	require.NoError(t, unmarshalReply(t, ":1500\r\n", &pttl))
+	assert.Equal(t, TTLReply{Unit: time.Millisecond, Duration: 1500 * time.Millisecond}, pttl)
+}
+
+func TestReplyHelpersStub(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "GET":
+			return nil
+		case "PTTL":
+			return int64(2500)
+		case "SET":
+			return resp2.SimpleString{S: "OK"}
+		}
+		return resp2.Error{E: errors.New("ERR unknown command")}
+	})
+	defer stub.Close()
+
+	var ns NullString
+	require.NoError(t, stub.Do(Cmd(&ns, "GET", "foo")))
+	assert.False(t, ns.Valid)
+
+	ttl := TTLReply{Unit: time.Millisecond}
+	require.NoError(t, stub.Do(Cmd(&ttl, "PTTL", "foo")))
+	assert.Equal(t, 2500*time.Millisecond, ttl.Duration)
+
+	var ok OK
+	require.NoError(t, stub.Do(Cmd(&ok, "SET", "foo", "bar", "NX")))
+	assert.True(t, bool(ok))
+}
@@ -0,0 +1,108 @@
+package resp2
+
+import (
+	"bufio"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// DecoderFunc unmarshals a single RESP message read from br into v, which is
+// an addressable value of the type the DecoderFunc was registered for.
+//
+// A DecoderFunc must always read the complete message from br, including for
+// nil and error messages. If it returns an error after having done so the
+// error should be wrapped in a resp.ErrDiscarded. The simplest way to do so is
+// to first unmarshal the message into an intermediate value using Any, e.g.:
+//
+//	func(br *bufio.Reader, v reflect.Value) error {
+//		var s string
+//		if err := (resp2.Any{I: &s}).UnmarshalRESP(br); err != nil {
+//			return err
+//		}
+//		id, err := uuid.Parse(s)
+//		if err != nil {
+//			return resp.ErrDiscarded{Err: err}
+//		}
+//		v.Set(reflect.ValueOf(id))
+//		return nil
+//	}
+//
+type DecoderFunc func(br *bufio.Reader, v reflect.Value) error
+
+// Decoders is a set of DecoderFuncs, each for a specific type, which can be set
+// on Any to unmarshal into types which don't implement resp.Unmarshaler, e.g.
+// types from other packages. Decoders is safe for concurrent use.
+//
+// When unmarshaling into a value, Any first checks whether the value
+// implements resp.Unmarshaler, which always takes precedence. Otherwise the
+// DecoderFunc registered for the value's type is used, if any, before falling
+// back to the built-in handling (including encoding.TextUnmarshaler and
+// encoding.BinaryUnmarshaler). DecoderFuncs set on Any take precedence over
+// those registered using RegisterDecoder.
+type Decoders struct {
+	n int32 // atomic, the number of registered DecoderFuncs
+
+	l  sync.RWMutex
+	fn map[reflect.Type]DecoderFunc
+}
+
+// NewDecoders returns an empty Decoders.
+func NewDecoders() *Decoders {
+	return &Decoders{fn: map[reflect.Type]DecoderFunc{}}
+}
+
+// Register registers the DecoderFunc used for values of type t, replacing any
+// DecoderFunc previously registered for t. If fn is nil the DecoderFunc for t
+// is removed.
+func (d *Decoders) Register(t reflect.Type, fn DecoderFunc) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	if fn == nil {
+		delete(d.fn, t)
+	} else {
+		d.fn[t] = fn
+	}
+	atomic.StoreInt32(&d.n, int32(len(d.fn)))
+}
+
+func (d *Decoders) lookup(t reflect.Type) DecoderFunc {
+	if d == nil || atomic.LoadInt32(&d.n) == 0 {
+		return nil
+	}
+	d.l.RLock()
+	defer d.l.RUnlock()
+	return d.fn[t]
+}
+
+var globalDecoders = NewDecoders()
+
+// RegisterDecoder registers the DecoderFunc used for values of type t by all
+// Anys (see Decoders), replacing any DecoderFunc previously registered for t.
+// If fn is nil the DecoderFunc for t is removed.
+//
+// RegisterDecoder is meant to be called during initialization, e.g. in an init
+// function.
+func RegisterDecoder(t reflect.Type, fn DecoderFunc) {
+	globalDecoders.Register(t, fn)
+}
+
+// decoder returns the DecoderFunc to be used for unmarshaling into i, along
+// with the value to pass to it.
+func (a Any) decoder(i interface{}) (DecoderFunc, reflect.Value) {
+	if a.Decoders == nil && atomic.LoadInt32(&globalDecoders.n) == 0 {
+		return nil, reflect.Value{}
+	}
+
+	v := reflect.ValueOf(i)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, reflect.Value{}
+	}
+	v = v.Elem()
+
+	fn := a.Decoders.lookup(v.Type())
+	if fn == nil {
+		fn = globalDecoders.lookup(v.Type())
+	}
+	return fn, v
+}
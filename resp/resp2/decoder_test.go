@@ -0,0 +1,96 @@
+package resp2
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+// testDecoded stands in for a type from another package, which can't implement
+// resp.Unmarshaler.
+type testDecoded struct {
+	upper string
+}
+
+func decodeTestDecoded(suffix string) DecoderFunc {
+	return func(br *bufio.Reader, v reflect.Value) error {
+		var s string
+		if err := (Any{I: &s}).UnmarshalRESP(br); err != nil {
+			return err
+		} else if s == "" {
+			return resp.ErrDiscarded{Err: errors.New("empty")}
+		}
+		v.Set(reflect.ValueOf(testDecoded{upper: strings.ToUpper(s) + suffix}))
+		return nil
+	}
+}
+
+// testDecodedUnmarshaler implements resp.Unmarshaler, which takes precedence
+// over registered DecoderFuncs.
+type testDecodedUnmarshaler struct {
+	s string
+}
+
+func (u *testDecodedUnmarshaler) UnmarshalRESP(br *bufio.Reader) error {
+	return Any{I: &u.s}.UnmarshalRESP(br)
+}
+
+func TestDecoders(t *T) {
+	decodedT := reflect.TypeOf(testDecoded{})
+	RegisterDecoder(decodedT, decodeTestDecoded(""))
+	defer RegisterDecoder(decodedT, nil)
+
+	unmarshal := func(a Any, in string) error {
+		return a.UnmarshalRESP(bufio.NewReader(bytes.NewBufferString(in)))
+	}
+
+	var d testDecoded
+	require.NoError(t, unmarshal(Any{I: &d}, "$3\r\nfoo\r\n"))
+	assert.Equal(t, testDecoded{upper: "FOO"}, d)
+
+	// nested values use the decoder too
+	var s struct {
+		A []testDecoded
+		B map[string]testDecoded
+	}
+	in := "*4\r\n$1\r\nA\r\n*2\r\n+foo\r\n+bar\r\n$1\r\nB\r\n*2\r\n+k\r\n+v\r\n"
+	require.NoError(t, unmarshal(Any{I: &s}, in))
+	assert.Equal(t, []testDecoded{{upper: "FOO"}, {upper: "BAR"}}, s.A)
+	assert.Equal(t, map[string]testDecoded{"k": {upper: "V"}}, s.B)
+
+	// errors returned by the decoder are returned, and the rest of the reply is
+	// discarded
+	br := bufio.NewReader(bytes.NewBufferString("*2\r\n$0\r\n\r\n+bar\r\n+after\r\n"))
+	var dd []testDecoded
+	err := Any{I: &dd}.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	var after string
+	require.NoError(t, Any{I: &after}.UnmarshalRESP(br))
+	assert.Equal(t, "after", after)
+
+	// Decoders set on Any take precedence over the global ones
+	decs := NewDecoders()
+	decs.Register(decodedT, decodeTestDecoded("!"))
+	require.NoError(t, unmarshal(Any{I: &d, Decoders: decs}, "+foo\r\n"))
+	assert.Equal(t, testDecoded{upper: "FOO!"}, d)
+
+	// resp.Unmarshaler takes precedence over all DecoderFuncs
+	uT := reflect.TypeOf(testDecodedUnmarshaler{})
+	decs.Register(uT, decodeTestDecoded(""))
+	var u testDecodedUnmarshaler
+	require.NoError(t, unmarshal(Any{I: &u, Decoders: decs}, "+foo\r\n"))
+	assert.Equal(t, "foo", u.s)
+
+	// removing a decoder falls back to the built-in handling, which can't
+	// handle the type
+	RegisterDecoder(decodedT, nil)
+	assert.Error(t, unmarshal(Any{I: &d}, "+foo\r\n"))
+}
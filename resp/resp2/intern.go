@@ -0,0 +1,43 @@
+package resp2
+
+// StringInterner is a bounded cache of strings which can be set on Any, so that
+// strings which are unmarshaled repeatedly share a single allocation instead
+// of a new one being made for every unmarshaled value.
+//
+// This reduces memory usage and GC pressure when the same small set of values
+// is read over and over again (e.g. enum-like values), at the cost of a map
+// lookup for every unmarshaled string and of the memory held by the cache
+// itself. For values which are mostly distinct it only adds overhead.
+//
+// A StringInterner is not safe for concurrent use.
+type StringInterner struct {
+	size, maxLen int
+	m            map[string]string
+}
+
+// NewStringInterner returns a StringInterner which holds up to size strings,
+// each of which may be at most maxLen bytes long. Longer strings are never
+// interned. Once the StringInterner is full no new strings are added to it.
+func NewStringInterner(size, maxLen int) *StringInterner {
+	return &StringInterner{
+		size:   size,
+		maxLen: maxLen,
+		m:      make(map[string]string, size),
+	}
+}
+
+// Intern returns b as a string, returning a previously returned string instead
+// of allocating a new one if possible.
+func (si *StringInterner) Intern(b []byte) string {
+	if len(b) > si.maxLen {
+		return string(b)
+	} else if s, ok := si.m[string(b)]; ok { // no allocation, since Go 1.3
+		return s
+	}
+
+	s := string(b)
+	if len(si.m) < si.size {
+		si.m[s] = s
+	}
+	return s
+}
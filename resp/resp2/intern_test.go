@@ -0,0 +1,80 @@
+package resp2
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	. "testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+func TestStringInterner(t *T) {
+	si := NewStringInterner(2, 3)
+
+	a, b := si.Intern([]byte("foo")), si.Intern([]byte("foo"))
+	assert.Equal(t, "foo", b)
+	assert.Equal(t, stringData(a), stringData(b))
+
+	// too long
+	a, b = si.Intern([]byte("fooo")), si.Intern([]byte("fooo"))
+	assert.Equal(t, "fooo", b)
+	assert.NotEqual(t, stringData(a), stringData(b))
+
+	// fill up the cache, after which nothing new is added
+	si.Intern([]byte("bar"))
+	a, b = si.Intern([]byte("baz")), si.Intern([]byte("baz"))
+	assert.Equal(t, "baz", b)
+	assert.NotEqual(t, stringData(a), stringData(b))
+}
+
+func TestAnyUnmarshalInterned(t *T) {
+	buf := new(bytes.Buffer)
+	require.NoError(t, Any{I: map[string][]string{
+		"a": {"foo", "bar"},
+		"b": {"foo", "baz"},
+	}}.MarshalRESP(buf))
+
+	var m map[string][]string
+	si := NewStringInterner(16, 16)
+	require.NoError(t, Any{I: &m, StringInterner: si}.UnmarshalRESP(bufio.NewReader(buf)))
+	assert.Equal(t, map[string][]string{
+		"a": {"foo", "bar"},
+		"b": {"foo", "baz"},
+	}, m)
+	assert.Equal(t, stringData(m["a"][0]), stringData(m["b"][0]))
+}
+
+func BenchmarkAnyUnmarshalStrings(b *B) {
+	buf := new(bytes.Buffer)
+	vals := make([]string, 100)
+	for i := range vals {
+		vals[i] = strings.Repeat(string(rune('a'+i%4)), 16)
+	}
+	require.NoError(b, Any{I: vals}.MarshalRESP(buf))
+	msg := buf.Bytes()
+
+	run := func(b *B, si *StringInterner) {
+		b.ReportAllocs()
+		r := bytes.NewReader(msg)
+		br := bufio.NewReader(r)
+		var out []string
+		for i := 0; i < b.N; i++ {
+			r.Reset(msg)
+			br.Reset(r)
+			if err := (Any{I: &out, StringInterner: si}).UnmarshalRESP(br); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("plain", func(b *B) { run(b, nil) })
+	b.Run("interned", func(b *B) { run(b, NewStringInterner(16, 32)) })
+}
@@ -3,6 +3,10 @@
 // communicate with its clients, but there's nothing about the protocol which
 // ties it to redis, it could be used for almost anything.
 //
+// The additional types of RESP3, e.g. maps, sets and push frames, can be
+// unmarshaled as well, so that the same receivers can be used regardless of
+// the protocol version the connection uses.
+//
 // See https://redis.io/topics/protocol for more details on the protocol.
 package resp2
 
@@ -12,6 +16,7 @@ import (
 	"encoding"
 	"fmt"
 	"io"
+	"math/big"
 	"reflect"
 	"strconv"
 	"sync"
@@ -42,6 +47,22 @@ var (
 	ArrayPrefix        = []byte{'*'}
 )
 
+// Enumeration of the additional message types of RESP3, which redis uses for
+// replies once HELLO 3 was sent on a connection. They are only ever read, see
+// Any and RawMessage.
+var (
+	MapPrefix            = []byte{'%'}
+	SetPrefix            = []byte{'~'}
+	NullPrefix           = []byte{'_'}
+	BooleanPrefix        = []byte{'#'}
+	DoublePrefix         = []byte{','}
+	BigNumberPrefix      = []byte{'('}
+	VerbatimStringPrefix = []byte{'='}
+	BlobErrorPrefix      = []byte{'!'}
+	AttributePrefix      = []byte{'|'}
+	PushPrefix           = []byte{'>'}
+)
+
 // String formats a prefix into a human-readable name for the type it denotes.
 func (p prefix) String() string {
 	pStr := string(p)
@@ -56,14 +77,53 @@ func (p prefix) String() string {
 		return "bulk-string"
 	case string(ArrayPrefix):
 		return "array"
+	case string(MapPrefix):
+		return "map"
+	case string(SetPrefix):
+		return "set"
+	case string(NullPrefix):
+		return "null"
+	case string(BooleanPrefix):
+		return "boolean"
+	case string(DoublePrefix):
+		return "double"
+	case string(BigNumberPrefix):
+		return "big-number"
+	case string(VerbatimStringPrefix):
+		return "verbatim-string"
+	case string(BlobErrorPrefix):
+		return "blob-error"
+	case string(AttributePrefix):
+		return "attribute"
+	case string(PushPrefix):
+		return "push"
 	default:
 		return pStr
 	}
 }
 
+// bulkStringDirectLen is the length above which the value of a bulk string is
+// written separately from its header, rather than being copied into a pooled
+// scratch buffer along with it. This saves copying large values twice, and
+// keeps the pool from holding on to large buffers.
+const bulkStringDirectLen = 512
+
+// writeDirect writes the header, the value b and the trailing delimiter of a
+// bulk string.
+func writeDirect(w io.Writer, header, b []byte) error {
+	if _, err := w.Write(header); err != nil {
+		return err
+	} else if _, err := w.Write(b); err != nil {
+		return err
+	}
+	_, err := w.Write(delim)
+	return err
+}
+
 var (
 	nilBulkString = []byte("$-1\r\n")
 	nilArray      = []byte("*-1\r\n")
+	null          = []byte("_\r\n")
 	emptyArray    = []byte("*0\r\n")
 )
 
@@ -107,6 +167,12 @@ func peekAndAssertPrefix(br *bufio.Reader, expectedPrefix []byte) error {
 			return err
 		}
 		return resp.ErrDiscarded{Err: respErr}
+	} else if bytes.Equal(b, BlobErrorPrefix) {
+		err := (Any{}).UnmarshalRESP(br)
+		if respErr := (Error{}); errors.As(err, &respErr) {
+			return resp.ErrDiscarded{Err: respErr}
+		}
+		return err
 	} else if err := (Any{}).UnmarshalRESP(br); err != nil {
 		return err
 	}
@@ -257,9 +323,14 @@ func (b BulkStringBytes) MarshalRESP(w io.Writer) error {
 	*scratch = append(*scratch, BulkStringPrefix...)
 	*scratch = strconv.AppendInt(*scratch, int64(len(b.B)), 10)
 	*scratch = append(*scratch, delim...)
-	*scratch = append(*scratch, b.B...)
-	*scratch = append(*scratch, delim...)
-	_, err := w.Write(*scratch)
+	var err error
+	if len(b.B) > bulkStringDirectLen {
+		err = writeDirect(w, *scratch, b.B)
+	} else {
+		*scratch = append(*scratch, b.B...)
+		*scratch = append(*scratch, delim...)
+		_, err = w.Write(*scratch)
+	}
 	bytesutil.PutBytes(scratch)
 	return err
 }
@@ -305,9 +376,18 @@ func (b BulkString) MarshalRESP(w io.Writer) error {
 	*scratch = append(*scratch, BulkStringPrefix...)
 	*scratch = strconv.AppendInt(*scratch, int64(len(b.S)), 10)
 	*scratch = append(*scratch, delim...)
-	*scratch = append(*scratch, b.S...)
-	*scratch = append(*scratch, delim...)
-	_, err := w.Write(*scratch)
+	var err error
+	if len(b.S) > bulkStringDirectLen {
+		if _, err = w.Write(*scratch); err == nil {
+			if _, err = io.WriteString(w, b.S); err == nil {
+				_, err = w.Write(delim)
+			}
+		}
+	} else {
+		*scratch = append(*scratch, b.S...)
+		*scratch = append(*scratch, delim...)
+		_, err = w.Write(*scratch)
+	}
 	bytesutil.PutBytes(scratch)
 	return err
 }
@@ -397,13 +477,29 @@ func (ah ArrayHeader) MarshalRESP(w io.Writer) error {
 	return err
 }
 
-// UnmarshalRESP implements the Unmarshaler method
+// UnmarshalRESP implements the Unmarshaler method. The headers of the RESP3
+// set, push and map types are accepted as well, where N of a map is the number
+// of its keys and values, i.e. twice the number of its entries.
 func (ah *ArrayHeader) UnmarshalRESP(br *bufio.Reader) error {
-	if err := assertBufferedPrefix(br, ArrayPrefix); err != nil {
+	pref := ArrayPrefix
+	if b, err := br.Peek(1); err == nil {
+		switch b[0] {
+		case SetPrefix[0]:
+			pref = SetPrefix
+		case PushPrefix[0]:
+			pref = PushPrefix
+		case MapPrefix[0]:
+			pref = MapPrefix
+		}
+	}
+	if err := assertBufferedPrefix(br, pref); err != nil {
 		return err
 	}
 	n, err := bytesutil.BufferedIntDelim(br)
 	ah.N = int(n)
+	if pref[0] == MapPrefix[0] && ah.N > 0 {
+		ah.N *= 2
+	}
 	return err
 }
 
@@ -473,11 +569,27 @@ func discardArrayAfterErr(br *bufio.Reader, left int, err error) error {
 // but they will be flattened into arrays of their alternating keys/values
 // first.
 //
+// When unmarshaling an array into a go array, e.g. [2]float64, the length of
+// the RESP array must match the length of the go array exactly, otherwise an
+// error is returned and the value of I won't be touched. The same goes for
+// when any of the elements can't be unmarshaled.
+//
+// When unmarshaling an array into a map whose value type is an empty struct,
+// e.g. map[string]struct{}, each element of the array is instead added as a
+// key of the map. This can be used to decode the result of commands like
+// SMEMBERS directly into a set.
+//
 // When using UnmarshalRESP the value of I must be a pointer or nil. If it is
 // nil then the RESP value will be read and discarded.
 //
 // If an error type is read in the UnmarshalRESP method then a resp2.Error will
 // be returned with that error, and the value of I won't be touched.
+//
+// When unmarshaling into a *big.Float whose precision is 0 the precision is
+// chosen such that all digits of the decimal string sent by redis (e.g. the
+// result of INCRBYFLOAT or ZSCORE) are retained, rather than defaulting to 64
+// bits. A *big.Rat may be used if the decimal value must be represented
+// exactly.
 type Any struct {
 	I interface{}
 
@@ -491,6 +603,26 @@ type Any struct {
 	// written, and an ArrayHeader must have been manually marshalled
 	// beforehand.
 	MarshalNoArrayHeaders bool
+
+	// If set then UnmarshalRESP will use the StringInterner when unmarshaling
+	// into strings, including strings within arrays, maps and structs.
+	StringInterner *StringInterner
+
+	// If true then UnmarshalRESP will return an error when unmarshaling an
+	// array into a struct if the array contains a key which doesn't match any
+	// field of the struct, rather than ignoring it. It also returns an error
+	// when unmarshaling an integer into a string or []byte, or a nil value
+	// into anything other than a pointer, interface, slice or map, rather
+	// than converting it. This also applies to values within arrays, maps and
+	// structs. This is useful for catching changes to the shape of a reply,
+	// e.g. across redis versions.
+	Strict bool
+
+	// If set then UnmarshalRESP will use the DecoderFuncs registered in
+	// Decoders, in addition to those registered using RegisterDecoder, when
+	// unmarshaling into values of their types, including values within arrays,
+	// maps and structs. See Decoders for the precedence rules.
+	Decoders *Decoders
 }
 
 func (a Any) cp(i interface{}) Any {
@@ -775,18 +907,25 @@ func saneDefault(prefix byte) interface{} {
 	// we don't handle ErrorPrefix because that always returns an error and
 	// doesn't touch I
 	switch prefix {
-	case ArrayPrefix[0]:
+	case ArrayPrefix[0], SetPrefix[0], PushPrefix[0]:
 		ii := make([]interface{}, 8)
 		return &ii
-	case BulkStringPrefix[0]:
+	case MapPrefix[0]:
+		m := map[string]interface{}{}
+		return &m
+	case BulkStringPrefix[0], VerbatimStringPrefix[0]:
 		bb := make([]byte, 16)
 		return &bb
-	case SimpleStringPrefix[0]:
+	case SimpleStringPrefix[0], BigNumberPrefix[0]:
 		return new(string)
 	case IntPrefix[0]:
 		return new(int64)
+	case BooleanPrefix[0]:
+		return new(bool)
+	case DoublePrefix[0]:
+		return new(float64)
 	}
-	panic("should never get here")
+	return nil
 }
 
 // We use pools for these even though they only get used within
@@ -812,6 +951,8 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 	// if I is itself an Unmarshaler just hit that directly
 	if u, ok := a.I.(resp.Unmarshaler); ok {
 		return u.UnmarshalRESP(br)
+	} else if fn, v := a.decoder(a.I); fn != nil {
+		return fn(br, v)
 	}
 
 	b, err := br.Peek(1)
@@ -820,12 +961,27 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 	}
 	prefix := b[0]
 
+	// attributes only carry auxiliary information about the reply following
+	// them, so they are skipped
+	if prefix == AttributePrefix[0] {
+		if err := discardAttribute(br); err != nil {
+			return err
+		}
+		return a.UnmarshalRESP(br)
+	}
+
 	// This is a super special case that _must_ be handled before we actually
 	// read from the reader. If an *interface{} is given we instead unmarshal
 	// into a default (created based on the type of th message), then set the
 	// *interface{} to that
-	if ai, ok := a.I.(*interface{}); ok && prefix != ErrorPrefix[0] {
-		innerA := Any{I: saneDefault(prefix)}
+	if ai, ok := a.I.(*interface{}); ok && prefix == NullPrefix[0] {
+		if _, err := br.Discard(len(null)); err != nil {
+			return err
+		}
+		*ai = nil
+		return nil
+	} else if def := saneDefault(prefix); ok && def != nil {
+		innerA := a.cp(def)
 		if err := innerA.UnmarshalRESP(br); err != nil {
 			return err
 		}
@@ -842,14 +998,67 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 	switch prefix {
 	case ErrorPrefix[0]:
 		return Error{E: errors.New(string(b))}
-	case ArrayPrefix[0]:
+	case ArrayPrefix[0], SetPrefix[0], PushPrefix[0], MapPrefix[0]:
 		l, err := bytesutil.ParseInt(b)
 		if err != nil {
 			return err
 		} else if l == -1 {
 			return a.unmarshalNil()
+		} else if prefix == MapPrefix[0] {
+			// maps are unmarshaled like arrays of alternating keys and values,
+			// which is what redis replies with in RESP2
+			l *= 2
 		}
 		return a.unmarshalArray(br, l)
+	case NullPrefix[0]:
+		return a.unmarshalNil()
+	case BlobErrorPrefix[0]:
+		l, err := bytesutil.ParseInt(b)
+		if err != nil {
+			return err
+		}
+		scratch := bytesutil.GetBytes()
+		defer bytesutil.PutBytes(scratch)
+		if *scratch, err = bytesutil.ReadNAppend(br, *scratch, int(l+2)); err != nil {
+			return err
+		}
+		return Error{E: errors.New(string((*scratch)[:l]))}
+	case VerbatimStringPrefix[0]:
+		// the value is prefixed by its format, e.g. "txt:", which is
+		// discarded
+		l, err := bytesutil.ParseInt(b)
+		if err != nil {
+			return err
+		} else if l < 4 {
+			return errors.Errorf("verbatim string of length %d is too short", l)
+		} else if _, err := br.Discard(4); err != nil {
+			return err
+		}
+		if err = a.unmarshalSingle(br, int(l-4)); err != nil {
+			if !errors.As(err, new(resp.ErrDiscarded)) {
+				return err
+			}
+		}
+		if _, discardErr := br.Discard(2); discardErr != nil {
+			return discardErr
+		}
+		return err
+	case BooleanPrefix[0]:
+		// booleans are unmarshaled like the integers 1 and 0, which is what
+		// redis replies with in RESP2
+		switch string(b) {
+		case "t":
+			b = bools[1]
+		case "f":
+			b = bools[0]
+		default:
+			return errors.Errorf("invalid boolean %q", b)
+		}
+		reader := byteReaderPool.Get().(*bytes.Reader)
+		reader.Reset(b)
+		err := a.unmarshalSingle(reader, reader.Len())
+		byteReaderPool.Put(reader)
+		return err
 	case BulkStringPrefix[0]:
 		l, err := bytesutil.ParseInt(b) // fuck DRY
 		if err != nil {
@@ -872,17 +1081,40 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 			return discardErr
 		}
 		return err
-	case SimpleStringPrefix[0], IntPrefix[0]:
+	case SimpleStringPrefix[0], IntPrefix[0], DoublePrefix[0], BigNumberPrefix[0]:
+		if prefix == IntPrefix[0] && a.Strict {
+			switch a.I.(type) {
+			case *string, *[]byte:
+				return resp.ErrDiscarded{
+					Err: errors.Errorf("cannot unmarshal integer %q into %T", b, a.I),
+				}
+			}
+		}
 		reader := byteReaderPool.Get().(*bytes.Reader)
 		reader.Reset(b)
 		err := a.unmarshalSingle(reader, reader.Len())
 		byteReaderPool.Put(reader)
 		return err
 	default:
-		return errors.Errorf("unknown type prefix %q", b[0])
+		return errors.Errorf("unknown type prefix %q", prefix)
 	}
 }
 
+// discardAttribute discards a RESP3 attribute, whose prefix was peeked
+// already.
+func discardAttribute(br *bufio.Reader) error {
+	br.Discard(1)
+	b, err := bytesutil.BufferedBytesDelim(br)
+	if err != nil {
+		return err
+	}
+	l, err := bytesutil.ParseInt(b)
+	if err != nil {
+		return err
+	}
+	return discardArray(br, int(l*2))
+}
+
 func (a Any) unmarshalSingle(body io.Reader, n int) error {
 	var (
 		err error
@@ -897,13 +1129,21 @@ func (a Any) unmarshalSingle(body io.Reader, n int) error {
 	case *string:
 		scratch := bytesutil.GetBytes()
 		*scratch, err = bytesutil.ReadNAppend(body, *scratch, n)
-		*ai = string(*scratch)
+		if a.StringInterner != nil {
+			*ai = a.StringInterner.Intern(*scratch)
+		} else {
+			*ai = string(*scratch)
+		}
 		bytesutil.PutBytes(scratch)
 	case *[]byte:
 		*ai, err = bytesutil.ReadNAppend(body, (*ai)[:0], n)
 	case *bool:
+		// commands like SISMEMBER or EXPIRE indicate true/false using 1/0
 		ui, err = bytesutil.ReadUint(body, n)
-		*ai = ui > 0
+		if err == nil && ui > 1 {
+			err = resp.ErrDiscarded{Err: errors.Errorf("can't unmarshal %d into bool, expected 0 or 1", ui)}
+		}
+		*ai = ui == 1
 	case *int:
 		i, err = bytesutil.ReadInt(body, n)
 		*ai = int(i)
@@ -942,6 +1182,13 @@ func (a Any) unmarshalSingle(body io.Reader, n int) error {
 		*ai, err = bytesutil.ReadFloat(body, 64, n)
 	case io.Writer:
 		_, err = io.CopyN(ai, body, int64(n))
+	case *big.Float:
+		scratch := bytesutil.GetBytes()
+		if *scratch, err = bytesutil.ReadNAppend(body, *scratch, n); err != nil {
+			break
+		}
+		err = unmarshalBigFloat(ai, *scratch)
+		bytesutil.PutBytes(scratch)
 	case encoding.TextUnmarshaler:
 		scratch := bytesutil.GetBytes()
 		if *scratch, err = bytesutil.ReadNAppend(body, *scratch, n); err != nil {
@@ -970,6 +1217,20 @@ func (a Any) unmarshalSingle(body io.Reader, n int) error {
 	return err
 }
 
+// unmarshalBigFloat parses the decimal string b into f. If f has no precision
+// set it is given one which is large enough to retain all digits of b.
+func unmarshalBigFloat(f *big.Float, b []byte) error {
+	if f.Prec() == 0 {
+		// each decimal digit needs log2(10) ~= 3.33 bits
+		prec := uint(len(b)*10/3 + 1)
+		if prec < 64 {
+			prec = 64
+		}
+		f.SetPrec(prec)
+	}
+	return f.UnmarshalText(b)
+}
+
 func (a Any) unmarshalNil() error {
 	vv := reflect.ValueOf(a.I)
 	if vv.Kind() != reflect.Ptr || !vv.Elem().CanSet() {
@@ -979,10 +1240,50 @@ func (a Any) unmarshalNil() error {
 	}
 
 	vve := vv.Elem()
+	if a.Strict {
+		switch vve.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		default:
+			return resp.ErrDiscarded{
+				Err: errors.Errorf("cannot unmarshal nil into %v", vve.Type()),
+			}
+		}
+	}
 	vve.Set(reflect.Zero(vve.Type()))
 	return nil
 }
 
+// isSetType returns true if the given map type has an empty struct as its
+// value type, e.g. map[string]struct{}, in which case arrays are decoded into
+// it as a set of keys, rather than as alternating keys/values.
+func isSetType(t reflect.Type) bool {
+	return t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+func (a Any) unmarshalArrayIntoSet(br *bufio.Reader, v reflect.Value, size int) error {
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(v.Type(), size))
+	}
+
+	var kvs reflect.Value
+	if size > 0 && canShareReflectValue(v.Type().Key()) {
+		kvs = reflect.New(v.Type().Key())
+	}
+
+	empty := reflect.New(v.Type().Elem()).Elem()
+	for i := 0; i < size; i++ {
+		kv := kvs
+		if !kv.IsValid() {
+			kv = reflect.New(v.Type().Key())
+		}
+		if err := a.cp(kv.Interface()).UnmarshalRESP(br); err != nil {
+			return discardArrayAfterErr(br, size-i-1, err)
+		}
+		v.SetMapIndex(kv.Elem(), empty)
+	}
+	return nil
+}
+
 func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 	if a.I == nil {
 		return discardArray(br, int(l))
@@ -1012,15 +1313,38 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 		}
 
 		for i := 0; i < size; i++ {
-			ai := Any{I: v.Index(i).Addr().Interface()}
+			ai := a.cp(v.Index(i).Addr().Interface())
 			if err := ai.UnmarshalRESP(br); err != nil {
 				return discardArrayAfterErr(br, int(l)-i-1, err)
 			}
 		}
 		return nil
 
+	case reflect.Array:
+		if size != v.Len() {
+			err := resp.ErrDiscarded{
+				Err: errors.Errorf("can't unmarshal array of size %d into %s", size, v.Type()),
+			}
+			return discardArrayAfterErr(br, size, err)
+		}
+
+		// elements are unmarshaled into a copy, so that v isn't touched if
+		// any of them fails
+		tmp := reflect.New(v.Type()).Elem()
+		tmp.Set(v)
+		for i := 0; i < size; i++ {
+			ai := a.cp(tmp.Index(i).Addr().Interface())
+			if err := ai.UnmarshalRESP(br); err != nil {
+				return discardArrayAfterErr(br, size-i-1, err)
+			}
+		}
+		v.Set(tmp)
+		return nil
+
 	case reflect.Map:
-		if size%2 != 0 {
+		if isSetType(v.Type()) {
+			return a.unmarshalArrayIntoSet(br, v, size)
+		} else if size%2 != 0 {
 			err := resp.ErrDiscarded{Err: errors.New("cannot decode redis array with odd number of elements into map")}
 			return discardArrayAfterErr(br, int(l), err)
 		} else if v.IsNil() {
@@ -1037,12 +1361,27 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 			vvs = reflect.New(v.Type().Elem())
 		}
 
+		// keys which aren't strings are first read in full, so that the key
+		// can be included in the error if it can't be converted into the key
+		// type.
+		var rawKey *RawMessage
+		if v.Type().Key().Kind() != reflect.String {
+			rawKey = new(RawMessage)
+		}
+
 		for i := 0; i < size; i += 2 {
 			kv := kvs
 			if !kv.IsValid() {
 				kv = reflect.New(v.Type().Key())
 			}
-			if err := (Any{I: kv.Interface()}).UnmarshalRESP(br); err != nil {
+			if rawKey == nil {
+				if err := a.cp(kv.Interface()).UnmarshalRESP(br); err != nil {
+					return discardArrayAfterErr(br, int(l)-i-1, err)
+				}
+			} else if err := rawKey.UnmarshalRESP(br); err != nil {
+				return err
+			} else if err := rawKey.UnmarshalInto(a.cp(kv.Interface())); err != nil {
+				err = mapKeyErr(*rawKey, v.Type().Key(), err)
 				return discardArrayAfterErr(br, int(l)-i-1, err)
 			}
 
@@ -1050,7 +1389,7 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 			if !vv.IsValid() {
 				vv = reflect.New(v.Type().Elem())
 			}
-			if err := (Any{I: vv.Interface()}).UnmarshalRESP(br); err != nil {
+			if err := a.cp(vv.Interface()).UnmarshalRESP(br); err != nil {
 				return discardArrayAfterErr(br, int(l)-i-2, err)
 			}
 
@@ -1078,7 +1417,12 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 				vv = getStructField(v, structField.indices)
 			}
 
-			if !ok || !vv.IsValid() {
+			if !ok && a.Strict {
+				err := resp.ErrDiscarded{
+					Err: errors.Errorf("unexpected field %q when unmarshaling into %v", field.B, v.Type()),
+				}
+				return discardArrayAfterErr(br, int(l)-i-1, err)
+			} else if !ok || !vv.IsValid() {
 				// discard the value
 				if err := (Any{}).UnmarshalRESP(br); err != nil {
 					return discardArrayAfterErr(br, int(l)-i-2, err)
@@ -1086,7 +1430,7 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 				continue
 			}
 
-			if err := (Any{I: vv.Interface()}).UnmarshalRESP(br); err != nil {
+			if err := a.cp(vv.Interface()).UnmarshalRESP(br); err != nil {
 				return discardArrayAfterErr(br, int(l)-i-2, err)
 			}
 		}
@@ -1099,6 +1443,24 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 	}
 }
 
+// mapKeyErr wraps an error which occurred when unmarshaling the given key into
+// the key type of a map.
+func mapKeyErr(rawKey RawMessage, ty reflect.Type, err error) error {
+	var discarded resp.ErrDiscarded
+	if errors.As(err, &discarded) {
+		err = discarded.Err
+	}
+
+	var key string
+	if rawKey.UnmarshalInto(Any{I: &key}) != nil {
+		key = string(rawKey)
+	}
+
+	return resp.ErrDiscarded{
+		Err: errors.Errorf("can't unmarshal map key %q into %v: %w", key, ty, err),
+	}
+}
+
 func canShareReflectValue(ty reflect.Type) bool {
 	switch ty.Kind() {
 	case reflect.Bool,
@@ -1245,20 +1607,28 @@ func (rm *RawMessage) unmarshal(br *bufio.Reader) error {
 	body := b[1 : len(b)-2]
 
 	switch b[0] {
-	case ArrayPrefix[0]:
+	case ArrayPrefix[0], SetPrefix[0], PushPrefix[0], MapPrefix[0], AttributePrefix[0]:
 		l, err := bytesutil.ParseInt(body)
 		if err != nil {
 			return err
 		} else if l == -1 {
 			return nil
 		}
+		prefix := b[0]
+		if prefix == MapPrefix[0] || prefix == AttributePrefix[0] {
+			l *= 2
+		}
 		for i := 0; i < int(l); i++ {
 			if err := rm.unmarshal(br); err != nil {
 				return err
 			}
 		}
+		if prefix == AttributePrefix[0] {
+			// an attribute is always followed by the reply it belongs to
+			return rm.unmarshal(br)
+		}
 		return nil
-	case BulkStringPrefix[0]:
+	case BulkStringPrefix[0], VerbatimStringPrefix[0], BlobErrorPrefix[0]:
 		l, err := bytesutil.ParseInt(body) // fuck DRY
 		if err != nil {
 			return err
@@ -1267,7 +1637,8 @@ func (rm *RawMessage) unmarshal(br *bufio.Reader) error {
 		}
 		*rm, err = bytesutil.ReadNAppend(br, *rm, int(l+2))
 		return err
-	case ErrorPrefix[0], SimpleStringPrefix[0], IntPrefix[0]:
+	case ErrorPrefix[0], SimpleStringPrefix[0], IntPrefix[0],
+		NullPrefix[0], BooleanPrefix[0], DoublePrefix[0], BigNumberPrefix[0]:
 		return nil
 	default:
 		return errors.Errorf("unknown type prefix %q", b[0])
@@ -1289,9 +1660,10 @@ func (rm RawMessage) UnmarshalInto(u resp.Unmarshaler) error {
 	return err
 }
 
-// IsNil returns true if the contents of RawMessage are one of the nil values.
+// IsNil returns true if the contents of RawMessage are one of the nil values,
+// including the RESP3 null.
 func (rm RawMessage) IsNil() bool {
-	return bytes.Equal(rm, nilBulkString) || bytes.Equal(rm, nilArray)
+	return bytes.Equal(rm, nilBulkString) || bytes.Equal(rm, nilArray) || bytes.Equal(rm, null)
 }
 
 // IsEmptyArray returns true if the contents of RawMessage is empty array value.
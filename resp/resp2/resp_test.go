@@ -3,6 +3,8 @@ package resp2
 import (
 	"bufio"
 	"bytes"
+	"math"
+	"math/big"
 	"reflect"
 	"strings"
 	. "testing"
@@ -62,6 +64,10 @@ func TestRESPTypes(t *T) {
 		errStr bool
 	}
 
+	// values above bulkStringDirectLen are written separately from the header
+	large := strings.Repeat("foo\r\n", 200)
+	largeOut := "$1000\r\n" + large + "\r\n"
+
 	encodeTests := func() []encodeTest {
 		return []encodeTest{
 			{in: &SimpleString{S: ""}, out: "+\r\n"},
@@ -78,6 +84,8 @@ func TestRESPTypes(t *T) {
 			{in: &BulkString{S: ""}, out: "$0\r\n\r\n"},
 			{in: &BulkString{S: "foo"}, out: "$3\r\nfoo\r\n"},
 			{in: &BulkString{S: "foo\r\nbar"}, out: "$8\r\nfoo\r\nbar\r\n"},
+			{in: &BulkStringBytes{B: []byte(large)}, out: largeOut},
+			{in: &BulkString{S: large}, out: largeOut},
 			{in: &BulkReader{LR: newLR("foo\r\nbar")}, out: "$8\r\nfoo\r\nbar\r\n"},
 			{in: &ArrayHeader{N: 5}, out: "*5\r\n"},
 			{in: &ArrayHeader{N: -1}, out: "*-1\r\n"},
@@ -473,6 +481,10 @@ func TestAnyUnmarshal(t *T) {
 			{in: ":1024\r\n", out: float64(1024)},
 			{in: ":1024\r\n", preloadEmpty: true, out: int64(1024)},
 			{in: ":1024\r\n", out: nil},
+			{in: ":0\r\n", out: false},
+			{in: ":1\r\n", out: true},
+			{in: ":2\r\n", out: false, shouldErr: "can't unmarshal 2 into bool, expected 0 or 1"},
+			{in: ":-1\r\n", out: false, shouldErr: "invalid character - at position 0 in parseUint"},
 
 			// Arrays
 			{in: "*-1\r\n", out: []interface{}(nil)},
@@ -501,7 +513,71 @@ func TestAnyUnmarshal(t *T) {
 				in:  "*2\r\n*2\r\n+foo\r\n+bar\r\n+baz\r\n",
 				out: []interface{}{[]interface{}{"foo", "bar"}, "baz"},
 			},
+			{
+				in: "*2\r\n" +
+					"*2\r\n" + "*2\r\n+a\r\n+b\r\n" + "*1\r\n+c\r\n" +
+					"*1\r\n" + "*3\r\n+d\r\n+e\r\n+f\r\n",
+				out: [][][]string{{{"a", "b"}, {"c"}}, {{"d", "e", "f"}}},
+			},
+			{
+				in: "*2\r\n" +
+					"*2\r\n" + "*2\r\n:1\r\n:2\r\n" + "*0\r\n" +
+					"*1\r\n" + "*1\r\n$1\r\n3\r\n",
+				out: [][][]int{{{1, 2}, {}}, {{3}}},
+			},
+			{
+				in:      "*1\r\n*1\r\n*1\r\n+a\r\n",
+				preload: [][][]string{{{"x", "y"}, {"z"}}, {{"zz"}}},
+				out:     [][][]string{{{"a"}}},
+			},
+			{
+				in: "*2\r\n" +
+					"*2\r\n" + "*2\r\n+a\r\n:1\r\n" + "+b\r\n" +
+					"*1\r\n" + "*1\r\n*1\r\n+c\r\n",
+				out: [][]interface{}{
+					{[]interface{}{"a", int64(1)}, "b"},
+					{[]interface{}{[]interface{}{"c"}}},
+				},
+			},
+			{
+				// e.g. GEOSEARCH with WITHCOORD and WITHDIST
+				in: "*2\r\n" +
+					"*3\r\n$7\r\nPalermo\r\n$6\r\n190.44\r\n" + "*2\r\n$4\r\n13.3\r\n$4\r\n38.1\r\n" +
+					"*3\r\n$7\r\nCatania\r\n$5\r\n56.44\r\n" + "*2\r\n$4\r\n15.0\r\n$4\r\n37.5\r\n",
+				out: []interface{}{
+					[]interface{}{[]byte("Palermo"), []byte("190.44"), []interface{}{[]byte("13.3"), []byte("38.1")}},
+					[]interface{}{[]byte("Catania"), []byte("56.44"), []interface{}{[]byte("15.0"), []byte("37.5")}},
+				},
+			},
+			{in: "*2\r\n$4\r\n13.5\r\n$2\r\n38\r\n", out: [2]float64{13.5, 38}},
+			{in: "*2\r\n$10\r\n1700000000\r\n$6\r\n123456\r\n", out: [2]string{"1700000000", "123456"}},
+			{in: "*0\r\n", out: [0]int{}},
+			{in: "*-1\r\n", out: [2]int{}},
+			{
+				in:        "*3\r\n:1\r\n:2\r\n:3\r\n",
+				out:       [2]int{},
+				shouldErr: "can't unmarshal array of size 3 into [2]int",
+			},
+			{
+				in:        "*1\r\n*2\r\n:1\r\n:2\r\n",
+				out:       [2]interface{}{},
+				shouldErr: "can't unmarshal array of size 1 into [2]interface {}",
+			},
+			{in: "*2\r\n*2\r\n:1\r\n:2\r\n*2\r\n:3\r\n:4\r\n", out: [][2]int{{1, 2}, {3, 4}}},
 			{in: "*2\r\n:1\r\n:2\r\n", out: map[string]string{"1": "2"}},
+			{in: "*4\r\n$1\r\n1\r\n$1\r\na\r\n:22\r\n$1\r\nb\r\n", out: map[int]string{1: "a", 22: "b"}},
+			{in: "*2\r\n$4\r\n-1.5\r\n:1\r\n", out: map[float64]bool{-1.5: true}},
+			{in: "*0\r\n", preload: map[string]struct{}(nil), out: map[string]struct{}{}},
+			{
+				in:  "*3\r\n$3\r\nfoo\r\n$3\r\nbar\r\n$3\r\nfoo\r\n",
+				out: map[string]struct{}{"foo": {}, "bar": {}},
+			},
+			{in: "*2\r\n:1\r\n$1\r\n2\r\n", out: map[int]struct{}{1: {}, 2: {}}},
+			{
+				in:        "*4\r\n$1\r\n1\r\n$1\r\na\r\n$3\r\ntwo\r\n$1\r\nb\r\n",
+				out:       map[int]string{},
+				shouldErr: `can't unmarshal map key "two" into int: invalid character t at position 0 in parseUint`,
+			},
 			{in: "*2\r\n*2\r\n+foo\r\n+bar\r\n*1\r\n+baz\r\n", out: nil},
 			{
 				in: "*6\r\n" +
@@ -615,6 +691,236 @@ func TestAnyUnmarshal(t *T) {
 	}
 }
 
+func TestAnyUnmarshalBigFloat(t *T) {
+	unmarshal := func(t *T, in string, f *big.Float) {
+		br := bufio.NewReader(bytes.NewBufferString(in))
+		require.NoError(t, Any{I: f}.UnmarshalRESP(br))
+	}
+
+	const long = "12345678901234567890.123456789"
+	in := "$30\r\n" + long + "\r\n"
+
+	t.Run("default precision", func(t *T) {
+		f := new(big.Float)
+		unmarshal(t, in, f)
+		assert.Equal(t, long, f.Text('f', 9))
+	})
+
+	t.Run("preset precision", func(t *T) {
+		f := new(big.Float).SetPrec(24)
+		unmarshal(t, in, f)
+		assert.Equal(t, uint(24), f.Prec())
+	})
+
+	t.Run("short", func(t *T) {
+		f := new(big.Float)
+		unmarshal(t, "$4\r\n10.5\r\n", f)
+		assert.Equal(t, uint(64), f.Prec())
+		assert.Equal(t, "10.5", f.Text('f', -1))
+	})
+
+	t.Run("inf", func(t *T) {
+		f := new(big.Float)
+		unmarshal(t, "$4\r\n-inf\r\n", f)
+		assert.True(t, f.IsInf())
+	})
+}
+
+func TestAnyUnmarshalGoArrayErr(t *T) {
+	// an element failing to unmarshal must leave the go array untouched
+	br := bufio.NewReader(bytes.NewBufferString("*2\r\n:3\r\n$3\r\nfoo\r\n+OK\r\n"))
+	a := [2]int{1, 2}
+	err := Any{I: &a}.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)), "err: %v", err)
+	assert.Equal(t, [2]int{1, 2}, a)
+
+	var ok string
+	require.NoError(t, Any{I: &ok}.UnmarshalRESP(br))
+	assert.Equal(t, "OK", ok)
+}
+
+func TestAnyUnmarshalStrict(t *T) {
+	type point struct {
+		X int `redis:"x"`
+		Y int `redis:"y"`
+	}
+	const in = "*6\r\n$1\r\nx\r\n:1\r\n$1\r\ny\r\n:2\r\n$1\r\nz\r\n:3\r\n"
+
+	t.Run("lenient", func(t *T) {
+		br := bufio.NewReader(bytes.NewBufferString(in + "+OK\r\n"))
+		var p point
+		require.NoError(t, Any{I: &p}.UnmarshalRESP(br))
+		assert.Equal(t, point{X: 1, Y: 2}, p)
+	})
+
+	t.Run("strict", func(t *T) {
+		br := bufio.NewReader(bytes.NewBufferString(in + "+OK\r\n"))
+		var p point
+		err := Any{I: &p, Strict: true}.UnmarshalRESP(br)
+		assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+		assert.Contains(t, err.Error(), `unexpected field "z"`)
+
+		// the whole reply was consumed
+		var ok string
+		require.NoError(t, Any{I: &ok}.UnmarshalRESP(br))
+		assert.Equal(t, "OK", ok)
+	})
+
+	t.Run("strict nested", func(t *T) {
+		br := bufio.NewReader(bytes.NewBufferString("*1\r\n" + in))
+		var ps []point
+		err := Any{I: &ps, Strict: true}.UnmarshalRESP(br)
+		assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	})
+
+	t.Run("strict types", func(t *T) {
+		type named struct {
+			Name string `redis:"name"`
+		}
+		tests := []struct {
+			in     string
+			into   interface{}
+			expErr string
+		}{
+			{in: ":1\r\n", into: new(string), expErr: `cannot unmarshal integer "1" into *string`},
+			{in: ":1\r\n", into: new([]byte), expErr: `cannot unmarshal integer "1" into *[]uint8`},
+			{in: "$-1\r\n", into: new(int), expErr: "cannot unmarshal nil into int"},
+			{in: "*-1\r\n", into: new(named), expErr: "cannot unmarshal nil into resp2.named"},
+			{in: "*2\r\n$4\r\nname\r\n:1\r\n", into: new(named), expErr: `cannot unmarshal integer "1" into *string`},
+			{in: "*2\r\n:1\r\n$-1\r\n", into: new([]int), expErr: "cannot unmarshal nil into int"},
+			{in: "$-1\r\n", into: new(*string)},
+			{in: "*-1\r\n", into: new([]string)},
+			{in: ":1\r\n", into: new(int)},
+		}
+
+		for _, test := range tests {
+			br := bufio.NewReader(bytes.NewBufferString(test.in + "+OK\r\n"))
+			err := Any{I: test.into, Strict: true}.UnmarshalRESP(br)
+			if test.expErr == "" {
+				assert.NoError(t, err, "in: %q", test.in)
+			} else {
+				assert.True(t, errors.As(err, new(resp.ErrDiscarded)), "in: %q", test.in)
+				assert.EqualError(t, err, test.expErr, "in: %q", test.in)
+			}
+
+			// the whole reply was consumed
+			var ok string
+			require.NoError(t, Any{I: &ok}.UnmarshalRESP(br))
+			assert.Equal(t, "OK", ok)
+		}
+	})
+}
+
+func TestAnyUnmarshalRESP3(t *T) {
+	unmarshal := func(t *T, in string, i interface{}) error {
+		br := bufio.NewReader(bytes.NewBufferString(in + "+OK\r\n"))
+		err := Any{I: i}.UnmarshalRESP(br)
+
+		// the whole reply must always be consumed
+		var ok string
+		require.NoError(t, Any{I: &ok}.UnmarshalRESP(br))
+		assert.Equal(t, "OK", ok)
+		return err
+	}
+
+	t.Run("map", func(t *T) {
+		const in = "%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n:2\r\n"
+
+		var m map[string]int
+		require.NoError(t, unmarshal(t, in, &m))
+		assert.Equal(t, map[string]int{"a": 1, "b": 2}, m)
+
+		var s struct {
+			A int `redis:"a"`
+			B int `redis:"b"`
+		}
+		require.NoError(t, unmarshal(t, in, &s))
+		assert.Equal(t, 1, s.A)
+		assert.Equal(t, 2, s.B)
+
+		var flat []string
+		require.NoError(t, unmarshal(t, in, &flat))
+		assert.Equal(t, []string{"a", "1", "b", "2"}, flat)
+
+		var i interface{}
+		require.NoError(t, unmarshal(t, in, &i))
+		assert.Equal(t, map[string]interface{}{"a": int64(1), "b": int64(2)}, i)
+	})
+
+	t.Run("set", func(t *T) {
+		var s []string
+		require.NoError(t, unmarshal(t, "~2\r\n+a\r\n+b\r\n", &s))
+		assert.Equal(t, []string{"a", "b"}, s)
+	})
+
+	t.Run("push", func(t *T) {
+		var s []string
+		require.NoError(t, unmarshal(t, ">2\r\n+message\r\n+foo\r\n", &s))
+		assert.Equal(t, []string{"message", "foo"}, s)
+	})
+
+	t.Run("null", func(t *T) {
+		s := "foo"
+		require.NoError(t, unmarshal(t, "_\r\n", &s))
+		assert.Equal(t, "", s)
+
+		var i interface{} = "foo"
+		require.NoError(t, unmarshal(t, "_\r\n", &i))
+		assert.Nil(t, i)
+	})
+
+	t.Run("boolean", func(t *T) {
+		var b bool
+		require.NoError(t, unmarshal(t, "#t\r\n", &b))
+		assert.True(t, b)
+		require.NoError(t, unmarshal(t, "#f\r\n", &b))
+		assert.False(t, b)
+
+		var i interface{}
+		require.NoError(t, unmarshal(t, "#t\r\n", &i))
+		assert.Equal(t, true, i)
+	})
+
+	t.Run("double", func(t *T) {
+		var f float64
+		require.NoError(t, unmarshal(t, ",1.5\r\n", &f))
+		assert.Equal(t, 1.5, f)
+		require.NoError(t, unmarshal(t, ",-inf\r\n", &f))
+		assert.True(t, math.IsInf(f, -1))
+	})
+
+	t.Run("big number", func(t *T) {
+		const long = "3492890328409238509324850943850943825024385"
+		var s string
+		require.NoError(t, unmarshal(t, "("+long+"\r\n", &s))
+		assert.Equal(t, long, s)
+
+		i := new(big.Int)
+		require.NoError(t, unmarshal(t, "("+long+"\r\n", i))
+		assert.Equal(t, long, i.String())
+	})
+
+	t.Run("verbatim string", func(t *T) {
+		var s string
+		require.NoError(t, unmarshal(t, "=7\r\ntxt:foo\r\n", &s))
+		assert.Equal(t, "foo", s)
+	})
+
+	t.Run("blob error", func(t *T) {
+		var s string
+		err := unmarshal(t, "!7\r\nERR foo\r\n", &s)
+		var rerr Error
+		require.True(t, errors.As(err, &rerr), "err: %v", err)
+		assert.Equal(t, "ERR foo", rerr.E.Error())
+	})
+
+	t.Run("attribute", func(t *T) {
+		var s string
+		require.NoError(t, unmarshal(t, "|1\r\n+key\r\n+val\r\n+foo\r\n", &s))
+		assert.Equal(t, "foo", s)
+	})
+}
+
 func TestRawMessage(t *T) {
 	rmtests := []struct {
 		b       string
@@ -635,6 +941,16 @@ func TestRawMessage(t *T) {
 		{b: "*2\r\n:1\r\n:2\r\n"},
 		{b: "*-1\r\n", isNil: true},
 		{b: "*0\r\n", isEmpty: true},
+		{b: "%1\r\n+a\r\n:1\r\n"},
+		{b: "~2\r\n:1\r\n:2\r\n"},
+		{b: ">2\r\n+message\r\n+foo\r\n"},
+		{b: "_\r\n", isNil: true},
+		{b: "#t\r\n"},
+		{b: ",1.5\r\n"},
+		{b: "(12345678901234567890\r\n"},
+		{b: "=7\r\ntxt:foo\r\n"},
+		{b: "!7\r\nERR foo\r\n"},
+		{b: "|1\r\n+key\r\n+val\r\n+foo\r\n"},
 	}
 
 	// one at a time
@@ -719,6 +1035,45 @@ func TestAnyConsumedOnErr(t *T) {
 	}
 }
 
+// chunkWriter records the size of each Write call. It intentionally doesn't
+// implement io.ReaderFrom, so that writes to it are not optimized away.
+type chunkWriter struct {
+	buf       bytes.Buffer
+	maxChunk  int
+	numWrites int
+}
+
+func (cw *chunkWriter) Write(b []byte) (int, error) {
+	if len(b) > cw.maxChunk {
+		cw.maxChunk = len(b)
+	}
+	cw.numWrites++
+	return cw.buf.Write(b)
+}
+
+func TestAnyStreaming(t *T) {
+	const size = 1 << 20
+	body := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+
+	// marshal the body from a reader, followed by another message to make sure
+	// the trailing CRLF is handled correctly
+	marshaled := new(bytes.Buffer)
+	lr := resp.NewLenReader(bytes.NewReader(body), size)
+	require.NoError(t, Any{I: lr, MarshalBulkString: true}.MarshalRESP(marshaled))
+	require.NoError(t, SimpleString{S: "NEXT"}.MarshalRESP(marshaled))
+
+	br := bufio.NewReader(marshaled)
+	cw := new(chunkWriter)
+	require.NoError(t, Any{I: cw}.UnmarshalRESP(br))
+	assert.Equal(t, body, cw.buf.Bytes())
+	assert.True(t, cw.numWrites > 1)
+	assert.True(t, cw.maxChunk < size, "max chunk size: %d", cw.maxChunk)
+
+	var ss SimpleString
+	require.NoError(t, ss.UnmarshalRESP(br))
+	assert.Equal(t, "NEXT", ss.S)
+}
+
 func TestErrorAs(t *T) {
 	{
 		err := Error{E: errors.New("foo")}
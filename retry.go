@@ -0,0 +1,183 @@
+package radix
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// RetryPolicy describes how a Pool or Cluster retries Actions which failed due
+// to a transient error, see PoolRetryPolicy and ClusterRetryPolicy.
+//
+// The zero value is a valid RetryPolicy which uses the defaults documented on
+// each field.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times an Action is performed,
+	// including the first attempt. Defaults to 3.
+	MaxAttempts int
+
+	// MaxElapsed limits the total time spent on an Action, including the time
+	// waited between attempts. An Action is not retried if the wait before the
+	// next attempt would exceed this budget. Zero means no limit.
+	//
+	// Note that the time taken by an attempt itself is bounded by the
+	// timeouts of the connections only, see DialTimeout.
+	MaxElapsed time.Duration
+
+	// Backoff determines how long to wait before each retry. Defaults to
+	// ExponentialJitterBackoff(10*time.Millisecond, time.Second).
+	Backoff Backoff
+
+	// ShouldRetry is called with the Action and the error it failed with, and
+	// returns whether the Action should be retried. Defaults to
+	// IsRetryableError.
+	ShouldRetry func(a Action, err error) bool
+}
+
+func (rp RetryPolicy) withDefaults() RetryPolicy {
+	if rp.MaxAttempts == 0 {
+		rp.MaxAttempts = 3
+	}
+	if rp.Backoff == nil {
+		rp.Backoff = ExponentialJitterBackoff(10*time.Millisecond, time.Second)
+	}
+	if rp.ShouldRetry == nil {
+		rp.ShouldRetry = IsRetryableError
+	}
+	return rp
+}
+
+// do calls fn with the Action until it succeeds, or until the RetryPolicy
+// doesn't allow any further attempts. The RetryPolicy must have its defaults
+// applied.
+func (rp RetryPolicy) do(a Action, fn func(Action) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn(a)
+		if err == nil || attempt >= rp.MaxAttempts || !rp.ShouldRetry(a, err) {
+			return err
+		}
+
+		wait := rp.Backoff.Next(attempt)
+		if rp.MaxElapsed > 0 && time.Since(start)+wait > rp.MaxElapsed {
+			return err
+		}
+		time.Sleep(wait)
+	}
+}
+
+var transientErrPrefixes = []string{
+	"LOADING ",
+	"TRYAGAIN ",
+	"CLUSTERDOWN ",
+	"MASTERDOWN ",
+}
+
+func isTransientRedisErr(err error) bool {
+	var respErr resp2.Error
+	if !errors.As(err, &respErr) {
+		return false
+	}
+
+	msg := respErr.Error()
+	for _, prefix := range transientErrPrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var readerT = reflect.TypeOf(new(io.Reader)).Elem()
+
+func isDialErr(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// IsRetryableError is the default ShouldRetry function of a RetryPolicy. It
+// returns true if the Action failed with an error which is likely to be
+// transient, and retrying the Action can not cause it to be processed twice:
+//
+// * Dial errors, e.g. because the redis instance is restarting, are always
+// retryable, since the Action was never sent.
+//
+// * LOADING, TRYAGAIN, CLUSTERDOWN and MASTERDOWN errors are retryable for
+// Actions created using Cmd, FlatCmd or CmdBytes, since redis rejected the
+// command without processing it.
+//
+// * Other connection errors, e.g. a connection reset, are only retryable for
+// Actions created using Cmd, FlatCmd or CmdBytes whose command is
+// idempotent, as determined by IsIdempotentCommand, since it's unknown
+// whether redis processed the command. This excludes blocking commands like
+// BLPOP, which might have removed an element before the connection failed.
+//
+// All other Actions, e.g. Pipelines or those created using WithConn, are only
+// retried after dial errors, since they might have been partially processed.
+//
+// Actions created using FlatCmd with an io.Reader argument, e.g. a
+// *bytes.Buffer, are never retried, since the reader might have already been
+// consumed by the failed attempt.
+func IsRetryableError(a Action, err error) bool {
+	_, inner := commandName(a)
+	cmd, ok := inner.(*cmdAction)
+	if ok && cmd.flat && hasReaderArg(cmd.flatArgs) {
+		return false
+	} else if isDialErr(err) {
+		return true
+	} else if !ok {
+		return false
+	}
+
+	if isTransientRedisErr(err) {
+		return true
+	}
+	return isConnErr(err) && IsIdempotentCommand(cmd.cmd)
+}
+
+// hasReaderArg returns true if any of the given FlatCmd arguments, or any
+// element of a slice, array or map argument, is an io.Reader.
+func hasReaderArg(args []interface{}) bool {
+	for _, arg := range args {
+		if isReaderArg(reflect.ValueOf(arg)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isReaderArg(v reflect.Value) bool {
+	if !v.IsValid() {
+		return false
+	} else if v.Type().Implements(readerT) {
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return isReaderArg(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return false
+		}
+		for i := 0; i < v.Len(); i++ {
+			if isReaderArg(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if isReaderArg(iter.Key()) || isReaderArg(iter.Value()) {
+				return true
+			}
+		}
+	}
+	return false
+}
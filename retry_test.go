@@ -0,0 +1,103 @@
+package radix
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	. "testing"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableError(t *T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	loadingErr := resp2.Error{E: errors.New("LOADING Redis is loading the dataset in memory")}
+
+	tests := []struct {
+		a   Action
+		err error
+		exp bool
+	}{
+		{Cmd(nil, "GET", "foo"), dialErr, true},
+		{Pipeline(Cmd(nil, "INCR", "foo")), dialErr, true},
+		{Cmd(nil, "INCR", "foo"), loadingErr, true},
+		{Cmd(nil, "INCR", "foo"), resp2.Error{E: errors.New("TRYAGAIN Multiple keys request during rehashing of slot")}, true},
+		{Cmd(nil, "GET", "foo"), resp2.Error{E: errors.New("CLUSTERDOWN The cluster is down")}, true},
+		{Cmd(nil, "GET", "foo"), resp2.Error{E: errors.New("ERR wrong number of arguments")}, false},
+		{Pipeline(Cmd(nil, "GET", "foo")), loadingErr, false},
+		{Cmd(nil, "GET", "foo"), io.EOF, true},
+		{Cmd(nil, "INCR", "foo"), io.EOF, false},
+		{Cmd(nil, "BLPOP", "foo", "0"), io.EOF, false},
+		{WithConn("foo", func(Conn) error { return nil }), io.EOF, false},
+		{Cmd(nil, "GET", "foo"), errors.New("some other error"), false},
+		{FlatCmd(nil, "SET", "foo", bytes.NewBufferString("bar")), dialErr, false},
+		{FlatCmd(nil, "SET", "foo", bytes.NewBufferString("bar")), loadingErr, false},
+		{FlatCmd(nil, "HSET", "foo", map[string]interface{}{"bar": bytes.NewBufferString("baz")}), loadingErr, false},
+		{FlatCmd(nil, "SET", "foo", []byte("bar")), loadingErr, true},
+		{WithDeadline(FlatCmd(nil, "GET", "foo", []interface{}{"bar", resp.NewLenReader(strings.NewReader("a"), 1)}), time.Now()), io.EOF, false},
+	}
+
+	for i, test := range tests {
+		assert.Equal(t, test.exp, IsRetryableError(test.a, test.err), "test %d: %v", i, test.err)
+	}
+}
+
+func TestRetryPolicy(t *T) {
+	loadingErr := resp2.Error{E: errors.New("LOADING Redis is loading the dataset in memory")}
+
+	t.Run("MaxAttempts", func(t *T) {
+		var attempts int
+		rp := RetryPolicy{Backoff: ConstantBackoff(0)}.withDefaults()
+		err := rp.do(Cmd(nil, "GET", "foo"), func(Action) error {
+			attempts++
+			return loadingErr
+		})
+		assert.Equal(t, loadingErr, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("Success", func(t *T) {
+		var attempts int
+		rp := RetryPolicy{MaxAttempts: 5, Backoff: ConstantBackoff(0)}.withDefaults()
+		err := rp.do(Cmd(nil, "GET", "foo"), func(Action) error {
+			if attempts++; attempts < 3 {
+				return loadingErr
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("MaxElapsed", func(t *T) {
+		var attempts int
+		rp := RetryPolicy{
+			MaxAttempts: 100,
+			MaxElapsed:  50 * time.Millisecond,
+			Backoff:     ConstantBackoff(20 * time.Millisecond),
+		}.withDefaults()
+		err := rp.do(Cmd(nil, "GET", "foo"), func(Action) error {
+			attempts++
+			return loadingErr
+		})
+		assert.Equal(t, loadingErr, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("NotRetryable", func(t *T) {
+		var attempts int
+		rp := RetryPolicy{Backoff: ConstantBackoff(0)}.withDefaults()
+		err := rp.do(Cmd(nil, "INCR", "foo"), func(Action) error {
+			attempts++
+			return io.EOF
+		})
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, 1, attempts)
+	})
+}
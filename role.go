@@ -0,0 +1,197 @@
+package radix
+
+import (
+	"bufio"
+	"strconv"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// RoleResult describes the reply of the ROLE command. Which fields besides Role
+// are set depends on the Role.
+type RoleResult struct {
+	// Role is one of "master", "slave" or "sentinel".
+	Role string
+
+	// Offset is the replication offset of a master, or the offset up to which a
+	// replica has processed the replication stream of its master.
+	Offset int64
+
+	// Replicas contains the replicas connected to a master.
+	Replicas []RoleReplica
+
+	// MasterAddr is the address of the master a replica is replicating from.
+	MasterAddr string
+
+	// MasterState is the state of a replica's link to its master, e.g.
+	// "connect", "sync" or "connected".
+	MasterState string
+
+	// Masters contains the names of all masters monitored by a sentinel.
+	Masters []string
+}
+
+// RoleReplica describes a single replica connected to a master, as returned by
+// the ROLE command.
+type RoleReplica struct {
+	Addr   string
+	Offset int64
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface, but only supports
+// unmarshaling the reply of ROLE.
+func (rr *RoleResult) UnmarshalRESP(br *bufio.Reader) error {
+	var arrHead resp2.ArrayHeader
+	if err := arrHead.UnmarshalRESP(br); err != nil {
+		return err
+	} else if arrHead.N == 0 {
+		return resp.ErrDiscarded{Err: errors.New("empty ROLE reply")}
+	}
+
+	*rr = RoleResult{}
+	if err := (resp2.Any{I: &rr.Role}).UnmarshalRESP(br); err != nil {
+		return discardAfterErr(br, arrHead.N-1, err)
+	}
+
+	// the shape of the rest of the reply depends on the role
+	var replicas [][]string
+	var masterHost, masterPort string
+	var rcvs []interface{}
+	switch rr.Role {
+	case "master":
+		rcvs = []interface{}{&rr.Offset, &replicas}
+	case "slave":
+		rcvs = []interface{}{&masterHost, &masterPort, &rr.MasterState, &rr.Offset}
+	case "sentinel":
+		rcvs = []interface{}{&rr.Masters}
+	}
+
+	if rcvs == nil || len(rcvs) != arrHead.N-1 {
+		for i := 1; i < arrHead.N; i++ {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+		}
+		return resp.ErrDiscarded{
+			Err: errors.Errorf("malformed ROLE reply with role %q and %d elements", rr.Role, arrHead.N),
+		}
+	}
+
+	for i, rcv := range rcvs {
+		if err := (resp2.Any{I: rcv}).UnmarshalRESP(br); err != nil {
+			return discardAfterErr(br, len(rcvs)-i-1, err)
+		}
+	}
+
+	if masterHost != "" {
+		rr.MasterAddr = masterHost + ":" + masterPort
+	}
+
+	// the whole reply was read already, so the errors can be discarded
+	for _, replicaStrs := range replicas {
+		if len(replicaStrs) < 3 {
+			return resp.ErrDiscarded{Err: errors.Errorf("malformed replica array: %#v", replicaStrs)}
+		}
+		offset, err := strconv.ParseInt(replicaStrs[2], 10, 64)
+		if err != nil {
+			return resp.ErrDiscarded{
+				Err: errors.Errorf("malformed replica offset %q: %w", replicaStrs[2], err),
+			}
+		}
+		rr.Replicas = append(rr.Replicas, RoleReplica{
+			Addr:   replicaStrs[0] + ":" + replicaStrs[1],
+			Offset: offset,
+		})
+	}
+
+	return nil
+}
+
+// Role performs a ROLE command using the given Client and returns its
+// unmarshaled reply.
+func Role(c Client) (RoleResult, error) {
+	var rr RoleResult
+	err := c.Do(Cmd(&rr, "ROLE"))
+	return rr, err
+}
+
+// ReplicationOffset returns the replication offset of the redis instance, as
+// returned by ROLE. For a master this is the offset up to which it has written
+// its replication stream, which includes all writes completed before calling
+// ReplicationOffset. For a replica this is the offset up to which it has
+// processed the replication stream of its master.
+//
+// The offset of a master can be passed to WithReplicationBarrier in order to
+// ensure that a read performed on a replica sees all of those writes.
+func ReplicationOffset(c Client) (int64, error) {
+	rr, err := Role(c)
+	if err != nil {
+		return 0, err
+	} else if rr.Role == "sentinel" {
+		return 0, errors.New("sentinels don't have a replication offset")
+	}
+	return rr.Offset, nil
+}
+
+// ErrReplicationBarrierTimeout is returned by Actions created using
+// WithReplicationBarrier if the instance didn't reach the replication offset
+// within the given timeout.
+var ErrReplicationBarrierTimeout = errors.New("timed out waiting for replication offset")
+
+// replicationBarrierInterval is the interval at which replicationBarrierAction
+// checks the replication offset.
+const replicationBarrierInterval = 10 * time.Millisecond
+
+type replicationBarrierAction struct {
+	Action
+	offset  int64
+	timeout time.Duration
+}
+
+// WithReplicationBarrier wraps the given Action such that, before running the
+// Action, it waits for the instance it is run on to reach the given replication
+// offset, as returned by ReplicationOffset on the master. This can be used for
+// read-your-writes consistency when reading from replicas, e.g. using the
+// DoSecondary methods of Sentinel and Cluster:
+//
+//	// after performing some writes on the master
+//	offset, err := radix.ReplicationOffset(sentinel)
+//
+//	// the read will only be performed once the chosen replica has seen all
+//	// writes up to offset
+//	err = sentinel.DoSecondary(radix.WithReplicationBarrier(
+//		radix.Cmd(&val, "GET", "foo"), offset, time.Second,
+//	))
+//
+// The offset is checked using ROLE on the same Conn the Action is run on,
+// every 10 milliseconds, until it is reached. If it isn't reached within the
+// given timeout ErrReplicationBarrierTimeout is returned and the Action is not
+// run.
+func WithReplicationBarrier(a Action, offset int64, timeout time.Duration) Action {
+	return &replicationBarrierAction{Action: a, offset: offset, timeout: timeout}
+}
+
+func (rba *replicationBarrierAction) Run(conn Conn) error {
+	deadline := time.Now().Add(rba.timeout)
+	for {
+		var rr RoleResult
+		if err := conn.Do(Cmd(&rr, "ROLE")); err != nil {
+			return err
+		} else if rr.Offset >= rba.offset {
+			break
+		} else if !time.Now().Add(replicationBarrierInterval).Before(deadline) {
+			return ErrReplicationBarrierTimeout
+		}
+		time.Sleep(replicationBarrierInterval)
+	}
+	return rba.Action.Run(conn)
+}
+
+func (rba *replicationBarrierAction) ClusterCanRetry() bool {
+	ccra, ok := rba.Action.(ClusterCanRetryAction)
+	return ok && ccra.ClusterCanRetry()
+}
@@ -0,0 +1,132 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestRole(t *T) {
+	tests := []struct {
+		reply interface{}
+		exp   RoleResult
+	}{
+		{
+			reply: []interface{}{
+				"master", 3129659,
+				[]interface{}{
+					[]string{"127.0.0.1", "9001", "3129242"},
+					[]string{"127.0.0.1", "9002", "3129543"},
+				},
+			},
+			exp: RoleResult{
+				Role:   "master",
+				Offset: 3129659,
+				Replicas: []RoleReplica{
+					{Addr: "127.0.0.1:9001", Offset: 3129242},
+					{Addr: "127.0.0.1:9002", Offset: 3129543},
+				},
+			},
+		},
+		{
+			reply: []interface{}{"master", 0, []interface{}{}},
+			exp:   RoleResult{Role: "master"},
+		},
+		{
+			reply: []interface{}{"slave", "127.0.0.1", 9000, "connected", 3167038},
+			exp: RoleResult{
+				Role:        "slave",
+				Offset:      3167038,
+				MasterAddr:  "127.0.0.1:9000",
+				MasterState: "connected",
+			},
+		},
+		{
+			reply: []interface{}{"sentinel", []string{"resque-master", "html-fragments-master"}},
+			exp: RoleResult{
+				Role:    "sentinel",
+				Masters: []string{"resque-master", "html-fragments-master"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		stub := Stub("tcp", "127.0.0.1:6379", func([]string) interface{} {
+			return test.reply
+		})
+		rr, err := Role(stub)
+		require.NoError(t, err)
+		assert.Equal(t, test.exp, rr)
+	}
+
+	// in all cases the whole reply should have been discarded
+	malformed := map[string]interface{}{
+		"unknown role":   []interface{}{"foo", 1, 2},
+		"invalid offset": []interface{}{"master", "foo", []interface{}{}},
+		"invalid role":   []interface{}{[]string{"master"}, 1, []interface{}{}},
+		"invalid replica offset": []interface{}{
+			"master", 1, []interface{}{[]string{"127.0.0.1", "9001", "foo"}},
+		},
+		"short replica": []interface{}{
+			"master", 1, []interface{}{[]string{"127.0.0.1", "9001"}},
+		},
+	}
+	for name, reply := range malformed {
+		t.Run(name, func(t *T) {
+			buf := new(bytes.Buffer)
+			require.NoError(t, resp2.Any{I: reply}.MarshalRESP(buf))
+			require.NoError(t, resp2.Any{I: "next"}.MarshalRESP(buf))
+			br := bufio.NewReader(buf)
+
+			var rr RoleResult
+			err := rr.UnmarshalRESP(br)
+			assert.True(t, errors.As(err, new(resp.ErrDiscarded)), "err: %v", err)
+
+			var next string
+			require.NoError(t, resp2.Any{I: &next}.UnmarshalRESP(br))
+			assert.Equal(t, "next", next)
+		})
+	}
+}
+
+func TestReplicationBarrier(t *T) {
+	var offset int64
+	var got []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = append(got, args[0])
+		switch args[0] {
+		case "ROLE":
+			// every check the replica has caught up a bit more
+			reply := []interface{}{"slave", "127.0.0.1", 9000, "connected", offset}
+			offset += 100
+			return reply
+		default:
+			return "bar"
+		}
+	})
+
+	n, err := ReplicationOffset(stub)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	got = nil
+	var res string
+	a := WithReplicationBarrier(Cmd(&res, "GET", "foo"), 350, time.Second)
+	assert.Equal(t, []string{"foo"}, a.Keys())
+	require.NoError(t, stub.Do(a))
+	assert.Equal(t, "bar", res)
+	assert.Equal(t, []string{"ROLE", "ROLE", "ROLE", "ROLE", "GET"}, got)
+
+	got = nil
+	err = stub.Do(WithReplicationBarrier(Cmd(nil, "GET", "foo"), 1e9, 30*time.Millisecond))
+	assert.True(t, errors.Is(err, ErrReplicationBarrierTimeout))
+	assert.NotContains(t, got, "GET")
+}
@@ -7,6 +7,7 @@ import (
 
 	errors "golang.org/x/xerrors"
 
+	"github.com/mediocregopher/radix/v3/resp"
 	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
 
@@ -45,6 +46,18 @@ type ScanOpts struct {
 	// If used with an older version of Redis or with a Command other than
 	// "SCAN", scanning will fail.
 	Type string
+
+	// Dedup causes the Scanner to skip elements it already returned. Redis may
+	// return an element multiple times during a single scan, e.g. if the
+	// keyspace was resized, and with Cluster.NewScanner keys may also be
+	// returned by two different nodes if they were moved in between, i.e.
+	// while the cluster is being resharded.
+	//
+	// The Scanner has to keep track of all returned elements, so memory usage
+	// grows with the number of elements. As the elements of HSCAN and ZSCAN
+	// are pairs, which can't be deduplicated individually, Dedup may only be
+	// used with SCAN and SSCAN.
+	Dedup bool
 }
 
 func (o ScanOpts) cmd(rcv interface{}, cursor string) CmdAction {
@@ -79,6 +92,10 @@ type scanner struct {
 	res    scanResult
 	resIdx int
 	err    error
+
+	// scanned is set once the first scan command has been performed, as only
+	// after that a cursor of "0" means the scan is done.
+	scanned bool
 }
 
 // NewScanner creates a new Scanner instance which will iterate over the redis
@@ -87,13 +104,17 @@ type scanner struct {
 // NOTE if Client is a *Cluster this will not work correctly, use the NewScanner
 // method on Cluster instead.
 func NewScanner(c Client, o ScanOpts) Scanner {
-	return &scanner{
+	var s Scanner = &scanner{
 		Client:   c,
 		ScanOpts: o,
 		res: scanResult{
 			cur: "0",
 		},
 	}
+	if o.Dedup {
+		s = newDedupScanner(s, o.Command)
+	}
+	return s
 }
 
 func (s *scanner) Next(res *string) bool {
@@ -110,12 +131,13 @@ func (s *scanner) Next(res *string) bool {
 			}
 		}
 
-		if s.res.cur == "0" && s.res.keys != nil {
+		if s.res.cur == "0" && s.scanned {
 			return false
 		}
 
 		s.err = s.Client.Do(s.cmd(&s.res, s.res.cur))
 		s.resIdx = 0
+		s.scanned = true
 	}
 }
 
@@ -123,6 +145,32 @@ func (s *scanner) Close() error {
 	return s.err
 }
 
+// dedupScanner wraps a Scanner to skip the elements it already returned, see
+// ScanOpts.Dedup.
+type dedupScanner struct {
+	Scanner
+	seen map[string]struct{}
+}
+
+func newDedupScanner(s Scanner, cmd string) Scanner {
+	switch strings.ToUpper(cmd) {
+	case "SCAN", "SSCAN":
+	default:
+		panic("ScanOpts.Dedup can only be used with SCAN and SSCAN")
+	}
+	return &dedupScanner{Scanner: s, seen: map[string]struct{}{}}
+}
+
+func (ds *dedupScanner) Next(res *string) bool {
+	for ds.Scanner.Next(res) {
+		if _, ok := ds.seen[*res]; !ok {
+			ds.seen[*res] = struct{}{}
+			return true
+		}
+	}
+	return false
+}
+
 type scanResult struct {
 	cur  string
 	keys []string
@@ -146,3 +194,156 @@ func (s *scanResult) UnmarshalRESP(br *bufio.Reader) error {
 
 	return (resp2.Any{I: &s.keys}).UnmarshalRESP(br)
 }
+
+// KeyValue is a key together with its type and value, as returned by a
+// ValueScanner.
+type KeyValue struct {
+	Key string
+
+	// Type is the type of the key, as returned by TYPE.
+	Type string
+
+	// Value depends on Type:
+	//
+	//	"string": string
+	//	"hash":   map[string]string
+	//	"list":   []string
+	//	"set":    []string
+	//	"zset":   []ZMember
+	//	"stream": []StreamEntry
+	//
+	// For all other types, e.g. those added by modules, Value is nil.
+	Value interface{}
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface. It only supports
+// unmarshaling the reply of keyValueScript.
+func (kv *KeyValue) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 1 {
+		return resp.ErrDiscarded{Err: errors.New("empty key value reply")}
+	} else if err := (resp2.Any{I: &kv.Type}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	var rcv interface{}
+	switch kv.Type {
+	case "string":
+		var s string
+		rcv, kv.Value = &s, &s
+	case "hash":
+		m := map[string]string{}
+		rcv, kv.Value = &m, m
+	case "list", "set":
+		var ss []string
+		rcv, kv.Value = &ss, &ss
+	case "zset":
+		var zm zMembers
+		rcv, kv.Value = &zm, &zm
+	case "stream":
+		var entries []StreamEntry
+		rcv, kv.Value = &entries, &entries
+	default:
+		kv.Value = nil
+	}
+
+	var err error
+	for i := 1; i < ah.N; i++ {
+		if i == 1 && rcv != nil {
+			err = (resp2.Any{I: rcv}).UnmarshalRESP(br)
+		} else {
+			err = (resp2.Any{}).UnmarshalRESP(br)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// dereference the pointers used for unmarshaling
+	switch v := kv.Value.(type) {
+	case *string:
+		kv.Value = *v
+	case *[]string:
+		kv.Value = *v
+	case *zMembers:
+		kv.Value = []ZMember(*v)
+	case *[]StreamEntry:
+		kv.Value = *v
+	}
+	return nil
+}
+
+// keyValueScript reads the type and value of a key atomically, so that keys
+// changing their type in between don't need to be handled.
+var keyValueScript = NewEvalScript(1, `
+	local t = redis.call("TYPE", KEYS[1]).ok
+	if t == "string" then
+		return {t, redis.call("GET", KEYS[1])}
+	elseif t == "hash" then
+		return {t, redis.call("HGETALL", KEYS[1])}
+	elseif t == "list" then
+		return {t, redis.call("LRANGE", KEYS[1], 0, -1)}
+	elseif t == "set" then
+		return {t, redis.call("SMEMBERS", KEYS[1])}
+	elseif t == "zset" then
+		return {t, redis.call("ZRANGE", KEYS[1], 0, -1, "WITHSCORES")}
+	elseif t == "stream" then
+		return {t, redis.call("XRANGE", KEYS[1], "-", "+")}
+	end
+	return {t}
+`)
+
+// ValueScanner is used to iterate through keys together with their values.
+//
+// Once created, repeatedly call Next() on it to fill the passed in KeyValue
+// pointer with the next key and its value. Next will return false if there's
+// no more keys to retrieve or if an error occurred, at which point Close should
+// be called to retrieve any error.
+type ValueScanner interface {
+	Next(*KeyValue) bool
+	Close() error
+}
+
+type valueScanner struct {
+	c   Client
+	s   Scanner
+	err error
+}
+
+// NewValueScanner creates a new ValueScanner, which iterates over all keys
+// returned by the given Scanner and reads the type and value of each key using
+// the given Client. The Scanner must be scanning over keys, i.e. be created
+// using a ScanOpts with the "SCAN" Command, and can be created using either
+// NewScanner or Cluster.NewScanner. The ScanOpts' Type can be used to only scan
+// keys of a specific type.
+//
+// The type and value of each key are read atomically using a lua script. Keys
+// which are deleted after being returned by the Scanner are skipped. Since
+// values are read in full, this should not be used for keys with very large
+// values.
+func NewValueScanner(c Client, s Scanner) ValueScanner {
+	return &valueScanner{c: c, s: s}
+}
+
+func (vs *valueScanner) Next(kv *KeyValue) bool {
+	var key string
+	for vs.err == nil && vs.s.Next(&key) {
+		*kv = KeyValue{Key: key}
+		if vs.err = vs.c.Do(keyValueScript.Cmd(kv, key)); vs.err != nil {
+			return false
+		} else if kv.Type == "none" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (vs *valueScanner) Close() error {
+	if err := vs.s.Close(); err != nil {
+		return err
+	}
+	return vs.err
+}
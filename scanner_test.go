@@ -8,6 +8,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
 
 var redisVersionPat = regexp.MustCompile(`(?m)^redis_version:(\d+)\.(\d+)\.(\d+).*$`)
@@ -68,6 +71,86 @@ func TestScanner(t *T) {
 	require.Nil(t, sc.Close())
 }
 
+func TestScannerStub(t *T) {
+	type page struct {
+		cur   string
+		elems []string
+	}
+	newStub := func(pages map[string]page) (Client, *[][]string) {
+		var cmds [][]string
+		return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+			cmds = append(cmds, args)
+			cur := args[1]
+			if args[0] != "SCAN" {
+				cur = args[2]
+			}
+			p := pages[cur]
+			return []interface{}{p.cur, p.elems}
+		}), &cmds
+	}
+
+	scanAll := func(t *T, c Client, o ScanOpts) []string {
+		res := []string{}
+		sc := NewScanner(c, o)
+		var elem string
+		for sc.Next(&elem) {
+			res = append(res, elem)
+		}
+		require.NoError(t, sc.Close())
+		return res
+	}
+
+	t.Run("empty pages", func(t *T) {
+		c, cmds := newStub(map[string]page{
+			"0": {cur: "5"},
+			"5": {cur: "7", elems: []string{"a"}},
+			"7": {cur: "9"},
+			"9": {cur: "0", elems: []string{"b"}},
+		})
+		res := scanAll(t, c, ScanOpts{Command: "SCAN", Pattern: "*", Count: 10})
+		assert.Equal(t, []string{"a", "b"}, res)
+		assert.Equal(t, [][]string{
+			{"SCAN", "0", "MATCH", "*", "COUNT", "10"},
+			{"SCAN", "5", "MATCH", "*", "COUNT", "10"},
+			{"SCAN", "7", "MATCH", "*", "COUNT", "10"},
+			{"SCAN", "9", "MATCH", "*", "COUNT", "10"},
+		}, *cmds)
+	})
+
+	t.Run("empty", func(t *T) {
+		c, cmds := newStub(map[string]page{
+			"0": {cur: "0"},
+		})
+		assert.Empty(t, scanAll(t, c, ScanAllKeys))
+		assert.Len(t, *cmds, 1)
+	})
+
+	t.Run("hscan", func(t *T) {
+		c, cmds := newStub(map[string]page{
+			"0": {cur: "3", elems: []string{"f1", "v1"}},
+			"3": {cur: "0", elems: []string{"f2", "v2"}},
+		})
+		res := scanAll(t, c, ScanOpts{Command: "HSCAN", Key: "hash"})
+		assert.Equal(t, []string{"f1", "v1", "f2", "v2"}, res)
+		assert.Equal(t, [][]string{{"HSCAN", "hash", "0"}, {"HSCAN", "hash", "3"}}, *cmds)
+	})
+
+	t.Run("dedup", func(t *T) {
+		pages := map[string]page{
+			"0": {cur: "3", elems: []string{"a", "b"}},
+			"3": {cur: "0", elems: []string{"b", "c", "a"}},
+		}
+		c, _ := newStub(pages)
+		assert.Equal(t, []string{"a", "b", "b", "c", "a"}, scanAll(t, c, ScanAllKeys))
+		c, _ = newStub(pages)
+		assert.Equal(t, []string{"a", "b", "c"}, scanAll(t, c, ScanOpts{Command: "SSCAN", Key: "set", Dedup: true}))
+
+		assert.Panics(t, func() {
+			NewScanner(c, ScanOpts{Command: "HSCAN", Key: "hash", Dedup: true})
+		})
+	})
+}
+
 // Similar to TestScanner, but scans over a set instead of the whole key space
 func TestScannerSet(t *T) {
 	c := dial()
@@ -126,6 +209,56 @@ func TestScannerType(t *T) {
 	scanType("zset")
 }
 
+func TestValueScanner(t *T) {
+	replies := map[string]interface{}{
+		"s":  []interface{}{"string", "foo"},
+		"h":  []interface{}{"hash", []string{"a", "1", "b", "2"}},
+		"l":  []interface{}{"list", []string{"c", "b", "a"}},
+		"st": []interface{}{"set", []string{"x", "y"}},
+		"z":  []interface{}{"zset", []string{"one", "1", "two", "2.5"}},
+		"x": []interface{}{"stream", []interface{}{
+			[]interface{}{"1-1", []string{"f", "v"}},
+		}},
+		"m":    []interface{}{"ReJSON-RL"},
+		"gone": []interface{}{"none"},
+	}
+	keys := []string{"s", "gone", "h", "l", "st", "z", "x", "m"}
+
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "SCAN":
+			return []interface{}{"0", keys}
+		case "EVALSHA":
+			return resp2.Error{E: errors.New("NOSCRIPT")}
+		case "EVAL":
+			// emulates keyValueScript
+			return replies[args[3]]
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	vs := NewValueScanner(stub, NewScanner(stub, ScanAllKeys))
+	var got []KeyValue
+	var kv KeyValue
+	for vs.Next(&kv) {
+		got = append(got, kv)
+	}
+	require.NoError(t, vs.Close())
+
+	assert.Equal(t, []KeyValue{
+		{Key: "s", Type: "string", Value: "foo"},
+		{Key: "h", Type: "hash", Value: map[string]string{"a": "1", "b": "2"}},
+		{Key: "l", Type: "list", Value: []string{"c", "b", "a"}},
+		{Key: "st", Type: "set", Value: []string{"x", "y"}},
+		{Key: "z", Type: "zset", Value: []ZMember{{"one", 1}, {"two", 2.5}}},
+		{Key: "x", Type: "stream", Value: []StreamEntry{
+			{ID: StreamEntryID{Time: 1, Seq: 1}, Fields: map[string]string{"f": "v"}},
+		}},
+		{Key: "m", Type: "ReJSON-RL"},
+	}, got)
+}
+
 func BenchmarkScanner(b *B) {
 	c := dial()
 
@@ -0,0 +1,304 @@
+package radix
+
+import (
+	"strconv"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// ErrSemaphoreTimeout is returned by Semaphore.Acquire if no slot could be
+// acquired within the given timeout.
+var ErrSemaphoreTimeout = errors.New("timed out acquiring the semaphore")
+
+// ErrSemaphoreNotHeld is returned by Semaphore.Release and Semaphore.Extend if
+// the given token doesn't hold a slot of the semaphore, e.g. because it
+// already expired.
+var ErrSemaphoreNotHeld = errors.New("semaphore slot not held")
+
+type semaphoreOpts struct {
+	ttl          time.Duration
+	retryBackoff Backoff
+}
+
+// SemaphoreOpt is an optional behavior which can be applied to the
+// NewSemaphore function to effect a Semaphore's behavior.
+type SemaphoreOpt func(*semaphoreOpts)
+
+// SemaphoreTTL sets the duration after which a slot held by a holder expires,
+// unless it is extended using Extend. This ensures that slots of holders which
+// crashed without releasing them become available again.
+func SemaphoreTTL(ttl time.Duration) SemaphoreOpt {
+	return func(so *semaphoreOpts) {
+		so.ttl = ttl
+	}
+}
+
+// SemaphoreRetryBackoff sets the Backoff used by Acquire to determine how long
+// to wait before retrying to acquire a slot while all slots are held.
+func SemaphoreRetryBackoff(b Backoff) SemaphoreOpt {
+	return func(so *semaphoreOpts) {
+		so.retryBackoff = b
+	}
+}
+
+// The holders are stored in a sorted set, with the time they acquired the slot
+// or last extended it as their score. Holders whose score is older than the
+// TTL are removed before checking the number of holders. The time of the redis
+// instance is used, so that the clocks of the holders don't need to be in sync.
+//
+// Callers of Acquire which are waiting for a slot are queued in a second
+// sorted set, using a ticket taken from a counter as their score, and a slot
+// is only acquired if the number of free slots is larger than the number of
+// waiters queued before the caller. The time each waiter last tried to acquire
+// a slot is stored in a third sorted set, and waiters which didn't try within
+// the TTL are dropped from the queue.
+//
+// KEYS[1]: the sorted set of holders
+// KEYS[2]: the sorted set of queued waiters
+// KEYS[3]: the sorted set of the times the waiters were last seen
+// KEYS[4]: the counter the tickets of the waiters are taken from
+// ARGV[1]: the limit
+// ARGV[2]: the TTL in milliseconds
+// ARGV[3]: the token of the new holder
+// ARGV[4]: "1" if the new holder should be queued if no slot is free
+var acquireSemaphoreScript = NewEvalScript(4, `
+	local t = redis.call("TIME")
+	local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	local expired = now - tonumber(ARGV[2])
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", expired)
+	for _, token in ipairs(redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", expired)) do
+		redis.call("ZREM", KEYS[2], token)
+	end
+	redis.call("ZREMRANGEBYSCORE", KEYS[3], "-inf", expired)
+
+	local free = tonumber(ARGV[1]) - redis.call("ZCARD", KEYS[1])
+	local rank = redis.call("ZRANK", KEYS[2], ARGV[3])
+	local ahead = rank or redis.call("ZCARD", KEYS[2])
+	if ahead < free then
+		redis.call("ZREM", KEYS[2], ARGV[3])
+		redis.call("ZREM", KEYS[3], ARGV[3])
+		redis.call("ZADD", KEYS[1], now, ARGV[3])
+		redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		return 1
+	end
+
+	if ARGV[4] == "1" then
+		if not rank then
+			redis.call("ZADD", KEYS[2], redis.call("INCR", KEYS[4]), ARGV[3])
+		end
+		redis.call("ZADD", KEYS[3], now, ARGV[3])
+		for i = 2, 4 do
+			redis.call("PEXPIRE", KEYS[i], ARGV[2])
+		end
+	end
+	return 0
+`)
+
+// KEYS[1]: the sorted set of holders
+// ARGV[1]: the TTL in milliseconds
+// ARGV[2]: the token of the holder
+var extendSemaphoreScript = NewEvalScript(1, `
+	local t = redis.call("TIME")
+	local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	local score = redis.call("ZSCORE", KEYS[1], ARGV[2])
+	if not score or tonumber(score) <= now - tonumber(ARGV[1]) then
+		return 0
+	end
+	redis.call("ZADD", KEYS[1], "XX", now, ARGV[2])
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	return 1
+`)
+
+// The slot is removed even if it already expired, but is only reported as
+// released if it was still held.
+//
+// KEYS[1]: the sorted set of holders
+// ARGV[1]: the TTL in milliseconds
+// ARGV[2]: the token of the holder
+var releaseSemaphoreScript = NewEvalScript(1, `
+	local t = redis.call("TIME")
+	local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	local score = redis.call("ZSCORE", KEYS[1], ARGV[2])
+	if not score then
+		return 0
+	end
+	redis.call("ZREM", KEYS[1], ARGV[2])
+	if tonumber(score) <= now - tonumber(ARGV[1]) then
+		return 0
+	end
+	return 1
+`)
+
+// Semaphore is a counting semaphore stored in a single redis instance, which
+// can be used to limit the number of processes concurrently accessing some
+// resource. Up to limit holders can hold a slot of the semaphore at the same
+// time, each identified by a random token generated when acquiring the slot.
+//
+// Slots are acquired and extended atomically using lua scripts. Callers of
+// Acquire which are waiting for a slot are queued, so that slots are acquired
+// in the order in which the callers started waiting, rather than by whoever
+// happens to retry first once a slot is released. Unlike Mutex the methods of
+// a Semaphore may be called concurrently, as the tokens are returned to the
+// caller instead of being stored in the Semaphore.
+//
+// Besides the given key the Semaphore uses the keys key+":queue",
+// key+":waiters" and key+":tickets" for the queue of waiters. When used with a
+// Cluster the key must contain a hash tag, e.g. "{sem}", so that all of them
+// belong to the same slot.
+type Semaphore struct {
+	c     Client
+	key   string
+	limit int
+	opts  semaphoreOpts
+}
+
+// NewSemaphore returns a Semaphore with the given limit, whose holders are
+// stored in a sorted set at the given key.
+//
+// The default options NewSemaphore uses are:
+//
+//	SemaphoreTTL(10 * time.Second)
+//	SemaphoreRetryBackoff(ConstantBackoff(100 * time.Millisecond))
+//
+func NewSemaphore(c Client, key string, limit int, opts ...SemaphoreOpt) *Semaphore {
+	s := &Semaphore{c: c, key: key, limit: limit}
+
+	defaultSemaphoreOpts := []SemaphoreOpt{
+		SemaphoreTTL(10 * time.Second),
+		SemaphoreRetryBackoff(ConstantBackoff(100 * time.Millisecond)),
+	}
+	for _, opt := range append(defaultSemaphoreOpts, opts...) {
+		opt(&s.opts)
+	}
+	return s
+}
+
+func (s *Semaphore) ttlMillis() string {
+	return strconv.FormatInt(int64(s.opts.ttl/time.Millisecond), 10)
+}
+
+func (s *Semaphore) queueKey() string {
+	return s.key + ":queue"
+}
+
+func (s *Semaphore) waitersKey() string {
+	return s.key + ":waiters"
+}
+
+func (s *Semaphore) ticketsKey() string {
+	return s.key + ":tickets"
+}
+
+func (s *Semaphore) tryAcquire(token string, enqueue bool) (bool, error) {
+	var ok bool
+	enqueueArg := "0"
+	if enqueue {
+		enqueueArg = "1"
+	}
+	err := s.c.Do(acquireSemaphoreScript.Cmd(&ok,
+		s.key, s.queueKey(), s.waitersKey(), s.ticketsKey(),
+		strconv.Itoa(s.limit), s.ttlMillis(), token, enqueueArg,
+	))
+	return ok, err
+}
+
+// dequeue removes the given token from the queue of waiters.
+func (s *Semaphore) dequeue(token string) error {
+	return s.c.Do(Pipeline(
+		Cmd(nil, "ZREM", s.queueKey(), token),
+		Cmd(nil, "ZREM", s.waitersKey(), token),
+	))
+}
+
+// TryAcquire tries to acquire a slot once. If a slot was acquired ok will be
+// true, and the returned token must be used to release or extend the slot.
+//
+// TryAcquire doesn't queue, so it will not acquire a slot while callers of
+// Acquire are waiting for one.
+func (s *Semaphore) TryAcquire() (token string, ok bool, err error) {
+	if token, err = newLockToken(); err != nil {
+		return "", false, err
+	}
+
+	if ok, err = s.tryAcquire(token, false); err != nil {
+		return "", false, err
+	} else if !ok {
+		return "", false, nil
+	}
+	return token, true, nil
+}
+
+// Acquire acquires a slot, retrying until either a slot was acquired or the
+// timeout is reached, in which case ErrSemaphoreTimeout is returned. A timeout
+// of zero causes Acquire to retry indefinitely.
+//
+// While retrying the caller is queued, and slots are acquired in the order in
+// which the callers were queued. A caller which doesn't retry within the TTL
+// of the Semaphore, e.g. because the Backoff given to SemaphoreRetryBackoff
+// waits for longer than that, loses its place in the queue.
+func (s *Semaphore) Acquire(timeout time.Duration) (string, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 1; ; attempt++ {
+		if ok, err := s.tryAcquire(token, true); err != nil {
+			// the queued token will be dropped once the TTL has passed if it
+			// can't be removed either
+			_ = s.dequeue(token)
+			return "", err
+		} else if ok {
+			return token, nil
+		}
+		wait := s.opts.retryBackoff.Next(attempt)
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			if err := s.dequeue(token); err != nil {
+				return "", err
+			}
+			return "", ErrSemaphoreTimeout
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Release releases the slot held by the given token. If the token doesn't hold
+// a slot anymore ErrSemaphoreNotHeld is returned. This includes slots which
+// have expired but haven't been cleaned up yet, since those may already have
+// been acquired by someone else.
+func (s *Semaphore) Release(token string) error {
+	var released bool
+	if err := s.c.Do(releaseSemaphoreScript.Cmd(&released, s.key, s.ttlMillis(), token)); err != nil {
+		return err
+	} else if !released {
+		return ErrSemaphoreNotHeld
+	}
+	return nil
+}
+
+// Extend resets the expiry of the slot held by the given token to the
+// Semaphore's TTL. If the token doesn't hold a slot anymore ErrSemaphoreNotHeld
+// is returned.
+func (s *Semaphore) Extend(token string) error {
+	var extended bool
+	if err := s.c.Do(extendSemaphoreScript.Cmd(&extended, s.key, s.ttlMillis(), token)); err != nil {
+		return err
+	} else if !extended {
+		return ErrSemaphoreNotHeld
+	}
+	return nil
+}
+
+// Holders returns the number of slots currently held, including slots which
+// have expired but haven't been cleaned up yet by the next acquisition.
+func (s *Semaphore) Holders() (int, error) {
+	var n int
+	err := s.c.Do(Cmd(&n, "ZCARD", s.key))
+	return n, err
+}
@@ -0,0 +1,237 @@
+package radix
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// testSemaphoreState is the state of a semaphore emulated by
+// testSemaphoreStub. now is used as the current time in milliseconds.
+type testSemaphoreState struct {
+	l       sync.Mutex
+	now     int64
+	holders map[string]int64
+	queue   []string
+	waiters map[string]int64
+}
+
+func (st *testSemaphoreState) dequeue(token string) {
+	for i := range st.queue {
+		if st.queue[i] == token {
+			st.queue = append(st.queue[:i], st.queue[i+1:]...)
+			break
+		}
+	}
+	delete(st.waiters, token)
+}
+
+func (st *testSemaphoreState) rank(token string) int {
+	for i := range st.queue {
+		if st.queue[i] == token {
+			return i
+		}
+	}
+	return -1
+}
+
+func (st *testSemaphoreState) acquire(limit int, ttl int64, token string, enqueue bool) int {
+	for token, score := range st.holders {
+		if score <= st.now-ttl {
+			delete(st.holders, token)
+		}
+	}
+	for token, seen := range st.waiters {
+		if seen <= st.now-ttl {
+			st.dequeue(token)
+		}
+	}
+
+	rank := st.rank(token)
+	ahead := rank
+	if rank < 0 {
+		ahead = len(st.queue)
+	}
+	if ahead < limit-len(st.holders) {
+		st.dequeue(token)
+		st.holders[token] = st.now
+		return 1
+	}
+
+	if enqueue {
+		if rank < 0 {
+			st.queue = append(st.queue, token)
+		}
+		st.waiters[token] = st.now
+	}
+	return 0
+}
+
+// testSemaphoreStub returns a Conn which emulates the scripts used by
+// Semaphore on top of the given state.
+func testSemaphoreStub(st *testSemaphoreState) Conn {
+	st.holders = map[string]int64{}
+	st.waiters = map[string]int64{}
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		st.l.Lock()
+		defer st.l.Unlock()
+
+		switch args[0] {
+		case "EVALSHA":
+			return resp2.Error{E: errors.New("NOSCRIPT")}
+		case "EVAL":
+			numKeys, _ := strconv.Atoi(args[2])
+			script, argv := args[1], args[3+numKeys:]
+			switch {
+			case strings.Contains(script, "INCR"):
+				limit, _ := strconv.Atoi(argv[0])
+				ttl, _ := strconv.ParseInt(argv[1], 10, 64)
+				return st.acquire(limit, ttl, argv[2], argv[3] == "1")
+			case strings.Contains(script, `"XX"`):
+				ttl, _ := strconv.ParseInt(argv[0], 10, 64)
+				score, ok := st.holders[argv[1]]
+				if !ok || score <= st.now-ttl {
+					return 0
+				}
+				st.holders[argv[1]] = st.now
+				return 1
+			case strings.Contains(script, "ZREM"):
+				ttl, _ := strconv.ParseInt(argv[0], 10, 64)
+				score, ok := st.holders[argv[1]]
+				delete(st.holders, argv[1])
+				if !ok || score <= st.now-ttl {
+					return 0
+				}
+				return 1
+			}
+		case "ZREM":
+			st.dequeue(args[2])
+			return 1
+		case "ZCARD":
+			return len(st.holders)
+		}
+		return errors.Errorf("testSemaphoreStub doesn't support command %q", args[0])
+	})
+}
+
+func TestSemaphore(t *T) {
+	st := &testSemaphoreState{now: 1000}
+	stub := testSemaphoreStub(st)
+	s := NewSemaphore(stub, "sem", 2,
+		SemaphoreTTL(time.Second),
+		SemaphoreRetryBackoff(ConstantBackoff(time.Millisecond)),
+	)
+
+	tokenA, ok, err := s.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, ok)
+	tokenB, err := s.Acquire(0)
+	require.NoError(t, err)
+	assert.NotEqual(t, tokenA, tokenB)
+
+	_, ok, err = s.TryAcquire()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = s.Acquire(10 * time.Millisecond)
+	assert.Equal(t, ErrSemaphoreTimeout, err)
+
+	n, err := s.Holders()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// releasing a slot makes it available again
+	require.NoError(t, s.Release(tokenA))
+	assert.Equal(t, ErrSemaphoreNotHeld, s.Release(tokenA))
+	tokenC, ok, err := s.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// slots of holders which didn't extend them expire
+	st.now += 600
+	require.NoError(t, s.Extend(tokenC))
+	st.now += 600
+	assert.Equal(t, ErrSemaphoreNotHeld, s.Extend(tokenB))
+	tokenD, ok, err := s.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.NotContains(t, st.holders, tokenB)
+	assert.Contains(t, st.holders, tokenC)
+	assert.Contains(t, st.holders, tokenD)
+}
+
+func TestSemaphoreQueue(t *T) {
+	st := &testSemaphoreState{now: 1000}
+	stub := testSemaphoreStub(st)
+	s := NewSemaphore(stub, "sem", 1,
+		SemaphoreTTL(time.Second),
+		SemaphoreRetryBackoff(ConstantBackoff(time.Millisecond)),
+	)
+
+	tokenA, ok, err := s.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	tokenCh := make(chan string)
+	go func() {
+		token, err := s.Acquire(0)
+		assert.NoError(t, err)
+		tokenCh <- token
+	}()
+	for queued := false; !queued; {
+		time.Sleep(time.Millisecond)
+		st.l.Lock()
+		queued = len(st.queue) == 1
+		st.l.Unlock()
+	}
+
+	// the released slot goes to the queued caller of Acquire, even though
+	// TryAcquire is called before it retries
+	require.NoError(t, s.Release(tokenA))
+	_, ok, err = s.TryAcquire()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	tokenB := <-tokenCh
+	assert.Contains(t, st.holders, tokenB)
+	assert.Empty(t, st.queue)
+
+	// a caller of Acquire which times out is removed from the queue
+	_, err = s.Acquire(10 * time.Millisecond)
+	assert.Equal(t, ErrSemaphoreTimeout, err)
+	assert.Empty(t, st.queue)
+	assert.Empty(t, st.waiters)
+
+	// a waiter which stopped retrying is dropped from the queue after the TTL
+	require.NoError(t, s.Release(tokenB))
+	st.queue, st.waiters["gone"] = []string{"gone"}, st.now
+	_, ok, err = s.TryAcquire()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	st.now += 1000
+	_, ok, err = s.TryAcquire()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, st.queue)
+}
+
+func TestSemaphoreReleaseExpired(t *T) {
+	st := &testSemaphoreState{now: 1000}
+	stub := testSemaphoreStub(st)
+	s := NewSemaphore(stub, "sem", 1, SemaphoreTTL(time.Second))
+
+	tokenA, ok, err := s.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// the expired slot is still stored, but releasing it must not succeed
+	st.now += 1000
+	assert.Equal(t, ErrSemaphoreNotHeld, s.Release(tokenA))
+	assert.NotContains(t, st.holders, tokenA)
+}
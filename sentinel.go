@@ -10,8 +10,9 @@ import (
 )
 
 type sentinelOpts struct {
-	cf ConnFunc
-	pf ClientFunc
+	cf         ConnFunc
+	pf         ClientFunc
+	readRouter readRouter
 }
 
 // SentinelOpt is an optional behavior which can be applied to the NewSentinel
@@ -40,6 +41,21 @@ func SentinelPoolFunc(pf ClientFunc) SentinelOpt {
 	}
 }
 
+// SentinelReadPolicy tells the Sentinel to perform read-only commands passed to
+// Do according to the given ReadPolicy, e.g. on a replica of the primary.
+// isRead is used to determine whether a command is read-only. If nil
+// IsIdempotentCommand is used. Actions other than those created using Cmd or
+// FlatCmd, and Actions wrapped using Primary, are always performed on the
+// primary.
+//
+// The same as for DoSecondary, replicas must be configured with
+// replica-read-only enabled.
+func SentinelReadPolicy(policy ReadPolicy, isRead func(cmd string) bool) SentinelOpt {
+	return func(so *sentinelOpts) {
+		so.readRouter = newReadRouter(policy, isRead)
+	}
+}
+
 // Sentinel is a Client which, in the background, connects to an available
 // sentinel node and handles all of the following:
 //
@@ -194,17 +210,52 @@ func (sc *Sentinel) dialSentinel() (Conn, error) {
 }
 
 // Do implements the method for the Client interface. It will pass the given
-// action on to the current primary.
+// action on to the current primary, unless it's a read-only command which is
+// performed on a replica according to the SentinelReadPolicy.
 //
 // NOTE it's possible that in between Do being called and the Action being
 // actually carried out that there could be a failover event. In that case, the
 // Action will likely fail and return an error.
 func (sc *Sentinel) Do(a Action) error {
+	if sc.so.readRouter.routed(a) {
+		return sc.doRead(a)
+	}
 	sc.l.RLock()
 	defer sc.l.RUnlock()
 	return sc.clients[sc.primAddr].Do(a)
 }
 
+func (sc *Sentinel) doRead(a Action) error {
+	sc.l.RLock()
+	primAddr := sc.primAddr
+	secAddrs := make([]string, 0, len(sc.clients))
+	for addr := range sc.clients {
+		if addr != primAddr {
+			secAddrs = append(secAddrs, addr)
+		}
+	}
+	sc.l.RUnlock()
+
+	addr, err := sc.so.readRouter.pick(primAddr, secAddrs, func(addr string) time.Duration {
+		// Clients for replicas are created lazily, so their latency is only
+		// known once they were created
+		client, err := sc.clientInner(addr)
+		if err != nil {
+			return 0
+		}
+		return clientLatency(client)
+	})
+	if err != nil {
+		return err
+	}
+
+	client, err := sc.clientInner(addr)
+	if err != nil {
+		return err
+	}
+	return client.Do(a)
+}
+
 // DoSecondary is like Do but executes the Action on a random replica if possible.
 //
 // For DoSecondary to work, replicas must be configured with replica-read-only
@@ -213,12 +264,25 @@ func (sc *Sentinel) Do(a Action) error {
 // NOTE it's possible that in between DoSecondary being called and the Action being
 // actually carried out that there could be a failover event. In that case, the
 // Action will likely fail and return an error.
+//
+// Actions wrapped using Primary are always sent to the primary. Actions of a
+// read-only Function are sent to the primary if the function is missing on the
+// secondary, see Function.ReadOnly.
 func (sc *Sentinel) DoSecondary(a Action) error {
+	if isPrimary(a) {
+		return sc.Do(a)
+	}
+
 	c, err := sc.clientInner("")
 	if err != nil {
 		return err
 	}
-	return c.Do(a)
+	err = c.Do(a)
+	if isFunctionNotFound(err) {
+		// the library of a Function can only be loaded on the primary
+		return sc.Do(a)
+	}
+	return err
 }
 
 // Addrs returns the currently known network address of the current primary
@@ -277,6 +341,7 @@ func (sc *Sentinel) clientInner(addr string) (Client, error) {
 	} else {
 		var ok bool
 		if client, ok = sc.clients[addr]; !ok {
+			sc.l.RUnlock()
 			return nil, errUnknownAddress
 		}
 	}
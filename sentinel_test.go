@@ -451,6 +451,16 @@ func TestSentinelSecondaryRead(t *T) {
 			assert.NotEqualf(t, scc.primAddr, addr, "command was sent to master at %s", primAddr)
 			assert.Containsf(t, secAddrs, addr, "returned address if not a secondary. expected one of %v, got %v", secAddrs, addr)
 		}
+
+		var addr string
+		require.NoError(t, scc.DoSecondary(Primary(Cmd(&addr, "GIMME", "YOUR", "ADDRESS"))))
+		assert.Equal(t, primAddr, addr)
+
+		// Primary is also detected within other wrappers
+		addr = ""
+		a := WithTimeout(WithCommandName(Primary(Cmd(&addr, "GIMME", "YOUR", "ADDRESS")), "gimme"), time.Second)
+		require.NoError(t, scc.DoSecondary(a))
+		assert.Equal(t, primAddr, addr)
 	}
 
 	runTest(32)
@@ -460,3 +470,42 @@ func TestSentinelSecondaryRead(t *T) {
 
 	runTest(32)
 }
+
+func TestSentinelReadPolicy(t *T) {
+	stub := newSentinelStub(
+		"127.0.0.1:9736", // primAddr
+		[]string{"127.0.0.2:9736", "127.0.0.3:9736"},                    // secAddrs
+		[]string{"127.0.0.1:29736", "127.0.0.2:9736", "127.0.0.3:9736"}, // sentAddrs
+	)
+
+	poolFn := func(network, addr string) (Client, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			return addr
+		}), nil
+	}
+
+	scc, err := NewSentinel(
+		"stub",
+		stub.sentAddrs,
+		SentinelConnFunc(stub.newConn),
+		SentinelPoolFunc(poolFn),
+		SentinelReadPolicy(ReadPolicyPreferReplica, func(cmd string) bool {
+			return cmd == "READ"
+		}),
+	)
+	require.Nil(t, err)
+	defer scc.Close()
+
+	primAddr, secAddrs := scc.Addrs()
+	for i := 0; i < 32; i++ {
+		var addr string
+		require.NoError(t, scc.Do(Cmd(&addr, "READ")))
+		assert.Contains(t, secAddrs, addr)
+	}
+
+	var addr string
+	require.NoError(t, scc.Do(Cmd(&addr, "WRITE")))
+	assert.Equal(t, primAddr, addr)
+	require.NoError(t, scc.Do(Primary(Cmd(&addr, "READ"))))
+	assert.Equal(t, primAddr, addr)
+}
@@ -0,0 +1,94 @@
+package radix
+
+import (
+	"bufio"
+	"strconv"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// SlowlogEntry is a single entry of the slow log, as returned by SLOWLOG GET.
+type SlowlogEntry struct {
+	// ID uniquely identifies the entry.
+	ID int64
+
+	// Timestamp is the time at which the command was processed.
+	Timestamp time.Time
+
+	// Duration is the time it took to execute the command.
+	Duration time.Duration
+
+	// Args contains the command and its arguments, possibly truncated by redis.
+	Args []string
+
+	// ClientAddr and ClientName identify the client which sent the command.
+	// They are only set with redis 4.0 and above.
+	ClientAddr, ClientName string
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (se *SlowlogEntry) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 4 {
+		for i := 0; i < ah.N; i++ {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+		}
+		return resp.ErrDiscarded{Err: errors.Errorf("invalid slowlog entry with %d elements", ah.N)}
+	}
+
+	// redis 4.0 added the client address and name, any further elements which
+	// might be added in the future are discarded.
+	var timestamp, micros int64
+	*se = SlowlogEntry{}
+	rcvs := []interface{}{&se.ID, &timestamp, &micros, &se.Args, &se.ClientAddr, &se.ClientName}
+	for i := 0; i < ah.N; i++ {
+		var rcv interface{}
+		if i < len(rcvs) {
+			rcv = rcvs[i]
+		}
+		if err := (resp2.Any{I: rcv}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+
+	se.Timestamp = time.Unix(timestamp, 0)
+	se.Duration = time.Duration(micros) * time.Microsecond
+	return nil
+}
+
+// SlowlogGet returns up to count of the most recent entries of the slow log,
+// using SLOWLOG GET. If count is 0 the server's default is used.
+func SlowlogGet(c Client, count int) ([]SlowlogEntry, error) {
+	args := []string{"GET"}
+	if count != 0 {
+		args = append(args, strconv.Itoa(count))
+	}
+
+	var entries []SlowlogEntry
+	if err := c.Do(Cmd(&entries, "SLOWLOG", args...)); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// SlowlogLen returns the number of entries in the slow log.
+func SlowlogLen(c Client) (int64, error) {
+	var n int64
+	if err := c.Do(Cmd(&n, "SLOWLOG", "LEN")); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// SlowlogReset removes all entries from the slow log.
+func SlowlogReset(c Client) error {
+	return c.Do(Cmd(nil, "SLOWLOG", "RESET"))
+}
@@ -0,0 +1,76 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowlog(t *T) {
+	var got [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = append(got, args)
+		switch strings.ToUpper(args[1]) {
+		case "GET":
+			return []interface{}{
+				[]interface{}{
+					14, 1309448221, 15,
+					[]string{"ping"},
+					"127.0.0.1:58217", "worker-123",
+				},
+				// pre-4.0 entries don't contain the client fields
+				[]interface{}{13, 1309448128, 30, []string{"slowlog", "get", "100"}},
+				// unknown trailing fields are ignored
+				[]interface{}{12, 1309448000, 1500, []string{"get", "foo"}, "", "", "extra"},
+			}
+		case "LEN":
+			return 3
+		default:
+			return "OK"
+		}
+	})
+
+	entries, err := SlowlogGet(stub, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []SlowlogEntry{
+		{
+			ID:         14,
+			Timestamp:  time.Unix(1309448221, 0),
+			Duration:   15 * time.Microsecond,
+			Args:       []string{"ping"},
+			ClientAddr: "127.0.0.1:58217",
+			ClientName: "worker-123",
+		},
+		{
+			ID:        13,
+			Timestamp: time.Unix(1309448128, 0),
+			Duration:  30 * time.Microsecond,
+			Args:      []string{"slowlog", "get", "100"},
+		},
+		{
+			ID:        12,
+			Timestamp: time.Unix(1309448000, 0),
+			Duration:  1500 * time.Microsecond,
+			Args:      []string{"get", "foo"},
+		},
+	}, entries)
+
+	_, err = SlowlogGet(stub, 0)
+	require.NoError(t, err)
+
+	n, err := SlowlogLen(stub)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	require.NoError(t, SlowlogReset(stub))
+
+	assert.Equal(t, [][]string{
+		{"SLOWLOG", "GET", "10"},
+		{"SLOWLOG", "GET"},
+		{"SLOWLOG", "LEN"},
+		{"SLOWLOG", "RESET"},
+	}, got)
+}
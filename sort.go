@@ -0,0 +1,76 @@
+package radix
+
+import "strconv"
+
+// SortOpts are optional parameters which can be passed into Sort and
+// SortStore.
+type SortOpts struct {
+	// By, if set, sorts the elements by the values of external keys, using the
+	// BY option, e.g. "weight_*". If it doesn't contain a "*" sorting is
+	// skipped, which can be combined with Get to retrieve external keys
+	// without sorting.
+	By string
+
+	// Offset is the number of elements to skip, and Count the maximum number
+	// of elements to return. If both are 0 no LIMIT is sent, otherwise a Count
+	// of 0 or less returns all elements after Offset.
+	Offset, Count int
+
+	// Get, if set, causes the values of the given external keys to be
+	// returned instead of the elements themselves, using one GET option per
+	// pattern. "#" refers to the element itself.
+	Get []string
+
+	// Desc sorts the elements from the highest to the lowest value.
+	Desc bool
+
+	// Alpha sorts the elements lexicographically instead of numerically.
+	Alpha bool
+}
+
+func (o SortOpts) args(key, dst string) []string {
+	args := []string{key}
+	if o.By != "" {
+		args = append(args, "BY", o.By)
+	}
+	if o.Offset != 0 || o.Count != 0 {
+		count := o.Count
+		if count <= 0 {
+			count = -1
+		}
+		args = append(args, "LIMIT", strconv.Itoa(o.Offset), strconv.Itoa(count))
+	}
+	for _, pattern := range o.Get {
+		args = append(args, "GET", pattern)
+	}
+	if o.Desc {
+		args = append(args, "DESC")
+	}
+	if o.Alpha {
+		args = append(args, "ALPHA")
+	}
+	if dst != "" {
+		args = append(args, "STORE", dst)
+	}
+	return args
+}
+
+// Sort returns the sorted elements of the list, set or sorted set stored at
+// key, using SORT. Elements referring to missing external keys when using
+// opts.Get are returned as empty strings.
+func Sort(c Client, key string, opts SortOpts) ([]string, error) {
+	var elems []string
+	if err := c.Do(Cmd(&elems, "SORT", opts.args(key, "")...)); err != nil {
+		return nil, err
+	}
+	return elems, nil
+}
+
+// SortStore is like Sort, but instead of returning the sorted elements they
+// are stored as a list at dst, replacing it, using the STORE option. The
+// number of stored elements is returned.
+func SortStore(c Client, key, dst string, opts SortOpts) (int64, error) {
+	var n int64
+	err := c.Do(Cmd(&n, "SORT", opts.args(key, dst)...))
+	return n, err
+}
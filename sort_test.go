@@ -0,0 +1,44 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSort(t *T) {
+	var got []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = args
+		for i := range args {
+			if args[i] == "STORE" {
+				return 3
+			}
+		}
+		return []interface{}{"a", nil, "c"}
+	})
+
+	elems, err := Sort(stub, "list", SortOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SORT", "list"}, got)
+	assert.Equal(t, []string{"a", "", "c"}, elems)
+
+	_, err = Sort(stub, "list", SortOpts{
+		By:     "weight_*",
+		Offset: 1,
+		Get:    []string{"#", "obj_*"},
+		Desc:   true,
+		Alpha:  true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"SORT", "list", "BY", "weight_*", "LIMIT", "1", "-1",
+		"GET", "#", "GET", "obj_*", "DESC", "ALPHA",
+	}, got)
+
+	n, err := SortStore(stub, "list", "dst", SortOpts{Count: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, []string{"SORT", "list", "LIMIT", "0", "10", "STORE", "dst"}, got)
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	errors "golang.org/x/xerrors"
@@ -34,6 +35,19 @@ func (s StreamEntryID) Before(o StreamEntryID) bool {
 	return s.Seq < o.Seq
 }
 
+// Compare returns -1 if s comes before o in a stream, 1 if it comes after o and
+// 0 if both are equal.
+func (s StreamEntryID) Compare(o StreamEntryID) int {
+	switch {
+	case s.Before(o):
+		return -1
+	case o.Before(s):
+		return 1
+	default:
+		return 0
+	}
+}
+
 // Prev returns the previous stream entry ID or s if there is no prior id (s is 0-0).
 func (s StreamEntryID) Prev() StreamEntryID {
 	if s.Seq > 0 {
@@ -96,23 +110,37 @@ func (s *StreamEntryID) UnmarshalRESP(br *bufio.Reader) error {
 		return err
 	}
 
-	split := bytes.IndexByte(bsb.B, '-')
+	id, err := parseStreamEntryID(bsb.B)
+	if err != nil {
+		return resp.ErrDiscarded{Err: err}
+	}
+	*s = id
+	return nil
+}
+
+func parseStreamEntryID(b []byte) (StreamEntryID, error) {
+	split := bytes.IndexByte(b, '-')
 	if split == -1 {
-		return errInvalidStreamID
+		return StreamEntryID{}, errInvalidStreamID
 	}
 
-	time, err := bytesutil.ParseUint(bsb.B[:split])
+	time, err := bytesutil.ParseUint(b[:split])
 	if err != nil {
-		return errInvalidStreamID
+		return StreamEntryID{}, errInvalidStreamID
 	}
 
-	seq, err := bytesutil.ParseUint(bsb.B[split+1:])
+	seq, err := bytesutil.ParseUint(b[split+1:])
 	if err != nil {
-		return errInvalidStreamID
+		return StreamEntryID{}, errInvalidStreamID
 	}
 
-	s.Time, s.Seq = time, seq
-	return nil
+	return StreamEntryID{Time: time, Seq: seq}, nil
+}
+
+// ParseStreamEntryID parses a stream entry ID in the format <time>-<seq>, as
+// returned by String.
+func ParseStreamEntryID(s string) (StreamEntryID, error) {
+	return parseStreamEntryID([]byte(s))
 }
 
 var _ fmt.Stringer = (*StreamEntryID)(nil)
@@ -147,7 +175,7 @@ func (s *StreamEntry) UnmarshalRESP(br *bufio.Reader) error {
 	} else if ah.N != 2 {
 		return errInvalidStreamEntry
 	} else if err := s.ID.UnmarshalRESP(br); err != nil {
-		return err
+		return discardAfterErr(br, 1, err)
 	}
 
 	// put this here in case the array has size -1
@@ -425,3 +453,464 @@ func (sr *streamReader) Next() (stream string, entries []StreamEntry, ok bool) {
 
 	return "", nil, true
 }
+
+// GroupConsumerOpts contains the options given to NewGroupConsumer.
+//
+// Streams, Group and Consumer are required.
+type GroupConsumerOpts struct {
+	// Streams contains the names of the streams to consume. The consumer group
+	// must already exist for each stream, unless CreateGroup is set.
+	Streams []string
+
+	// Group is the name of the consumer group.
+	Group string
+
+	// Consumer is the name of the consumer within the group.
+	Consumer string
+
+	// Count limits the number of entries read from each stream by every call to
+	// Consume. If Count is 0, all available entries will be read.
+	Count int
+
+	// Block specifies the duration that reads will wait for new entries before
+	// returning. It behaves the same as StreamReaderOpts.Block.
+	Block time.Duration
+
+	// NoBlock disables blocking when no new entries are available.
+	//
+	// If this is true, setting Block will not have any effect.
+	NoBlock bool
+
+	// ClaimMinIdle enables claiming entries of other consumers of the group
+	// using XAUTOCLAIM, if they have been pending for at least ClaimMinIdle.
+	// This allows for entries of consumers which have died to be processed.
+	//
+	// If ClaimMinIdle is 0, no entries will be claimed.
+	ClaimMinIdle time.Duration
+
+	// ClaimInterval is the minimum interval between attempts to claim stale
+	// entries.
+	//
+	// If ClaimInterval is 0, it defaults to ClaimMinIdle.
+	ClaimInterval time.Duration
+
+	// CreateGroup causes the first call to Consume to create the consumer group
+	// for each stream using XGROUP CREATE with the MKSTREAM option, so that
+	// streams which don't exist yet are created as well. Groups which already
+	// exist are left as is.
+	CreateGroup bool
+
+	// CreateGroupStartID is the ID of the last entry which is considered
+	// delivered to a group created because of CreateGroup, so that only later
+	// entries are consumed.
+	//
+	// If CreateGroupStartID is empty, "$" is used, i.e. only entries added
+	// after the group was created are consumed. Use "0" to consume all entries.
+	CreateGroupStartID string
+}
+
+// GroupConsumer reads entries from one or more streams as part of a consumer
+// group, passing each entry to a handler and acknowledging it if the handler
+// succeeds.
+type GroupConsumer struct {
+	c    Client
+	opts GroupConsumerOpts
+
+	readArgs  []string
+	claimArgs []string // arguments for XAUTOCLAIM after the stream name
+	lastClaim time.Time
+	created   bool
+}
+
+// NewGroupConsumer returns a new GroupConsumer for the given Client.
+//
+// Any changes on opts after calling NewGroupConsumer will have no effect.
+func NewGroupConsumer(c Client, opts GroupConsumerOpts) *GroupConsumer {
+	gc := &GroupConsumer{c: c, opts: opts}
+	gc.opts.Streams = append([]string(nil), opts.Streams...)
+
+	gc.readArgs = []string{"GROUP", opts.Group, opts.Consumer}
+	if opts.Count > 0 {
+		gc.readArgs = append(gc.readArgs, "COUNT", strconv.Itoa(opts.Count))
+	}
+
+	if !opts.NoBlock {
+		dur := 5 * time.Second
+		if opts.Block < 0 {
+			dur = 0
+		} else if opts.Block > 0 {
+			dur = opts.Block
+		}
+		msec := int(dur / time.Millisecond)
+		gc.readArgs = append(gc.readArgs, "BLOCK", strconv.Itoa(msec))
+	}
+
+	gc.readArgs = append(gc.readArgs, "STREAMS")
+	gc.readArgs = append(gc.readArgs, gc.opts.Streams...)
+	for range gc.opts.Streams {
+		gc.readArgs = append(gc.readArgs, ">")
+	}
+
+	if gc.opts.CreateGroupStartID == "" {
+		gc.opts.CreateGroupStartID = "$"
+	}
+
+	if gc.opts.ClaimMinIdle > 0 {
+		if gc.opts.ClaimInterval <= 0 {
+			gc.opts.ClaimInterval = gc.opts.ClaimMinIdle
+		}
+		minIdle := strconv.FormatInt(int64(gc.opts.ClaimMinIdle/time.Millisecond), 10)
+		gc.claimArgs = []string{opts.Group, opts.Consumer, minIdle}
+	}
+
+	return gc
+}
+
+// Consume reads new entries for the consumer and calls fn for each of them.
+// Entries for which fn returns nil are acknowledged using XACK, while entries for
+// which fn returns an error are left pending, so that they can be claimed
+// again later.
+//
+// If ClaimMinIdle is set and ClaimInterval has passed since the last time,
+// Consume will first claim any stale pending entries using XAUTOCLAIM and call
+// fn for each of them.
+//
+// Consume only returns an error if communication with redis failed, errors
+// returned by fn are not returned. Consume is meant to be called in a loop and
+// must not be called concurrently.
+func (gc *GroupConsumer) Consume(fn func(stream string, entry StreamEntry) error) error {
+	if gc.opts.CreateGroup && !gc.created {
+		if err := gc.createGroups(); err != nil {
+			return err
+		}
+		gc.created = true
+	}
+
+	if gc.claimArgs != nil && time.Since(gc.lastClaim) >= gc.opts.ClaimInterval {
+		if err := gc.claim(fn); err != nil {
+			return err
+		}
+		gc.lastClaim = time.Now()
+	}
+
+	var res []StreamEntries
+	if err := gc.c.Do(Cmd(&res, "XREADGROUP", gc.readArgs...)); err != nil {
+		return err
+	}
+
+	for _, sre := range res {
+		if err := gc.handle(sre.Stream, sre.Entries, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gc *GroupConsumer) createGroups() error {
+	for _, stream := range gc.opts.Streams {
+		err := gc.c.Do(Cmd(nil, "XGROUP", "CREATE", stream, gc.opts.Group, gc.opts.CreateGroupStartID, "MKSTREAM"))
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gc *GroupConsumer) claim(fn func(stream string, entry StreamEntry) error) error {
+	args := make([]string, 0, len(gc.claimArgs)+4)
+	for _, stream := range gc.opts.Streams {
+		start := "0-0"
+		for {
+			args = append(args[:0], stream)
+			args = append(args, gc.claimArgs...)
+			args = append(args, start)
+			if gc.opts.Count > 0 {
+				args = append(args, "COUNT", strconv.Itoa(gc.opts.Count))
+			}
+
+			var res streamAutoClaim
+			if err := gc.c.Do(Cmd(&res, "XAUTOCLAIM", args...)); err != nil {
+				return err
+			} else if err := gc.handle(stream, res.entries, fn); err != nil {
+				return err
+			}
+
+			if res.next == (StreamEntryID{}) {
+				break
+			}
+			start = res.next.String()
+		}
+	}
+	return nil
+}
+
+func (gc *GroupConsumer) handle(stream string, entries []StreamEntry, fn func(string, StreamEntry) error) error {
+	args := []string{stream, gc.opts.Group}
+	for _, entry := range entries {
+		if fn(stream, entry) == nil {
+			args = append(args, entry.ID.String())
+		}
+	}
+
+	if len(args) == 2 {
+		return nil
+	}
+	return gc.c.Do(Cmd(nil, "XACK", args...))
+}
+
+// streamAutoClaim is the result of an XAUTOCLAIM command.
+type streamAutoClaim struct {
+	next    StreamEntryID
+	entries []StreamEntry
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (s *streamAutoClaim) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 2 {
+		err := resp.ErrDiscarded{Err: errors.New("invalid xautoclaim response")}
+		return discardAfterErr(br, ah.N, err)
+	} else if err := s.next.UnmarshalRESP(br); err != nil {
+		return discardAfterErr(br, ah.N-1, err)
+	}
+	n := ah.N
+
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return discardAfterErr(br, n-2, err)
+	}
+
+	// redis 6.2 returns deleted entries as nil, which are skipped
+	s.entries = make([]StreamEntry, 0, ah.N)
+	for i := 0; i < ah.N; i++ {
+		var entry StreamEntry
+		mn := MaybeNil{Rcv: &entry}
+		if err := mn.UnmarshalRESP(br); err != nil {
+			return discardAfterErr(br, ah.N-i-1+n-2, err)
+		} else if !mn.Nil {
+			s.entries = append(s.entries, entry)
+		}
+	}
+
+	// since redis 7 the ids of deleted entries are returned as well
+	for i := 2; i < n; i++ {
+		if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamInfoFull is the result of XINFO STREAM with the FULL option, as
+// returned by XInfoStreamFull. Fields which are not returned by the redis
+// version being used are left at their zero value.
+type StreamInfoFull struct {
+	Length          int64         `redis:"length"`
+	RadixTreeKeys   int64         `redis:"radix-tree-keys"`
+	RadixTreeNodes  int64         `redis:"radix-tree-nodes"`
+	LastGeneratedID StreamEntryID `redis:"last-generated-id"`
+
+	// These are only returned by redis 7.0 and above.
+	MaxDeletedEntryID    StreamEntryID `redis:"max-deleted-entry-id"`
+	EntriesAdded         int64         `redis:"entries-added"`
+	RecordedFirstEntryID StreamEntryID `redis:"recorded-first-entry-id"`
+
+	Entries []StreamEntry     `redis:"entries"`
+	Groups  []StreamGroupInfo `redis:"groups"`
+}
+
+// StreamGroupInfo describes a consumer group of a stream, as part of a
+// StreamInfoFull.
+type StreamGroupInfo struct {
+	Name            string
+	LastDeliveredID StreamEntryID
+
+	// EntriesRead and Lag are only returned by redis 7.0 and above. Lag is -1
+	// if redis can't determine it.
+	EntriesRead int64
+	Lag         int64
+
+	// PELCount is the total number of pending entries of the group. Pending
+	// may contain fewer entries if the COUNT option limited the reply.
+	PELCount  int64
+	Pending   []StreamPendingEntry
+	Consumers []StreamConsumerInfo
+}
+
+type streamGroupInfo struct {
+	Name            string               `redis:"name"`
+	LastDeliveredID StreamEntryID        `redis:"last-delivered-id"`
+	EntriesRead     MaybeNil             `redis:"entries-read"`
+	Lag             MaybeNil             `redis:"lag"`
+	PELCount        int64                `redis:"pel-count"`
+	Pending         []StreamPendingEntry `redis:"pending"`
+	Consumers       []StreamConsumerInfo `redis:"consumers"`
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (s *StreamGroupInfo) UnmarshalRESP(br *bufio.Reader) error {
+	*s = StreamGroupInfo{Lag: -1}
+	sgi := streamGroupInfo{
+		EntriesRead: MaybeNil{Rcv: &s.EntriesRead},
+		Lag:         MaybeNil{Rcv: &s.Lag},
+	}
+	if err := (resp2.Any{I: &sgi}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	s.Name = sgi.Name
+	s.LastDeliveredID = sgi.LastDeliveredID
+	s.PELCount = sgi.PELCount
+	s.Pending = sgi.Pending
+	s.Consumers = sgi.Consumers
+	return nil
+}
+
+// StreamConsumerInfo describes a consumer within a consumer group, as part of
+// a StreamInfoFull.
+type StreamConsumerInfo struct {
+	Name string
+
+	// SeenTime is the last time the consumer attempted an interaction, e.g.
+	// XREADGROUP or XCLAIM.
+	SeenTime time.Time
+
+	// ActiveTime is the last time the consumer successfully read or claimed
+	// entries. It is only returned by redis 7.2 and above, and is zero if the
+	// consumer was never active.
+	ActiveTime time.Time
+
+	// PELCount is the total number of entries pending for the consumer.
+	// Pending may contain fewer entries if the COUNT option limited the reply.
+	PELCount int64
+	Pending  []StreamPendingEntry
+}
+
+type streamConsumerInfo struct {
+	Name       string               `redis:"name"`
+	SeenTime   int64                `redis:"seen-time"`
+	ActiveTime int64                `redis:"active-time"`
+	PELCount   int64                `redis:"pel-count"`
+	Pending    []StreamPendingEntry `redis:"pending"`
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (s *StreamConsumerInfo) UnmarshalRESP(br *bufio.Reader) error {
+	var sci streamConsumerInfo
+	if err := (resp2.Any{I: &sci}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	// the pending entries of a consumer don't contain the consumer's name
+	for i := range sci.Pending {
+		sci.Pending[i].Consumer = sci.Name
+	}
+
+	*s = StreamConsumerInfo{
+		Name:     sci.Name,
+		SeenTime: unixMilli(sci.SeenTime),
+		PELCount: sci.PELCount,
+		Pending:  sci.Pending,
+	}
+	if sci.ActiveTime > 0 {
+		s.ActiveTime = unixMilli(sci.ActiveTime)
+	}
+	return nil
+}
+
+// StreamPendingEntry is an entry in the pending entries list (PEL) of a
+// consumer group, as part of a StreamInfoFull.
+type StreamPendingEntry struct {
+	ID       StreamEntryID
+	Consumer string
+
+	// DeliveryTime is the last time the entry was delivered to a consumer.
+	DeliveryTime time.Time
+
+	// DeliveryCount is the number of times the entry was delivered.
+	DeliveryCount int64
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (s *StreamPendingEntry) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	// entries in the PEL of a group contain the consumer name, while entries
+	// in the PEL of a consumer don't.
+	var deliveryTime int64
+	*s = StreamPendingEntry{}
+	var rcvs []interface{}
+	switch ah.N {
+	case 3:
+		rcvs = []interface{}{&s.ID, &deliveryTime, &s.DeliveryCount}
+	case 4:
+		rcvs = []interface{}{&s.ID, &s.Consumer, &deliveryTime, &s.DeliveryCount}
+	default:
+		for i := 0; i < ah.N; i++ {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+		}
+		return resp.ErrDiscarded{Err: errors.Errorf("invalid pending entry with %d elements", ah.N)}
+	}
+
+	for _, rcv := range rcvs {
+		if err := (resp2.Any{I: rcv}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+	s.DeliveryTime = unixMilli(deliveryTime)
+	return nil
+}
+
+func unixMilli(ms int64) time.Time {
+	return time.Unix(ms/1e3, (ms%1e3)*1e6)
+}
+
+// XInfoStreamFull returns the full state of the stream stored at key,
+// including its entries, consumer groups, consumers and pending entries, using
+// XINFO STREAM with the FULL option.
+//
+// count limits the number of entries as well as the number of pending entries
+// returned for each group and consumer. If count is 0 the server's default is
+// used, while a negative count returns everything.
+func XInfoStreamFull(c Client, key string, count int) (StreamInfoFull, error) {
+	args := []string{"STREAM", key, "FULL"}
+	if count < 0 {
+		args = append(args, "COUNT", "0")
+	} else if count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(count))
+	}
+
+	var info StreamInfoFull
+	err := c.Do(Cmd(&info, "XINFO", args...))
+	return info, err
+}
+
+// XSetIDOpts contains optional parameters for XSetID.
+type XSetIDOpts struct {
+	// EntriesAdded, if not zero, sets the number of entries which were ever
+	// added to the stream. Requires redis 7.0 or newer.
+	EntriesAdded int64
+
+	// MaxDeletedID, if not zero, sets the ID of the largest entry which was
+	// ever deleted from the stream. Requires redis 7.0 or newer.
+	MaxDeletedID StreamEntryID
+}
+
+// XSetID sets the last generated ID of the stream stored at key using XSETID.
+// Entries added afterwards using an ID of "*" will have IDs after id.
+func XSetID(c Client, key string, id StreamEntryID, opts XSetIDOpts) error {
+	args := []string{key, id.String()}
+	if opts.EntriesAdded != 0 {
+		args = append(args, "ENTRIESADDED", strconv.FormatInt(opts.EntriesAdded, 10))
+	}
+	if opts.MaxDeletedID != (StreamEntryID{}) {
+		args = append(args, "MAXDELETEDID", opts.MaxDeletedID.String())
+	}
+	return c.Do(Cmd(nil, "XSETID", args...))
+}
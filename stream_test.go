@@ -10,8 +10,11 @@ import (
 	. "testing"
 	"time"
 
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
 )
 
 func TestStreamEntryID(t *T) {
@@ -289,6 +292,32 @@ func TestStreamEntryID(t *T) {
 			assert.Equal(t, test.E, s)
 		}
 	})
+
+	t.Run("Compare", func(t *T) {
+		a := StreamEntryID{Time: 9, Seq: 1}
+		b := StreamEntryID{Time: 10, Seq: 0}
+		assert.Equal(t, -1, a.Compare(b))
+		assert.Equal(t, 1, b.Compare(a))
+		assert.Equal(t, 0, a.Compare(a))
+	})
+
+	t.Run("Parse", func(t *T) {
+		id, err := ParseStreamEntryID("1638125141232-1")
+		require.NoError(t, err)
+		assert.Equal(t, StreamEntryID{Time: 1638125141232, Seq: 1}, id)
+
+		// numeric, not lexical, comparison
+		a, err := ParseStreamEntryID("999-0")
+		require.NoError(t, err)
+		b, err := ParseStreamEntryID("1000-0")
+		require.NoError(t, err)
+		assert.True(t, a.Before(b))
+
+		for _, s := range []string{"", "1", "-1", "1-", "a-1", "1-b", "1-2-3"} {
+			_, err := ParseStreamEntryID(s)
+			assert.Error(t, err, "id: %q", s)
+		}
+	})
 }
 
 var benchErr error
@@ -1066,3 +1095,249 @@ func assertConsumer(tb TB, c Client, stream, group, consumer string, pending int
 
 	assert.Failf(tb, "pending messages assertion failed", "consumer %s not in group %s for stream %s", consumer, group, stream)
 }
+
+func TestGroupConsumer(t *T) {
+	entry := func(id string, fields ...string) interface{} {
+		return []interface{}{id, fields}
+	}
+
+	var cmds [][]string
+	acked := map[string][]string{}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch args[0] {
+		case "XAUTOCLAIM":
+			if args[5] == "0-0" {
+				return []interface{}{"5-0", []interface{}{entry("1-0", "a", "1"), nil}, []string{"2-0"}}
+			}
+			return []interface{}{"0-0", []interface{}{entry("5-0", "a", "5")}}
+		case "XREADGROUP":
+			return []interface{}{
+				[]interface{}{"s1", []interface{}{entry("6-0", "a", "6"), entry("7-0", "a", "7")}},
+			}
+		case "XACK":
+			acked[args[1]] = append(acked[args[1]], args[3:]...)
+			return len(args) - 3
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	gc := NewGroupConsumer(stub, GroupConsumerOpts{
+		Streams:      []string{"s1"},
+		Group:        "g",
+		Consumer:     "c",
+		Count:        10,
+		Block:        time.Second,
+		ClaimMinIdle: time.Minute,
+	})
+
+	var seen []string
+	fn := func(stream string, e StreamEntry) error {
+		assert.Equal(t, "s1", stream)
+		seen = append(seen, e.ID.String())
+		if e.ID.String() == "7-0" {
+			return errors.New("failed")
+		}
+		return nil
+	}
+
+	require.NoError(t, gc.Consume(fn))
+	assert.Equal(t, []string{"1-0", "5-0", "6-0", "7-0"}, seen)
+	assert.Equal(t, map[string][]string{"s1": {"1-0", "5-0", "6-0"}}, acked)
+	assert.Equal(t, [][]string{
+		{"XAUTOCLAIM", "s1", "g", "c", "60000", "0-0", "COUNT", "10"},
+		{"XACK", "s1", "g", "1-0"},
+		{"XAUTOCLAIM", "s1", "g", "c", "60000", "5-0", "COUNT", "10"},
+		{"XACK", "s1", "g", "5-0"},
+		{"XREADGROUP", "GROUP", "g", "c", "COUNT", "10", "BLOCK", "1000", "STREAMS", "s1", ">"},
+		{"XACK", "s1", "g", "6-0"},
+	}, cmds)
+
+	// the claim interval hasn't passed yet, so only XREADGROUP is called
+	cmds = nil
+	require.NoError(t, gc.Consume(fn))
+	assert.Equal(t, "XREADGROUP", cmds[0][0])
+	assert.Len(t, cmds, 2)
+}
+
+func TestStreamAutoClaimMalformed(t *T) {
+	// in all cases the whole reply should have been discarded
+	for _, reply := range []interface{}{
+		[]interface{}{"5-0"},
+		[]interface{}{"foo", []interface{}{}, []string{}},
+		[]interface{}{"5-0", []interface{}{[]interface{}{"foo", []string{"a", "1"}}}, []string{"2-0"}},
+	} {
+		buf := new(bytes.Buffer)
+		require.NoError(t, resp2.Any{I: reply}.MarshalRESP(buf))
+		require.NoError(t, resp2.Any{I: "next"}.MarshalRESP(buf))
+		br := bufio.NewReader(buf)
+
+		var s streamAutoClaim
+		err := s.UnmarshalRESP(br)
+		assert.True(t, errors.As(err, new(resp.ErrDiscarded)), "reply: %#v err: %v", reply, err)
+
+		var next string
+		require.NoError(t, resp2.Any{I: &next}.UnmarshalRESP(br))
+		assert.Equal(t, "next", next)
+	}
+}
+
+func TestGroupConsumerCreateGroup(t *T) {
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch {
+		case args[0] == "XGROUP" && args[2] == "s2":
+			return resp2.Error{E: errors.New("BUSYGROUP Consumer Group name already exists")}
+		case args[0] == "XGROUP":
+			return "OK"
+		case args[0] == "XREADGROUP":
+			return nil
+		default:
+			return resp2.Error{E: errors.Errorf("unexpected command %q", args[0])}
+		}
+	})
+
+	gc := NewGroupConsumer(stub, GroupConsumerOpts{
+		Streams:     []string{"s1", "s2"},
+		Group:       "g",
+		Consumer:    "c",
+		NoBlock:     true,
+		CreateGroup: true,
+	})
+	fn := func(string, StreamEntry) error { return nil }
+
+	// groups are only created once, and existing groups are ignored
+	require.NoError(t, gc.Consume(fn))
+	require.NoError(t, gc.Consume(fn))
+	assert.Equal(t, [][]string{
+		{"XGROUP", "CREATE", "s1", "g", "$", "MKSTREAM"},
+		{"XGROUP", "CREATE", "s2", "g", "$", "MKSTREAM"},
+		{"XREADGROUP", "GROUP", "g", "c", "STREAMS", "s1", "s2", ">", ">"},
+		{"XREADGROUP", "GROUP", "g", "c", "STREAMS", "s1", "s2", ">", ">"},
+	}, cmds)
+
+	gc = NewGroupConsumer(stub, GroupConsumerOpts{
+		Streams:            []string{"s1"},
+		Group:              "g",
+		Consumer:           "c",
+		NoBlock:            true,
+		CreateGroup:        true,
+		CreateGroupStartID: "0",
+	})
+	cmds = nil
+	require.NoError(t, gc.Consume(fn))
+	assert.Equal(t, []string{"XGROUP", "CREATE", "s1", "g", "0", "MKSTREAM"}, cmds[0])
+}
+
+func TestXInfoStreamFull(t *T) {
+	var got []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = args
+		return []interface{}{
+			"length", 2,
+			"radix-tree-keys", 1,
+			"radix-tree-nodes", 2,
+			"last-generated-id", "1638125141232-1",
+			"max-deleted-entry-id", "0-0",
+			"entries-added", 2,
+			"recorded-first-entry-id", "1638125133432-0",
+			"entries", []interface{}{
+				[]interface{}{"1638125133432-0", []string{"message", "apple"}},
+				[]interface{}{"1638125141232-1", []string{"message", "banana"}},
+			},
+			"groups", []interface{}{
+				[]interface{}{
+					"name", "mygroup",
+					"last-delivered-id", "1638125133432-0",
+					"entries-read", 1,
+					"lag", nil,
+					"pel-count", 1,
+					"pending", []interface{}{
+						[]interface{}{"1638125133432-0", "Alice", 1638125153423, 1},
+					},
+					"consumers", []interface{}{
+						[]interface{}{
+							"name", "Alice",
+							"seen-time", 1638125153423,
+							"active-time", 1638125153423,
+							"pel-count", 1,
+							"pending", []interface{}{
+								[]interface{}{"1638125133432-0", 1638125153423, 1},
+							},
+						},
+					},
+				},
+			},
+		}
+	})
+
+	info, err := XInfoStreamFull(stub, "mystream", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"XINFO", "STREAM", "mystream", "FULL", "COUNT", "10"}, got)
+
+	ts := time.Unix(1638125153, 423*int64(time.Millisecond))
+	pending := StreamPendingEntry{
+		ID:            StreamEntryID{Time: 1638125133432},
+		Consumer:      "Alice",
+		DeliveryTime:  ts,
+		DeliveryCount: 1,
+	}
+	assert.Equal(t, StreamInfoFull{
+		Length:               2,
+		RadixTreeKeys:        1,
+		RadixTreeNodes:       2,
+		LastGeneratedID:      StreamEntryID{Time: 1638125141232, Seq: 1},
+		EntriesAdded:         2,
+		RecordedFirstEntryID: StreamEntryID{Time: 1638125133432},
+		Entries: []StreamEntry{
+			{ID: StreamEntryID{Time: 1638125133432}, Fields: map[string]string{"message": "apple"}},
+			{ID: StreamEntryID{Time: 1638125141232, Seq: 1}, Fields: map[string]string{"message": "banana"}},
+		},
+		Groups: []StreamGroupInfo{{
+			Name:            "mygroup",
+			LastDeliveredID: StreamEntryID{Time: 1638125133432},
+			EntriesRead:     1,
+			Lag:             -1,
+			PELCount:        1,
+			Pending:         []StreamPendingEntry{pending},
+			Consumers: []StreamConsumerInfo{{
+				Name:       "Alice",
+				SeenTime:   ts,
+				ActiveTime: ts,
+				PELCount:   1,
+				Pending:    []StreamPendingEntry{pending},
+			}},
+		}},
+	}, info)
+
+	_, err = XInfoStreamFull(stub, "mystream", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"XINFO", "STREAM", "mystream", "FULL"}, got)
+
+	_, err = XInfoStreamFull(stub, "mystream", -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"XINFO", "STREAM", "mystream", "FULL", "COUNT", "0"}, got)
+}
+
+func TestXSetID(t *T) {
+	var got []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = args
+		return "OK"
+	})
+
+	id := StreamEntryID{Time: 1638125141232, Seq: 1}
+	require.NoError(t, XSetID(stub, "mystream", id, XSetIDOpts{}))
+	assert.Equal(t, []string{"XSETID", "mystream", "1638125141232-1"}, got)
+
+	require.NoError(t, XSetID(stub, "mystream", id, XSetIDOpts{
+		EntriesAdded: 5,
+		MaxDeletedID: StreamEntryID{Time: 1638125141232},
+	}))
+	assert.Equal(t, []string{
+		"XSETID", "mystream", "1638125141232-1",
+		"ENTRIESADDED", "5", "MAXDELETEDID", "1638125141232-0",
+	}, got)
+}
@@ -0,0 +1,222 @@
+package radix
+
+import (
+	"bufio"
+	"strconv"
+	"sync"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+// ErrXAdderClosed is returned for entries added to an XAdder after it was
+// closed.
+var ErrXAdderClosed = errors.New("XAdder is closed")
+
+// XAdderOpts contains the options given to NewXAdder.
+type XAdderOpts struct {
+	// MaxBatchSize is the maximum number of entries sent in a single pipeline.
+	// Defaults to 100.
+	MaxBatchSize int
+
+	// FlushInterval is the maximum time an entry waits for more entries to be
+	// batched with it before being sent. Defaults to 10 milliseconds.
+	FlushInterval time.Duration
+
+	// MaxLen, if not zero, trims each stream to about MaxLen entries when
+	// adding to it, using XADD's MAXLEN option. If Exact is set the stream is
+	// trimmed to exactly MaxLen entries, which is less efficient.
+	MaxLen int64
+	Exact  bool
+}
+
+// XAddResult is the result of adding a single entry using an XAdder.
+type XAddResult struct {
+	doneCh  chan struct{}
+	decoded bool
+	id      StreamEntryID
+	err     error
+}
+
+// Done returns a channel which is closed once the entry has been added, or
+// adding it has failed.
+func (r *XAddResult) Done() <-chan struct{} {
+	return r.doneCh
+}
+
+// Result blocks until the entry has been added and returns the ID generated
+// for it, or the error which occurred while adding it. Errors returned by
+// redis for a single entry only affect that entry.
+func (r *XAddResult) Result() (StreamEntryID, error) {
+	<-r.doneCh
+	return r.id, r.err
+}
+
+func (r *XAddResult) finish(err error) {
+	// if the reply for this entry was decoded before err happened the entry
+	// itself was added successfully, or failed with its own error
+	if !r.decoded {
+		r.err = err
+	}
+	close(r.doneCh)
+}
+
+type xaddEntry struct {
+	stream string
+	fields map[string]string
+	res    *XAddResult
+}
+
+// xaddReply unmarshals the reply to a single XADD in a batch, recording any
+// error returned by redis on the entry instead of failing the whole pipeline.
+type xaddReply struct {
+	res *XAddResult
+}
+
+func (xr xaddReply) UnmarshalRESP(br *bufio.Reader) error {
+	err := xr.res.id.UnmarshalRESP(br)
+	if err == nil {
+		xr.res.decoded = true
+	} else if errors.As(err, new(resp.ErrDiscarded)) {
+		xr.res.decoded, xr.res.err = true, err
+		return nil
+	}
+	return err
+}
+
+// XAdder adds entries to streams in batches, sending all XADD commands of a
+// batch in a single pipeline. Only a single batch is in flight at any time;
+// while it is, new entries are buffered up to MaxBatchSize, after which Add
+// blocks until the batch has been acknowledged.
+//
+// XAdder is safe for concurrent use. Entries added from a single go-routine are
+// added to the stream in the order Add was called.
+type XAdder struct {
+	c      Client
+	opts   XAdderOpts
+	trimOp []string
+
+	l      sync.RWMutex
+	closed bool
+	addCh  chan xaddEntry
+	doneCh chan struct{}
+}
+
+// NewXAdder returns a new XAdder which uses the given Client.
+//
+// Any changes on opts after calling NewXAdder will have no effect.
+func NewXAdder(c Client, opts XAdderOpts) *XAdder {
+	if opts.MaxBatchSize <= 0 {
+		opts.MaxBatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 10 * time.Millisecond
+	}
+
+	xa := &XAdder{
+		c:      c,
+		opts:   opts,
+		addCh:  make(chan xaddEntry, opts.MaxBatchSize),
+		doneCh: make(chan struct{}),
+	}
+	if opts.MaxLen > 0 {
+		xa.trimOp = []string{"MAXLEN", "~", strconv.FormatInt(opts.MaxLen, 10)}
+		if opts.Exact {
+			xa.trimOp[1] = "="
+		}
+	}
+	go xa.spin()
+	return xa
+}
+
+// Add adds a new entry with the given fields to the stream, using an ID
+// generated by redis. The entry is added asynchronously, the returned
+// XAddResult can be used to get the ID of the entry once it was added.
+//
+// If MaxBatchSize entries are already waiting to be sent, Add blocks until
+// there is room.
+func (xa *XAdder) Add(stream string, fields map[string]string) *XAddResult {
+	res := &XAddResult{doneCh: make(chan struct{})}
+
+	xa.l.RLock()
+	defer xa.l.RUnlock()
+	if xa.closed {
+		res.finish(ErrXAdderClosed)
+		return res
+	}
+	xa.addCh <- xaddEntry{stream: stream, fields: fields, res: res}
+	return res
+}
+
+func (xa *XAdder) spin() {
+	defer close(xa.doneCh)
+
+	batch := make([]xaddEntry, 0, xa.opts.MaxBatchSize)
+	timer := time.NewTimer(0)
+	<-timer.C
+	for {
+		e, ok := <-xa.addCh
+		if !ok {
+			return
+		}
+		batch = append(batch, e)
+
+		timer.Reset(xa.opts.FlushInterval)
+	collect:
+		for len(batch) < xa.opts.MaxBatchSize {
+			select {
+			case e, ok := <-xa.addCh:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, e)
+			case <-timer.C:
+				break collect
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		xa.send(batch)
+		batch = batch[:0]
+	}
+}
+
+func (xa *XAdder) send(batch []xaddEntry) {
+	cmds := make([]CmdAction, len(batch))
+	for i, e := range batch {
+		args := make([]string, 0, 2+len(xa.trimOp)+len(e.fields)*2)
+		args = append(args, e.stream)
+		args = append(args, xa.trimOp...)
+		args = append(args, "*")
+		for k, v := range e.fields {
+			args = append(args, k, v)
+		}
+		cmds[i] = Cmd(xaddReply{res: e.res}, "XADD", args...)
+	}
+
+	err := xa.c.Do(Pipeline(cmds...))
+	for _, e := range batch {
+		e.res.finish(err)
+	}
+}
+
+// Close flushes all buffered entries, waits for them to be added and stops
+// the XAdder. Entries added after Close return ErrXAdderClosed. The Client is
+// not closed.
+func (xa *XAdder) Close() error {
+	xa.l.Lock()
+	if !xa.closed {
+		xa.closed = true
+		close(xa.addCh)
+	}
+	xa.l.Unlock()
+	<-xa.doneCh
+	return nil
+}
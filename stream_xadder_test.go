@@ -0,0 +1,135 @@
+package radix
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// countingClient counts the Actions performed on it, blocking each of them
+// until a value can be received from unblockCh if it isn't nil.
+type countingClient struct {
+	Client
+	n         int64
+	unblockCh chan struct{}
+}
+
+func (cc *countingClient) Do(a Action) error {
+	atomic.AddInt64(&cc.n, 1)
+	if cc.unblockCh != nil {
+		<-cc.unblockCh
+	}
+	return cc.Client.Do(a)
+}
+
+func newXAddStub() Client {
+	var l sync.Mutex
+	var seq int
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		l.Lock()
+		defer l.Unlock()
+		if args[0] != "XADD" {
+			return errors.Errorf("unexpected command %q", args[0])
+		} else if args[1] == "bad" {
+			return resp2.Error{E: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")}
+		} else if args[2] != "*" && args[2] != "MAXLEN" {
+			return errors.Errorf("unexpected argument %q", args[2])
+		}
+		seq++
+		return "1-" + strconv.Itoa(seq)
+	})
+}
+
+func TestXAdder(t *T) {
+	cc := &countingClient{Client: newXAddStub()}
+	xa := NewXAdder(cc, XAdderOpts{MaxBatchSize: 4, FlushInterval: time.Hour, MaxLen: 100})
+
+	var results []*XAddResult
+	for i := 0; i < 8; i++ {
+		stream := "foo"
+		if i == 5 {
+			stream = "bad"
+		}
+		results = append(results, xa.Add(stream, map[string]string{"i": strconv.Itoa(i)}))
+	}
+
+	var seq uint64
+	for i, res := range results {
+		id, err := res.Result()
+		if i == 5 {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		seq++
+		assert.Equal(t, StreamEntryID{Time: 1, Seq: seq}, id)
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&cc.n))
+
+	// the last, partial batch is sent when closing
+	res := xa.Add("foo", map[string]string{"a": "b"})
+	require.NoError(t, xa.Close())
+	id, err := res.Result()
+	require.NoError(t, err)
+	assert.Equal(t, StreamEntryID{Time: 1, Seq: 8}, id)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&cc.n))
+
+	_, err = xa.Add("foo", map[string]string{"a": "b"}).Result()
+	assert.True(t, errors.Is(err, ErrXAdderClosed))
+}
+
+func TestXAdderFlushInterval(t *T) {
+	xa := NewXAdder(newXAddStub(), XAdderOpts{FlushInterval: 10 * time.Millisecond})
+	defer xa.Close()
+
+	res := xa.Add("foo", map[string]string{"a": "b"})
+	select {
+	case <-res.Done():
+	case <-time.After(time.Second):
+		t.Fatal("entry was not flushed")
+	}
+	id, err := res.Result()
+	require.NoError(t, err)
+	assert.Equal(t, StreamEntryID{Time: 1, Seq: 1}, id)
+}
+
+func TestXAdderBackpressure(t *T) {
+	cc := &countingClient{Client: newXAddStub(), unblockCh: make(chan struct{})}
+	xa := NewXAdder(cc, XAdderOpts{MaxBatchSize: 2, FlushInterval: time.Millisecond})
+
+	// the first entry is sent on its own and blocks, the next two fill up the
+	// buffer
+	first := xa.Add("foo", map[string]string{"a": "b"})
+	for atomic.LoadInt64(&cc.n) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	xa.Add("foo", map[string]string{"a": "b"})
+	xa.Add("foo", map[string]string{"a": "b"})
+
+	addedCh := make(chan *XAddResult)
+	go func() { addedCh <- xa.Add("foo", map[string]string{"a": "b"}) }()
+	select {
+	case <-addedCh:
+		t.Fatal("Add did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cc.unblockCh <- struct{}{}
+	_, err := first.Result()
+	require.NoError(t, err)
+	last := <-addedCh
+
+	close(cc.unblockCh)
+	id, err := last.Result()
+	require.NoError(t, err)
+	assert.Equal(t, StreamEntryID{Time: 1, Seq: 4}, id)
+	require.NoError(t, xa.Close())
+}
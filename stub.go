@@ -32,17 +32,20 @@ type buffer struct {
 	bufL         *sync.Cond
 	buf          *bytes.Buffer
 	bufbr        *bufio.Reader
+	bufcr        *countingReader
 	closed       bool
 	readDeadline time.Time
 }
 
 func newBuffer(remoteNetwork, remoteAddr string) *buffer {
 	buf := new(bytes.Buffer)
+	bufcr := &countingReader{r: buf}
 	return &buffer{
 		remoteAddr: bufferAddr{network: remoteNetwork, addr: remoteAddr},
 		bufL:       sync.NewCond(new(sync.Mutex)),
 		buf:        buf,
-		bufbr:      bufio.NewReader(buf),
+		bufbr:      bufio.NewReader(bufcr),
+		bufcr:      bufcr,
 	}
 }
 
@@ -108,6 +111,13 @@ func (b *buffer) Decode(u resp.Unmarshaler) error {
 	return u.UnmarshalRESP(b.bufbr)
 }
 
+// bytesRead returns the number of bytes of replies which were decoded so far.
+func (b *buffer) bytesRead() int64 {
+	b.bufL.L.Lock()
+	defer b.bufL.L.Unlock()
+	return b.bufcr.n - int64(b.bufbr.Buffered())
+}
+
 func (b *buffer) Close() error {
 	b.bufL.L.Lock()
 	defer b.bufL.L.Unlock()
@@ -160,6 +170,36 @@ func (e *timeoutError) Temporary() bool { return true }
 type stub struct {
 	*buffer
 	fn func([]string) interface{}
+
+	// delayCh is set if StubLatency was used, and receives all replies, which
+	// are buffered by the delayReplies go-routine once their latency passed.
+	latency   time.Duration
+	delayCh   chan delayedReply
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+type delayedReply struct {
+	m  resp.Marshaler
+	at time.Time
+}
+
+type stubOpts struct {
+	latency time.Duration
+}
+
+// StubOpt is an optional behavior which can be applied to the Stub function to
+// effect the returned Conn's behavior.
+type StubOpt func(*stubOpts)
+
+// StubLatency tells the Stub to delay each reply by the given duration, as if
+// it had to travel over the network to a remote redis instance. Replies are
+// still returned in order. Read deadlines are respected, i.e. Decode fails with
+// a timeout if the deadline is hit before the reply arrived.
+func StubLatency(d time.Duration) StubOpt {
+	return func(so *stubOpts) {
+		so.latency = d
+	}
 }
 
 // Stub returns a (fake) Conn which pretends it is a Conn to a real redis
@@ -171,6 +211,12 @@ type stub struct {
 // from the callback is then marshalled and buffered interanlly, and will be
 // unmarshalled in the next call to Decode.
 //
+// If the callback returns a resp2.Error it is returned by Decode, like an
+// error returned by redis. Any other error is returned directly by Encode
+// instead, which can be used to simulate a network error. Messages which redis
+// sends without a preceding command, e.g. client tracking invalidations, can be
+// written to the Stub using StubPush.
+//
 // remoteNetwork and remoteAddr can be empty, but if given will be used as the
 // return from the RemoteAddr method.
 //
@@ -178,10 +224,69 @@ type stub struct {
 // in a separate go-routine. The SetDeadline and SetReadDeadline methods can be
 // used as usual to limit how long Decode blocks. All other inherited net.Conn
 // methods will panic.
-func Stub(remoteNetwork, remoteAddr string, fn func([]string) interface{}) Conn {
-	return &stub{
-		buffer: newBuffer(remoteNetwork, remoteAddr),
-		fn:     fn,
+func Stub(remoteNetwork, remoteAddr string, fn func([]string) interface{}, opts ...StubOpt) Conn {
+	var so stubOpts
+	for _, opt := range opts {
+		opt(&so)
+	}
+
+	s := &stub{
+		buffer:  newBuffer(remoteNetwork, remoteAddr),
+		fn:      fn,
+		closeCh: make(chan struct{}),
+	}
+	if so.latency > 0 {
+		s.delayCh = make(chan delayedReply, 128)
+		s.latency = so.latency
+		go s.delayReplies()
+	}
+	return s
+}
+
+// StubPush writes the given message to the internal buffer of a Conn created
+// by Stub or PubSubStub, as if redis had sent it without a preceding command.
+// It will be returned by the next call to Decode which doesn't find an earlier
+// reply in the buffer. The message is marshalled like the return from the
+// callback of Stub.
+func StubPush(conn Conn, msg interface{}) error {
+	if ps, ok := conn.(*pubSubStub); ok {
+		conn = ps.Conn
+	}
+	s, ok := conn.(*stub)
+	if !ok {
+		return errors.Errorf("StubPush called with a Conn of type %T, which wasn't created by Stub", conn)
+	}
+
+	m, ok := msg.(resp.Marshaler)
+	if !ok {
+		m = resp2.Any{I: msg}
+	}
+	return s.buffer.Encode(m)
+}
+
+func (s *stub) delayReplies() {
+	for {
+		select {
+		case r := <-s.delayCh:
+			time.Sleep(time.Until(r.at))
+			// an error means the stub was closed, mirroring a reply which
+			// gets lost on the network
+			_ = s.buffer.Encode(r.m)
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+func (s *stub) encodeReply(m resp.Marshaler) error {
+	if s.delayCh == nil {
+		return s.buffer.Encode(m)
+	}
+	select {
+	case s.delayCh <- delayedReply{m: m, at: time.Now().Add(s.latency)}:
+		return nil
+	case <-s.closeCh:
+		return s.buffer.err("write", errClosed)
 	}
 }
 
@@ -215,10 +320,12 @@ func (s *stub) Encode(m resp.Marshaler) error {
 		// result is an error it is assumed to want to be returned directly.
 		ret := s.fn(ss)
 		if m, ok := ret.(resp.Marshaler); ok {
-			return s.buffer.Encode(m)
+			if err := s.encodeReply(m); err != nil {
+				return err
+			}
 		} else if err, _ := ret.(error); err != nil {
 			return err
-		} else if err = s.buffer.Encode(resp2.Any{I: ret}); err != nil {
+		} else if err = s.encodeReply(resp2.Any{I: ret}); err != nil {
 			return err
 		}
 	}
@@ -229,3 +336,8 @@ func (s *stub) Encode(m resp.Marshaler) error {
 func (s *stub) NetConn() net.Conn {
 	return s.buffer
 }
+
+func (s *stub) Close() error {
+	s.closeOnce.Do(func() { close(s.closeCh) })
+	return s.buffer.Close()
+}
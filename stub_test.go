@@ -105,6 +105,71 @@ func TestStubLockingTimeout(t *T) {
 	assert.True(t, nerr.Timeout())
 }
 
+func TestStubLatency(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return args[1]
+	}, StubLatency(50*time.Millisecond))
+	defer stub.Close()
+
+	start := time.Now()
+	var a, b string
+	require.NoError(t, stub.Do(Pipeline(
+		Cmd(&a, "ECHO", "a"),
+		Cmd(&b, "ECHO", "b"),
+	)))
+	assert.Equal(t, "a", a)
+	assert.Equal(t, "b", b)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	// the reply is still read once it arrives after a read deadline was hit
+	require.NoError(t, stub.Encode(Cmd(nil, "ECHO", "c")))
+	require.NoError(t, stub.NetConn().SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	err := stub.Decode(resp2.Any{})
+	var nerr net.Error
+	assert.True(t, errors.As(err, &nerr) && nerr.Timeout(), "err: %v", err)
+
+	require.NoError(t, stub.NetConn().SetReadDeadline(time.Time{}))
+	var c string
+	require.NoError(t, stub.Decode(resp2.Any{I: &c}))
+	assert.Equal(t, "c", c)
+}
+
+func TestStubPush(t *T) {
+	stub := testStub()
+	require.NoError(t, StubPush(stub, []string{"invalidate", "foo"}))
+
+	var msg []string
+	require.NoError(t, stub.Decode(resp2.Any{I: &msg}))
+	assert.Equal(t, []string{"invalidate", "foo"}, msg)
+
+	conn, _ := PubSubStub("tcp", "127.0.0.1:6379", nil)
+	defer conn.Close()
+	require.NoError(t, StubPush(conn, resp2.SimpleString{S: "OK"}))
+	var ok string
+	require.NoError(t, conn.Decode(resp2.Any{I: &ok}))
+	assert.Equal(t, "OK", ok)
+
+	assert.Error(t, StubPush(brokenConn{stub}, "foo"))
+}
+
+func TestStubErrors(t *T) {
+	netErr := errors.New("connection reset")
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "NET" {
+			return netErr
+		}
+		return resp2.Error{E: errors.New("ERR injected")}
+	})
+
+	err := stub.Do(Cmd(nil, "NET"))
+	assert.Equal(t, netErr, err)
+
+	err = stub.Do(Cmd(nil, "GET", "foo"))
+	var respErr resp2.Error
+	assert.True(t, errors.As(err, &respErr), "err: %v", err)
+	assert.Equal(t, "ERR injected", respErr.Error())
+}
+
 func ExampleStub() {
 	m := map[string]string{}
 	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
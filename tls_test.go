@@ -1,6 +1,7 @@
 package radix
 
 import (
+	"bufio"
 	"crypto/tls"
 	"net"
 	"strings"
@@ -10,11 +11,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-)
 
-func TestDialUseTLS(t *testing.T) {
-	// In order to test a TLS connection we need to start a TLS terminating proxy
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
 
+func testTLSCert(t *testing.T) tls.Certificate {
 	// Both the key and the certificate were generated by running the following command:
 	//   go run $GOROOT/src/crypto/tls/generate_cert.go --host localhost
 
@@ -74,6 +75,12 @@ Baas4jyR6hQ0qRSe4PmQrA==
 	pem := []byte(rsaCertPEM + rsaKeyPEM)
 	cert, err := tls.X509KeyPair(pem, pem)
 	require.NoError(t, err)
+	return cert
+}
+
+func TestDialUseTLS(t *testing.T) {
+	// In order to test a TLS connection we need to start a TLS terminating proxy
+	cert := testTLSCert(t)
 
 	// The following TLS proxy is based on https://gist.github.com/cs8425/a742349a55596f1b251a#file-tls2tcp_server-go
 	listener, err := tls.Listen("tcp", ":63790", &tls.Config{
@@ -180,3 +187,70 @@ Baas4jyR6hQ0qRSe4PmQrA==
 	_, err = Dial("tcp", "127.0.0.1:63790", DialUseTLS(nil), DialConnectTimeout(60*time.Minute))
 	assert.Error(t, err)
 }
+
+func TestDialRediss(t *testing.T) {
+	serverNameCh := make(chan string, 1)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNameCh <- hello.ServerName
+			return nil, nil
+		},
+		Certificates: []tls.Certificate{testTLSCert(t)},
+	})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	cmdsCh := make(chan []string, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					var cmd []string
+					if err := (resp2.Any{I: &cmd}).UnmarshalRESP(br); err != nil {
+						return
+					}
+					cmdsCh <- cmd
+					if _, err := conn.Write([]byte("+OK\r\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	url := "rediss://:myPass@localhost:" + port + "/2"
+
+	// the default config verifies the certificate, which is self-signed, but
+	// the handshake must still have been attempted with the URI's host.
+	_, err = Dial("tcp", url)
+	assert.Error(t, err)
+	assert.Equal(t, "localhost", <-serverNameCh)
+	assert.Len(t, cmdsCh, 0)
+
+	c, err := Dial("tcp", url, DialUseTLS(&tls.Config{InsecureSkipVerify: true}))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, "localhost", <-serverNameCh)
+	assert.Equal(t, []string{"AUTH", "myPass"}, <-cmdsCh)
+	assert.Equal(t, []string{"SELECT", "2"}, <-cmdsCh)
+
+	// TLS can be enabled and configured using query parameters as well
+	c, err = Dial("tcp", "redis://localhost:"+port+"/3?tls=true&tls_insecure_skip_verify=true")
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, "localhost", <-serverNameCh)
+	assert.Equal(t, []string{"SELECT", "3"}, <-cmdsCh)
+
+	// or disabled, in which case the handshake isn't attempted
+	_, err = Dial("tcp", url+"?tls=false", DialReadTimeout(time.Second))
+	assert.Error(t, err)
+	assert.Len(t, serverNameCh, 0)
+}
@@ -0,0 +1,74 @@
+package trace
+
+import "time"
+
+// ConnTrace is passed into radix.Dial via radix.DialWithTrace, and contains
+// callbacks which will be triggered for specific events during the Conn's
+// runtime.
+//
+// All callbacks are called synchronously.
+type ConnTrace struct {
+	// DoStarted is called before an Action is performed using the Conn. The
+	// returned function, if not nil, is called once the Action has completed,
+	// regardless of whether it succeeded. This allows for measuring latencies
+	// or creating tracing spans without having to correlate the two events.
+	//
+	// When the Conn is used by a Pool this is also called for the pipelines
+	// created by the Pool's implicit pipelining, with an empty CommandName.
+	DoStarted func(ConnDoStarted) func(ConnDoCompleted)
+
+	// DialCompleted is called once radix.Dial has completed, regardless of
+	// whether it succeeded. The elapsed time includes setting up the
+	// connection, e.g. TLS and AUTH.
+	DialCompleted func(ConnDialCompleted)
+}
+
+// ConnCommon contains information which is passed into all Conn-related
+// callbacks.
+type ConnCommon struct {
+	// Network and Addr indicate the network/address the Conn was created with.
+	Network, Addr string
+}
+
+// ConnDoStarted is passed into the ConnTrace.DoStarted callback whenever an
+// Action is about to be performed.
+type ConnDoStarted struct {
+	ConnCommon
+
+	// CommandName is the name of the command which is performed, or the name
+	// given to the Action using radix.WithCommandName. It is empty if neither
+	// is available, e.g. for a Pipeline which wasn't given a name.
+	CommandName string
+
+	// Key is the first key the Action operates on, or empty if it doesn't
+	// have any.
+	Key string
+}
+
+// ConnDoCompleted is passed into the function returned from the
+// ConnTrace.DoStarted callback once the Action has completed.
+type ConnDoCompleted struct {
+	ConnCommon
+
+	// CommandName and Key are the same as in ConnDoStarted.
+	CommandName string
+	Key         string
+
+	// How long it took to perform the Action.
+	ElapsedTime time.Duration
+
+	// The error returned from the Action, if any.
+	Err error
+}
+
+// ConnDialCompleted is passed into the ConnTrace.DialCompleted callback
+// whenever radix.Dial has completed.
+type ConnDialCompleted struct {
+	ConnCommon
+
+	// How long it took to dial and set up the connection.
+	ElapsedTime time.Duration
+
+	// The error Dial failed with, if any.
+	Err error
+}
@@ -22,6 +22,10 @@ type PoolTrace struct {
 
 	// InitCompleted is called after pool fills its connections
 	InitCompleted func(PoolInitCompleted)
+
+	// GetTimedOut is called when waiting for a connection to become available
+	// timed out, see the radix.PoolOnEmpty options.
+	GetTimedOut func(PoolGetTimedOut)
 }
 
 // PoolCommon contains information which is passed into all Pool-related
@@ -54,6 +58,11 @@ const (
 	// because the Pool was empty and an Action requires one. See the
 	// radix.PoolOnEmpty options.
 	PoolConnCreatedReasonPoolEmpty PoolConnCreatedReason = "pool empty"
+
+	// PoolConnCreatedReasonRetry indicates a connection was being created in
+	// order to retry an Action which failed due to a connection error. See
+	// radix.PoolRetryIdempotentOnce.
+	PoolConnCreatedReasonRetry PoolConnCreatedReason = "retry"
 )
 
 // PoolConnCreated is passed into the PoolTrace.ConnCreated callback whenever
@@ -88,6 +97,14 @@ const (
 	// PoolConnClosedReasonPoolFull indicates a connection was closed due to
 	// the Pool already being full. See The radix.PoolOnFullClose options.
 	PoolConnClosedReasonPoolFull PoolConnClosedReason = "pool full"
+
+	// PoolConnClosedReasonMaxLifetime indicates a connection was closed due
+	// to having reached its maximum lifetime. See radix.PoolMaxLifetime.
+	PoolConnClosedReasonMaxLifetime PoolConnClosedReason = "max lifetime"
+
+	// PoolConnClosedReasonIdleTimeout indicates a connection was closed due
+	// to having been idle for too long. See radix.PoolIdleTimeout.
+	PoolConnClosedReasonIdleTimeout PoolConnClosedReason = "idle timeout"
 )
 
 // PoolConnClosed is passed into the PoolTrace.ConnClosed callback whenever the
@@ -112,6 +129,15 @@ type PoolDoCompleted struct {
 	// on to which are available for usage at the moment the trace occurs.
 	AvailCount int
 
+	// InFlightCount indicates the number of Actions being performed by the
+	// Pool at the moment the trace occurs, including this one.
+	InFlightCount int
+
+	// CommandName is the name of the command which was performed, or the name
+	// given to the Action using radix.WithCommandName. It is empty if neither
+	// is available, e.g. for a Pipeline which wasn't given a name.
+	CommandName string
+
 	// How long it took to send command.
 	ElapsedTime time.Duration
 
@@ -131,3 +157,14 @@ type PoolInitCompleted struct {
 	// How long it took to fill all connections.
 	ElapsedTime time.Duration
 }
+
+// PoolGetTimedOut is passed into the PoolTrace.GetTimedOut callback whenever
+// the Pool gave up waiting for a connection to become available. Depending on
+// the radix.PoolOnEmpty option used, the Pool then either creates a new
+// connection or returns radix.ErrPoolEmpty.
+type PoolGetTimedOut struct {
+	PoolCommon
+
+	// How long the Pool waited for a connection.
+	WaitTime time.Duration
+}
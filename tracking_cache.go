@@ -0,0 +1,420 @@
+package radix
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3/trace"
+)
+
+// trackingInvalidateChannel is the channel invalidation messages are published
+// on when CLIENT TRACKING is used with a redirect over RESP2.
+const trackingInvalidateChannel = "__redis__:invalidate"
+
+type trackingCacheOpts struct {
+	cf       ConnFunc
+	poolSize int
+	poolOpts []PoolOpt
+	maxSize  int
+	ttl      time.Duration
+}
+
+// TrackingCacheOpt is an optional behavior which can be applied to the
+// NewTrackingCache function to effect a TrackingCache's behavior.
+type TrackingCacheOpt func(*trackingCacheOpts)
+
+// TrackingCacheConnFunc tells the TrackingCache to use the given ConnFunc when
+// creating the connection used for receiving invalidation messages, as well as
+// the connections of its Pool.
+func TrackingCacheConnFunc(cf ConnFunc) TrackingCacheOpt {
+	return func(tco *trackingCacheOpts) {
+		tco.cf = cf
+	}
+}
+
+// TrackingCachePoolSize sets the size of the Pool used by the TrackingCache
+// for performing commands.
+func TrackingCachePoolSize(size int) TrackingCacheOpt {
+	return func(tco *trackingCacheOpts) {
+		tco.poolSize = size
+	}
+}
+
+// TrackingCachePoolOpts sets the options used when creating the Pool of the
+// TrackingCache. PoolConnFunc and PoolWithTrace are always overwritten by the
+// TrackingCache, use TrackingCacheConnFunc instead of the former.
+func TrackingCachePoolOpts(opts ...PoolOpt) TrackingCacheOpt {
+	return func(tco *trackingCacheOpts) {
+		tco.poolOpts = opts
+	}
+}
+
+// TrackingCacheMaxSize sets the maximum number of keys held in the cache. Once
+// the maximum is reached the least recently used keys are evicted.
+func TrackingCacheMaxSize(size int) TrackingCacheOpt {
+	return func(tco *trackingCacheOpts) {
+		tco.maxSize = size
+	}
+}
+
+// TrackingCacheTTL sets the duration after which a cached key expires, even if
+// no invalidation message was received for it. This limits how long a value
+// can be stale if an invalidation message is lost. If zero, cached keys only
+// expire when they are invalidated or evicted.
+func TrackingCacheTTL(ttl time.Duration) TrackingCacheOpt {
+	return func(tco *trackingCacheOpts) {
+		tco.ttl = ttl
+	}
+}
+
+// TrackingCacheStats contains the statistics of a TrackingCache, as returned by
+// its Stats method.
+type TrackingCacheStats struct {
+	// Hits and Misses are the number of reads which were served from the
+	// cache, and which had to be sent to redis, respectively.
+	Hits, Misses int64
+
+	// Evictions is the number of keys removed from the cache because its
+	// maximum size was reached.
+	Evictions int64
+
+	// Invalidations is the number of keys removed from the cache because they
+	// were invalidated, either by redis or because one of the connections of
+	// the Pool was closed.
+	Invalidations int64
+
+	// Size is the number of keys currently held in the cache.
+	Size int
+}
+
+// HitRatio returns the ratio of reads served from the cache to all reads, or
+// zero if there were no reads yet.
+func (s TrackingCacheStats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+type trackingKind int
+
+const (
+	trackingKindString trackingKind = iota
+	trackingKindHash
+)
+
+// trackingString is the cached reply of a GET.
+type trackingString struct {
+	val string
+	ok  bool
+}
+
+type trackingEntry struct {
+	key       string
+	kind      trackingKind
+	val       interface{}
+	expiresAt time.Time
+}
+
+// trackingFetch keeps track of the reads of a key which are currently in
+// flight, so that their values aren't cached if the key is invalidated before
+// they are done.
+type trackingFetch struct {
+	n           int
+	invalidated bool
+}
+
+// TrackingCache is a Client which caches the values of keys read using Get and
+// HGetAll in memory, using server-assisted client side caching (see
+// https://redis.io/topics/client-side-caching) to remove keys from the cache
+// as soon as they are modified in redis.
+//
+// All commands are performed on a Pool, whose connections have CLIENT TRACKING
+// enabled with invalidation messages redirected to a separate connection
+// subscribed to the __redis__:invalidate channel. Since redis stops tracking
+// the keys read by a connection once it is closed, the whole cache is cleared
+// whenever a connection of the Pool is closed.
+//
+// If the connection receiving invalidation messages fails the cache is cleared,
+// and all further reads are sent to redis. A new TrackingCache must be created
+// in that case to resume caching.
+//
+// TrackingCache requires Redis 6 or newer. It is safe for concurrent use.
+type TrackingCache struct {
+	opts   trackingCacheOpts
+	pool   *Pool
+	ps     PubSubConn
+	msgCh  chan PubSubMessage
+	errCh  chan error
+	doneCh chan struct{}
+
+	l       sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used entry
+	fetches map[string]*trackingFetch
+	flushes uint64
+	broken  bool
+	stats   TrackingCacheStats
+}
+
+var _ Client = new(TrackingCache)
+
+// NewTrackingCache creates a TrackingCache for the redis instance at the given
+// address.
+//
+// The default options NewTrackingCache uses are:
+//
+//	TrackingCacheConnFunc(DefaultConnFunc)
+//	TrackingCachePoolSize(10)
+//	TrackingCacheMaxSize(10000)
+//	TrackingCacheTTL(0)
+//
+func NewTrackingCache(network, addr string, opts ...TrackingCacheOpt) (*TrackingCache, error) {
+	tc := &TrackingCache{
+		msgCh:   make(chan PubSubMessage, 16),
+		errCh:   make(chan error, 1),
+		doneCh:  make(chan struct{}),
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		fetches: map[string]*trackingFetch{},
+	}
+
+	defaultTrackingCacheOpts := []TrackingCacheOpt{
+		TrackingCacheConnFunc(DefaultConnFunc),
+		TrackingCachePoolSize(10),
+		TrackingCacheMaxSize(10000),
+		TrackingCacheTTL(0),
+	}
+	for _, opt := range append(defaultTrackingCacheOpts, opts...) {
+		opt(&tc.opts)
+	}
+
+	conn, err := tc.opts.cf(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	var id int64
+	if err := conn.Do(Cmd(&id, "CLIENT", "ID")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tc.ps = newPubSub(conn, tc.errCh)
+	go tc.spin()
+	if err := tc.ps.Subscribe(tc.msgCh, trackingInvalidateChannel); err != nil {
+		tc.ps.Close()
+		<-tc.doneCh
+		return nil, err
+	}
+
+	// the args can't be invalid, as only Redirect is set
+	trackingArgs, _ := ClientTracking{Redirect: id}.args()
+	poolOpts := append([]PoolOpt(nil), tc.opts.poolOpts...)
+	poolOpts = append(poolOpts,
+		PoolConnFunc(func(network, addr string) (Conn, error) {
+			conn, err := tc.opts.cf(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := doOK(conn, "CLIENT", trackingArgs...); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}),
+		PoolWithTrace(trace.PoolTrace{
+			ConnClosed: func(trace.PoolConnClosed) { tc.flush() },
+		}),
+	)
+	if tc.pool, err = NewPool(network, addr, tc.opts.poolSize, poolOpts...); err != nil {
+		tc.ps.Close()
+		<-tc.doneCh
+		return nil, err
+	}
+	return tc, nil
+}
+
+func (tc *TrackingCache) spin() {
+	defer close(tc.doneCh)
+	for {
+		select {
+		case m := <-tc.msgCh:
+			switch {
+			case m.Messages == nil:
+				tc.invalidate(m.Message)
+			case len(m.Messages) == 0:
+				tc.flush()
+			default:
+				tc.invalidate(m.Messages...)
+			}
+		case <-tc.errCh:
+			// without invalidation messages the cache can't be kept up to
+			// date anymore
+			tc.l.Lock()
+			tc.broken = true
+			tc.l.Unlock()
+			tc.flush()
+			return
+		}
+	}
+}
+
+// remove removes the given entry. tc.l must be held.
+func (tc *TrackingCache) remove(el *list.Element) {
+	delete(tc.entries, el.Value.(*trackingEntry).key)
+	tc.lru.Remove(el)
+}
+
+func (tc *TrackingCache) invalidate(keys ...[]byte) {
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	for _, key := range keys {
+		if el, ok := tc.entries[string(key)]; ok {
+			tc.remove(el)
+			tc.stats.Invalidations++
+		}
+		if f, ok := tc.fetches[string(key)]; ok {
+			f.invalidated = true
+		}
+	}
+}
+
+func (tc *TrackingCache) flush() {
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	tc.stats.Invalidations += int64(len(tc.entries))
+	tc.entries = map[string]*list.Element{}
+	tc.lru.Init()
+	tc.flushes++
+}
+
+func (tc *TrackingCache) lookup(key string, kind trackingKind) (interface{}, bool) {
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	if el, ok := tc.entries[key]; ok {
+		e := el.Value.(*trackingEntry)
+		if e.kind == kind && (e.expiresAt.IsZero() || time.Now().Before(e.expiresAt)) {
+			tc.lru.MoveToFront(el)
+			tc.stats.Hits++
+			return e.val, true
+		}
+		tc.remove(el)
+	}
+	tc.stats.Misses++
+	return nil, false
+}
+
+// fetch performs the Action, which reads the given key, and caches the value
+// returned by val afterwards, unless the key was invalidated in the meantime.
+func (tc *TrackingCache) fetch(key string, kind trackingKind, a Action, val func() interface{}) error {
+	tc.l.Lock()
+	f, ok := tc.fetches[key]
+	if !ok {
+		f = new(trackingFetch)
+		tc.fetches[key] = f
+	}
+	f.n++
+	flushes := tc.flushes
+	tc.l.Unlock()
+
+	err := tc.pool.Do(a)
+
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	// fetches of the same key which overlap with an invalidated one aren't
+	// cached either, which is unfortunate but safe.
+	if f.n--; f.n == 0 {
+		delete(tc.fetches, key)
+	}
+	if err != nil || f.invalidated || flushes != tc.flushes || tc.broken {
+		return err
+	}
+
+	if el, ok := tc.entries[key]; ok {
+		tc.remove(el)
+	}
+	e := &trackingEntry{key: key, kind: kind, val: val()}
+	if tc.opts.ttl > 0 {
+		e.expiresAt = time.Now().Add(tc.opts.ttl)
+	}
+	tc.entries[key] = tc.lru.PushFront(e)
+	for tc.lru.Len() > tc.opts.maxSize {
+		tc.remove(tc.lru.Back())
+		tc.stats.Evictions++
+	}
+	return nil
+}
+
+// Get returns the value of the given key as returned by GET, reading it from
+// the cache if possible. The returned bool will be false if the key isn't set,
+// which is cached as well.
+func (tc *TrackingCache) Get(key string) (string, bool, error) {
+	if v, ok := tc.lookup(key, trackingKindString); ok {
+		ts := v.(trackingString)
+		return ts.val, ts.ok, nil
+	}
+
+	var val string
+	mn := MaybeNil{Rcv: &val}
+	err := tc.fetch(key, trackingKindString, Cmd(&mn, "GET", key), func() interface{} {
+		return trackingString{val: val, ok: !mn.Nil}
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return val, !mn.Nil, nil
+}
+
+// HGetAll returns all fields of the hash at the given key as returned by
+// HGETALL, reading them from the cache if possible. The returned map may be
+// modified by the caller.
+func (tc *TrackingCache) HGetAll(key string) (map[string]string, error) {
+	if v, ok := tc.lookup(key, trackingKindHash); ok {
+		return copyStringMap(v.(map[string]string)), nil
+	}
+
+	var m map[string]string
+	err := tc.fetch(key, trackingKindHash, Cmd(&m, "HGETALL", key), func() interface{} {
+		return copyStringMap(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	cp := make(map[string]string, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
+}
+
+// Stats returns the current statistics of the TrackingCache.
+func (tc *TrackingCache) Stats() TrackingCacheStats {
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	stats := tc.stats
+	stats.Size = tc.lru.Len()
+	return stats
+}
+
+// Do implements the method for the Client interface. The Action is performed
+// on the Pool of the TrackingCache without using the cache. Keys modified by
+// the Action will be invalidated as usual.
+func (tc *TrackingCache) Do(a Action) error {
+	return tc.pool.Do(a)
+}
+
+// Close implements the method for the Client interface.
+func (tc *TrackingCache) Close() error {
+	err := tc.ps.Close()
+	<-tc.doneCh
+	if perr := tc.pool.Close(); err == nil {
+		err = perr
+	}
+	return err
+}
@@ -0,0 +1,187 @@
+package radix
+
+import (
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type trackingCacheStub struct {
+	t      *T
+	stubCh chan<- PubSubMessage
+
+	l      sync.Mutex
+	vals   map[string]string
+	hashes map[string]map[string]string
+	reads  int
+}
+
+func newTrackingCacheStub(t *T, opts ...TrackingCacheOpt) (*TrackingCache, *trackingCacheStub) {
+	s := &trackingCacheStub{
+		t:      t,
+		vals:   map[string]string{},
+		hashes: map[string]map[string]string{},
+	}
+
+	var dialed bool
+	cf := func(network, addr string) (Conn, error) {
+		if !dialed {
+			dialed = true
+			conn, stubCh := PubSubStub(network, addr, func(args []string) interface{} {
+				require.Equal(t, []string{"CLIENT", "ID"}, args)
+				return 7
+			})
+			s.stubCh = stubCh
+			return conn, nil
+		}
+		return Stub(network, addr, s.fn), nil
+	}
+
+	opts = append([]TrackingCacheOpt{
+		TrackingCacheConnFunc(cf),
+		TrackingCachePoolSize(1),
+	}, opts...)
+	tc, err := NewTrackingCache("tcp", "127.0.0.1:6379", opts...)
+	require.NoError(t, err)
+	return tc, s
+}
+
+func (s *trackingCacheStub) fn(args []string) interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "CLIENT":
+		require.Equal(s.t, []string{"CLIENT", "TRACKING", "ON", "REDIRECT", "7"}, args)
+		return "OK"
+	case "GET":
+		s.reads++
+		if val, ok := s.vals[args[1]]; ok {
+			return val
+		}
+		return nil
+	case "HGETALL":
+		s.reads++
+		return s.hashes[args[1]]
+	case "SET":
+		s.vals[args[1]] = args[2]
+		return "OK"
+	}
+	return nil
+}
+
+func (s *trackingCacheStub) set(key, val string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.vals[key] = val
+}
+
+func (s *trackingCacheStub) numReads() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.reads
+}
+
+// invalidate sends an invalidation message for the given keys, or for all keys
+// if none are given, and waits for it to be processed.
+func (s *trackingCacheStub) invalidate(tc *TrackingCache, keys ...string) {
+	before := tc.Stats().Invalidations
+	msgs := [][]byte{}
+	for _, key := range keys {
+		msgs = append(msgs, []byte(key))
+	}
+	s.stubCh <- PubSubMessage{Channel: trackingInvalidateChannel, Messages: msgs}
+
+	deadline := time.Now().Add(time.Second)
+	for tc.Stats().Invalidations == before {
+		require.True(s.t, time.Now().Before(deadline), "invalidation not processed")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTrackingCache(t *T) {
+	tc, s := newTrackingCacheStub(t)
+	defer tc.Close()
+
+	s.set("foo", "1")
+	s.hashes["bar"] = map[string]string{"a": "1", "b": "2"}
+
+	assertGet := func(key, expVal string, expOK bool) {
+		val, ok, err := tc.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, expVal, val)
+		assert.Equal(t, expOK, ok)
+	}
+
+	// the second read of a key is served from the cache, including misses
+	assertGet("foo", "1", true)
+	assertGet("foo", "1", true)
+	assertGet("baz", "", false)
+	assertGet("baz", "", false)
+	assert.Equal(t, 2, s.numReads())
+
+	m, err := tc.HGetAll("bar")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, m)
+	m["c"] = "3"
+	m, err = tc.HGetAll("bar")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, m)
+	assert.Equal(t, 3, s.numReads())
+
+	// Do doesn't use the cache, and only invalidated keys are read again
+	require.NoError(t, tc.Do(Cmd(nil, "SET", "foo", "2")))
+	assertGet("foo", "1", true)
+	s.invalidate(tc, "foo")
+	assertGet("foo", "2", true)
+	assertGet("baz", "", false)
+	assert.Equal(t, 4, s.numReads())
+
+	// a nil array invalidates all keys
+	s.invalidate(tc)
+	assert.Equal(t, 0, tc.Stats().Size)
+	assertGet("foo", "2", true)
+	assertGet("baz", "", false)
+	assert.Equal(t, 6, s.numReads())
+
+	stats := tc.Stats()
+	assert.Equal(t, TrackingCacheStats{
+		Hits:          5,
+		Misses:        6,
+		Invalidations: 4,
+		Size:          2,
+	}, stats)
+	assert.InDelta(t, 5.0/11.0, stats.HitRatio(), 0.0001)
+}
+
+func TestTrackingCacheEviction(t *T) {
+	tc, s := newTrackingCacheStub(t,
+		TrackingCacheMaxSize(2),
+		TrackingCacheTTL(50*time.Millisecond),
+	)
+	defer tc.Close()
+
+	get := func(key string) {
+		_, _, err := tc.Get(key)
+		require.NoError(t, err)
+	}
+
+	// "b" is the least recently used key once "c" is read
+	get("a")
+	get("b")
+	get("a")
+	get("c")
+	assert.Equal(t, 3, s.numReads())
+	get("a")
+	assert.Equal(t, 3, s.numReads())
+	get("b")
+	assert.Equal(t, 4, s.numReads())
+	assert.Equal(t, int64(2), tc.Stats().Evictions)
+
+	time.Sleep(60 * time.Millisecond)
+	get("b")
+	assert.Equal(t, 5, s.numReads())
+}
@@ -0,0 +1,232 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// NoTTL is returned by TTL and PTTL for keys which exist but have no
+// associated expiry.
+const NoTTL time.Duration = -1
+
+// TTL returns the remaining time to live of the given key, using the TTL
+// command, which has a resolution of one second. Use PTTL for a millisecond
+// resolution.
+//
+// exists will be false if the key doesn't exist, in which case ttl is 0. If
+// the key exists but doesn't expire ttl will be NoTTL.
+func TTL(c Client, key string) (ttl time.Duration, exists bool, err error) {
+	return doTTL(c, "TTL", key, time.Second)
+}
+
+// PTTL is like TTL, but uses the PTTL command, which has a resolution of one
+// millisecond.
+func PTTL(c Client, key string) (ttl time.Duration, exists bool, err error) {
+	return doTTL(c, "PTTL", key, time.Millisecond)
+}
+
+func doTTL(c Client, cmd, key string, unit time.Duration) (time.Duration, bool, error) {
+	var n int64
+	if err := c.Do(Cmd(&n, cmd, key)); err != nil {
+		return 0, false, err
+	}
+
+	switch {
+	case n == -2:
+		return 0, false, nil
+	case n < 0:
+		return NoTTL, true, nil
+	default:
+		return time.Duration(n) * unit, true, nil
+	}
+}
+
+// cappedTTLKeyCmds contains the write commands which may create a key without
+// an expiry, mapped to the index of that key in their arguments. SET is
+// handled separately, see cappedTTLConn.
+var cappedTTLKeyCmds = map[string]int{
+	"APPEND":       1,
+	"BITFIELD":     1,
+	"BITOP":        2,
+	"COPY":         2,
+	"DECR":         1,
+	"DECRBY":       1,
+	"GEOADD":       1,
+	"GETSET":       1,
+	"HINCRBY":      1,
+	"HINCRBYFLOAT": 1,
+	"HMSET":        1,
+	"HSET":         1,
+	"HSETNX":       1,
+	"INCR":         1,
+	"INCRBY":       1,
+	"INCRBYFLOAT":  1,
+	"LPUSH":        1,
+	"PFADD":        1,
+	"PFMERGE":      1,
+	"RPUSH":        1,
+	"SADD":         1,
+	"SDIFFSTORE":   1,
+	"SETBIT":       1,
+	"SETNX":        1,
+	"SETRANGE":     1,
+	"SINTERSTORE":  1,
+	"SUNIONSTORE":  1,
+	"XADD":         1,
+	"ZADD":         1,
+	"ZDIFFSTORE":   1,
+	"ZINCRBY":      1,
+	"ZINTERSTORE":  1,
+	"ZRANGESTORE":  1,
+	"ZUNIONSTORE":  1,
+}
+
+type cappedTTLConn struct {
+	Conn
+	ttl string // in milliseconds
+
+	l       sync.Mutex
+	inMulti bool
+	// extra contains, for each command which was sent and whose reply wasn't
+	// read yet, the number of PEXPIRE commands which were sent after it.
+	extra []int
+}
+
+// NewCappedTTLConn wraps the given Conn such that keys written without an
+// expiry are given the default TTL, which is useful for cache-only redis
+// instances where no key should be persistent.
+//
+// SET commands without any of the EX, PX, EXAT, PXAT or KEEPTTL options are
+// sent with a PX option added. For other write commands which may create a key,
+// e.g. HSET or LPUSH, a `PEXPIRE key ttl NX` command is sent right after the
+// command, which only sets the TTL if the key doesn't have one yet. Its reply is
+// read and discarded along with the reply of the command, and if it failed its
+// error is returned, even though the command itself succeeded. PEXPIRE with the
+// NX option is only available in redis 7.0 and above.
+//
+// Commands which set an expiry themselves, like SETEX, or which are sent
+// within a MULTI/EXEC transaction (other than SET) are sent as-is, as are
+// commands performed by scripts.
+//
+// To use NewCappedTTLConn with a Pool, wrap the Conns created by its ConnFunc,
+// see ReadOnlyConn for an example.
+func NewCappedTTLConn(conn Conn, defaultTTL time.Duration) Conn {
+	ms := int64(defaultTTL / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return &cappedTTLConn{Conn: conn, ttl: strconv.FormatInt(ms, 10)}
+}
+
+func (cc *cappedTTLConn) Do(a Action) error {
+	return a.Run(cc)
+}
+
+func (cc *cappedTTLConn) Encode(m resp.Marshaler) error {
+	in := new(bytes.Buffer)
+	if err := m.MarshalRESP(in); err != nil {
+		return err
+	}
+
+	cc.l.Lock()
+	defer cc.l.Unlock()
+
+	out := new(bytes.Buffer)
+	br := bufio.NewReader(in)
+	var extra []int
+	for {
+		var args []string
+		if err := (resp2.Any{I: &args}).UnmarshalRESP(br); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		} else if len(args) == 0 {
+			continue
+		}
+
+		args, expires := cc.capArgs(args)
+		if err := (resp2.Any{I: args}).MarshalRESP(out); err != nil {
+			return err
+		}
+		for _, key := range expires {
+			pexpire := []string{"PEXPIRE", key, cc.ttl, "NX"}
+			if err := (resp2.Any{I: pexpire}).MarshalRESP(out); err != nil {
+				return err
+			}
+		}
+		extra = append(extra, len(expires))
+	}
+
+	if err := cc.Conn.Encode(resp2.RawMessage(out.Bytes())); err != nil {
+		return err
+	}
+	cc.extra = append(cc.extra, extra...)
+	return nil
+}
+
+// capArgs returns the arguments to send for the given command, as well as the
+// keys which need a PEXPIRE sent after it.
+func (cc *cappedTTLConn) capArgs(args []string) ([]string, []string) {
+	cmd := strings.ToUpper(args[0])
+	switch {
+	case cmd == "MULTI":
+		cc.inMulti = true
+	case cmd == "EXEC" || cmd == "DISCARD":
+		cc.inMulti = false
+	case cmd == "SET":
+		if len(args) < 3 {
+			break
+		}
+		for _, arg := range args[3:] {
+			switch strings.ToUpper(arg) {
+			case "EX", "PX", "EXAT", "PXAT", "KEEPTTL":
+				return args, nil
+			}
+		}
+		args = append(args, "PX", cc.ttl)
+	case cc.inMulti:
+	case cmd == "MSET" || cmd == "MSETNX":
+		var keys []string
+		for i := 1; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+		return args, keys
+	default:
+		if i, ok := cappedTTLKeyCmds[cmd]; ok && i < len(args) {
+			return args, []string{args[i]}
+		}
+	}
+	return args, nil
+}
+
+func (cc *cappedTTLConn) Decode(u resp.Unmarshaler) error {
+	var extra int
+	cc.l.Lock()
+	if len(cc.extra) > 0 {
+		extra, cc.extra = cc.extra[0], cc.extra[1:]
+	}
+	cc.l.Unlock()
+
+	err := cc.Conn.Decode(u)
+	if err != nil && !errors.As(err, new(resp.ErrDiscarded)) {
+		return err
+	}
+	for i := 0; i < extra; i++ {
+		if pexpireErr := cc.Conn.Decode(&resp2.Any{}); pexpireErr != nil && !errors.As(pexpireErr, new(resp.ErrDiscarded)) {
+			return pexpireErr
+		} else if pexpireErr != nil && err == nil {
+			err = errors.Errorf("setting default TTL failed: %w", pexpireErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package radix
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestTTL(t *T) {
+	replies := map[string]int{"expires": 90, "persistent": -1, "missing": -2}
+	var lastCmd string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		lastCmd = args[0]
+		return replies[args[1]]
+	})
+
+	tests := []struct {
+		key    string
+		ttl    time.Duration
+		pttl   time.Duration
+		exists bool
+	}{
+		{key: "expires", ttl: 90 * time.Second, pttl: 90 * time.Millisecond, exists: true},
+		{key: "persistent", ttl: NoTTL, pttl: NoTTL, exists: true},
+		{key: "missing"},
+	}
+
+	for _, test := range tests {
+		ttl, exists, err := TTL(stub, test.key)
+		require.NoError(t, err)
+		assert.Equal(t, "TTL", lastCmd)
+		assert.Equal(t, test.ttl, ttl, test.key)
+		assert.Equal(t, test.exists, exists, test.key)
+
+		ttl, exists, err = PTTL(stub, test.key)
+		require.NoError(t, err)
+		assert.Equal(t, "PTTL", lastCmd)
+		assert.Equal(t, test.pttl, ttl, test.key)
+		assert.Equal(t, test.exists, exists, test.key)
+	}
+}
+
+func TestCappedTTLConn(t *T) {
+	var cmds [][]string
+	var inMulti bool
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch {
+		case args[0] == "MULTI":
+			inMulti = true
+			return "OK"
+		case args[0] == "EXEC":
+			inMulti = false
+			return []interface{}{1, "OK"}
+		case inMulti:
+			return resp2.SimpleString{S: "QUEUED"}
+		}
+
+		switch args[0] {
+		case "PEXPIRE":
+			if args[1] == "old" {
+				return resp2.Error{E: errors.New("ERR wrong number of arguments")}
+			}
+			return 1
+		case "HSET":
+			return 1
+		default:
+			return "OK"
+		}
+	})
+	conn := NewCappedTTLConn(stub, time.Minute)
+
+	assertCmds := func(t *T, exp ...[]string) {
+		t.Helper()
+		assert.Equal(t, exp, cmds)
+		cmds = nil
+	}
+
+	t.Run("SET", func(t *T) {
+		require.NoError(t, conn.Do(Cmd(nil, "SET", "foo", "EX")))
+		require.NoError(t, conn.Do(Cmd(nil, "SET", "foo", "bar", "NX", "ex", "10")))
+		require.NoError(t, conn.Do(Cmd(nil, "SETEX", "foo", "10", "bar")))
+		assertCmds(t,
+			[]string{"SET", "foo", "EX", "PX", "60000"},
+			[]string{"SET", "foo", "bar", "NX", "ex", "10"},
+			[]string{"SETEX", "foo", "10", "bar"},
+		)
+	})
+
+	t.Run("PEXPIRE", func(t *T) {
+		var a, b int
+		require.NoError(t, conn.Do(Pipeline(
+			Cmd(&a, "HSET", "foo", "a", "1"),
+			Cmd(&b, "HSET", "bar", "b", "2"),
+		)))
+		assert.Equal(t, 1, a)
+		assert.Equal(t, 1, b)
+		assertCmds(t,
+			[]string{"HSET", "foo", "a", "1"},
+			[]string{"PEXPIRE", "foo", "60000", "NX"},
+			[]string{"HSET", "bar", "b", "2"},
+			[]string{"PEXPIRE", "bar", "60000", "NX"},
+		)
+
+		err := conn.Do(Cmd(nil, "HSET", "old", "a", "1"))
+		assert.True(t, errors.As(err, new(resp2.Error)), "err: %v", err)
+		assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+		cmds = nil
+
+		// the Conn is still in sync afterwards
+		require.NoError(t, conn.Do(Cmd(&a, "HSET", "foo", "a", "1")))
+		assert.Equal(t, 1, a)
+		cmds = nil
+	})
+
+	t.Run("MULTI", func(t *T) {
+		require.NoError(t, conn.Do(Txn(
+			Cmd(nil, "HSET", "foo", "a", "1"),
+			Cmd(nil, "SET", "foo", "bar"),
+		)))
+		assertCmds(t,
+			[]string{"MULTI"},
+			[]string{"HSET", "foo", "a", "1"},
+			[]string{"SET", "foo", "bar", "PX", "60000"},
+			[]string{"EXEC"},
+		)
+	})
+}
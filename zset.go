@@ -0,0 +1,283 @@
+package radix
+
+import (
+	"bufio"
+	"math"
+	"strconv"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// ZMember is a member of a sorted set together with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// zMembers unmarshals a flat array of members and scores, as returned by
+// sorted set commands with the WITHSCORES option.
+type zMembers []ZMember
+
+func (zm *zMembers) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N%2 != 0 {
+		for i := 0; i < ah.N; i++ {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+		}
+		return resp.ErrDiscarded{Err: errors.Errorf("odd number of elements (%d) in reply with scores", ah.N)}
+	}
+
+	*zm = make(zMembers, ah.N/2)
+	for i := range *zm {
+		m := &(*zm)[i]
+		if err := (resp2.Any{I: &m.Member}).UnmarshalRESP(br); err != nil {
+			return err
+		} else if err := (resp2.Any{I: &m.Score}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ZScoreBound is the minimum or maximum score of a range of sorted set
+// members, as used by ZRangeByScore. The zero value is not a valid bound.
+type ZScoreBound struct {
+	s string
+}
+
+// Bounds which match any score.
+var (
+	ZScoreNegInf = ZScoreBound{s: "-inf"}
+	ZScorePosInf = ZScoreBound{s: "+inf"}
+)
+
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "+inf"
+	} else if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// ZScoreInclusive returns a ZScoreBound which includes the given score.
+func ZScoreInclusive(score float64) ZScoreBound {
+	return ZScoreBound{s: formatScore(score)}
+}
+
+// ZScoreExclusive returns a ZScoreBound which excludes the given score.
+func ZScoreExclusive(score float64) ZScoreBound {
+	return ZScoreBound{s: "(" + formatScore(score)}
+}
+
+// String returns the bound as sent to redis, e.g. "1.5", "(1.5" or "+inf".
+func (b ZScoreBound) String() string {
+	return b.s
+}
+
+// ZLexBound is the minimum or maximum member of a range of sorted set members,
+// as used by ZRangeByLex. The zero value is not a valid bound.
+type ZLexBound struct {
+	s string
+}
+
+// Bounds which match any member.
+var (
+	ZLexMin = ZLexBound{s: "-"}
+	ZLexMax = ZLexBound{s: "+"}
+)
+
+// ZLexInclusive returns a ZLexBound which includes the given member.
+func ZLexInclusive(member string) ZLexBound {
+	return ZLexBound{s: "[" + member}
+}
+
+// ZLexExclusive returns a ZLexBound which excludes the given member.
+func ZLexExclusive(member string) ZLexBound {
+	return ZLexBound{s: "(" + member}
+}
+
+// String returns the bound as sent to redis, e.g. "[a", "(a" or "+".
+func (b ZLexBound) String() string {
+	return b.s
+}
+
+// ZRangeOpts are optional parameters which can be passed into ZRangeByScore
+// and ZRangeByLex.
+type ZRangeOpts struct {
+	// Reverse returns the members ordered from the highest to the lowest
+	// score (or member), using ZREVRANGEBYSCORE or ZREVRANGEBYLEX. The min and
+	// max bounds are given the same way as when Reverse is false.
+	Reverse bool
+
+	// Offset is the number of matching members to skip, and Count the maximum
+	// number of members to return. If both are 0 no LIMIT is sent, otherwise
+	// a Count of 0 or less returns all members after Offset.
+	Offset, Count int
+
+	// WithScores causes the scores of the members to be returned as well. It
+	// is only supported by ZRangeByScore.
+	WithScores bool
+}
+
+func (o ZRangeOpts) args(key, min, max string) []string {
+	args := make([]string, 0, 7)
+	if o.Reverse {
+		args = append(args, key, max, min)
+	} else {
+		args = append(args, key, min, max)
+	}
+	if o.WithScores {
+		args = append(args, "WITHSCORES")
+	}
+	if o.Offset != 0 || o.Count != 0 {
+		count := o.Count
+		if count <= 0 {
+			count = -1
+		}
+		args = append(args, "LIMIT", strconv.Itoa(o.Offset), strconv.Itoa(count))
+	}
+	return args
+}
+
+// ZRangeByScore returns the members of the sorted set stored at key whose
+// scores are within the given bounds, ordered by their scores, using
+// ZRANGEBYSCORE. The Score fields of the returned ZMembers are only set if
+// opts.WithScores is set.
+func ZRangeByScore(c Client, key string, min, max ZScoreBound, opts ZRangeOpts) ([]ZMember, error) {
+	cmd := "ZRANGEBYSCORE"
+	if opts.Reverse {
+		cmd = "ZREVRANGEBYSCORE"
+	}
+	args := opts.args(key, min.s, max.s)
+
+	if opts.WithScores {
+		var members zMembers
+		if err := c.Do(Cmd(&members, cmd, args...)); err != nil {
+			return nil, err
+		}
+		return members, nil
+	}
+
+	var names []string
+	if err := c.Do(Cmd(&names, cmd, args...)); err != nil {
+		return nil, err
+	}
+	members := make([]ZMember, len(names))
+	for i := range names {
+		members[i].Member = names[i]
+	}
+	return members, nil
+}
+
+// ZRangeByLex returns the members of the sorted set stored at key which are
+// within the given bounds, ordered lexicographically, using ZRANGEBYLEX. This
+// requires all members of the sorted set to have the same score.
+//
+// opts.WithScores is not supported and causes an error to be returned.
+func ZRangeByLex(c Client, key string, min, max ZLexBound, opts ZRangeOpts) ([]string, error) {
+	if opts.WithScores {
+		return nil, errors.New("ZRangeByLex does not support WithScores")
+	}
+
+	cmd := "ZRANGEBYLEX"
+	if opts.Reverse {
+		cmd = "ZREVRANGEBYLEX"
+	}
+
+	var members []string
+	if err := c.Do(Cmd(&members, cmd, opts.args(key, min.s, max.s)...)); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// zRangeStore performs ZRANGESTORE using the BYSCORE or BYLEX option given in
+// by, and returns the number of stored members.
+func zRangeStore(c Client, dst, src, min, max, by string, opts ZRangeOpts) (int64, error) {
+	if opts.WithScores {
+		return 0, errors.New("ZRANGESTORE does not support WithScores")
+	}
+
+	rangeArgs := opts.args(src, min, max)
+	args := make([]string, 0, len(rangeArgs)+3)
+	args = append(args, dst)
+	args = append(args, rangeArgs[:3]...)
+	args = append(args, by)
+	if opts.Reverse {
+		args = append(args, "REV")
+	}
+	args = append(args, rangeArgs[3:]...)
+
+	var n int64
+	err := c.Do(Cmd(&n, "ZRANGESTORE", args...))
+	return n, err
+}
+
+// ZRangeStoreByScore is like ZRangeByScore, but instead of returning the
+// members they are stored in the sorted set at dst, replacing it, using
+// ZRANGESTORE with the BYSCORE option. The number of stored members is
+// returned. ZRANGESTORE is only available in redis 6.2 and above.
+//
+// opts.WithScores is not supported and causes an error to be returned, the
+// scores are always stored.
+func ZRangeStoreByScore(c Client, dst, src string, min, max ZScoreBound, opts ZRangeOpts) (int64, error) {
+	return zRangeStore(c, dst, src, min.s, max.s, "BYSCORE", opts)
+}
+
+// ZRangeStoreByLex is like ZRangeByLex, but instead of returning the members
+// they are stored in the sorted set at dst, replacing it, using ZRANGESTORE
+// with the BYLEX option. The number of stored members is returned.
+//
+// opts.WithScores is not supported and causes an error to be returned.
+func ZRangeStoreByLex(c Client, dst, src string, min, max ZLexBound, opts ZRangeOpts) (int64, error) {
+	return zRangeStore(c, dst, src, min.s, max.s, "BYLEX", opts)
+}
+
+var updateAndRankScript = NewEvalScript(1, `
+	redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
+	local score = redis.call("ZSCORE", KEYS[1], ARGV[2])
+	local rank
+	if ARGV[3] == "1" then
+		rank = redis.call("ZREVRANK", KEYS[1], ARGV[2])
+	else
+		rank = redis.call("ZRANK", KEYS[1], ARGV[2])
+	end
+	return {score, rank}
+`)
+
+// UpdateAndRank sets the score of member in the sorted set stored at key using
+// ZADD and returns its new score and rank. The rank is 0-based and ordered from
+// the lowest to the highest score, or from the highest to the lowest score if
+// rev is true (as with ZREVRANK), which is commonly used for leaderboards.
+//
+// Unlike performing ZADD and ZRANK separately, UpdateAndRank runs both commands
+// atomically using a lua script, so that the returned rank can't be affected by
+// concurrent updates.
+func UpdateAndRank(c Client, key, member string, score float64, rev bool) (newScore float64, rank int64, err error) {
+	revStr := "0"
+	if rev {
+		revStr = "1"
+	}
+
+	var res []string
+	if err := c.Do(updateAndRankScript.Cmd(&res, key, formatScore(score), member, revStr)); err != nil {
+		return 0, 0, err
+	} else if len(res) != 2 {
+		return 0, 0, errors.Errorf("expected 2 elements in reply, got %d", len(res))
+	}
+
+	if newScore, err = strconv.ParseFloat(res[0], 64); err != nil {
+		return 0, 0, err
+	} else if rank, err = strconv.ParseInt(res[1], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return newScore, rank, nil
+}
@@ -0,0 +1,142 @@
+package radix
+
+import (
+	"math"
+	"strconv"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestZScoreBound(t *T) {
+	assert.Equal(t, "1.5", ZScoreInclusive(1.5).String())
+	assert.Equal(t, "(1.5", ZScoreExclusive(1.5).String())
+	assert.Equal(t, "(-3", ZScoreExclusive(-3).String())
+	assert.Equal(t, "1e+21", ZScoreInclusive(1e21).String())
+	assert.Equal(t, "+inf", ZScoreInclusive(math.Inf(1)).String())
+	assert.Equal(t, "(-inf", ZScoreExclusive(math.Inf(-1)).String())
+	assert.Equal(t, "-inf", ZScoreNegInf.String())
+	assert.Equal(t, "+inf", ZScorePosInf.String())
+
+	assert.Equal(t, "[a", ZLexInclusive("a").String())
+	assert.Equal(t, "(a", ZLexExclusive("a").String())
+	assert.Equal(t, "[", ZLexInclusive("").String())
+	assert.Equal(t, "-", ZLexMin.String())
+	assert.Equal(t, "+", ZLexMax.String())
+}
+
+func TestZRangeBy(t *T) {
+	var got []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = args
+		for _, arg := range args {
+			if arg == "WITHSCORES" {
+				return []string{"a", "1", "b", "2.5", "c", "inf"}
+			}
+		}
+		return []string{"a", "b", "c"}
+	})
+
+	members, err := ZRangeByScore(stub, "zset", ZScoreExclusive(1), ZScorePosInf, ZRangeOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ZRANGEBYSCORE", "zset", "(1", "+inf"}, got)
+	assert.Equal(t, []ZMember{{Member: "a"}, {Member: "b"}, {Member: "c"}}, members)
+
+	members, err = ZRangeByScore(stub, "zset", ZScoreNegInf, ZScoreInclusive(2.5), ZRangeOpts{
+		Reverse:    true,
+		WithScores: true,
+		Offset:     1,
+		Count:      3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ZREVRANGEBYSCORE", "zset", "2.5", "-inf", "WITHSCORES", "LIMIT", "1", "3"}, got)
+	assert.Equal(t, []ZMember{
+		{Member: "a", Score: 1},
+		{Member: "b", Score: 2.5},
+		{Member: "c", Score: math.Inf(1)},
+	}, members)
+
+	names, err := ZRangeByLex(stub, "zset", ZLexInclusive("a"), ZLexMax, ZRangeOpts{Offset: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ZRANGEBYLEX", "zset", "[a", "+", "LIMIT", "2", "-1"}, got)
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+
+	_, err = ZRangeByLex(stub, "zset", ZLexMin, ZLexExclusive("c"), ZRangeOpts{Reverse: true, Count: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ZREVRANGEBYLEX", "zset", "(c", "-", "LIMIT", "0", "2"}, got)
+
+	_, err = ZRangeByLex(stub, "zset", ZLexMin, ZLexMax, ZRangeOpts{WithScores: true})
+	assert.Error(t, err)
+}
+
+func TestZRangeStore(t *T) {
+	var got []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		got = args
+		return 2
+	})
+
+	n, err := ZRangeStoreByScore(stub, "dst", "src", ZScoreInclusive(1), ZScoreExclusive(5), ZRangeOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, []string{"ZRANGESTORE", "dst", "src", "1", "(5", "BYSCORE"}, got)
+
+	_, err = ZRangeStoreByScore(stub, "dst", "src", ZScoreNegInf, ZScorePosInf, ZRangeOpts{
+		Reverse: true,
+		Offset:  1,
+		Count:   2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ZRANGESTORE", "dst", "src", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2"}, got)
+
+	_, err = ZRangeStoreByLex(stub, "dst", "src", ZLexInclusive("a"), ZLexMax, ZRangeOpts{Offset: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ZRANGESTORE", "dst", "src", "[a", "+", "BYLEX", "LIMIT", "2", "-1"}, got)
+
+	_, err = ZRangeStoreByScore(stub, "dst", "src", ZScoreNegInf, ZScorePosInf, ZRangeOpts{WithScores: true})
+	assert.Error(t, err)
+}
+
+func TestUpdateAndRank(t *T) {
+	scores := map[string]float64{"a": 1, "b": 5}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "EVALSHA":
+			return resp2.Error{E: errors.New("NOSCRIPT")}
+		case "EVAL":
+			// emulates updateAndRankScript
+			score, _ := strconv.ParseFloat(args[4], 64)
+			member, rev := args[5], args[6] == "1"
+			scores[member] = score
+
+			var rank int
+			for m, s := range scores {
+				if m != member && ((!rev && s < score) || (rev && s > score)) {
+					rank++
+				}
+			}
+			return []interface{}{args[4], rank}
+		default:
+			return errors.Errorf("unexpected command %q", args[0])
+		}
+	})
+
+	score, rank, err := UpdateAndRank(stub, "board", "c", 3.5, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3.5, score)
+	assert.Equal(t, int64(1), rank)
+
+	score, rank, err = UpdateAndRank(stub, "board", "c", 10, true)
+	require.NoError(t, err)
+	assert.Equal(t, float64(10), score)
+	assert.Equal(t, int64(0), rank)
+
+	_, rank, err = UpdateAndRank(stub, "board", "a", math.Inf(-1), true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rank)
+	assert.True(t, math.IsInf(scores["a"], -1))
+}